@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apidiffIgnoredHeaders are response headers that differ between any two
+// calls, so 'wt apidiff' does not compare them unless asked to.
+var apidiffIgnoredHeaders = []string{"Date", "Age", "Expires", "Last-Modified", "Etag", "Content-Length", "X-Request-Id"}
+
+// apidiffMaxValue bounds how much of a JSON value a diff line shows.
+const apidiffMaxValue = 80
+
+// apidiffOptions are the flags of 'wt apidiff'.
+type apidiffOptions struct {
+	paths         []string
+	port          int
+	method        string
+	headers       []string
+	data          string
+	ignore        []string // JSON paths not to compare, e.g. .meta.requestId or .items[*].id
+	ignoreHeaders []string
+	compareAll    bool // compare every header, including apidiffIgnoredHeaders
+	insecure      bool
+}
+
+// apidiffBaseURL returns the URL paths are resolved against: 127.0.0.1 on
+// the given port, or else on the first port forwarded by the devcontainer.
+func apidiffBaseURL(dir string, port int) (string, error) {
+	if port == 0 {
+		cfg, err := readDevcontainerConfig(dir)
+		if err != nil {
+			return "", err
+		}
+		if cfg != nil {
+			for _, p := range cfg.forwardedPorts() {
+				if n, err := strconv.Atoi(p); err == nil {
+					port = n
+					break
+				}
+			}
+		}
+	}
+	if port == 0 {
+		return "", fmt.Errorf("devcontainer.json forwards no port; pass --port or full URLs in --paths")
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", port), nil
+}
+
+// apidiffRequests builds the request for each entry of --paths: a path
+// resolved against base, or a full URL.
+func apidiffRequests(base string, opts apidiffOptions) ([]replayRequest, error) {
+	var reqs []replayRequest
+	for _, p := range opts.paths {
+		r := replayRequest{method: opts.method, url: p, header: http.Header{}, body: opts.data}
+		if !strings.Contains(p, "://") {
+			if !strings.HasPrefix(p, "/") {
+				p = "/" + p
+			}
+			r.url = base + p
+		}
+		r.url = normalizeLocalhostURL(r.url)
+		for _, h := range opts.headers {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid --header %q: expected \"Name: value\"", h)
+			}
+			r.addHeader(name, value)
+		}
+		if r.body != "" && r.header.Get("Content-Type") == "" {
+			r.header.Set("Content-Type", "application/json")
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// shortJSON renders v for a diff line, truncated to apidiffMaxValue.
+func shortJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if s := string(data); len(s) <= apidiffMaxValue {
+		return s
+	}
+	return string(data[:apidiffMaxValue-3]) + "..."
+}
+
+// jsonPathIgnored reports whether path (e.g. .items[3].id) is one of the
+// ignored paths or inside one; [*] and .* in an ignored path match any
+// index or key.
+func jsonPathIgnored(path string, ignore []string) bool {
+	segments := jsonPathSegments(path)
+	for _, ig := range ignore {
+		pattern := jsonPathSegments(ig)
+		if len(pattern) > len(segments) {
+			continue
+		}
+		match := true
+		for i, seg := range pattern {
+			if seg != segments[i] && seg != "[*]" && seg != ".*" {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPathSegments splits ".a.b[2]" into ".a", ".b", "[2]".
+func jsonPathSegments(path string) []string {
+	var segments []string
+	start := 0
+	for i := 1; i <= len(path); i++ {
+		if i == len(path) || path[i] == '.' || path[i] == '[' {
+			if i > start {
+				segments = append(segments, path[start:i])
+			}
+			start = i
+		}
+	}
+	return segments
+}
+
+// diffJSON appends to out a line for each difference between a and b,
+// addressed by its path from the root (".").
+func diffJSON(path string, a, b any, ignore []string, out *[]string) {
+	if jsonPathIgnored(path, ignore) {
+		return
+	}
+	display := path
+	if display == "" {
+		display = "."
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "." + k
+			x, inA := av[k]
+			y, inB := bv[k]
+			switch {
+			case jsonPathIgnored(child, ignore):
+			case !inB:
+				*out = append(*out, fmt.Sprintf("%s: removed %s", child, shortJSON(x)))
+			case !inA:
+				*out = append(*out, fmt.Sprintf("%s: added %s", child, shortJSON(y)))
+			default:
+				diffJSON(child, x, y, ignore, out)
+			}
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(av), len(bv)); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case jsonPathIgnored(child, ignore):
+			case i >= len(bv):
+				*out = append(*out, fmt.Sprintf("%s: removed %s", child, shortJSON(av[i])))
+			case i >= len(av):
+				*out = append(*out, fmt.Sprintf("%s: added %s", child, shortJSON(bv[i])))
+			default:
+				diffJSON(child, av[i], bv[i], ignore, out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, fmt.Sprintf("%s: %s -> %s", display, shortJSON(a), shortJSON(b)))
+	}
+}
+
+// decodeJSON decodes body, keeping numbers as written.
+func decodeJSON(body []byte) (any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// apidiffResponses returns the differences between the responses of a and
+// b to the same request: status, headers, and body, the body field by field
+// when both are JSON.
+func apidiffResponses(a, b replayResponse, opts apidiffOptions) []string {
+	if a.err != nil || b.err != nil {
+		if a.err != nil && b.err != nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s -> %s", a.summary(), b.summary())}
+	}
+	var out []string
+	if a.status != b.status {
+		out = append(out, fmt.Sprintf("status: %d -> %d", a.status, b.status))
+	}
+	ignored := map[string]bool{}
+	for _, h := range opts.ignoreHeaders {
+		ignored[http.CanonicalHeaderKey(h)] = true
+	}
+	if !opts.compareAll {
+		for _, h := range apidiffIgnoredHeaders {
+			ignored[h] = true
+		}
+	}
+	var names []string
+	for name := range a.header {
+		names = append(names, name)
+	}
+	for name := range b.header {
+		if _, ok := a.header[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ignored[name] {
+			continue
+		}
+		av, bv := strings.Join(a.header.Values(name), ", "), strings.Join(b.header.Values(name), ", ")
+		switch {
+		case av == bv:
+		case len(a.header.Values(name)) == 0:
+			out = append(out, fmt.Sprintf("header %s: added %q", name, bv))
+		case len(b.header.Values(name)) == 0:
+			out = append(out, fmt.Sprintf("header %s: removed %q", name, av))
+		default:
+			out = append(out, fmt.Sprintf("header %s: %q -> %q", name, av, bv))
+		}
+	}
+	aj, aok := decodeJSON(a.body)
+	bj, bok := decodeJSON(b.body)
+	if aok && bok {
+		diffJSON("", aj, bj, opts.ignore, &out)
+		return out
+	}
+	if bytes.Equal(a.body, b.body) {
+		return out
+	}
+	al, bl := comparableBody(a.body, nil), comparableBody(b.body, nil)
+	if len(al) > replayMaxDiffLines || len(bl) > replayMaxDiffLines {
+		return append(out, fmt.Sprintf("body: differs (%d vs %d bytes)", len(a.body), len(b.body)))
+	}
+	out = append(out, "body:")
+	for _, line := range diffLines(al, bl) {
+		out = append(out, "  "+line)
+	}
+	return out
+}
+
+// runAPIDiff implements 'wt apidiff': it sends the same requests through
+// the proxies of the worktrees at a and b and reports how the responses
+// differ. It exits 1 if any do.
+func runAPIDiff(a, b string, opts apidiffOptions) error {
+	base := ""
+	for _, p := range opts.paths {
+		if !strings.Contains(p, "://") {
+			var err error
+			if base, err = apidiffBaseURL(a, opts.port); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	reqs, err := apidiffRequests(base, opts)
+	if err != nil {
+		return err
+	}
+	clientA, err := replayClient(a, opts.insecure)
+	if err != nil {
+		return err
+	}
+	clientB, err := replayClient(b, opts.insecure)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("--- %s\n+++ %s\n", filepath.Base(a), filepath.Base(b))
+	differ := 0
+	for _, r := range reqs {
+		ra, rb := sendReplayRequest(clientA, r), sendReplayRequest(clientB, r)
+		diff := apidiffResponses(ra, rb, opts)
+		if len(diff) == 0 {
+			fmt.Printf("same    %s %s -> %s\n", r.method, r.url, rb.summary())
+			continue
+		}
+		differ++
+		fmt.Printf("DIFF    %s %s\n", r.method, r.url)
+		for _, line := range diff {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	fmt.Printf("%d of %d endpoints differ\n", differ, len(reqs))
+	if differ > 0 {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
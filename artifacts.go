@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// artifactsEnv holds the worktree's artifacts directory: the host path
+	// in hooks, the mount point in 'wt exec' sessions.
+	artifactsEnv = "WT_ARTIFACTS"
+	// artifactsContainerPath is where 'wt up' mounts the artifacts
+	// directory in the devcontainer.
+	artifactsContainerPath = "/wt/artifacts"
+)
+
+// artifactsDir returns (and creates) the artifacts directory of the worktree
+// at dir, in its wt state directory: a scratch space outside the checkout
+// where logs, screenshots, reports, and downloads land.
+func artifactsDir(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	artifacts := filepath.Join(stateDir, "artifacts")
+	if err := os.MkdirAll(artifacts, 0755); err != nil {
+		return "", fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+	return artifacts, nil
+}
+
+// newArtifactPath returns a fresh timestamped path such as
+// screenshots/20260301-141502.png in the worktree's artifacts directory,
+// creating the kind subdirectory.
+func newArtifactPath(dir, kind, ext string) (string, error) {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return "", err
+	}
+	kindDir := filepath.Join(artifacts, kind)
+	if err := os.MkdirAll(kindDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+	return filepath.Join(kindDir, time.Now().Format("20060102-150405")+ext), nil
+}
+
+// artifactsMountArgs returns the 'devcontainer up' arguments that mount the
+// worktree's artifacts directory into the container.
+func artifactsMountArgs(dir string) ([]string, error) {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"--mount", fmt.Sprintf("type=bind,source=%s,target=%s", artifacts, artifactsContainerPath)}, nil
+}
+
+// artifact is a file in a worktree's artifacts directory.
+type artifact struct {
+	rel     string // path relative to the artifacts directory
+	size    int64
+	modTime time.Time
+}
+
+// listArtifacts returns the files under the artifacts directory, newest
+// first.
+func listArtifacts(artifacts string) ([]artifact, error) {
+	var files []artifact
+	err := filepath.WalkDir(artifacts, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(artifacts, path)
+		files = append(files, artifact{rel: rel, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	return files, err
+}
+
+// runArtifactsLs implements 'wt artifacts ls'.
+func runArtifactsLs(dir string) error {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return err
+	}
+	files, err := listArtifacts(artifacts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", artifacts)
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No artifacts for %s yet\n", filepath.Base(dir))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGE\tSIZE\tPATH")
+	for _, f := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", formatAge(f.modTime), formatSize(f.size), f.rel)
+	}
+	return w.Flush()
+}
+
+// hasArtifact reports whether rel exists in the worktree's artifacts
+// directory.
+func hasArtifact(dir, rel string) bool {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(artifacts, rel))
+	return err == nil
+}
+
+// runArtifactsOpen implements 'wt artifacts open': it opens the artifacts
+// directory, or the file or subdirectory rel in it, with the desktop's
+// default application.
+func runArtifactsOpen(dir, rel string) error {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(artifacts, rel)
+	if r, err := filepath.Rel(artifacts, path); err != nil || r == ".." || strings.HasPrefix(r, "../") {
+		return fmt.Errorf("%s is outside the artifacts directory", rel)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no artifact %s; see 'wt artifacts ls'", rel)
+	}
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	if _, err := exec.LookPath(opener); err != nil {
+		fmt.Println(path)
+		return fmt.Errorf("%s not found; open %s by hand", opener, path)
+	}
+	return exec.Command(opener, path).Start()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const bitbucketAPI = "https://api.bitbucket.org/2.0"
+
+// bitbucketForge drives Bitbucket Pipelines through the REST API. It
+// authenticates with $BITBUCKET_TOKEN (a repository or workspace access
+// token), or $BITBUCKET_USERNAME and $BITBUCKET_APP_PASSWORD.
+type bitbucketForge struct {
+	repo remoteRepo // path is "workspace/repo"
+}
+
+func (bitbucketForge) name() string { return forgeBitbucket }
+
+// bitbucketPipeline is the subset of the Pipelines API response wt reads.
+type bitbucketPipeline struct {
+	UUID        string `json:"uuid"`
+	BuildNumber int64  `json:"build_number"`
+	State       struct {
+		Name   string `json:"name"`
+		Result struct {
+			Name string `json:"name"`
+		} `json:"result"`
+	} `json:"state"`
+}
+
+func (b bitbucketForge) request(method, path string, body any) (bitbucketPipeline, error) {
+	var p bitbucketPipeline
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return p, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, bitbucketAPI+"/repositories/"+b.repo.path+path, reader)
+	if err != nil {
+		return p, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case os.Getenv("BITBUCKET_TOKEN") != "":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("BITBUCKET_TOKEN"))
+	case os.Getenv("BITBUCKET_USERNAME") != "":
+		req.SetBasicAuth(os.Getenv("BITBUCKET_USERNAME"), os.Getenv("BITBUCKET_APP_PASSWORD"))
+	default:
+		return p, fmt.Errorf("set BITBUCKET_TOKEN, or BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD, to use Bitbucket Pipelines")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return p, fmt.Errorf("bitbucket API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return p, fmt.Errorf("bitbucket API returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("failed to parse bitbucket API response: %w", err)
+	}
+	return p, nil
+}
+
+func (b bitbucketForge) runURL(buildNumber int64) string {
+	return fmt.Sprintf("https://bitbucket.org/%s/pipelines/results/%d", b.repo.path, buildNumber)
+}
+
+// triggerCI starts the branch's pipeline, or the custom pipeline named by
+// --workflow; inputs become pipeline variables.
+func (b bitbucketForge) triggerCI(dir, branch string, opts ciRunOptions) (ciRun, error) {
+	target := map[string]any{"type": "pipeline_ref_target", "ref_type": "branch", "ref_name": branch}
+	workflow := "default"
+	if opts.workflow != "" {
+		target["selector"] = map[string]string{"type": "custom", "pattern": opts.workflow}
+		workflow = opts.workflow
+	}
+	body := map[string]any{"target": target}
+	var vars []map[string]string
+	for _, f := range opts.fields {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return ciRun{}, fmt.Errorf("invalid field %q; expected key=value", f)
+		}
+		vars = append(vars, map[string]string{"key": key, "value": value})
+	}
+	if len(vars) > 0 {
+		body["variables"] = vars
+	}
+	p, err := b.request(http.MethodPost, "/pipelines/", body)
+	if err != nil {
+		return ciRun{}, err
+	}
+	return ciRun{ID: p.BuildNumber, Ref: p.UUID, URL: b.runURL(p.BuildNumber), Workflow: workflow, Branch: branch, Triggered: time.Now().UTC()}, nil
+}
+
+func (b bitbucketForge) watchCI(dir string, run ciRun) error {
+	return pollCI(run, 10*time.Second, func() (string, bool, bool, error) {
+		p, err := b.request(http.MethodGet, "/pipelines/"+run.Ref, nil)
+		if err != nil {
+			return "", false, false, err
+		}
+		if p.State.Name != "COMPLETED" {
+			return strings.ToLower(p.State.Name), false, false, nil
+		}
+		result := p.State.Result.Name
+		return strings.ToLower(result), true, result == "SUCCESSFUL", nil
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	bootstrapInHost      = "host"
+	bootstrapInContainer = "container"
+)
+
+// runBootstrap runs the configured add.bootstrap commands, in order, in the
+// worktree at dir. Output streams to the terminal; the first failing command
+// stops the sequence.
+func runBootstrap(dir string, cfg AddConfig) error {
+	inContainer := cfg.BootstrapIn == bootstrapInContainer
+	for i, command := range cfg.Bootstrap {
+		fmt.Fprintf(os.Stderr, "==> [%d/%d] %s\n", i+1, len(cfg.Bootstrap), command)
+		var c *exec.Cmd
+		if inContainer {
+			c = exec.Command("devcontainer", "exec", "--workspace-folder", dir, "/bin/sh", "-c", command)
+			c.Env = append(os.Environ(), "DOCKER_CLI_HINTS=false")
+		} else {
+			c = exec.Command("/bin/sh", "-c", command)
+			c.Dir = dir
+		}
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("bootstrap command %q failed: %w\nThe worktree was created at %s; fix the problem and re-run the remaining commands there", command, err, dir)
+		}
+	}
+	return nil
+}
+
+// bootstrapNeedsContainer reports whether bootstrap commands must run inside
+// the worktree's devcontainer.
+func bootstrapNeedsContainer(dir string, cfg AddConfig) bool {
+	if len(cfg.Bootstrap) == 0 || cfg.BootstrapIn != bootstrapInContainer {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json"))
+	return err == nil
+}
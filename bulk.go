@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// bulkAddEntry is one line of a 'wt add --from-file' list: a worktree name
+// and, optionally, the branch to put in it.
+type bulkAddEntry struct {
+	name   string
+	branch string
+}
+
+// readBulkAddList reads "name [branch]" lines from path, or from stdin when
+// path is "-". Blank lines and lines starting with '#' are skipped.
+func readBulkAddList(path string, naming NamingConfig) ([]bulkAddEntry, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var entries []bulkAddEntry
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"name [branch]\"", path, n)
+		}
+		e := bulkAddEntry{name: fields[0]}
+		if len(fields) == 2 {
+			e.branch = fields[1]
+		}
+		if err := checkNewWorktreeName(e.name, naming); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if seen[e.name] {
+			return nil, fmt.Errorf("%s:%d: %s is listed twice", path, n, e.name)
+		}
+		seen[e.name] = true
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// runBulkAdd implements 'wt add --from-file': it creates a worktree for each
+// entry with the other 'wt add' flags, fetching the remotes until a fetch
+// succeeds, and reports which ones failed. A failure does not stop the
+// others.
+func runBulkAdd(cmd *cobra.Command, args []string, path string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("--from-file cannot be combined with a name")
+	}
+	for _, flag := range []string{"interactive", "auto", "code", "cd", "branch", "pr", "issue", "no-track", "from-stash"} {
+		if cmd.Flags().Changed(flag) {
+			return fmt.Errorf("--from-file cannot be combined with --%s", flag)
+		}
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	entries, err := readBulkAddList(path, cfg.Naming)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no worktree names in %s", path)
+	}
+	opts := addOptionsFromFlags(cmd)
+	var fetched bool
+	opts.fetched = &fetched
+	track, _ := cmd.Flags().GetBool("track")
+	var failed []string
+	results := make([]string, len(entries))
+	for i, e := range entries {
+		fmt.Fprintf(os.Stderr, "==> [%d/%d] %s\n", i+1, len(entries), e.name)
+		o := opts
+		o.branch = e.branch
+		if track {
+			if o.branch == "" {
+				o.branch = e.name
+			}
+			o.track = true
+		}
+		err := addWorktree(e.name, o)
+		if err == nil {
+			err = finishAdd(e.name, o)
+		}
+		if err != nil {
+			failed = append(failed, e.name)
+			results[i] = fmt.Sprintf("  FAILED  %s: %v", e.name, err)
+			continue
+		}
+		results[i] = "  ok      " + e.name
+	}
+	fmt.Fprintf(os.Stderr, "\nCreated %d of %d worktrees:\n", len(entries)-len(failed), len(entries))
+	for _, r := range results {
+		fmt.Fprintln(os.Stderr, r)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to create %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRunBulkAddFetchesUntilAFetchSucceeds(t *testing.T) {
+	tests := []struct {
+		name        string
+		list        string
+		wantFetched bool
+	}{
+		{"first entry fetches", "one\ntwo\n", true},
+		{"first entry fails before fetching", "taken\none\n", true},
+		{"every entry fails before fetching", "taken\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			// A branch that only a fetch brings in.
+			upstream := filepath.Join(filepath.Dir(repo), "upstream")
+			gitIn(t, upstream, "branch", "feature")
+			if err := os.Mkdir(repo+"@taken", 0755); err != nil {
+				t.Fatal(err)
+			}
+			list := filepath.Join(t.TempDir(), "list")
+			if err := os.WriteFile(list, []byte(tt.list), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_ = runBulkAdd(&cobra.Command{}, nil, list)
+
+			fetched := exec.Command("git", "rev-parse", "--verify", "-q", "refs/remotes/origin/feature").Run() == nil
+			if fetched != tt.wantFetched {
+				t.Errorf("fetched = %v, want %v", fetched, tt.wantFetched)
+			}
+		})
+	}
+}
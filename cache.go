@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// cacheNetwork is the docker network shared by the cache containers and the
+// devcontainers that use them.
+const cacheNetwork = "wt-cache"
+
+// cacheService is a dependency cache wt can run once for all worktrees.
+type cacheService struct {
+	image   string
+	port    int
+	dataDir string   // volume mount point inside the cache container
+	runEnv  []string // env for the cache container itself
+	// env wires a devcontainer to the cache; "{url}" is replaced with the
+	// cache's base URL.
+	env []string
+}
+
+var cacheServices = map[string]cacheService{
+	"apt": {
+		image:   "sameersbn/apt-cacher-ng:latest",
+		port:    3142,
+		dataDir: "/var/cache/apt-cacher-ng",
+	},
+	"npm": {
+		image:   "verdaccio/verdaccio:5",
+		port:    4873,
+		dataDir: "/verdaccio/storage",
+		env:     []string{"npm_config_registry={url}/", "YARN_REGISTRY={url}/"},
+	},
+	"pip": {
+		image:   "epicwink/proxpi:latest",
+		port:    5000,
+		dataDir: "/cache",
+		runEnv:  []string{"PROXPI_CACHE_DIR=/cache"},
+		env:     []string{"PIP_INDEX_URL={url}/index/", "PIP_TRUSTED_HOST=wt-cache-pip", "UV_INDEX_URL={url}/index/"},
+	},
+}
+
+func cacheContainerName(name string) string {
+	return "wt-cache-" + name
+}
+
+func cacheURL(name string) string {
+	return fmt.Sprintf("http://%s:%d", cacheContainerName(name), cacheServices[name].port)
+}
+
+// cacheEnv returns the env assignments that point package managers in a
+// devcontainer at the enabled caches.
+func (c CacheConfig) cacheEnv() []string {
+	var env []string
+	for _, name := range c.Services {
+		for _, e := range cacheServices[name].env {
+			env = append(env, strings.ReplaceAll(e, "{url}", cacheURL(name)))
+		}
+	}
+	return env
+}
+
+// cacheContainerState returns the docker state of a cache container, or ""
+// if it does not exist.
+func cacheContainerState(name string) string {
+	out, err := exec.Command("docker", "inspect", "--format", "{{.State.Status}}", cacheContainerName(name)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ensureCaches starts the shared network and the enabled cache containers if
+// they are not running yet. Cached data lives in named volumes, so it
+// survives 'wt cache down'.
+func ensureCaches(cfg CacheConfig) error {
+	if exec.Command("docker", "network", "inspect", cacheNetwork).Run() != nil {
+		if out, err := exec.Command("docker", "network", "create", cacheNetwork).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create network %s: %s", cacheNetwork, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, name := range cfg.Services {
+		svc := cacheServices[name]
+		container := cacheContainerName(name)
+		var args []string
+		switch cacheContainerState(name) {
+		case "running":
+			continue
+		case "":
+			args = []string{"run", "-d", "--restart", "unless-stopped", "--name", container,
+				"--network", cacheNetwork, "-v", container + ":" + svc.dataDir}
+			if offline {
+				args = append(args, "--pull=never")
+			}
+			for _, e := range svc.runEnv {
+				args = append(args, "-e", e)
+			}
+			args = append(args, svc.image)
+		default:
+			args = []string{"start", container}
+		}
+		fmt.Fprintf(os.Stderr, "Starting %s cache (%s)\n", name, container)
+		if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start %s: %s", container, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// attachCaches connects the worktree's running devcontainer to the cache
+// network and persists the cache settings inside it: an apt proxy config and
+// a profile script exporting the package manager env for later shells.
+func attachCaches(dir string, cfg CacheConfig) error {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("docker", "network", "connect", cacheNetwork, containerID).CombinedOutput(); err != nil &&
+		!strings.Contains(string(out), "already exists") {
+		return fmt.Errorf("failed to connect to %s: %s", cacheNetwork, strings.TrimSpace(string(out)))
+	}
+	var script strings.Builder
+	for _, name := range cfg.Services {
+		if name == "apt" {
+			fmt.Fprintf(&script, "if [ -d /etc/apt/apt.conf.d ]; then echo 'Acquire::http::Proxy \"%s\";' > /etc/apt/apt.conf.d/01wt-cache; fi\n", cacheURL(name))
+		}
+	}
+	script.WriteString("mkdir -p /etc/profile.d && cat > /etc/profile.d/wt-cache.sh <<'EOF'\n")
+	for _, e := range cfg.cacheEnv() {
+		fmt.Fprintf(&script, "export %s\n", e)
+	}
+	script.WriteString("EOF\n")
+	if out, err := exec.Command("docker", "exec", "-u", "root", containerID, "sh", "-c", script.String()).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to configure caches in the container: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runCacheDown stops and removes the cache containers; their volumes are
+// kept.
+func runCacheDown() error {
+	names := make([]string, 0, len(cacheServices))
+	for name := range cacheServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cacheContainerState(name) == "" {
+			continue
+		}
+		if out, err := exec.Command("docker", "rm", "-f", cacheContainerName(name)).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove %s: %s", cacheContainerName(name), strings.TrimSpace(string(out)))
+		}
+		fmt.Fprintf(os.Stderr, "Removed %s\n", cacheContainerName(name))
+	}
+	return nil
+}
+
+func runCacheStatus(cfg CacheConfig) error {
+	enabled := map[string]bool{}
+	for _, name := range cfg.Services {
+		enabled[name] = true
+	}
+	names := make([]string, 0, len(cacheServices))
+	for name := range cacheServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CACHE\tENABLED\tSTATE\tURL")
+	for _, name := range names {
+		state := cacheContainerState(name)
+		if state == "" {
+			state = "-"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", name, enabled[name], state, cacheURL(name))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// carriedStash is the stash through which carryChanges moved uncommitted
+// changes out of the worktree at src. It is kept until the new worktree is
+// fully set up, so that a rollback can give src its changes back.
+type carriedStash struct {
+	src    string
+	commit string
+}
+
+// carryChanges moves the uncommitted changes of the worktree at src,
+// untracked files included, into the new worktree at dst through a stash,
+// which all worktrees share. On success src is left clean and the stash is
+// returned, nil if there was nothing to carry; otherwise src gets its
+// changes back.
+func carryChanges(src, dst string) (*carriedStash, error) {
+	out, err := exec.Command("git", "-C", src, "status", "--porcelain").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the status of %s: %w", filepath.Base(src), err)
+	}
+	if len(strings.TrimSpace(string(out))) == 0 {
+		fmt.Fprintf(os.Stderr, "No uncommitted changes in %s to carry\n", filepath.Base(src))
+		return nil, nil
+	}
+	msg := fmt.Sprintf("wt: carried from %s to %s", filepath.Base(src), filepath.Base(dst))
+	if out, err := exec.Command("git", "-C", src, "stash", "push", "--include-untracked", "-m", msg).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to stash the changes in %s: %s", filepath.Base(src), strings.TrimSpace(string(out)))
+	}
+	stash, err := revParse(src, "stash@{0}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the stash of %s: %w", filepath.Base(src), err)
+	}
+	carried := &carriedStash{src: src, commit: stash}
+	if out, err := exec.Command("git", "-C", dst, "stash", "apply", "--index", stash).CombinedOutput(); err != nil {
+		applyErr := fmt.Errorf("failed to apply the changes of %s: %s", filepath.Base(src), strings.TrimSpace(string(out)))
+		if conflicts, _ := exec.Command("git", "-C", dst, "diff", "--name-only", "--diff-filter=U").Output(); len(conflicts) > 0 {
+			applyErr = fmt.Errorf("the changes of %s conflict with the new worktree's base in %s", filepath.Base(src), strings.Join(strings.Fields(string(conflicts)), ", "))
+		}
+		if err := carried.restore(); err != nil {
+			return nil, fmt.Errorf("%w; %v", applyErr, err)
+		}
+		return nil, applyErr
+	}
+	fmt.Fprintf(os.Stderr, "Carried the uncommitted changes of %s\n", filepath.Base(src))
+	return carried, nil
+}
+
+// restore pops the stash back into the worktree it was taken from.
+func (c *carriedStash) restore() error {
+	if out, err := exec.Command("git", "-C", c.src, "stash", "pop", "--index", stashRef(c.src, c.commit)).CombinedOutput(); err != nil {
+		return fmt.Errorf("restoring the changes in %s failed (they are in 'git stash list'): %s", filepath.Base(c.src), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// drop deletes the stash once the changes are safe in the new worktree.
+func (c *carriedStash) drop() {
+	if out, err := exec.Command("git", "-C", c.src, "stash", "drop", stashRef(c.src, c.commit)).CombinedOutput(); err != nil {
+		warnf("drop it with 'git stash drop'", "the carried changes are still in the stash: %s", strings.TrimSpace(string(out)))
+	}
+}
+
+// stashRef returns the stash@{n} entry holding the stash commit, which other
+// stashes pushed meanwhile may have moved from stash@{0}.
+func stashRef(dir, commit string) string {
+	out, err := exec.Command("git", "-C", dir, "stash", "list", "--format=%H").Output()
+	if err == nil {
+		for i, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if line == commit {
+				return fmt.Sprintf("stash@{%d}", i)
+			}
+		}
+	}
+	return "stash@{0}"
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// changelogCommit is one commit a worktree has that the base does not.
+type changelogCommit struct {
+	SHA     string    `json:"sha"`
+	Subject string    `json:"subject"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+}
+
+// changelogFile is a file the worktree's commits changed, with line counts
+// (-1 for binary files).
+type changelogFile struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+}
+
+// changelogEntry summarizes what one worktree has done relative to the base.
+type changelogEntry struct {
+	Name    string            `json:"name"`
+	Path    string            `json:"path"`
+	Branch  string            `json:"branch,omitempty"`
+	Base    string            `json:"base"`
+	Commits []changelogCommit `json:"commits"`
+	Files   []changelogFile   `json:"files"`
+}
+
+// worktreeChangelog lists the commits on HEAD of dir that are not in base,
+// optionally only those committed after since, and the files they touch.
+func worktreeChangelog(wt siblingWorktree, base string, since time.Time) (changelogEntry, error) {
+	entry := changelogEntry{
+		Name:    wt.name,
+		Path:    wt.path,
+		Branch:  getWorktreeStatus(wt.path).branch,
+		Base:    base,
+		Commits: []changelogCommit{},
+		Files:   []changelogFile{},
+	}
+	args := []string{"-C", wt.path, "log", "--no-merges", "--format=%h%x00%s%x00%an%x00%ct", base + "..HEAD"}
+	if !since.IsZero() {
+		args = append(args, "--since="+since.Format(time.RFC3339))
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return entry, fmt.Errorf("git log in %s failed: %w", filepath.Base(wt.path), err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 4 {
+			continue
+		}
+		secs, _ := strconv.ParseInt(fields[3], 10, 64)
+		entry.Commits = append(entry.Commits, changelogCommit{SHA: fields[0], Subject: fields[1], Author: fields[2], Time: time.Unix(secs, 0)})
+	}
+	if len(entry.Commits) == 0 {
+		return entry, nil
+	}
+
+	// Files touched by the listed commits: from the oldest one's parent.
+	from := base
+	if !since.IsZero() {
+		from = entry.Commits[len(entry.Commits)-1].SHA + "^"
+	}
+	out, err = exec.Command("git", "-C", wt.path, "diff", "--numstat", from+"...HEAD").Output()
+	if err != nil {
+		return entry, nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		added, err := strconv.Atoi(fields[0])
+		if err != nil {
+			added = -1
+		}
+		deleted, err := strconv.Atoi(fields[1])
+		if err != nil {
+			deleted = -1
+		}
+		entry.Files = append(entry.Files, changelogFile{Path: fields[2], Added: added, Deleted: deleted})
+	}
+	return entry, nil
+}
+
+// runChangelog summarizes, for every worktree, the commits it has that base
+// (default: the main worktree's branch) does not.
+func runChangelog(base string, since time.Duration, asJSON bool) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		base = getWorktreeStatus(mainRoot).branch
+		if base == "" {
+			base = "HEAD"
+		}
+	}
+	if _, err := revParse(mainRoot, base); err != nil {
+		return err
+	}
+	var sinceTime time.Time
+	if since > 0 {
+		sinceTime = time.Now().Add(-since)
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return err
+	}
+	entries := []changelogEntry{}
+	for _, wt := range worktrees {
+		entry, err := worktreeChangelog(wt, base, sinceTime)
+		if err != nil {
+			warnf("", "%v", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	printed := 0
+	for _, e := range entries {
+		if len(e.Commits) == 0 {
+			continue
+		}
+		if printed > 0 {
+			fmt.Println()
+		}
+		printed++
+		ref := e.Branch
+		if ref == "" {
+			ref = "detached"
+		}
+		added, deleted := 0, 0
+		for _, f := range e.Files {
+			added += max(f.Added, 0)
+			deleted += max(f.Deleted, 0)
+		}
+		fmt.Printf("%s (%s): %d commit(s), %d file(s), +%d -%d\n", e.Name, ref, len(e.Commits), len(e.Files), added, deleted)
+		for _, c := range e.Commits {
+			fmt.Printf("  %s %s (%s, %s)\n", c.SHA, c.Subject, c.Author, formatAge(c.Time))
+		}
+		const maxFiles = 10
+		for i, f := range e.Files {
+			if i == maxFiles {
+				fmt.Printf("    ... and %d more file(s)\n", len(e.Files)-maxFiles)
+				break
+			}
+			fmt.Printf("    %s\n", f.Path)
+		}
+	}
+	if printed == 0 {
+		fmt.Fprintf(os.Stderr, "No worktree has commits that are not in %s\n", base)
+	}
+	return nil
+}
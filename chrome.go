@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// chromeDownloadsDir returns the directory Chrome should download into for
+// the worktree at dir, per chrome.downloadDir: empty means <worktree>/.downloads,
+// "state" means the worktree's wt state directory, "artifacts" its artifacts
+// directory, and any other value is a path relative to the worktree.
+func chromeDownloadsDir(dir string, cfg ChromeConfig) (string, error) {
+	switch cfg.DownloadDir {
+	case "":
+		return filepath.Join(dir, ".downloads"), nil
+	case "state":
+		stateDir, err := worktreeStateDir(dir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(stateDir, "downloads"), nil
+	case "artifacts":
+		artifacts, err := artifactsDir(dir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(artifacts, "downloads"), nil
+	default:
+		if filepath.IsAbs(cfg.DownloadDir) {
+			return filepath.Join(cfg.DownloadDir, filepath.Base(dir)), nil
+		}
+		return filepath.Join(dir, cfg.DownloadDir), nil
+	}
+}
+
+// setChromeDownloadDir points the profile's default download directory at
+// downloadsDir by merging into its Preferences file. Chrome has no command-line
+// switch for this, but reads Preferences at startup.
+func setChromeDownloadDir(profileDir, downloadsDir string) error {
+	if err := os.MkdirAll(downloadsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create downloads directory: %w", err)
+	}
+	prefsPath := filepath.Join(profileDir, "Default", "Preferences")
+	prefs := map[string]any{}
+	if data, err := os.ReadFile(prefsPath); err == nil {
+		if err := json.Unmarshal(data, &prefs); err != nil {
+			// Leave a profile we don't understand alone.
+			return fmt.Errorf("failed to parse %s: %w", prefsPath, err)
+		}
+	}
+	section := func(key string) map[string]any {
+		if m, ok := prefs[key].(map[string]any); ok {
+			return m
+		}
+		m := map[string]any{}
+		prefs[key] = m
+		return m
+	}
+	download := section("download")
+	download["default_directory"] = downloadsDir
+	download["prompt_for_download"] = false
+	download["directory_upgrade"] = true
+	section("savefile")["default_directory"] = downloadsDir
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(prefsPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(prefsPath, data, 0644)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// ciMode is set by --ci, $WT_CI, or the $CI variable most CI systems export.
+// It implies nonInteractive and makes 'wt exec' avoid terminal assumptions.
+var ciMode bool
+
+// nonInteractive is set by --non-interactive or CI mode: wt never prompts and
+// never spawns an interactive shell.
+var nonInteractive bool
+
+var errNonInteractive = errors.New("input required, but wt is running non-interactively (--ci/--non-interactive)")
+
+// slotEnv pins the slot (and so the env template PortOffset) of a worktree
+// created by this process, so CI jobs get predictable ports.
+const slotEnv = "WT_SLOT"
+
+// configureCIMode resolves --ci and --non-interactive, falling back to the
+// environment when the flags are not given.
+func configureCIMode(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("ci") {
+		ciMode = envTrue("WT_CI") || envTrue("CI")
+	}
+	if ciMode {
+		nonInteractive = true
+	}
+}
+
+func envTrue(name string) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && v
+}
+
+// pinnedSlot returns the slot requested through $WT_SLOT, if any.
+func pinnedSlot() (int, bool) {
+	v := os.Getenv(slotEnv)
+	if v == "" {
+		return 0, false
+	}
+	slot, err := strconv.Atoi(v)
+	if err != nil || slot < 1 {
+		return 0, false
+	}
+	return slot, true
+}
+
+// ciExecStdin keeps 'wt exec --ci' from handing a terminal to the command,
+// so devcontainer exec does not allocate a TTY. Piped input is kept.
+func ciExecStdin() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	os.Stdin = devNull
+	return nil
+}
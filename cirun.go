@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ciRun is a workflow run triggered by 'wt ci run', saved in the worktree's
+// state so it can be found again.
+type ciRun struct {
+	Provider  string    `json:"provider,omitempty"`
+	ID        int64     `json:"id"`
+	Ref       string    `json:"ref,omitempty"` // provider-specific handle, e.g. a Bitbucket pipeline UUID
+	URL       string    `json:"url"`
+	Workflow  string    `json:"workflow"`
+	Branch    string    `json:"branch"`
+	Commit    string    `json:"commit"`
+	Triggered time.Time `json:"triggered"`
+}
+
+// ciRunOptions are the flags of 'wt ci run'.
+type ciRunOptions struct {
+	workflow string
+	fields   []string // workflow_dispatch inputs as key=value
+	push     bool     // push the branch to origin first
+	watch    bool     // stream the run's progress until it finishes
+}
+
+func ciRunsFile(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "ci-runs.json"), nil
+}
+
+func loadCIRuns(dir string) ([]ciRun, error) {
+	path, err := ciRunsFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var runs []ciRun
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return runs, nil
+}
+
+func saveCIRun(dir string, run ciRun) error {
+	runs, err := loadCIRuns(dir)
+	if err != nil {
+		return err
+	}
+	path, err := ciRunsFile(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(append(runs, run), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// defaultWorkflow picks the workflow to run when none is configured: the
+// only file in .github/workflows, if there is exactly one.
+func defaultWorkflow(dir string) (string, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, ".github", "workflows", pattern))
+		files = append(files, matches...)
+	}
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("no workflows in .github/workflows")
+	case 1:
+		return filepath.Base(files[0]), nil
+	default:
+		names := make([]string, len(files))
+		for i, f := range files {
+			names[i] = filepath.Base(f)
+		}
+		return "", fmt.Errorf("several workflows found (%s); choose one with --workflow or ci.workflow in %s", strings.Join(names, ", "), projectConfigFile)
+	}
+}
+
+// remoteBranchHead returns the commit origin has for branch, or "" if the
+// branch was never pushed.
+func remoteBranchHead(dir, branch string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "ls-remote", "origin", "refs/heads/"+branch).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query origin: %w", err)
+	}
+	sha, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	return sha, nil
+}
+
+// runCIRun triggers CI for the worktree's branch on the repository's forge,
+// records the resulting run, and optionally watches it.
+func runCIRun(dir string, opts ciRunOptions) error {
+	if offline {
+		return errOffline("wt ci run")
+	}
+	f, err := detectForge(dir)
+	if err != nil {
+		return err
+	}
+	st := getWorktreeStatus(dir)
+	if st.branch == "" {
+		return fmt.Errorf("%s is detached; CI runs against a pushed branch, so check one out first", filepath.Base(dir))
+	}
+	head, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	commit := strings.TrimSpace(string(head))
+
+	if opts.push {
+		pushCmd := exec.Command("git", "-C", dir, "push", "-u", "origin", st.branch)
+		pushCmd.Stdout = os.Stderr
+		pushCmd.Stderr = os.Stderr
+		if err := pushCmd.Run(); err != nil {
+			return fmt.Errorf("git push failed: %w", err)
+		}
+	}
+	remote, err := remoteBranchHead(dir, st.branch)
+	if err != nil {
+		return err
+	}
+	switch {
+	case remote == "":
+		return fmt.Errorf("branch %s is not on origin; push it first (or use --push)", st.branch)
+	case remote != commit:
+		warnf("", "origin/%s is at %.7s but the worktree is at %.7s; CI runs what was pushed", st.branch, remote, commit)
+	}
+
+	run, err := f.triggerCI(dir, st.branch, opts)
+	if err != nil {
+		return err
+	}
+	run.Provider = f.name()
+	run.Commit = remote
+	if err := saveCIRun(dir, run); err != nil {
+		warnf("", "failed to record run: %v", err)
+	}
+	fmt.Println(run.URL)
+
+	if !opts.watch {
+		return nil
+	}
+	return f.watchCI(dir, run)
+}
+
+// findDispatchedRun waits for the run created by a workflow dispatch to show
+// up; gh workflow run does not report it.
+func findDispatchedRun(dir, workflow, branch string, since time.Time) (ciRun, error) {
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		out, err := exec.Command("gh", "run", "list", "--workflow", workflow, "--branch", branch,
+			"--event", "workflow_dispatch", "--limit", "5", "--json", "databaseId,url,createdAt").Output()
+		if err == nil {
+			var runs []struct {
+				DatabaseID int64     `json:"databaseId"`
+				URL        string    `json:"url"`
+				CreatedAt  time.Time `json:"createdAt"`
+			}
+			if json.Unmarshal(out, &runs) == nil {
+				for _, r := range runs {
+					// Allow for clock skew between this host and GitHub.
+					if r.CreatedAt.After(since.Add(-10 * time.Second)) {
+						return ciRun{ID: r.DatabaseID, URL: r.URL, Workflow: workflow, Branch: branch, Triggered: since}, nil
+					}
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return ciRun{}, fmt.Errorf("triggered %s but could not find the run; check 'gh run list --workflow %s'", workflow, workflow)
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
+
+func runCIList(dir string) error {
+	runs, err := loadCIRuns(dir)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		fmt.Fprintf(os.Stderr, "No CI runs recorded for %s; start one with 'wt ci run'\n", filepath.Base(dir))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRIGGERED\tWORKFLOW\tBRANCH\tCOMMIT\tURL")
+	for _, r := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.7s\t%s\n", r.Triggered.Local().Format(time.DateTime), r.Workflow, r.Branch, r.Commit, r.URL)
+	}
+	return w.Flush()
+}
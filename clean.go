@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cleanInHost      = "host"
+	cleanInContainer = "container"
+)
+
+// cleanProtected are never removed by 'wt clean': the env files wt copies
+// and renders, and the per-worktree browser and editor profiles.
+var cleanProtected = []string{".env*", ".devcontainer/.env", ".vscode-profile/", ".chrome-profile/"}
+
+// cleanGitArgs returns the 'git clean' arguments that remove untracked and
+// ignored files except the protected ones: the built-in list, add.like and
+// add.copy patterns, shared directories, and clean.protect.
+func cleanGitArgs(cfg *Config, dryRun bool) []string {
+	args := []string{"clean", "-x", "-d"}
+	if dryRun {
+		args = append(args, "-n")
+	} else {
+		args = append(args, "-f")
+	}
+	var patterns []string
+	patterns = append(patterns, cleanProtected...)
+	patterns = append(patterns, cfg.Add.Like...)
+	for _, e := range cfg.Add.Copy {
+		patterns = append(patterns, e.Path)
+	}
+	for _, e := range cfg.Share {
+		patterns = append(patterns, "/"+strings.TrimPrefix(e.Path, "/"))
+	}
+	patterns = append(patterns, cfg.Clean.Protect...)
+	for _, p := range patterns {
+		// With -x, git still honors -e patterns as ignore rules, so matching
+		// files are kept.
+		args = append(args, "-e", p)
+	}
+	return args
+}
+
+// runClean slims the worktree at dir without removing it: the clean.commands
+// from .wt.yaml run (in the devcontainer, unless clean.in is "host"), then
+// 'git clean -xdf' when clean.git is set or no commands are configured.
+func runClean(dir string, dryRun, yes bool) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	useGit := cfg.Clean.Git || len(cfg.Clean.Commands) == 0
+	inContainer := false
+	if cfg.Clean.In != cleanInHost {
+		if _, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json")); err == nil {
+			inContainer = true
+		}
+	}
+
+	if useGit {
+		preview := exec.Command("git", cleanGitArgs(cfg, true)...)
+		preview.Dir = dir
+		out, err := preview.Output()
+		if err != nil {
+			return fmt.Errorf("git clean failed: %w", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) == 1 && lines[0] == "" {
+			lines = nil
+		}
+		if dryRun || verbose {
+			for _, l := range lines {
+				fmt.Println(l)
+			}
+		}
+		if len(lines) == 0 {
+			fmt.Fprintln(os.Stderr, "git clean: nothing to remove")
+			useGit = false
+		} else if !dryRun && !yes {
+			ok, err := promptYesNo(fmt.Sprintf("Remove %d untracked and ignored paths from %s?", len(lines), filepath.Base(dir)), false)
+			if err != nil {
+				return fmt.Errorf("%w; pass --yes to clean without asking", err)
+			}
+			if !ok {
+				return fmt.Errorf("aborted")
+			}
+		}
+	}
+	if dryRun {
+		for _, command := range cfg.Clean.Commands {
+			where := cleanInHost
+			if inContainer {
+				where = cleanInContainer
+			}
+			fmt.Printf("would run (%s): %s\n", where, command)
+		}
+		return nil
+	}
+
+	before := dirSizes(dir)["."]
+	for i, command := range cfg.Clean.Commands {
+		fmt.Fprintf(os.Stderr, "==> [%d/%d] %s\n", i+1, len(cfg.Clean.Commands), command)
+		var c *exec.Cmd
+		if inContainer {
+			c = exec.Command("devcontainer", "exec", "--workspace-folder", dir, "/bin/sh", "-c", command)
+			c.Env = append(os.Environ(), "DOCKER_CLI_HINTS=false")
+		} else {
+			c = exec.Command("/bin/sh", "-c", command)
+			c.Dir = dir
+		}
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("clean command %q failed: %w", command, err)
+		}
+	}
+	if useGit {
+		c := exec.Command("git", cleanGitArgs(cfg, false)...)
+		c.Dir = dir
+		c.Stderr = os.Stderr
+		if verbose {
+			c.Stdout = os.Stdout
+		}
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("git clean failed: %w", err)
+		}
+	}
+	after := dirSizes(dir)["."]
+	freed := before - after
+	if freed < 0 {
+		freed = 0
+	}
+	fmt.Fprintf(os.Stderr, "Freed %s; %s now holds %s of files\n", formatSize(freed), filepath.Base(dir), formatSize(after))
+	// Report a worktree still over its quota, but never fail the clean.
+	return checkQuota(dir, QuotaConfig{Max: cfg.Quota.Max})
+}
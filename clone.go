@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// cloneShape describes how much of origin the local repository holds.
+type cloneShape struct {
+	shallow bool   // history is cut off (git clone --depth)
+	filter  string // partial clone filter, e.g. "blob:none"; empty for a full clone
+}
+
+func detectCloneShape() cloneShape {
+	var shape cloneShape
+	if out, err := exec.Command("git", "rev-parse", "--is-shallow-repository").Output(); err == nil {
+		shape.shallow = strings.TrimSpace(string(out)) == "true"
+	}
+	if out, err := exec.Command("git", "config", "--get", "remote.origin.partialclonefilter").Output(); err == nil {
+		shape.filter = strings.TrimSpace(string(out))
+	}
+	return shape
+}
+
+// fetchArgs returns the extra 'git fetch' arguments for a clone of this
+// shape. add.fetchFilter turns a full clone into a partial one on the first
+// fetch (git records the filter for later fetches); add.fetchDepth bounds
+// how much history a shallow clone pulls in.
+func (shape cloneShape) fetchArgs(cfg AddConfig) []string {
+	var args []string
+	if cfg.FetchFilter != "" && cfg.FetchFilter != shape.filter {
+		args = append(args, "--filter="+cfg.FetchFilter)
+	}
+	if shape.shallow && cfg.FetchDepth > 0 {
+		args = append(args, "--depth="+strconv.Itoa(cfg.FetchDepth))
+	}
+	return args
+}
+
+// ensureBaseAvailable makes sure base resolves to a commit, fetching it from
+// origin on demand. Shallow and single-branch clones often lack the ref a
+// worktree should start from; "origin/<branch>" is fetched into its
+// remote-tracking ref, anything else into FETCH_HEAD. It returns the ref to
+// pass to 'git worktree add'.
+func ensureBaseAvailable(base string, shape cloneShape, cfg AddConfig) (string, error) {
+	if exec.Command("git", "rev-parse", "--verify", "--quiet", base+"^{commit}").Run() == nil {
+		return base, nil
+	}
+	if err := exec.Command("git", "remote", "get-url", "origin").Run(); err != nil {
+		return base, nil
+	}
+	if offline {
+		return "", errOffline(fmt.Sprintf("%s is not in the local clone; fetching it", base))
+	}
+	args := []string{"fetch"}
+	if shape.shallow {
+		depth := cfg.FetchDepth
+		if depth <= 0 {
+			depth = 1
+		}
+		args = append(args, "--depth="+strconv.Itoa(depth))
+	}
+	ref := base
+	if branch, ok := strings.CutPrefix(base, "origin/"); ok {
+		args = append(args, "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch)
+	} else {
+		args = append(args, "origin", base)
+		ref = "FETCH_HEAD"
+	}
+	fmt.Fprintf(os.Stderr, "%s is not in the local clone; fetching it from origin\n", base)
+	fetchCmd := exec.Command("git", args...)
+	fetchCmd.Stdout = os.Stdout
+	fetchCmd.Stderr = os.Stderr
+	if err := fetchCmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to fetch %s from origin: %w", base, err)
+	}
+	return ref, nil
+}
+
+// deepenClone fetches n more commits of history into a shallow clone.
+func deepenClone(n int) error {
+	fetchCmd := exec.Command("git", "fetch", "--deepen="+strconv.Itoa(n), "origin")
+	fetchCmd.Stdout = os.Stdout
+	fetchCmd.Stderr = os.Stderr
+	if err := fetchCmd.Run(); err != nil {
+		return fmt.Errorf("git fetch --deepen failed: %w", err)
+	}
+	return nil
+}
+
+// repoNameFromURL returns the directory name git clone would pick for url:
+// its last path component without ".git".
+func repoNameFromURL(url string) string {
+	url = strings.TrimRight(url, "/")
+	if i := strings.LastIndexAny(url, "/:"); i >= 0 {
+		url = url[i+1:]
+	}
+	return strings.TrimSuffix(url, ".git")
+}
+
+// runClone implements 'wt clone': it clones url into dir (default: the
+// repository name) with any extra 'git clone' arguments, configures it for
+// sibling worktrees, and optionally scaffolds a devcontainer.
+func runClone(url, dir string, gitArgs []string, initDevcontainer bool) error {
+	if dir == "" {
+		dir = repoNameFromURL(url)
+	}
+	if dir == "" {
+		return fmt.Errorf("cannot derive a directory name from %q; pass one", url)
+	}
+	if strings.Contains(filepath.Base(dir), worktreeDelimiter) {
+		return fmt.Errorf("%q contains %q, which wt reserves for worktree directories (repo%sname); choose another directory", dir, worktreeDelimiter, worktreeDelimiter)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(abs); err == nil {
+		return fmt.Errorf("%s already exists", abs)
+	}
+	if offline {
+		return errOffline("cloning")
+	}
+	args := append(append([]string{"clone"}, gitArgs...), url, abs)
+	clone := exec.Command("git", args...)
+	clone.Stdout = os.Stderr
+	clone.Stderr = os.Stderr
+	if err := clone.Run(); err != nil {
+		return fmt.Errorf("git clone failed: %w", err)
+	}
+	// Relative worktree links keep working inside devcontainers, which
+	// mount the worktree at another path.
+	if out, err := exec.Command("git", "-C", abs, "config", "worktree.useRelativePaths", "true").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set worktree.useRelativePaths: %s", strings.TrimSpace(string(out)))
+	}
+	if err := os.Chdir(abs); err != nil {
+		return err
+	}
+	registerRepo(abs)
+
+	_, statErr := os.Stat(filepath.Join(abs, ".devcontainer"))
+	hasDevcontainer := statErr == nil
+	switch {
+	case initDevcontainer && hasDevcontainer:
+		fmt.Fprintln(os.Stderr, "The repository already has a .devcontainer/; skipping wt init")
+	case initDevcontainer:
+		if err := runInit(&cobra.Command{}, nil); err != nil {
+			return err
+		}
+		hasDevcontainer = true
+	}
+
+	name := filepath.Base(abs)
+	fmt.Fprintf(os.Stderr, "\nCloned into %s. Worktrees go next to it, e.g. %s%sfeature-x:\n", abs, name, worktreeDelimiter)
+	fmt.Fprintf(os.Stderr, "  cd %s\n  wt add feature-x\n", abs)
+	if !hasDevcontainer {
+		fmt.Fprintln(os.Stderr, "Run 'wt init' there to scaffold a devcontainer.")
+	}
+	if _, err := recordCDTarget(abs); err != nil {
+		return err
+	}
+	fmt.Println(abs)
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on this platform; callers copy the bytes.
+func cloneFile(src, dst string, perm os.FileMode) error {
+	return errors.ErrUnsupported
+}
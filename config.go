@@ -0,0 +1,665 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigFile is the name of the per-repository config file, read from
+// the root of the main repository.
+const projectConfigFile = ".wt.yaml"
+
+// Config holds the per-repository wt settings loaded from .wt.yaml.
+type Config struct {
+	Env          EnvConfig          `yaml:"env"`
+	CD           CDConfig           `yaml:"cd"`
+	Add          AddConfig          `yaml:"add"`
+	Chrome       ChromeConfig       `yaml:"chrome"`
+	Editor       EditorConfig       `yaml:"editor"`
+	Exec         ExecConfig         `yaml:"exec"`
+	Up           RunPolicyConfig    `yaml:"up"`
+	Build        RunPolicyConfig    `yaml:"build"`
+	CI           CIConfig           `yaml:"ci"`
+	Forge        ForgeConfig        `yaml:"forge"`
+	Cache        CacheConfig        `yaml:"cache"`
+	Proxy        ProxyConfig        `yaml:"proxy"`
+	Schedule     ScheduleConfig     `yaml:"schedule"`
+	Terminal     TerminalConfig     `yaml:"terminal"`
+	HostServices HostServicesConfig `yaml:"hostServices"`
+	Quota        QuotaConfig        `yaml:"quota"`
+	Clean        CleanConfig        `yaml:"clean"`
+	ShipWt       ShipConfig         `yaml:"shipWt"`
+	Credentials  CredentialsConfig  `yaml:"credentials"`
+	Terraform    TerraformConfig    `yaml:"terraform"`
+	Naming       NamingConfig       `yaml:"naming"`
+	Ls           LsConfig           `yaml:"ls"`
+	Policy       PolicyConfig       `yaml:"policy"`
+	// SharedServices are containers, such as a local registry or an S3
+	// mock, that run once on the host for all worktrees.
+	SharedServices []SharedService `yaml:"sharedServices"`
+	// Share lists heavy directories such as node_modules or .venv that all
+	// worktrees share instead of each building its own.
+	Share []ShareEntry `yaml:"share"`
+	// Offline keeps wt off the network for this repository, as if --offline
+	// were always given: no fetches from origin and no image pulls.
+	Offline bool `yaml:"offline"`
+	// Extends names a shared config, e.g. git@github.com:org/wt-config (a
+	// git repository, optionally #ref) or ../wt-config (a directory). Its
+	// .wt.yaml supplies defaults that this file overrides key by key, and
+	// its .wt/template and .wt/hooks are used when the project has none.
+	Extends string `yaml:"extends"`
+
+	// extendsDir is the local directory of Extends.
+	extendsDir string
+}
+
+// CredentialsConfig gives each worktree's 'wt exec' sessions their own
+// cloud credentials, so that parallel branches don't act on each other's
+// resources and what they do can be told apart.
+type CredentialsConfig struct {
+	AWS  AWSCredentialsConfig  `yaml:"aws"`
+	Kube KubeCredentialsConfig `yaml:"kube"`
+}
+
+// KubeCredentialsConfig pins each worktree to one Kubernetes context and
+// namespace through a kubeconfig of its own. Both fields may use the
+// placeholders {name} and {repo}; setting either enables it.
+type KubeCredentialsConfig struct {
+	// Context is the host context the worktree uses (default the current
+	// one), e.g. "kind-{name}".
+	Context string `yaml:"context"`
+	// Namespace is the namespace pinned in that context, e.g.
+	// "{repo}-{name}".
+	Namespace string `yaml:"namespace"`
+}
+
+// AWSCredentialsConfig scopes AWS credentials per worktree. The fields
+// Profile and SessionName may use the placeholders {name} and {repo}.
+type AWSCredentialsConfig struct {
+	// RoleARN is assumed on the host for each worktree; the session gets
+	// the resulting short-lived keys.
+	RoleARN string `yaml:"roleArn"`
+	// Profile is the AWS profile. With RoleARN it is the host profile used
+	// to assume the role; without, it is passed on as AWS_PROFILE, e.g.
+	// "dev-{name}".
+	Profile string `yaml:"profile"`
+	// SessionName names the role session (default "wt-{repo}-{name}"), so
+	// CloudTrail shows which worktree acted.
+	SessionName string `yaml:"sessionName"`
+	// Duration is the lifetime of assumed-role keys (default 1h).
+	Duration time.Duration `yaml:"duration"`
+	// Region sets AWS_REGION.
+	Region string `yaml:"region"`
+}
+
+// TerraformConfig binds each worktree to its own Terraform workspace, so that
+// 'terraform plan' in parallel worktrees works on separate state. Workspace
+// and BackendKey may use the placeholders {name} and {repo}.
+type TerraformConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Workspace is set as TF_WORKSPACE (default "{repo}-{name}").
+	Workspace string `yaml:"workspace"`
+	// BackendKey, e.g. "{repo}/{name}/terraform.tfstate", is passed to
+	// 'terraform init' as -backend-config=key=..., for backends that keep
+	// each worktree's state under a key of its own instead.
+	BackendKey string `yaml:"backendKey"`
+}
+
+// LsConfig configures 'wt ls'.
+type LsConfig struct {
+	// Base is the ref 'wt ls --base' compares worktrees with (default: what
+	// origin/HEAD points at, e.g. origin/main).
+	Base string `yaml:"base"`
+}
+
+// NamingConfig is the team's convention for new worktree names, e.g. a
+// type prefix as in repo@feat-login. A name must satisfy both rules when
+// both are set. Existing worktrees are not affected.
+type NamingConfig struct {
+	// Prefixes lists the allowed name prefixes, e.g. [feat-, fix-, exp-].
+	Prefixes []string `yaml:"prefixes"`
+	// Pattern is a regular expression the whole name must match.
+	Pattern string `yaml:"pattern"`
+}
+
+// SharedService is a container wt keeps running for all worktrees and joins
+// every devcontainer to.
+type SharedService struct {
+	// Name identifies the service; its container is wt-svc-<name>.
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+	// Port is the port devcontainers reach it on, exported to them as
+	// WT_SERVICE_<NAME>=wt-svc-<name>:<port>.
+	Port int `yaml:"port"`
+	// Env sets variables in the service container.
+	Env []string `yaml:"env"`
+	// Command overrides the image's command.
+	Command []string `yaml:"command"`
+	// Volumes are directories in the service container kept in named
+	// volumes.
+	Volumes []string `yaml:"volumes"`
+	// Publish maps ports on the host, as in 'docker run -p', e.g.
+	// "5000:5000".
+	Publish []string `yaml:"publish"`
+}
+
+// ShipConfig puts the wt binary into each devcontainer on 'wt up', so that
+// commands in the container can call 'wt name', 'wt dir', or 'wt ports'.
+type ShipConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Binaries maps a container architecture ("amd64", "arm64") to a
+	// linux wt binary, absolute or relative to the main repository. It is
+	// needed when the running wt can't run in the container, e.g. on macOS.
+	Binaries map[string]string `yaml:"binaries"`
+}
+
+// ShareEntry is one directory shared by all worktrees. It is written either
+// as a plain worktree-relative path, or as a mapping with the path and a
+// mode.
+type ShareEntry struct {
+	// Path is relative to the worktree root, e.g. "node_modules".
+	Path string `yaml:"path"`
+	// Mode is "link" (default), which makes the path a symlink to the shared
+	// directory on 'wt add', or "mount", which bind-mounts the shared
+	// directory over it in the devcontainer on 'wt up'.
+	Mode string `yaml:"mode"`
+}
+
+// UnmarshalYAML accepts both the plain path and the mapping form.
+func (e *ShareEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&e.Path)
+	}
+	type plain ShareEntry
+	return value.Decode((*plain)(e))
+}
+
+func (e ShareEntry) mode() string {
+	if e.Mode == "" {
+		return shareModeLink
+	}
+	return e.Mode
+}
+
+// CleanConfig controls 'wt clean'.
+type CleanConfig struct {
+	// Commands run in the worktree's devcontainer (on the host without one),
+	// e.g. "make clean".
+	Commands []string `yaml:"commands"`
+	// In is "container" (default when the worktree has a devcontainer) or
+	// "host".
+	In string `yaml:"in"`
+	// Git also runs 'git clean -xdf' after Commands; it always runs when no
+	// commands are configured.
+	Git bool `yaml:"git"`
+	// Protect lists extra patterns (gitignore syntax) git clean keeps, on top
+	// of env files, wt's profile directories, and add.like patterns.
+	Protect []string `yaml:"protect"`
+}
+
+// QuotaConfig limits the disk space of each worktree (its files plus its
+// devcontainer's writable layer and volumes) and how many worktrees a
+// repository has.
+type QuotaConfig struct {
+	// Max is the size limit, e.g. "20GiB" or "500MB". Empty means no quota.
+	Max string `yaml:"max"`
+	// Worktrees is the most sibling worktrees 'wt add' creates before it
+	// suggests removing the least recently used. 0 means no limit.
+	Worktrees int `yaml:"worktrees"`
+	// Action is "warn" (default) or "block", which makes 'wt up' and
+	// 'wt exec' refuse to run in a worktree over the quota, and 'wt add'
+	// refuse to go over quota.worktrees.
+	Action string `yaml:"action"`
+}
+
+// PolicyConfig holds guardrails that wt enforces. Each refuses the command
+// with a message saying why, unless --force is given; such overrides are
+// recorded in wt's audit log. Set in the shared config of extends, they
+// cannot be loosened by the project's .wt.yaml.
+type PolicyConfig struct {
+	// RmRequiresClean makes 'wt rm' refuse worktrees with uncommitted
+	// changes or untracked files.
+	RmRequiresClean bool `yaml:"rmRequiresClean"`
+	// MaxWorktrees is the most sibling worktrees a repository may have;
+	// 'wt add' refuses to go over it. 0 means no limit.
+	MaxWorktrees int `yaml:"maxWorktrees"`
+	// AllowedImages are the base images devcontainers may use, as patterns
+	// such as "mcr.microsoft.com/devcontainers/*"; 'wt up' checks the
+	// devcontainer.json image or the FROM lines of its Dockerfile.
+	AllowedImages []string `yaml:"allowedImages"`
+	// Contact is added to every refusal, e.g. "ask in #platform".
+	Contact string `yaml:"contact"`
+
+	// sharedImages is the extends source's allowedImages, which images
+	// must match as well when the project sets its own.
+	sharedImages []string
+}
+
+// HostServicesConfig lets code in every worktree's container reach services
+// running on the host through a fixed host name, whatever the container
+// runtime's gateway is.
+type HostServicesConfig struct {
+	// Alias is the host name containers use for the host (default
+	// host.wt.internal).
+	Alias string `yaml:"alias"`
+	// Services are the host services exported to containers as
+	// WT_HOST_<NAME>=<alias>:<port>.
+	Services []HostService `yaml:"services"`
+}
+
+// HostService is a service listening on the host.
+type HostService struct {
+	Name string `yaml:"name"`
+	Port int    `yaml:"port"`
+	// PerWorktree adds the worktree's port offset (slot * env.portStride) to
+	// Port, for host services started once per worktree.
+	PerWorktree bool `yaml:"perWorktree"`
+}
+
+// TerminalConfig labels the terminal while 'wt cd' or 'wt exec' runs an
+// interactive shell.
+type TerminalConfig struct {
+	// Title sets the window/tab title, restoring the previous one on exit.
+	Title bool `yaml:"title"`
+	// Badge sets the iTerm2 badge and the WezTerm user variable wt_worktree.
+	Badge bool `yaml:"badge"`
+	// Format is the label, with the placeholders {name} and {repo}
+	// (default "{name}").
+	Format string `yaml:"format"`
+}
+
+// ScheduleConfig controls the maintenance installed by 'wt schedule'.
+type ScheduleConfig struct {
+	// At is the daily time of day ("HH:MM", default 03:00).
+	At string `yaml:"at"`
+	// Tasks selects what runs: fetch, update, prune, and gc (default all).
+	Tasks []string `yaml:"tasks"`
+}
+
+// ProxyConfig sets the container ports of the proxies in the devcontainers,
+// e.g. "1080" or "53/udp", overriding container labels and devcontainer.json.
+type ProxyConfig struct {
+	SOCKS5 string `yaml:"socks5"`
+	HTTP   string `yaml:"http"`
+	DNS    string `yaml:"dns"`
+}
+
+// CacheConfig enables the shared dependency caches started by 'wt cache'.
+type CacheConfig struct {
+	// Services lists the caches devcontainers use: "apt", "npm", and "pip".
+	Services []string `yaml:"services"`
+}
+
+// ForgeConfig selects the code hosting provider for CI integrations.
+type ForgeConfig struct {
+	// Provider is "github", "gitlab", or "bitbucket". When empty it is
+	// guessed from the origin remote's host, defaulting to GitHub.
+	Provider string `yaml:"provider"`
+}
+
+// CIConfig controls 'wt ci'.
+type CIConfig struct {
+	// Workflow is the GitHub workflow file name (e.g. "test.yml") 'wt ci run'
+	// dispatches. Bitbucket uses --workflow as a custom pipeline name.
+	Workflow string `yaml:"workflow"`
+}
+
+// ExecConfig controls 'wt exec'.
+type ExecConfig struct {
+	RunPolicyConfig `yaml:",inline"`
+	// Log tees the output of every 'wt exec' command into a timestamped file
+	// under the worktree's state directory, as if --log-file were given.
+	Log bool `yaml:"log"`
+	// Prompt prefixes the prompt of interactive 'wt exec' shells with the
+	// worktree name and the last failing exit status.
+	Prompt bool `yaml:"prompt"`
+	// MaxTime is the wall-clock limit enforced inside the container on every
+	// 'wt exec' command, as if --max-time were given. Zero means no limit.
+	MaxTime time.Duration `yaml:"maxTime"`
+	// Env controls which host environment variables reach 'wt exec'
+	// sessions.
+	Env ExecEnvConfig `yaml:"env"`
+}
+
+// ExecEnvConfig filters the host environment of 'wt exec' sessions.
+type ExecEnvConfig struct {
+	// Allow lists variable patterns (shell globs) let through; when set,
+	// everything else is dropped except the few wt itself needs (PATH,
+	// HOME, DOCKER_*, ...). When empty, every variable is let through.
+	Allow []string `yaml:"allow"`
+	// Deny lists variable patterns that are always dropped, e.g. "AWS_*".
+	Deny []string `yaml:"deny"`
+}
+
+// RunPolicyConfig sets the default timeout and retry policy of a
+// container-backed command; --timeout, --retries, and --retry-on override it.
+type RunPolicyConfig struct {
+	// Timeout kills an attempt that runs longer, e.g. "10m". Zero means no
+	// limit.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is how many times a failed or timed-out command is rerun.
+	Retries int `yaml:"retries"`
+	// RetryDelay is the pause between attempts (default 5s).
+	RetryDelay time.Duration `yaml:"retryDelay"`
+	// RetryOn is a regexp; a failed attempt is retried only when its output
+	// matches, e.g. "ECONNRESET|flaky". Empty retries any failure.
+	RetryOn string `yaml:"retryOn"`
+}
+
+// EditorConfig controls 'wt code' and 'wt add --code'.
+type EditorConfig struct {
+	// Command is the editor to run, with optional arguments, e.g. "cursor".
+	// Overrides $VISUAL; defaults to "code".
+	Command string `yaml:"command"`
+	// Attach selects how VS Code-based editors open a worktree with a
+	// devcontainer: "auto" (default), "container", or "host".
+	Attach string `yaml:"attach"`
+	// Args are extra arguments passed to the editor before the folder.
+	Args []string `yaml:"args"`
+	// Extensions are VS Code extension IDs installed for every worktree on
+	// top of devcontainer.json's: into the attached container, or into a
+	// "wt-<repo>" VS Code profile when opening on the host.
+	Extensions []string `yaml:"extensions"`
+}
+
+// ChromeConfig controls 'wt chrome'.
+type ChromeConfig struct {
+	// DownloadDir is where the worktree's Chrome saves downloads: empty for
+	// <worktree>/.downloads, "state" for the worktree's wt state directory,
+	// "artifacts" for its artifacts directory, a relative path inside the worktree, or an absolute directory under
+	// which a per-worktree subdirectory is used.
+	DownloadDir string `yaml:"downloadDir"`
+}
+
+// AddConfig controls 'wt add'.
+type AddConfig struct {
+	// Bootstrap lists shell commands run in each new worktree after it is
+	// created, e.g. "npm ci" or "make generate".
+	Bootstrap []string `yaml:"bootstrap"`
+	// BootstrapIn selects where bootstrap commands run: "host" (default) or
+	// "container", which starts the devcontainer first.
+	BootstrapIn string `yaml:"bootstrapIn"`
+	// Like lists glob patterns ("**" matches any directories) of untracked or
+	// ignored files copied by 'wt add --like <worktree>'.
+	Like []string `yaml:"like"`
+	// Copy lists glob patterns of untracked or ignored files that every
+	// 'wt add' copies from the worktree it runs in, on top of the env files.
+	Copy []CopyEntry `yaml:"copy"`
+	// Template is the directory whose contents seed every new worktree,
+	// relative to the main repository (default .wt/template).
+	Template string `yaml:"template"`
+	// Sparse lists the directories new worktrees check out (cone-mode sparse
+	// checkout), unless 'wt add --sparse' remembered other ones.
+	Sparse []string `yaml:"sparse"`
+	// NamePattern generates names for 'wt add' without a name, using the
+	// placeholders {adjective}, {noun}, {date}, {time}, and {rand}.
+	NamePattern string `yaml:"namePattern"`
+	// FetchMaxAge lets 'wt add' skip fetching the remotes when another wt
+	// process fetched more recently than this (default 10s).
+	FetchMaxAge time.Duration `yaml:"fetchMaxAge"`
+	// FetchFilter is a partial clone filter such as "blob:none" passed to
+	// 'git fetch'; git then downloads blobs only when checked out.
+	FetchFilter string `yaml:"fetchFilter"`
+	// FetchDepth limits how much history fetches into a shallow clone pull.
+	FetchDepth int `yaml:"fetchDepth"`
+	// FetchTimeout abandons the best-effort fetch of 'wt add' when it takes
+	// longer (default 30s), e.g. on a flaky network.
+	FetchTimeout time.Duration `yaml:"fetchTimeout"`
+	// IssueCommand prints the issue 'wt add --issue' names a worktree after,
+	// with {number} replaced: JSON with a title and url, or the title alone
+	// (default "gh issue view {number} --json number,title,url").
+	IssueCommand string `yaml:"issueCommand"`
+}
+
+// CopyEntry is one add.copy pattern. It is written either as a plain glob,
+// or as a mapping with the glob under path and an overwrite flag.
+type CopyEntry struct {
+	// Path is a glob such as "config/*.local.yaml" or "tmp/certs/**".
+	Path string `yaml:"path"`
+	// Overwrite replaces files that already exist in the new worktree (e.g.
+	// tracked defaults); by default they are left alone.
+	Overwrite bool `yaml:"overwrite"`
+	// Hardlink links the files instead of copying them, for large data
+	// that is only read (e.g. fixtures): a change to one worktree's file
+	// changes them all. Files that can't be linked are copied.
+	Hardlink bool `yaml:"hardlink"`
+}
+
+// UnmarshalYAML accepts both the plain glob and the mapping form.
+func (e *CopyEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&e.Path)
+	}
+	type plain CopyEntry
+	return value.Decode((*plain)(e))
+}
+
+func (c AddConfig) fetchMaxAge() time.Duration {
+	if c.FetchMaxAge > 0 {
+		return c.FetchMaxAge
+	}
+	return defaultFetchMaxAge
+}
+
+func (c AddConfig) fetchTimeout() time.Duration {
+	if c.FetchTimeout > 0 {
+		return c.FetchTimeout
+	}
+	return defaultFetchTimeout
+}
+
+// CDConfig controls 'wt cd'.
+type CDConfig struct {
+	// NoShell skips the subshell when the shell-init wrapper is loaded, so
+	// the calling shell changes directory immediately.
+	NoShell bool `yaml:"noShell"`
+}
+
+// EnvConfig controls which keys from copied .env files propagate into new
+// worktrees.
+type EnvConfig struct {
+	// Allow lists key patterns (shell globs) that are copied verbatim. When
+	// empty, every key is copied.
+	Allow []string `yaml:"allow"`
+	// Deny lists key patterns that are never copied as-is, even if allowed.
+	Deny []string `yaml:"deny"`
+	// Mode selects what happens to keys that are not allowed: "omit" drops
+	// the line, "redact" keeps the key with an empty value.
+	Mode string `yaml:"mode"`
+	// PortStride is the distance between the port offsets of consecutive
+	// worktrees when rendering .env.wt.tmpl (default 100).
+	PortStride int `yaml:"portStride"`
+}
+
+const defaultPortStride = 100
+
+func (c EnvConfig) portStride() int {
+	if c.PortStride > 0 {
+		return c.PortStride
+	}
+	return defaultPortStride
+}
+
+const (
+	envModeOmit   = "omit"
+	envModeRedact = "redact"
+)
+
+// loadConfig reads .wt.yaml from the main repository root. A missing file
+// yields an empty config.
+func loadConfig() (*Config, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	return loadConfigFile(filepath.Join(mainRoot, projectConfigFile))
+}
+
+func loadConfigFile(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var head struct {
+		Extends string `yaml:"extends"`
+	}
+	if err := yaml.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if head.Extends != "" {
+		if cfg.extendsDir, err = resolveExtends(head.Extends, filepath.Dir(path), false); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		basePath := filepath.Join(cfg.extendsDir, projectConfigFile)
+		if baseData, err := os.ReadFile(basePath); err == nil {
+			if err := yaml.Unmarshal(baseData, cfg); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", basePath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", basePath, err)
+		}
+	}
+	// Unmarshaling over the shared defaults keeps those this file does not
+	// set; lists are replaced as a whole.
+	shared := cfg.Policy
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	cfg.Policy = cfg.Policy.tightenedBy(shared)
+	cfg.Extends = head.Extends
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	switch c.Env.Mode {
+	case "", envModeOmit, envModeRedact:
+	default:
+		return fmt.Errorf("env.mode must be %q or %q, got %q", envModeOmit, envModeRedact, c.Env.Mode)
+	}
+	switch c.Add.BootstrapIn {
+	case "", bootstrapInHost, bootstrapInContainer:
+	default:
+		return fmt.Errorf("add.bootstrapIn must be %q or %q, got %q", bootstrapInHost, bootstrapInContainer, c.Add.BootstrapIn)
+	}
+	for name, p := range map[string]RunPolicyConfig{"exec": c.Exec.RunPolicyConfig, "up": c.Up, "build": c.Build} {
+		if p.Timeout < 0 || p.Retries < 0 || p.RetryDelay < 0 {
+			return fmt.Errorf("%s.timeout, %s.retries, and %s.retryDelay must not be negative", name, name, name)
+		}
+		if _, err := regexp.Compile(p.RetryOn); err != nil {
+			return fmt.Errorf("%s.retryOn: %w", name, err)
+		}
+	}
+	if c.Exec.MaxTime < 0 {
+		return fmt.Errorf("exec.maxTime must not be negative")
+	}
+	for _, e := range c.Add.Copy {
+		if e.Path == "" {
+			return fmt.Errorf("add.copy: every entry needs a path")
+		}
+	}
+	for _, e := range c.Share {
+		clean := filepath.Clean(e.Path)
+		if e.Path == "" || filepath.IsAbs(e.Path) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("share: %q must be a path inside the worktree", e.Path)
+		}
+		if e.mode() != shareModeLink && e.mode() != shareModeMount {
+			return fmt.Errorf("share: mode must be %q or %q, got %q", shareModeLink, shareModeMount, e.Mode)
+		}
+	}
+	if d := c.Credentials.AWS.Duration; d != 0 && (d < 15*time.Minute || d > 12*time.Hour) {
+		return fmt.Errorf("credentials.aws.duration must be between 15m and 12h")
+	}
+	if c.Naming.Pattern != "" {
+		if _, err := regexp.Compile(c.Naming.Pattern); err != nil {
+			return fmt.Errorf("naming.pattern: %w", err)
+		}
+	}
+	for _, p := range c.Naming.Prefixes {
+		if p == "" || validateWorktreeName(p) != nil {
+			return fmt.Errorf("naming.prefixes: %q is not a valid start of a worktree name", p)
+		}
+	}
+	if c.Add.FetchTimeout < 0 {
+		return fmt.Errorf("add.fetchTimeout must not be negative")
+	}
+	seenServices := map[string]bool{}
+	for _, svc := range c.SharedServices {
+		if !validSharedServiceName.MatchString(svc.Name) {
+			return fmt.Errorf("sharedServices: name %q must be lowercase letters, digits, and dashes", svc.Name)
+		}
+		if seenServices[svc.Name] {
+			return fmt.Errorf("sharedServices: %s is declared twice", svc.Name)
+		}
+		seenServices[svc.Name] = true
+		if svc.Image == "" {
+			return fmt.Errorf("sharedServices: %s needs an image", svc.Name)
+		}
+	}
+	for _, name := range c.Cache.Services {
+		if _, ok := cacheServices[name]; !ok {
+			return fmt.Errorf("cache.services: unknown cache %q (known: apt, npm, pip)", name)
+		}
+	}
+	if c.Quota.Max != "" {
+		if _, err := parseSize(c.Quota.Max); err != nil {
+			return fmt.Errorf("quota.max: %w", err)
+		}
+	}
+	switch c.Clean.In {
+	case "", cleanInHost, cleanInContainer:
+	default:
+		return fmt.Errorf("clean.in must be %q or %q, got %q", cleanInHost, cleanInContainer, c.Clean.In)
+	}
+	switch c.Quota.Action {
+	case "", quotaActionWarn, quotaActionBlock:
+	default:
+		return fmt.Errorf("quota.action must be %q or %q, got %q", quotaActionWarn, quotaActionBlock, c.Quota.Action)
+	}
+	if c.Quota.Worktrees < 0 {
+		return fmt.Errorf("quota.worktrees must not be negative, got %d", c.Quota.Worktrees)
+	}
+	if c.Policy.MaxWorktrees < 0 {
+		return fmt.Errorf("policy.maxWorktrees must not be negative, got %d", c.Policy.MaxWorktrees)
+	}
+	for _, p := range c.Policy.AllowedImages {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("policy.allowedImages: bad pattern %q", p)
+		}
+	}
+	if err := c.HostServices.validate(); err != nil {
+		return err
+	}
+	if c.Schedule.At != "" {
+		if _, _, err := scheduleTime(c.Schedule.At); err != nil {
+			return fmt.Errorf("schedule.at: %w", err)
+		}
+	}
+	if err := validateTasks(c.Schedule.Tasks); err != nil {
+		return fmt.Errorf("schedule.tasks: %w", err)
+	}
+	switch c.Forge.Provider {
+	case "", forgeGitHub, forgeGitLab, forgeBitbucket:
+	default:
+		return fmt.Errorf("forge.provider must be %q, %q, or %q, got %q", forgeGitHub, forgeGitLab, forgeBitbucket, c.Forge.Provider)
+	}
+	switch c.Editor.Attach {
+	case "", editorAttachAuto, editorAttachContainer, editorAttachHost:
+	default:
+		return fmt.Errorf("editor.attach must be %q, %q, or %q, got %q", editorAttachAuto, editorAttachContainer, editorAttachHost, c.Editor.Attach)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAWSSessionName = "wt-{repo}-{name}"
+	defaultAWSDuration    = time.Hour
+	// awsCredentialsFile caches a worktree's assumed-role keys in its
+	// state directory until shortly before they expire.
+	awsCredentialsFile = "aws-credentials.json"
+	// awsRefreshMargin is how long before expiry cached keys are renewed,
+	// so that a command started now doesn't lose them halfway.
+	awsRefreshMargin = 10 * time.Minute
+)
+
+// awsSessionNameInvalid matches what AWS does not accept in a role session
+// name.
+var awsSessionNameInvalid = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// awsCredentials are the keys of an assumed role, as printed by
+// 'aws sts assume-role'.
+type awsCredentials struct {
+	RoleARN         string    `json:"RoleArn,omitempty"`
+	SessionName     string    `json:"SessionName,omitempty"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// credentialEnv returns the env assignments that give 'wt exec' sessions in
+// the worktree's container their own cloud credentials. It is empty when no
+// credentials are configured.
+func credentialEnv(dir string, cfg CredentialsConfig) ([]string, error) {
+	env, err := awsCredentialEnv(dir, cfg.AWS)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Kube.enabled() {
+		// Regenerated each time, e.g. to pick up renewed tokens; the
+		// container sees it through the mount made by 'wt up'.
+		if _, err := writeKubeconfig(dir, cfg.Kube); err != nil {
+			return nil, err
+		}
+		env = append(env, "KUBECONFIG="+kubeconfigContainerPath)
+	}
+	return env, nil
+}
+
+// awsSessionName returns the role session name for the worktree at dir,
+// trimmed to what AWS accepts.
+func (c AWSCredentialsConfig) awsSessionName(dir string) string {
+	format := c.SessionName
+	if format == "" {
+		format = defaultAWSSessionName
+	}
+	name := awsSessionNameInvalid.ReplaceAllString(expandWorktreeFormat(format, dir), "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+func awsCredentialEnv(dir string, c AWSCredentialsConfig) ([]string, error) {
+	if c.RoleARN == "" && c.Profile == "" && c.Region == "" {
+		return nil, nil
+	}
+	sessionName := c.awsSessionName(dir)
+	var env []string
+	if c.Region != "" {
+		env = append(env, "AWS_REGION="+c.Region)
+	}
+	if c.RoleARN == "" {
+		// SDKs use AWS_ROLE_SESSION_NAME when the profile assumes a role.
+		if c.Profile != "" {
+			env = append(env, "AWS_PROFILE="+expandWorktreeFormat(c.Profile, dir), "AWS_ROLE_SESSION_NAME="+sessionName)
+		}
+		return env, nil
+	}
+	creds, err := assumeAWSRole(dir, c, sessionName)
+	if err != nil {
+		return nil, err
+	}
+	return append(env,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+creds.SessionToken,
+		"AWS_ROLE_SESSION_NAME="+sessionName,
+	), nil
+}
+
+// assumeAWSRole returns keys for the configured role in a session named
+// for the worktree at dir, from the cache in its state directory while they
+// remain valid, otherwise from 'aws sts assume-role'.
+func assumeAWSRole(dir string, c AWSCredentialsConfig, sessionName string) (*awsCredentials, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	cacheFile := filepath.Join(stateDir, awsCredentialsFile)
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		var cached awsCredentials
+		if json.Unmarshal(data, &cached) == nil && cached.RoleARN == c.RoleARN && cached.SessionName == sessionName &&
+			time.Until(cached.Expiration) > awsRefreshMargin {
+			return &cached, nil
+		}
+	}
+	duration := c.Duration
+	if duration == 0 {
+		duration = defaultAWSDuration
+	}
+	args := []string{"sts", "assume-role",
+		"--role-arn", c.RoleARN,
+		"--role-session-name", sessionName,
+		"--duration-seconds", fmt.Sprint(int(duration.Seconds())),
+		"--output", "json"}
+	if c.Profile != "" {
+		args = append(args, "--profile", c.Profile)
+	}
+	out, err := exec.Command("aws", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("failed to assume %s: %s", c.RoleARN, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to assume %s: %w", c.RoleARN, err)
+	}
+	var resp struct {
+		Credentials awsCredentials `json:"Credentials"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil || resp.Credentials.AccessKeyID == "" {
+		return nil, fmt.Errorf("failed to assume %s: unexpected output from 'aws sts assume-role'", c.RoleARN)
+	}
+	creds := resp.Credentials
+	creds.RoleARN = c.RoleARN
+	creds.SessionName = sessionName
+	if data, err := json.Marshal(creds); err == nil {
+		if err := os.WriteFile(cacheFile, data, 0600); err != nil {
+			warnf("", "failed to cache AWS credentials: %v", err)
+		}
+	}
+	return &creds, nil
+}
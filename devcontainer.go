@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// devcontainerConfig holds the subset of devcontainer.json fields wt reads.
+type devcontainerConfig struct {
+	AppPort      json.RawMessage `json:"appPort"`
+	ForwardPorts []any           `json:"forwardPorts"`
+	RunArgs      []string        `json:"runArgs"`
+	Image        string          `json:"image"`
+	Build        struct {
+		Dockerfile string `json:"dockerfile"`
+	} `json:"build"`
+	DockerFile string         `json:"dockerFile"` // the older spelling of build.dockerfile
+	Features   map[string]any `json:"features"`
+	// RemoteUser is the user commands and VS Code run as in the container;
+	// ContainerUser is the fallback, as in the devcontainer CLI.
+	RemoteUser      string                                `json:"remoteUser"`
+	ContainerUser   string                                `json:"containerUser"`
+	WorkspaceFolder string                                `json:"workspaceFolder"`
+	PortsAttributes map[string]devcontainerPortAttributes `json:"portsAttributes"`
+	Customizations  struct {
+		VSCode struct {
+			Extensions []string `json:"extensions"`
+		} `json:"vscode"`
+	} `json:"customizations"`
+}
+
+type devcontainerPortAttributes struct {
+	Label string `json:"label"`
+}
+
+// user returns the user commands run as in the container, or "" for the
+// image's default user.
+func (c *devcontainerConfig) user() string {
+	if c.RemoteUser != "" {
+		return c.RemoteUser
+	}
+	return c.ContainerUser
+}
+
+// forwardedPorts returns the forwardPorts entries as strings: a container
+// port such as "3000", or "service:port" for a compose service.
+func (c *devcontainerConfig) forwardedPorts() []string {
+	var ports []string
+	for _, p := range c.ForwardPorts {
+		switch v := p.(type) {
+		case float64:
+			ports = append(ports, strconv.Itoa(int(v)))
+		case string:
+			ports = append(ports, v)
+		}
+	}
+	return ports
+}
+
+// remoteWorkspaceFolder returns where the worktree at dir is mounted in the
+// container: workspaceFolder with ${localWorkspaceFolderBasename} expanded,
+// or /workspaces/<dir name> by default.
+func (c *devcontainerConfig) remoteWorkspaceFolder(dir string) string {
+	if c.WorkspaceFolder == "" {
+		return "/workspaces/" + filepath.Base(dir)
+	}
+	return strings.NewReplacer(
+		"${localWorkspaceFolderBasename}", filepath.Base(dir),
+		"${localWorkspaceFolder}", dir,
+	).Replace(c.WorkspaceFolder)
+}
+
+// readDevcontainerConfig parses .devcontainer/devcontainer.json in dir.
+// It returns nil without error when the file does not exist.
+func readDevcontainerConfig(dir string) (*devcontainerConfig, error) {
+	path := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cfg devcontainerConfig
+	if err := json.Unmarshal(stripJSONC(data), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// attachedContainerConfigDir is where VS Code's Dev Containers extension
+// keeps the per-container configurations it applies when attaching to a
+// container, relative to the user data directory.
+const attachedContainerConfigDir = "User/globalStorage/ms-vscode-remote.remote-containers/nameConfigs"
+
+// writeAttachedContainerConfig carries the devcontainer.json settings that
+// VS Code ignores when attaching to a running container (the extensions and
+// remoteUser) into the attached-container configuration of the worktree's
+// container under userDataDir, along with the extra extensions. Extensions
+// already listed there are kept.
+func writeAttachedContainerConfig(userDataDir, dir string, extra []string) error {
+	cfg, err := readDevcontainerConfig(dir)
+	if err != nil || cfg == nil {
+		return err
+	}
+	extensions := append(append([]string{}, cfg.Customizations.VSCode.Extensions...), extra...)
+	if len(extensions) == 0 && cfg.user() == "" {
+		return nil
+	}
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "inspect", "--format", "{{.Name}}", containerID).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(string(out)), "/")
+	path := filepath.Join(userDataDir, attachedContainerConfigDir, name+".json")
+
+	attached := map[string]any{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(stripJSONC(data), &attached); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	var merged []string
+	seen := map[string]bool{}
+	existing, _ := attached["extensions"].([]any)
+	for _, e := range existing {
+		if id, ok := e.(string); ok && !seen[strings.ToLower(id)] {
+			seen[strings.ToLower(id)] = true
+			merged = append(merged, id)
+		}
+	}
+	for _, id := range extensions {
+		if !seen[strings.ToLower(id)] {
+			seen[strings.ToLower(id)] = true
+			merged = append(merged, id)
+		}
+	}
+	if len(merged) > 0 {
+		attached["extensions"] = merged
+	}
+	if user := cfg.user(); user != "" {
+		attached["remoteUser"] = user
+	}
+	data, err := json.MarshalIndent(attached, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// stripJSONC removes // and /* */ comments and trailing commas from JSONC
+// content so it can be decoded with encoding/json.
+func stripJSONC(data []byte) []byte {
+	return stripTrailingCommas(stripJSONComments(data))
+}
+
+func stripJSONComments(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out.WriteByte('\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+func stripTrailingCommas(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		} else if c == ',' {
+			j := i + 1
+			for j < len(data) && (data[j] == ' ' || data[j] == '\t' || data[j] == '\n' || data[j] == '\r') {
+				j++
+			}
+			if j < len(data) && (data[j] == '}' || data[j] == ']') {
+				continue
+			}
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// doctorCheck is one diagnosis of 'wt doctor'. An empty problem means the
+// check passed; fix, when set, remedies the problem.
+type doctorCheck struct {
+	name    string
+	problem string
+	fixDesc string
+	fix     func() error
+}
+
+// completionWriter generates the completion script for a shell.
+type completionWriter func(shell string, w io.Writer) error
+
+// runDoctor diagnoses the wt setup of the current repository and machine.
+// With fix, each fixable problem is remedied after a confirmation (skipped
+// with yes). It exits 1 when problems remain.
+func runDoctor(genCompletion completionWriter, fix, yes bool) error {
+	var checks []doctorCheck
+	checks = append(checks, doctorToolChecks()...)
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		checks = append(checks, doctorRelativePaths(mainRoot), doctorPrunable(mainRoot), doctorStateDirs(mainRoot))
+		checks = append(checks, doctorContainers(mainRoot)...)
+	} else {
+		checks = append(checks, doctorCheck{name: "git repository", problem: "not inside a git repository; repository checks skipped"})
+	}
+	checks = append(checks, doctorCompletion(genCompletion))
+
+	remaining := 0
+	for _, c := range checks {
+		if c.problem == "" {
+			fmt.Printf("OK     %s\n", c.name)
+			continue
+		}
+		fmt.Printf("FAIL   %s: %s\n", c.name, c.problem)
+		if c.fix == nil || !fix {
+			remaining++
+			continue
+		}
+		if !yes {
+			if nonInteractive {
+				fmt.Printf("       not fixed: pass --yes to fix non-interactively\n")
+				remaining++
+				continue
+			}
+			ok, err := promptYesNo(fmt.Sprintf("Fix: %s?", c.fixDesc), true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				remaining++
+				continue
+			}
+		}
+		if err := c.fix(); err != nil {
+			fmt.Printf("       fix failed: %v\n", err)
+			remaining++
+			continue
+		}
+		fmt.Printf("FIXED  %s: %s\n", c.name, c.fixDesc)
+	}
+	if remaining > 0 {
+		if !fix {
+			fmt.Fprintln(os.Stderr, "Run 'wt doctor --fix' to fix what can be fixed automatically.")
+		}
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+func doctorToolChecks() []doctorCheck {
+	var checks []doctorCheck
+	for _, tool := range []struct{ bin, why string }{
+		{"git", "wt cannot work without it"},
+		{"docker", "needed for devcontainers"},
+		{"devcontainer", "needed for devcontainers; install @devcontainers/cli"},
+	} {
+		c := doctorCheck{name: tool.bin + " installed"}
+		if _, err := exec.LookPath(tool.bin); err != nil {
+			c.problem = "not found on PATH (" + tool.why + ")"
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// doctorRelativePaths checks worktree.useRelativePaths, which lets
+// devcontainers mounting the parent directory follow worktree links.
+func doctorRelativePaths(mainRoot string) doctorCheck {
+	c := doctorCheck{name: "worktree.useRelativePaths"}
+	out, _ := exec.Command("git", "-C", mainRoot, "config", "--get", "worktree.useRelativePaths").Output()
+	if strings.TrimSpace(string(out)) != "true" {
+		c.problem = "not set; worktree links break inside devcontainers"
+		c.fixDesc = "git config worktree.useRelativePaths true"
+		c.fix = func() error {
+			return exec.Command("git", "-C", mainRoot, "config", "worktree.useRelativePaths", "true").Run()
+		}
+	}
+	return c
+}
+
+// doctorPrunable checks for worktree admin entries whose directory is gone.
+func doctorPrunable(mainRoot string) doctorCheck {
+	c := doctorCheck{name: "worktree admin entries"}
+	out, _ := exec.Command("git", "-C", mainRoot, "worktree", "prune", "--dry-run", "--verbose").CombinedOutput()
+	if lines := strings.TrimSpace(string(out)); lines != "" {
+		c.problem = fmt.Sprintf("%d dangling (%s)", len(strings.Split(lines, "\n")), lastLine(lines))
+		c.fixDesc = "git worktree prune"
+		c.fix = func() error {
+			return exec.Command("git", "-C", mainRoot, "worktree", "prune").Run()
+		}
+	}
+	return c
+}
+
+// doctorStateDirs checks that every worktree has its wt state directory.
+func doctorStateDirs(mainRoot string) doctorCheck {
+	c := doctorCheck{name: "state directories"}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		c.problem = err.Error()
+		return c
+	}
+	worktrees, _ := siblingWorktrees(mainRoot)
+	dirs := []string{mainRoot}
+	for _, wt := range worktrees {
+		if _, err := os.Stat(wt.path); err == nil {
+			dirs = append(dirs, wt.path)
+		}
+	}
+	var missing []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(repoDir, "worktrees", filepath.Base(dir))); os.IsNotExist(err) {
+			missing = append(missing, dir)
+		}
+	}
+	if len(missing) > 0 {
+		c.problem = fmt.Sprintf("missing for %d worktree(s)", len(missing))
+		c.fixDesc = "create the missing state directories"
+		c.fix = func() error {
+			for _, dir := range missing {
+				if _, err := worktreeStateDir(dir); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return c
+}
+
+// doctorContainers finds devcontainers of this repository whose
+// devcontainer.local_folder label points at a directory that no longer
+// exists, typically after the repository was moved. Docker cannot relabel a
+// container, so the fix removes it and 'wt up' recreates it for the current
+// path.
+func doctorContainers(mainRoot string) []doctorCheck {
+	out, err := exec.Command("docker", "ps", "-a", "--filter", "label=devcontainer.local_folder",
+		"--format", `{{.ID}}	{{.Label "devcontainer.local_folder"}}`).Output()
+	if err != nil {
+		return nil
+	}
+	repo := filepath.Base(mainRoot)
+	worktrees, _ := siblingWorktrees(mainRoot)
+	current := map[string]string{repo: mainRoot}
+	for _, wt := range worktrees {
+		current[filepath.Base(wt.path)] = wt.path
+	}
+	var checks []doctorCheck
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		id, folder, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		base := filepath.Base(folder)
+		if base != repo && !strings.HasPrefix(base, repo+"@") {
+			continue
+		}
+		if _, err := os.Stat(folder); err == nil {
+			continue
+		}
+		c := doctorCheck{name: "container " + id}
+		if path, ok := current[base]; ok {
+			c.problem = fmt.Sprintf("labeled for %s, but the worktree is now at %s", folder, path)
+			c.fixDesc = fmt.Sprintf("remove container %s so 'wt up %s' recreates it", id, parseWorktreeName(base, repo))
+		} else {
+			c.problem = fmt.Sprintf("orphaned; %s no longer exists", folder)
+			c.fixDesc = "remove container " + id
+		}
+		containerID := id
+		c.fix = func() error {
+			return exec.Command("docker", "rm", "-f", containerID).Run()
+		}
+		checks = append(checks, c)
+	}
+	if len(checks) == 0 {
+		checks = append(checks, doctorCheck{name: "container labels"})
+	}
+	return checks
+}
+
+// completionPath returns where completions for shell are loaded from
+// automatically, or "" for shells wt cannot install completions for.
+func completionPath(shell string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	switch shell {
+	case "bash":
+		dataDir := os.Getenv("XDG_DATA_HOME")
+		if dataDir == "" {
+			dataDir = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataDir, "bash-completion", "completions", "wt")
+	case "fish":
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			configDir = filepath.Join(home, ".config")
+		}
+		return filepath.Join(configDir, "fish", "completions", "wt.fish")
+	case "zsh":
+		return filepath.Join(home, ".zfunc", "_wt")
+	}
+	return ""
+}
+
+// doctorCompletion checks that completions are installed for the login
+// shell.
+func doctorCompletion(genCompletion completionWriter) doctorCheck {
+	shell := filepath.Base(os.Getenv("SHELL"))
+	c := doctorCheck{name: "shell completion"}
+	path := completionPath(shell)
+	if path == "" {
+		return c
+	}
+	c.name = shell + " completion"
+	if _, err := os.Stat(path); err == nil {
+		return c
+	}
+	c.problem = "not installed at " + path
+	c.fixDesc = "write 'wt completion " + shell + "' to " + path
+	c.fix = func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := genCompletion(shell, f); err != nil {
+			return err
+		}
+		if shell == "zsh" {
+			fmt.Println(`       add to ~/.zshrc before compinit: fpath=(~/.zfunc $fpath)`)
+		}
+		return nil
+	}
+	return c
+}
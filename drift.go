@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Drift states reported by 'wt drift'.
+const (
+	driftFresh       = "fresh"
+	driftStale       = "stale"
+	driftUnknown     = "unknown"
+	driftNoContainer = "no container"
+)
+
+// devcontainerRecord remembers the .devcontainer hash a worktree's container
+// was created from. ContainerID is empty until 'wt drift' matches the record
+// to the container the recorded 'wt up' created.
+type devcontainerRecord struct {
+	Hash        string    `json:"hash"`
+	At          time.Time `json:"at"`
+	ContainerID string    `json:"containerId,omitempty"`
+}
+
+func devcontainerRecordFile(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "devcontainer.json"), nil
+}
+
+func loadDevcontainerRecord(dir string) *devcontainerRecord {
+	path, err := devcontainerRecordFile(dir)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var rec devcontainerRecord
+	if json.Unmarshal(data, &rec) != nil {
+		return nil
+	}
+	return &rec
+}
+
+func saveDevcontainerRecord(dir string, rec devcontainerRecord) error {
+	path, err := devcontainerRecordFile(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// devcontainerHash hashes the paths and contents of every file under the
+// worktree's .devcontainer directory.
+func devcontainerHash(dir string) (string, error) {
+	root := filepath.Join(dir, ".devcontainer")
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// recordDevcontainerUp notes the .devcontainer hash before 'devcontainer up'
+// creates a container for dir. A container that already exists is reused by
+// 'devcontainer up' unless extra removes it, so its record is left alone.
+func recordDevcontainerUp(dir string, extra []string) {
+	if out, err := exec.Command("docker", "ps", "-aq", "--filter", "label=devcontainer.local_folder="+dir).Output(); err == nil &&
+		strings.TrimSpace(string(out)) != "" && !slices.Contains(extra, "--remove-existing-container") {
+		return
+	}
+	hash, err := devcontainerHash(dir)
+	if err != nil {
+		return
+	}
+	_ = saveDevcontainerRecord(dir, devcontainerRecord{Hash: hash, At: time.Now()})
+}
+
+// devcontainerDrift compares the worktree's container with its current
+// .devcontainer files and returns the drift state plus a short reason.
+func devcontainerDrift(dir string) (state, reason string) {
+	out, err := exec.Command("docker", "ps", "-aq", "--filter", "label=devcontainer.local_folder="+dir).Output()
+	containerID := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	if err != nil || containerID == "" {
+		return driftNoContainer, ""
+	}
+	out, err = exec.Command("docker", "inspect", "--format", "{{.Created}}", containerID).Output()
+	if err != nil {
+		return driftUnknown, "cannot inspect container"
+	}
+	created, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(out)))
+	if err != nil {
+		return driftUnknown, "cannot read container creation time"
+	}
+	hash, err := devcontainerHash(dir)
+	if err != nil {
+		return driftUnknown, err.Error()
+	}
+
+	rec := loadDevcontainerRecord(dir)
+	if rec != nil && rec.ContainerID == "" && !created.Before(rec.At.Add(-time.Second)) {
+		rec.ContainerID = containerID
+		_ = saveDevcontainerRecord(dir, *rec)
+	}
+	if rec != nil && rec.ContainerID == containerID {
+		if rec.Hash == hash {
+			return driftFresh, ""
+		}
+		return driftStale, ".devcontainer changed since the container was created"
+	}
+
+	// No record for this container: fall back to modification times.
+	var newest time.Time
+	var newestFile string
+	filepath.WalkDir(filepath.Join(dir, ".devcontainer"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+				newest, newestFile = info.ModTime(), path
+			}
+		}
+		return nil
+	})
+	if newest.After(created) {
+		rel, _ := filepath.Rel(dir, newestFile)
+		return driftStale, rel + " modified after the container was created"
+	}
+	return driftUnknown, "container not created by wt up; no changes by file time"
+}
+
+// runDrift reports the drift state of the given worktrees and, with fix,
+// recreates stale containers. It exits 1 when a stale container remains.
+func runDrift(worktrees []siblingWorktree, fix bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKTREE\tSTATE\tREASON")
+	var stale []siblingWorktree
+	for _, wt := range worktrees {
+		state, reason := devcontainerDrift(wt.path)
+		if state == driftNoContainer && len(worktrees) > 1 {
+			continue
+		}
+		if state == driftStale {
+			stale = append(stale, wt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", wt.name, state, reason)
+	}
+	w.Flush()
+	if len(stale) == 0 {
+		return nil
+	}
+	if !fix {
+		fmt.Fprintln(os.Stderr, "Rebuild stale containers with: wt drift --fix")
+		return &exitCodeError{code: 1}
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, wt := range stale {
+		if err := checkImagePolicy(wt.path, cfg.Policy, false); err != nil {
+			warnf("", "%s: %v", wt.name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Rebuilding the devcontainer of %s\n", wt.name)
+		if _, err := removeDevcontainer(wt.path); err != nil {
+			warnf("", "%v", err)
+			failed = true
+			continue
+		}
+		if err := devcontainerUp(wt.path, nil, runPolicy{}, false); err != nil {
+			warnf("", "%s: %v", wt.name, err)
+			failed = true
+		}
+	}
+	if failed {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// driftTargets returns the worktree named by args, or every worktree
+// including the main one.
+func driftTargets(args []string) ([]siblingWorktree, error) {
+	if len(args) == 1 {
+		dir, _, err := resolveWorkspaceFolder(args)
+		if err != nil {
+			return nil, err
+		}
+		return []siblingWorktree{{name: args[0], path: dir}}, nil
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return nil, err
+	}
+	return append([]siblingWorktree{{name: filepath.Base(mainRoot), path: mainRoot}}, worktrees...), nil
+}
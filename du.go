@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+const (
+	quotaActionWarn  = "warn"
+	quotaActionBlock = "block"
+)
+
+// sizeUnits maps the suffixes parseSize accepts to their multipliers: SI
+// units as docker prints them, and binary units.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseSize parses a size such as "20GiB", "1.5GB", or "512MB".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// diskUsage is the space a worktree takes: its directory, and the docker
+// volumes and writable layer of its devcontainer.
+type diskUsage struct {
+	worktree  int64
+	volumes   map[string]int64
+	container int64
+}
+
+func (u diskUsage) volumesTotal() int64 {
+	var total int64
+	for _, n := range u.volumes {
+		total += n
+	}
+	return total
+}
+
+func (u diskUsage) total() int64 {
+	return u.worktree + u.volumesTotal() + u.container
+}
+
+// dirSizes walks root and returns the cumulative size of every directory
+// under it (root included), keyed by path relative to root. Symlinks are
+// not followed.
+func dirSizes(root string) map[string]int64 {
+	sizes := map[string]int64{}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, filepath.Dir(path))
+		for {
+			sizes[rel] += info.Size()
+			if rel == "." {
+				break
+			}
+			rel = filepath.Dir(rel)
+		}
+		return nil
+	})
+	return sizes
+}
+
+// dockerDiskUsage is the subset of 'docker system df -v' output wt reads.
+type dockerDiskUsage struct {
+	Containers []struct {
+		ID   string `json:"ID"`
+		Size string `json:"Size"`
+	} `json:"Containers"`
+	Volumes []struct {
+		Name string `json:"Name"`
+		Size string `json:"Size"`
+	} `json:"Volumes"`
+}
+
+// loadDockerDiskUsage runs 'docker system df -v' once; it returns nil when
+// docker is unavailable.
+func loadDockerDiskUsage() *dockerDiskUsage {
+	out, err := exec.Command("docker", "system", "df", "-v", "--format", "json").Output()
+	if err != nil {
+		return nil
+	}
+	var df dockerDiskUsage
+	if err := json.Unmarshal(out, &df); err != nil {
+		return nil
+	}
+	return &df
+}
+
+// worktreeVolumes returns the named volumes the worktree's devcontainer
+// mounts, plus those of its docker compose project.
+func worktreeVolumes(containerID string) []string {
+	seen := map[string]bool{}
+	var names []string
+	add := func(out []byte) {
+		for _, name := range strings.Fields(string(out)) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if out, err := exec.Command("docker", "inspect", "--format",
+		`{{range .Mounts}}{{if eq .Type "volume"}}{{.Name}} {{end}}{{end}}`, containerID).Output(); err == nil {
+		add(out)
+	}
+	if out, err := exec.Command("docker", "inspect", "--format", `{{index .Config.Labels "com.docker.compose.project"}}`, containerID).Output(); err == nil {
+		if project := strings.TrimSpace(string(out)); project != "" {
+			if out, err := exec.Command("docker", "volume", "ls", "-q", "--filter", "label=com.docker.compose.project="+project).Output(); err == nil {
+				add(out)
+			}
+		}
+	}
+	return names
+}
+
+// sizeCacheTTL is how long 'wt ls --size' reuses a worktree's measured size.
+const sizeCacheTTL = 10 * time.Minute
+
+// recordWorktreeSize caches the size of the worktree at dir's files in its
+// state directory.
+func recordWorktreeSize(dir string, size int64) {
+	if stateDir, err := worktreeStateDir(dir); err == nil {
+		_ = os.WriteFile(filepath.Join(stateDir, "size"), []byte(strconv.FormatInt(size, 10)+"\n"), 0644)
+	}
+}
+
+// cachedWorktreeSize returns the size of the worktree at dir's files,
+// measuring it only when the cached value is older than sizeCacheTTL.
+func cachedWorktreeSize(dir string) int64 {
+	if stateDir, err := worktreeStateDir(dir); err == nil {
+		path := filepath.Join(stateDir, "size")
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < sizeCacheTTL {
+			if data, err := os.ReadFile(path); err == nil {
+				if size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+					return size
+				}
+			}
+		}
+	}
+	size := dirSizes(dir)["."]
+	recordWorktreeSize(dir, size)
+	return size
+}
+
+// worktreeDiskUsage measures the worktree at dir. df may be nil to skip the
+// docker side.
+func worktreeDiskUsage(dir string, df *dockerDiskUsage) diskUsage {
+	u := diskUsage{worktree: dirSizes(dir)["."], volumes: map[string]int64{}}
+	recordWorktreeSize(dir, u.worktree)
+	if df == nil {
+		return u
+	}
+	out, err := exec.Command("docker", "ps", "-aq", "--no-trunc", "--filter", "label=devcontainer.local_folder="+dir).Output()
+	if err != nil {
+		return u
+	}
+	containerID := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	if containerID == "" {
+		return u
+	}
+	for _, c := range df.Containers {
+		if c.ID != "" && strings.HasPrefix(containerID, c.ID) {
+			// Size reads "12.3MB (virtual 1.2GB)"; only the writable layer
+			// belongs to this worktree.
+			size, _, _ := strings.Cut(c.Size, " ")
+			u.container, _ = parseSize(size)
+		}
+	}
+	volumes := map[string]bool{}
+	for _, name := range worktreeVolumes(containerID) {
+		volumes[name] = true
+	}
+	for _, v := range df.Volumes {
+		if volumes[v.Name] {
+			u.volumes[v.Name], _ = parseSize(v.Size)
+		}
+	}
+	return u
+}
+
+// checkQuota compares the worktree's disk usage with quota.max from
+// .wt.yaml, warning or, with quota.action: block, failing when it is over.
+func checkQuota(dir string, cfg QuotaConfig) error {
+	if cfg.Max == "" {
+		return nil
+	}
+	limit, err := parseSize(cfg.Max)
+	if err != nil {
+		return err
+	}
+	used := worktreeDiskUsage(dir, loadDockerDiskUsage()).total()
+	if used <= limit {
+		return nil
+	}
+	name := filepath.Base(dir)
+	msg := fmt.Sprintf("%s uses %s, over its quota of %s", name, formatSize(used), formatSize(limit))
+	hint := fmt.Sprintf("find the biggest directories with 'wt du %s --top 10' or slim it with 'wt clean %s'", name, name)
+	if cfg.Action == quotaActionBlock {
+		return fmt.Errorf("%s; %s", msg, hint)
+	}
+	warnf(hint, "%s", msg)
+	return nil
+}
+
+// runDu reports disk usage. Without a worktree it lists every worktree,
+// biggest first (the top ones with top > 0); with one, it lists its top
+// largest directories and its volumes.
+func runDu(dir string, top int, cfg QuotaConfig) error {
+	var limit int64
+	if cfg.Max != "" {
+		var err error
+		if limit, err = parseSize(cfg.Max); err != nil {
+			return err
+		}
+	}
+	df := loadDockerDiskUsage()
+	if dir != "" {
+		return runDuWorktree(dir, top, df)
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		name  string
+		usage diskUsage
+	}
+	var rows []row
+	for _, wt := range worktrees {
+		rows = append(rows, row{wt.name, worktreeDiskUsage(wt.path, df)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].usage.total() > rows[j].usage.total() })
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILES\tCONTAINER\tVOLUMES\tTOTAL\tQUOTA")
+	for _, r := range rows {
+		quota := "-"
+		if limit > 0 {
+			quota = "ok"
+			if r.usage.total() > limit {
+				quota = "over"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.name, formatSize(r.usage.worktree), formatSize(r.usage.container),
+			formatSize(r.usage.volumesTotal()), formatSize(r.usage.total()), quota)
+	}
+	return w.Flush()
+}
+
+// runDuWorktree lists the worktree's top largest directories and its
+// devcontainer's volumes. A directory that is almost entirely one child is
+// skipped in favor of the child, so the list points at the actual offenders
+// (e.g. node_modules rather than the package containing it).
+func runDuWorktree(dir string, top int, df *dockerDiskUsage) error {
+	if top <= 0 {
+		top = 10
+	}
+	sizes := dirSizes(dir)
+	largestChild := map[string]int64{}
+	for rel, n := range sizes {
+		if rel == "." {
+			continue
+		}
+		parent := filepath.Dir(rel)
+		if n > largestChild[parent] {
+			largestChild[parent] = n
+		}
+	}
+	var dirs []string
+	for rel, n := range sizes {
+		if rel != "." && largestChild[rel]*10 < n*9 {
+			dirs = append(dirs, rel)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return sizes[dirs[i]] > sizes[dirs[j]] })
+	if len(dirs) > top {
+		dirs = dirs[:top]
+	}
+	u := worktreeDiskUsage(dir, df)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tPATH")
+	for _, rel := range dirs {
+		fmt.Fprintf(w, "%s\t%s/\n", formatSize(sizes[rel]), rel)
+	}
+	if u.container > 0 {
+		fmt.Fprintf(w, "%s\t(container writable layer)\n", formatSize(u.container))
+	}
+	var volumes []string
+	for name := range u.volumes {
+		volumes = append(volumes, name)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return u.volumes[volumes[i]] > u.volumes[volumes[j]] })
+	for _, name := range volumes {
+		fmt.Fprintf(w, "%s\tvolume %s\n", formatSize(u.volumes[name]), name)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nTotal: %s\n", formatSize(u.total()))
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	editorAttachAuto      = "auto"
+	editorAttachContainer = "container"
+	editorAttachHost      = "host"
+)
+
+const defaultEditor = "code"
+
+// vscodeFamilyEditors are editors that accept VS Code's command line,
+// including --folder-uri for attaching to a devcontainer.
+var vscodeFamilyEditors = map[string]bool{
+	"code":          true,
+	"code-insiders": true,
+	"codium":        true,
+	"cursor":        true,
+	"windsurf":      true,
+	"positron":      true,
+}
+
+// editor describes how 'wt code' launches an editor.
+type editor struct {
+	argv   []string // command plus any arguments it was configured with
+	attach string   // editorAttachAuto, editorAttachContainer, or editorAttachHost
+	args   []string // extra arguments appended before the folder
+	// extensions are the editor.extensions VS Code installs for the worktree.
+	extensions []string
+}
+
+// resolveEditor picks the editor command from, in order: the --editor flag,
+// editor.command in .wt.yaml, $VISUAL, and finally "code". An empty attach
+// falls back to editor.attach, then "auto".
+func resolveEditor(cfg EditorConfig, command, attach string, extra []string) (editor, error) {
+	if command == "" {
+		command = cfg.Command
+	}
+	if command == "" {
+		command = os.Getenv("VISUAL")
+	}
+	if command == "" {
+		command = defaultEditor
+	}
+	if attach == "" {
+		attach = cfg.Attach
+	}
+	if attach == "" {
+		attach = editorAttachAuto
+	}
+	switch attach {
+	case editorAttachAuto, editorAttachContainer, editorAttachHost:
+	default:
+		return editor{}, fmt.Errorf("--attach must be %q, %q, or %q, got %q", editorAttachAuto, editorAttachContainer, editorAttachHost, attach)
+	}
+	argv := strings.Fields(command)
+	return editor{
+		argv:       argv,
+		attach:     attach,
+		args:       append(append([]string{}, cfg.Args...), extra...),
+		extensions: cfg.Extensions,
+	}, nil
+}
+
+// name returns the basename of the editor binary, e.g. "cursor".
+func (e editor) name() string {
+	return filepath.Base(e.argv[0])
+}
+
+func (e editor) vscodeFamily() bool {
+	return vscodeFamilyEditors[e.name()]
+}
+
+// exec replaces wt with the editor, passing args after the configured ones.
+func (e editor) exec(args ...string) error {
+	argv := append(append(append([]string{}, e.argv[1:]...), e.args...), args...)
+	if printCmd {
+		printCommand(append([]string{e.argv[0]}, argv...))
+		return nil
+	}
+	return sysExec(e.argv[0], argv)
+}
+
+// openInEditor opens dir in the editor. VS Code-family editors are attached
+// to the worktree's devcontainer when it has one and the devcontainer CLI is
+// available, unless attach is "host"; other editors always get the host
+// folder. force overrides the image policy when the container is started.
+func openInEditor(dir string, e editor, force bool) error {
+	if e.attach != editorAttachHost {
+		hasDevcontainer := false
+		if _, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json")); err == nil {
+			hasDevcontainer = true
+		}
+		switch {
+		case e.attach == editorAttachContainer && !hasDevcontainer:
+			return fmt.Errorf("%s has no .devcontainer/devcontainer.json to attach to", filepath.Base(dir))
+		case e.attach == editorAttachContainer && !e.vscodeFamily():
+			return fmt.Errorf("%s cannot attach to a devcontainer; use a VS Code-based editor or --attach host", e.name())
+		case hasDevcontainer && e.vscodeFamily():
+			if _, err := exec.LookPath("devcontainer"); err == nil || e.attach == editorAttachContainer {
+				return openDevcontainer(dir, e, force)
+			}
+		}
+	}
+
+	if len(e.extensions) > 0 && e.name() == defaultEditor {
+		return openInExtensionProfile(dir, e)
+	}
+	return e.exec(dir)
+}
+
+// openInExtensionProfile opens dir on the host in a VS Code profile named
+// after the repository, installing editor.extensions there first so they
+// stay out of the default profile.
+func openInExtensionProfile(dir string, e editor) error {
+	profile := "wt-" + strings.SplitN(filepath.Base(dir), "@", 2)[0]
+	args := append(append([]string{}, e.argv[1:]...), "--profile", profile)
+	for _, id := range e.extensions {
+		args = append(args, "--install-extension", id)
+	}
+	install := exec.Command(e.argv[0], args...)
+	install.Stdout = os.Stderr
+	install.Stderr = os.Stderr
+	if printCmd {
+		printCommand(install.Args)
+	} else if err := install.Run(); err != nil {
+		warnf("", "could not install editor.extensions into profile %s: %v", profile, err)
+	}
+	return e.exec("--profile", profile, dir)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// envTemplateSuffix marks a dotenv template; "<file>.wt.tmpl" renders to
+// "<file>" in each worktree.
+const envTemplateSuffix = ".wt.tmpl"
+
+// envTemplates lists the template files wt renders, relative to a worktree.
+var envTemplates = []string{".env" + envTemplateSuffix, filepath.Join(".devcontainer", ".env"+envTemplateSuffix)}
+
+// envTemplateData is the data passed to .env.wt.tmpl templates.
+type envTemplateData struct {
+	Name       string // worktree name; empty for the main worktree
+	Repo       string // basename of the main repository
+	Dir        string // absolute worktree directory
+	Slot       int    // stable per-worktree index; 0 for the main worktree
+	PortOffset int    // Slot multiplied by env.portStride
+	ProxyPort  string // host SOCKS5 proxy port; empty if no container is running
+}
+
+// placeholders returns the replacer for the __WT_*__ placeholders that
+// copied env files may contain, e.g. DB_SCHEMA=app___WT_NAME__.
+func (d *envTemplateData) placeholders() *strings.Replacer {
+	return strings.NewReplacer(
+		"__WT_NAME__", d.Name,
+		"__WT_REPO__", d.Repo,
+		"__WT_DIR__", d.Dir,
+		"__WT_SLOT__", strconv.Itoa(d.Slot),
+		"__WT_PORT_OFFSET__", strconv.Itoa(d.PortOffset),
+	)
+}
+
+var envTemplateFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"mul": func(a, b int) int { return a * b },
+}
+
+// envKeyPatterns are key fragments that usually mark a secret value.
+var envKeyPatterns = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "PRIVATE_KEY", "API_KEY", "ACCESS_KEY", "CREDENTIAL"}
+
+// envKey returns the variable name assigned on a dotenv line, or "" for
+// blank lines, comments, and lines that are not assignments.
+func envKey(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ""
+	}
+	trimmed = strings.TrimPrefix(trimmed, "export ")
+	key, _, ok := strings.Cut(trimmed, "=")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+func matchesAnyPattern(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keyAllowed reports whether a key may be copied verbatim under cfg.
+func (cfg EnvConfig) keyAllowed(key string) bool {
+	if matchesAnyPattern(key, cfg.Deny) {
+		return false
+	}
+	if len(cfg.Allow) == 0 {
+		return true
+	}
+	return matchesAnyPattern(key, cfg.Allow)
+}
+
+// filterEnv applies the allow/deny rules to dotenv content. Keys that are not
+// allowed are dropped, or kept with an empty value in redact mode. It returns
+// the filtered content and the keys that were withheld.
+func (cfg EnvConfig) filterEnv(data []byte) ([]byte, []string) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return data, nil
+	}
+	var out bytes.Buffer
+	var withheld []string
+	var open byte     // quote of a value that continues on the next line
+	var dropping bool // whether that value belongs to a withheld key
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, line := range lines {
+		if open != 0 {
+			if !dropping {
+				out.WriteString(line)
+			}
+			if closesQuote(line, open) {
+				open = 0
+			}
+			continue
+		}
+		key := envKey(line)
+		if key != "" {
+			open = openedQuote(line)
+		}
+		dropping = false
+		if key == "" || cfg.keyAllowed(key) {
+			out.WriteString(line)
+			continue
+		}
+		dropping = true
+		withheld = append(withheld, key)
+		if cfg.Mode == envModeRedact {
+			prefix := ""
+			if strings.HasPrefix(strings.TrimSpace(line), "export ") {
+				prefix = "export "
+			}
+			out.WriteString(prefix + key + "=\n")
+		}
+	}
+	return out.Bytes(), withheld
+}
+
+// openedQuote returns the quote that starts the value of a dotenv
+// assignment line and is not closed on it, as in a multi-line PEM key, or 0.
+func openedQuote(line string) byte {
+	_, value, _ := strings.Cut(line, "=")
+	value = strings.TrimSpace(value)
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return 0
+	}
+	if closesQuote(value[1:], value[0]) {
+		return 0
+	}
+	return value[0]
+}
+
+// closesQuote reports whether s holds the quote q, skipping quotes escaped
+// with a backslash inside double quotes.
+func closesQuote(s string, q byte) bool {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && q == '"':
+			i++
+		case s[i] == q:
+			return true
+		}
+	}
+	return false
+}
+
+// credentialKeys returns the keys in dotenv content whose names look like
+// secrets and that carry a non-empty value. A private key block is reported
+// as "PRIVATE KEY".
+func credentialKeys(data []byte) []string {
+	var found []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "PRIVATE KEY-----") {
+			found = append(found, "PRIVATE KEY")
+			continue
+		}
+		key := envKey(line)
+		if key == "" {
+			continue
+		}
+		_, value, _ := strings.Cut(line, "=")
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if value == "" {
+			continue
+		}
+		upper := strings.ToUpper(key)
+		for _, p := range envKeyPatterns {
+			if strings.Contains(upper, p) {
+				found = append(found, key)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// hasEnvTemplates reports whether the worktree at dir has any env template.
+func hasEnvTemplates(dir string) bool {
+	for _, rel := range envTemplates {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renderEnvTemplates renders every env template present in the worktree at
+// dir. It returns the rendered file paths relative to dir.
+func renderEnvTemplates(dir string, cfg EnvConfig) ([]string, error) {
+	var rendered []string
+	var data *envTemplateData
+	for _, rel := range envTemplates {
+		src := filepath.Join(dir, rel)
+		content, err := os.ReadFile(src)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return rendered, err
+		}
+		if data == nil {
+			if data, err = newEnvTemplateData(dir, cfg); err != nil {
+				return rendered, err
+			}
+		}
+		tmpl, err := template.New(rel).Funcs(envTemplateFuncs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return rendered, fmt.Errorf("failed to parse %s: %w", rel, err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return rendered, fmt.Errorf("failed to render %s: %w", rel, err)
+		}
+		dstRel := strings.TrimSuffix(rel, envTemplateSuffix)
+		if err := os.WriteFile(filepath.Join(dir, dstRel), out.Bytes(), 0644); err != nil {
+			return rendered, fmt.Errorf("failed to write %s: %w", dstRel, err)
+		}
+		rendered = append(rendered, dstRel)
+	}
+	return rendered, nil
+}
+
+func newEnvTemplateData(dir string, cfg EnvConfig) (*envTemplateData, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	slot, err := worktreeSlot(dir)
+	if err != nil {
+		return nil, err
+	}
+	repo := filepath.Base(mainRoot)
+	data := &envTemplateData{
+		Name:       parseWorktreeName(filepath.Base(dir), repo),
+		Repo:       repo,
+		Dir:        dir,
+		Slot:       slot,
+		PortOffset: slot * cfg.portStride(),
+	}
+	if port, err := getProxyPort(dir); err == nil {
+		data.ProxyPort = port
+	}
+	return data, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// execEnvEssential are host variables wt itself and the docker and
+// devcontainer CLIs need; exec.env.allow does not drop them, though
+// exec.env.deny can.
+var execEnvEssential = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TMPDIR", "LANG", "LC_*",
+	"DOCKER_*", "XDG_*", "WT_*", "CI",
+}
+
+// keep reports whether the host variable key may reach 'wt exec' sessions.
+func (c ExecEnvConfig) keep(key string) bool {
+	if matchesAnyPattern(key, c.Deny) {
+		return false
+	}
+	if len(c.Allow) == 0 {
+		return true
+	}
+	return matchesAnyPattern(key, c.Allow) || matchesAnyPattern(key, execEnvEssential)
+}
+
+// applyExecEnv removes the host variables exec.env does not let through
+// from wt's own environment, which the devcontainer CLI (for
+// ${localEnv:...} in devcontainer.json), and commands run without a
+// devcontainer, inherit. It is a no-op when exec.env is not configured.
+func applyExecEnv(c ExecEnvConfig) {
+	if len(c.Allow) == 0 && len(c.Deny) == 0 {
+		return
+	}
+	var dropped []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if key != "" && !c.keep(key) {
+			os.Unsetenv(key)
+			dropped = append(dropped, key)
+		}
+	}
+	if verbose && len(dropped) > 0 {
+		sort.Strings(dropped)
+		fmt.Fprintf(os.Stderr, "Withheld from the session: %s\n", strings.Join(dropped, ", "))
+	}
+}
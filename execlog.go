@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// execLogFlagDefault is the --log-file value meaning "pick a path in the
+// worktree's logs directory".
+const execLogFlagDefault = "auto"
+
+// execLogsDir returns the directory holding 'wt exec' logs for the worktree
+// at dir: logs/ in its artifacts directory. Logs from before there was one
+// are moved there.
+func execLogsDir(dir string) (string, error) {
+	artifacts, err := artifactsDir(dir)
+	if err != nil {
+		return "", err
+	}
+	logsDir := filepath.Join(artifacts, "logs")
+	if _, err := os.Stat(logsDir); os.IsNotExist(err) {
+		_ = os.Rename(filepath.Join(filepath.Dir(artifacts), "logs"), logsDir)
+	}
+	return logsDir, nil
+}
+
+// newExecLogPath returns a fresh timestamped log path for the worktree at dir.
+func newExecLogPath(dir string) (string, error) {
+	logsDir, err := execLogsDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create logs directory: %w", err)
+	}
+	return filepath.Join(logsDir, time.Now().Format("20060102-150405")+".log"), nil
+}
+
+// attemptLogPath returns the log file of attempt (counting from 0) of a
+// command logged to logPath: logPath itself for the first attempt, and for a
+// retry the attempt number before the extension, as in 20260301-141502.2.log.
+func attemptLogPath(logPath string, attempt int) string {
+	if attempt == 0 {
+		return logPath
+	}
+	ext := filepath.Ext(logPath)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(logPath, ext), attempt+1, ext)
+}
+
+// runLogged runs argv with its stdout and stderr mirrored to the terminal
+// and appended to the log file at logPath, retrying as policy allows. Each
+// attempt gets a log of its own (see attemptLogPath) that starts with the
+// command and start time and ends with the exit status and duration.
+func runLogged(argv []string, logPath string, policy runPolicy) error {
+	var paths []string
+	runErr := runAttempts(argv, policy, func(attempt int) (io.Writer, io.Writer, func(error)) {
+		path := attemptLogPath(logPath, attempt)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			warnf("", "failed to open log file: %v", err)
+			return os.Stdout, os.Stderr, nil
+		}
+		paths = append(paths, path)
+		start := time.Now()
+		fmt.Fprintf(f, "# command: %s\n# started: %s\n", strings.Join(argv, " "), start.Format(time.RFC3339))
+		if policy.retries > 0 {
+			fmt.Fprintf(f, "# attempt: %d of %d\n", attempt+1, policy.retries+1)
+		}
+		return io.MultiWriter(os.Stdout, f), io.MultiWriter(os.Stderr, f), func(err error) {
+			fmt.Fprintf(f, "# exit: %d\n# duration: %s\n", attemptExitCode(err), time.Since(start).Round(time.Millisecond))
+			f.Close()
+		}
+	})
+	for _, path := range paths {
+		fmt.Fprintf(os.Stderr, "Logged output to %s\n", path)
+	}
+	return runErr
+}
+
+// execLogInfo summarizes a log file for 'wt logs --exec'.
+type execLogInfo struct {
+	id       string
+	path     string
+	command  string
+	exit     string
+	duration string
+}
+
+func listExecLogs(dir string) ([]execLogInfo, error) {
+	logsDir, err := execLogsDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := filepath.Glob(filepath.Join(logsDir, "*.log"))
+	var logs []execLogInfo
+	for _, p := range paths {
+		info := execLogInfo{id: strings.TrimSuffix(filepath.Base(p), ".log"), path: p, exit: "-", duration: "-"}
+		if f, err := os.Open(p); err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if v, ok := strings.CutPrefix(line, "# command: "); ok && info.command == "" {
+					info.command = v
+				} else if v, ok := strings.CutPrefix(line, "# exit: "); ok {
+					info.exit = v
+				} else if v, ok := strings.CutPrefix(line, "# duration: "); ok {
+					info.duration = v
+				}
+			}
+			f.Close()
+		}
+		logs = append(logs, info)
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].id < logs[j].id })
+	return logs, nil
+}
+
+func runExecLogsList(dir string) error {
+	logs, err := listExecLogs(dir)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		fmt.Fprintf(os.Stderr, "No exec logs for %s; capture some with 'wt exec --log-file -- <command>'\n", filepath.Base(dir))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEXIT\tDURATION\tCOMMAND")
+	for _, l := range logs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.id, l.exit, l.duration, l.command)
+	}
+	return w.Flush()
+}
+
+// resolveExecLog finds a log by id (or unique id prefix); "last" picks the
+// most recent one.
+func resolveExecLog(dir, id string) (string, error) {
+	logs, err := listExecLogs(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(logs) == 0 {
+		return "", fmt.Errorf("no exec logs for %s", filepath.Base(dir))
+	}
+	if id == "last" {
+		return logs[len(logs)-1].path, nil
+	}
+	var matches []execLogInfo
+	for _, l := range logs {
+		if l.id == id {
+			return l.path, nil
+		}
+		if strings.HasPrefix(l.id, id) {
+			matches = append(matches, l)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no exec log %q; see 'wt logs --exec'", id)
+	case 1:
+		return matches[0].path, nil
+	default:
+		return "", fmt.Errorf("log id %q is ambiguous", id)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An extends source is a git repository, such as
+// git@github.com:org/wt-config, optionally pinned to a branch or tag with
+// #ref, or a local directory. It is laid out like a project: a .wt.yaml with
+// the defaults, .wt/template/ and .wt/hooks/.
+
+// isLocalExtends reports whether source names a local directory rather than
+// a git URL.
+func isLocalExtends(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") || strings.HasPrefix(source, "~/")
+}
+
+// splitExtends splits source into its repository URL and ref.
+func splitExtends(source string) (url, ref string) {
+	url, ref, _ = strings.Cut(source, "#")
+	return url, ref
+}
+
+// extendsCacheDir returns where the git source is cloned: a directory in
+// wt's state named after the repository plus a short hash of the source.
+func extendsCacheDir(source string) (string, error) {
+	home, err := stateHome()
+	if err != nil {
+		return "", err
+	}
+	url, _ := splitExtends(source)
+	name := strings.TrimSuffix(filepath.Base(strings.ReplaceAll(url, ":", "/")), ".git")
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(home, "extends", name+"-"+hex.EncodeToString(sum[:])[:8]), nil
+}
+
+// resolveExtends returns the directory holding the extends source: the
+// local directory (relative to base), or the cached clone of the git
+// repository, which is cloned on first use and updated only with refresh.
+func resolveExtends(source, base string, refresh bool) (string, error) {
+	if isLocalExtends(source) {
+		dir := source
+		if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, rest)
+		} else if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return "", fmt.Errorf("extends: %s is not a directory", dir)
+		}
+		return dir, nil
+	}
+	dir, err := extendsCacheDir(source)
+	if err != nil {
+		return "", err
+	}
+	url, ref := splitExtends(source)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if !refresh {
+			return dir, nil
+		}
+		if offline {
+			return "", errOffline("refreshing " + url)
+		}
+		if ref == "" {
+			ref = "HEAD"
+		}
+		if out, err := exec.Command("git", "-C", dir, "fetch", "--quiet", "--depth", "1", "origin", ref).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to refresh %s: %s", url, strings.TrimSpace(string(out)))
+		}
+		if out, err := exec.Command("git", "-C", dir, "reset", "--quiet", "--hard", "FETCH_HEAD").CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to refresh %s: %s", url, strings.TrimSpace(string(out)))
+		}
+		return dir, nil
+	}
+	if offline {
+		return "", errOffline("fetching " + url)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create extends cache: %w", err)
+	}
+	// Clone next to the cache and rename, so that a concurrent wt never sees
+	// half a checkout.
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".clone-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	fmt.Fprintf(os.Stderr, "Fetching shared wt config from %s...\n", url)
+	if out, err := exec.Command("git", append(args, url, tmp)...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to clone %s: %s", url, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, ".git")); statErr == nil {
+			return dir, nil
+		}
+		return "", fmt.Errorf("failed to cache %s: %w", url, err)
+	}
+	return dir, nil
+}
+
+// extendsRoot returns the directory of the current repository's extends
+// source, or "" when it has none.
+func extendsRoot() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.extendsDir
+}
+
+// runExtendsShow implements 'wt extends show'.
+func runExtendsShow(cfg *Config) error {
+	if cfg.Extends == "" {
+		fmt.Fprintf(os.Stderr, "%s has no extends\n", projectConfigFile)
+		return nil
+	}
+	fmt.Printf("extends:  %s\n", cfg.Extends)
+	fmt.Printf("path:     %s\n", cfg.extendsDir)
+	if !isLocalExtends(cfg.Extends) {
+		if out, err := exec.Command("git", "-C", cfg.extendsDir, "log", "-1", "--format=%h %s").Output(); err == nil {
+			fmt.Printf("commit:   %s\n", strings.TrimSpace(string(out)))
+		}
+		// FETCH_HEAD appears with the first refresh; HEAD dates the clone.
+		for _, f := range []string{"FETCH_HEAD", "HEAD"} {
+			if info, err := os.Stat(filepath.Join(cfg.extendsDir, ".git", f)); err == nil {
+				fmt.Printf("fetched:  %s\n", info.ModTime().Format("2006-01-02 15:04"))
+				break
+			}
+		}
+	}
+	for _, p := range []string{projectConfigFile, templateDir, hooksDir} {
+		state := "-"
+		if _, err := os.Stat(filepath.Join(cfg.extendsDir, p)); err == nil {
+			state = "yes"
+		}
+		fmt.Printf("  %-14s %s\n", p, state)
+	}
+	return nil
+}
+
+// runExtendsRefresh implements 'wt extends refresh': it updates the cached
+// clone of the git extends source.
+func runExtendsRefresh(cfg *Config) error {
+	if cfg.Extends == "" {
+		return fmt.Errorf("%s has no extends to refresh", projectConfigFile)
+	}
+	if isLocalExtends(cfg.Extends) {
+		fmt.Fprintf(os.Stderr, "%s is a local directory; nothing to refresh\n", cfg.Extends)
+		return nil
+	}
+	start := time.Now()
+	dir, err := resolveExtends(cfg.Extends, "", true)
+	if err != nil {
+		return err
+	}
+	if _, err := loadConfig(); err != nil {
+		return fmt.Errorf("the refreshed config is invalid: %w", err)
+	}
+	out, _ := exec.Command("git", "-C", dir, "log", "-1", "--format=%h %s").Output()
+	fmt.Fprintf(os.Stderr, "Refreshed %s in %s: %s\n", cfg.Extends, time.Since(start).Round(time.Millisecond), strings.TrimSpace(string(out)))
+	return nil
+}
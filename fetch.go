@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultFetchMaxAge is how recent a completed fetch must be for 'wt add' to
+// reuse it instead of fetching again.
+const defaultFetchMaxAge = 10 * time.Second
+
+// defaultFetchTimeout bounds the best-effort fetch of 'wt add' so a missing
+// or flaky network does not stall worktree creation.
+const defaultFetchTimeout = 30 * time.Second
+
+// fetchRemotes fetches every configured remote of the repository at
+// mainRoot, sharing the work between concurrent wt processes. A file lock in
+// the repo's state directory serializes fetches; a process that waited on
+// the lock, or that finds a fetch newer than maxAge, skips its own fetch.
+// args are passed to git fetch before the remote name; fetches running
+// longer than timeout are killed.
+func fetchRemotes(mainRoot string, remotes []string, maxAge, timeout time.Duration, args []string) error {
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return runFetch(remotes, args, timeout)
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		return runFetch(remotes, args, timeout)
+	}
+	waitStart := time.Now()
+	unlock, err := lockFile(filepath.Join(repoDir, "fetch.lock"), "Waiting for another wt process to finish fetching...")
+	if err != nil {
+		return runFetch(remotes, args, timeout)
+	}
+	defer unlock()
+
+	marker := filepath.Join(repoDir, "last-fetch")
+	if info, err := os.Stat(marker); err == nil {
+		at := info.ModTime()
+		if !at.Before(waitStart) || time.Since(at) < maxAge {
+			fmt.Fprintf(os.Stderr, "Remotes were fetched %s ago; skipping fetch\n", time.Since(at).Round(time.Second))
+			return nil
+		}
+	}
+
+	if err := runFetch(remotes, args, timeout); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.WriteFile(marker, nil, 0644); err == nil {
+		_ = os.Chtimes(marker, now, now)
+	}
+	return nil
+}
+
+// lockFile takes an exclusive lock on the file at path, creating it, and
+// returns the function that releases it. While another wt process holds the
+// lock, it prints waiting, if not empty, and blocks.
+func lockFile(path, waiting string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if waiting != "" {
+			fmt.Fprintln(os.Stderr, waiting)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// gitRemotes returns the names of the repository's remotes.
+func gitRemotes() []string {
+	out, err := exec.Command("git", "remote").Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+// runFetch fetches the remotes. A single remote shows git's own progress;
+// several are fetched in parallel, with a line for each as it finishes.
+func runFetch(remotes, args []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fetchCmd := func(remote string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "git", append(append([]string{"fetch"}, args...), remote)...)
+		// Fail instead of waiting for credentials nobody will type.
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		return cmd
+	}
+	timedOut := func(what string) error {
+		return fmt.Errorf("%s timed out after %s (is the network down? --offline skips fetching)", what, timeout)
+	}
+	if len(remotes) == 1 {
+		cmd := fetchCmd(remotes[0])
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			return timedOut("fetching " + remotes[0])
+		}
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Fetching %s\n", strings.Join(remotes, ", "))
+	width := 0
+	for _, remote := range remotes {
+		width = max(width, len(remote))
+	}
+	// Each fetch reports whether it was the one cut off by the deadline, so
+	// a remote that failed or finished just before it is not blamed for it.
+	type result struct {
+		remote   string
+		elapsed  time.Duration
+		err      error
+		timedOut bool
+	}
+	results := make(chan result)
+	for _, remote := range remotes {
+		go func() {
+			start := time.Now()
+			// git hands the transfer to git-remote-https or ssh, which keep
+			// the output pipe open after git is killed; kill the whole group
+			// and stop waiting on the pipe shortly after.
+			cmd := fetchCmd(remote)
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+			cmd.WaitDelay = time.Second
+			out, err := cmd.CombinedOutput()
+			timedOut := err != nil && ctx.Err() == context.DeadlineExceeded
+			if err != nil {
+				if msg := gitFatalLine(string(out)); msg != "" {
+					err = errors.New(msg)
+				}
+			}
+			results <- result{remote, time.Since(start), err, timedOut}
+		}()
+	}
+	var failed, late []string
+	for range remotes {
+		r := <-results
+		switch {
+		case r.timedOut:
+			fmt.Fprintf(os.Stderr, "  %-*s  timed out\n", width, r.remote)
+			late = append(late, r.remote)
+		case r.err != nil:
+			fmt.Fprintf(os.Stderr, "  %-*s  failed: %v\n", width, r.remote, r.err)
+			failed = append(failed, r.remote)
+		default:
+			fmt.Fprintf(os.Stderr, "  %-*s  done in %s\n", width, r.remote, r.elapsed.Round(100*time.Millisecond))
+		}
+	}
+	switch {
+	case len(late) > 0 && len(failed) > 0:
+		return fmt.Errorf("failed to fetch %s; %w", strings.Join(failed, ", "), timedOut("fetching "+strings.Join(late, ", ")))
+	case len(late) > 0:
+		return timedOut("fetching " + strings.Join(late, ", "))
+	case len(failed) > 0:
+		return fmt.Errorf("failed to fetch %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// gitFatalLine returns the message of git's first "fatal:" or "error:"
+// line in out, or else its last line.
+func gitFatalLine(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		for _, prefix := range []string{"fatal: ", "error: "} {
+			if msg, ok := strings.CutPrefix(strings.TrimSpace(line), prefix); ok {
+				return msg
+			}
+		}
+	}
+	return lastLine(out)
+}
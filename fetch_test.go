@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRepo creates a repository with one commit, cloned from an upstream
+// as origin, changes into it, and points wt's state at a temporary
+// directory. It returns the clone's path.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	top := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(top, "state"))
+	t.Setenv("GIT_AUTHOR_NAME", "wt")
+	t.Setenv("GIT_AUTHOR_EMAIL", "wt@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "wt")
+	t.Setenv("GIT_COMMITTER_EMAIL", "wt@example.com")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	upstream := filepath.Join(top, "upstream")
+	repo := filepath.Join(top, "repo")
+	gitIn(t, top, "init", "-q", "-b", "main", upstream)
+	gitIn(t, upstream, "commit", "-q", "--allow-empty", "-m", "initial")
+	gitIn(t, top, "clone", "-q", upstream, repo)
+	t.Chdir(repo)
+	return repo
+}
+
+// gitIn runs git with args in dir and fails the test if it fails.
+func gitIn(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestRunFetchAttributesFailures(t *testing.T) {
+	repo := newTestRepo(t)
+	// The slow remote's transport leaves a grandchild holding git's output
+	// open, as git-remote-https and ssh do.
+	slow := filepath.Join(t.TempDir(), "slow.sh")
+	if err := os.WriteFile(slow, []byte("#!/bin/sh\nsleep 30 &\nwait\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, repo, "config", "protocol.ext.allow", "always")
+	gitIn(t, repo, "remote", "add", "slow", "ext::"+slow)
+	gitIn(t, repo, "remote", "add", "bad", filepath.Join(t.TempDir(), "missing"))
+
+	tests := []struct {
+		name    string
+		remotes []string
+		want    []string // substrings of the error; none for success
+		notWant []string
+	}{
+		{"all fetched", []string{"origin"}, nil, nil},
+		{"one fails", []string{"origin", "bad"}, []string{"failed to fetch bad"}, []string{"timed out", "origin"}},
+		{"one times out", []string{"origin", "slow"}, []string{"fetching slow timed out"}, []string{"failed", "origin"}},
+		{"one fails, one times out", []string{"bad", "origin", "slow"},
+			[]string{"failed to fetch bad;", "fetching slow timed out"}, []string{"origin", "bad timed out"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := runFetch(tt.remotes, nil, time.Second)
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("took %s; the timeout did not bound the fetch", elapsed)
+			}
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("error %q does not contain %q", err, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(err.Error(), s) {
+					t.Errorf("error %q contains %q", err, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	forgeGitHub    = "github"
+	forgeGitLab    = "gitlab"
+	forgeBitbucket = "bitbucket"
+)
+
+// forge is a code hosting provider wt integrates with for CI runs and
+// pull request checkouts.
+type forge interface {
+	name() string
+	// pullRequestRef returns the ref on origin that holds the head of pull
+	// (or merge) request number.
+	pullRequestRef(number int) (string, error)
+	// triggerCI starts a pipeline for branch and returns the recorded run.
+	triggerCI(dir, branch string, opts ciRunOptions) (ciRun, error)
+	// watchCI streams the run's progress until it finishes and fails when
+	// the run did not succeed.
+	watchCI(dir string, run ciRun) error
+}
+
+// remoteRepo is the host and "owner/repo" path of a git remote URL.
+type remoteRepo struct {
+	host string
+	path string
+}
+
+// parseRemoteURL understands scp-like ("git@host:owner/repo.git"), ssh://,
+// and http(s):// remote URLs, plus local paths.
+func parseRemoteURL(raw string) (remoteRepo, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		if userHost, path, ok := strings.Cut(raw, ":"); ok {
+			_, host, found := strings.Cut(userHost, "@")
+			if !found {
+				host = userHost
+			}
+			return remoteRepo{host: host, path: strings.TrimSuffix(strings.Trim(path, "/"), ".git")}, nil
+		}
+		// A local path; there is no host to go by.
+		return remoteRepo{path: raw}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return remoteRepo{}, fmt.Errorf("unrecognized remote URL %q: %w", raw, err)
+	}
+	return remoteRepo{host: u.Hostname(), path: strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")}, nil
+}
+
+func originRepo(dir string) (remoteRepo, error) {
+	out, err := exec.Command("git", "-C", dir, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return remoteRepo{}, fmt.Errorf("git remote 'origin' not configured")
+	}
+	return parseRemoteURL(string(out))
+}
+
+// detectForge picks the provider from forge.provider in .wt.yaml, or else
+// from the host of the origin remote.
+func detectForge(dir string) (forge, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := originRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	provider := cfg.Forge.Provider
+	if provider == "" {
+		host := strings.ToLower(repo.host)
+		switch {
+		case strings.Contains(host, "gitlab"):
+			provider = forgeGitLab
+		case strings.Contains(host, "bitbucket"):
+			provider = forgeBitbucket
+		default:
+			provider = forgeGitHub
+		}
+	}
+	switch provider {
+	case forgeGitHub:
+		return githubForge{workflow: cfg.CI.Workflow}, nil
+	case forgeGitLab:
+		return gitlabForge{}, nil
+	case forgeBitbucket:
+		if strings.Count(repo.path, "/") != 1 {
+			return nil, fmt.Errorf("cannot derive a Bitbucket workspace/repo from origin %q", repo.path)
+		}
+		return bitbucketForge{repo: repo}, nil
+	}
+	return nil, fmt.Errorf("unknown forge provider %q", provider)
+}
+
+// githubForge drives GitHub Actions through the gh CLI.
+type githubForge struct {
+	workflow string // ci.workflow from .wt.yaml
+}
+
+func (githubForge) name() string { return forgeGitHub }
+
+func (g githubForge) triggerCI(dir, branch string, opts ciRunOptions) (ciRun, error) {
+	if _, err := exec.LookPath("gh"); err != nil {
+		return ciRun{}, fmt.Errorf("the GitHub CLI (gh) is required; see https://cli.github.com")
+	}
+	workflow := opts.workflow
+	if workflow == "" {
+		workflow = g.workflow
+	}
+	if workflow == "" {
+		var err error
+		if workflow, err = defaultWorkflow(dir); err != nil {
+			return ciRun{}, err
+		}
+	}
+
+	triggered := time.Now().UTC()
+	ghArgs := []string{"workflow", "run", workflow, "--ref", branch}
+	for _, f := range opts.fields {
+		ghArgs = append(ghArgs, "-f", f)
+	}
+	ghCmd := exec.Command("gh", ghArgs...)
+	ghCmd.Dir = dir
+	ghCmd.Stdout = os.Stderr
+	ghCmd.Stderr = os.Stderr
+	if err := ghCmd.Run(); err != nil {
+		return ciRun{}, fmt.Errorf("gh workflow run failed: %w", err)
+	}
+	return findDispatchedRun(dir, workflow, branch, triggered)
+}
+
+func (githubForge) watchCI(dir string, run ciRun) error {
+	watchCmd := exec.Command("gh", "run", "watch", fmt.Sprint(run.ID), "--exit-status")
+	watchCmd.Dir = dir
+	watchCmd.Stdout = os.Stderr
+	watchCmd.Stderr = os.Stderr
+	return childExitError(watchCmd.Run())
+}
+
+// pollCI reports status changes from check every interval until it says the
+// run is done, and fails unless the run succeeded. Providers without a watch
+// command of their own use it.
+func pollCI(run ciRun, interval time.Duration, check func() (status string, done, ok bool, err error)) error {
+	last := ""
+	for {
+		status, done, ok, err := check()
+		if err != nil {
+			return err
+		}
+		if status != last {
+			fmt.Fprintf(os.Stderr, "%s  %s\n", time.Now().Format(time.TimeOnly), status)
+			last = status
+		}
+		if done {
+			if !ok {
+				return fmt.Errorf("CI run %s finished with status %s", run.URL, status)
+			}
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// gitlabForge drives GitLab CI pipelines through the glab CLI.
+type gitlabForge struct{}
+
+func (gitlabForge) name() string { return forgeGitLab }
+
+// gitlabPipeline is the subset of the GitLab pipeline API response wt reads.
+type gitlabPipeline struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	SHA    string `json:"sha"`
+	WebURL string `json:"web_url"`
+}
+
+// glabAPI calls the GitLab API through 'glab api'; a non-nil body is sent as
+// JSON with the given method.
+func glabAPI(dir, method, endpoint string, body any) (gitlabPipeline, error) {
+	if _, err := exec.LookPath("glab"); err != nil {
+		return gitlabPipeline{}, fmt.Errorf("the GitLab CLI (glab) is required; see https://gitlab.com/gitlab-org/cli")
+	}
+	args := []string{"api", "--method", method, endpoint}
+	cmd := exec.Command("glab", args...)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return gitlabPipeline{}, err
+		}
+		cmd = exec.Command("glab", append(args, "--header", "Content-Type: application/json", "--input", "-")...)
+		cmd.Stdin = bytes.NewReader(data)
+	}
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return gitlabPipeline{}, fmt.Errorf("glab api failed: %w", err)
+	}
+	var p gitlabPipeline
+	if err := json.Unmarshal(out, &p); err != nil {
+		return gitlabPipeline{}, fmt.Errorf("failed to parse glab api output: %w", err)
+	}
+	return p, nil
+}
+
+// triggerCI creates a pipeline for branch. Inputs become pipeline variables;
+// GitLab has a single pipeline per project, so --workflow does not apply.
+func (gitlabForge) triggerCI(dir, branch string, opts ciRunOptions) (ciRun, error) {
+	if opts.workflow != "" {
+		warnf("", "--workflow is ignored for GitLab; the project's pipeline runs")
+	}
+	body := map[string]any{"ref": branch}
+	var vars []map[string]string
+	for _, f := range opts.fields {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return ciRun{}, fmt.Errorf("invalid field %q; expected key=value", f)
+		}
+		vars = append(vars, map[string]string{"key": key, "value": value})
+	}
+	if len(vars) > 0 {
+		body["variables"] = vars
+	}
+	p, err := glabAPI(dir, "POST", "projects/:id/pipeline", body)
+	if err != nil {
+		return ciRun{}, err
+	}
+	return ciRun{ID: p.ID, URL: p.WebURL, Workflow: "pipeline", Branch: branch, Triggered: time.Now().UTC()}, nil
+}
+
+func (gitlabForge) watchCI(dir string, run ciRun) error {
+	return pollCI(run, 10*time.Second, func() (string, bool, bool, error) {
+		p, err := glabAPI(dir, "GET", fmt.Sprintf("projects/:id/pipelines/%d", run.ID), nil)
+		if err != nil {
+			return "", false, false, err
+		}
+		switch p.Status {
+		case "success":
+			return p.Status, true, true, nil
+		case "failed", "canceled", "skipped":
+			return p.Status, true, false, nil
+		}
+		return p.Status, false, false, nil
+	})
+}
@@ -2,7 +2,13 @@ module github.com/chirino/wt
 
 go 1.25.3
 
-require github.com/spf13/cobra v1.10.2
+require (
+	github.com/creack/pty v1.1.24
+	github.com/spf13/cobra v1.10.2
+	golang.org/x/sys v0.38.0
+	golang.org/x/term v0.37.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
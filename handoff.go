@@ -0,0 +1,228 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// handoffVersion is the format version of hand-off manifests.
+	handoffVersion = 1
+	// handoffManifestFile, handoffBundleFile, and handoffPatchFile are the
+	// entries of a hand-off archive.
+	handoffManifestFile = "manifest.json"
+	handoffBundleFile   = "commits.bundle"
+	handoffPatchFile    = "uncommitted.patch"
+)
+
+// handoffManifest describes a worktree packaged by 'wt hand-off'.
+type handoffManifest struct {
+	Version int       `json:"version"`
+	Repo    string    `json:"repo"`             // main repository basename
+	Remote  string    `json:"remote,omitempty"` // origin URL, to clone from when the repo is missing
+	Name    string    `json:"name"`
+	Branch  string    `json:"branch,omitempty"` // empty when HEAD was detached
+	Head    string    `json:"head"`
+	Image   string    `json:"image,omitempty"` // the devcontainer's image, by digest when it has one
+	Created time.Time `json:"created"`
+	// Files maps each other entry of the archive to its SHA-256.
+	Files map[string]string `json:"files"`
+}
+
+// handoffBundle writes the commits of the worktree at dir that no remote
+// has yet, with its branch, to a git bundle at path. ok is false when every
+// commit is already on a remote.
+func handoffBundle(dir, branch, path string) (ok bool, err error) {
+	refs := []string{"HEAD"}
+	if branch != "" {
+		refs = append(refs, "refs/heads/"+branch)
+	}
+	args := append([]string{"-C", dir, "bundle", "create", path}, refs...)
+	args = append(args, "--not", "--remotes")
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "empty bundle") {
+			return false, nil
+		}
+		return false, fmt.Errorf("git bundle failed: %s", strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// handoffPatch returns a binary diff of the uncommitted changes of the
+// worktree at dir against HEAD, untracked files included. It stages them in
+// a throwaway index so the worktree's own index is left alone.
+func handoffPatch(dir string) ([]byte, error) {
+	index, err := os.CreateTemp("", "wt-handoff-index-")
+	if err != nil {
+		return nil, err
+	}
+	index.Close()
+	defer os.Remove(index.Name())
+	env := append(os.Environ(), "GIT_INDEX_FILE="+index.Name())
+	for _, args := range [][]string{{"read-tree", "HEAD"}, {"add", "-A"}} {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(out)))
+		}
+	}
+	cmd := exec.Command("git", "-C", dir, "diff", "--cached", "--binary", "HEAD")
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+	return out, nil
+}
+
+// handoffImage returns a reference to the image of the worktree's
+// devcontainer that a teammate can pull: its repository digest when it was
+// pulled or pushed, or else its name. It is empty without a container.
+func handoffImage(dir string) string {
+	image, err := worktreeImage(dir)
+	if err != nil {
+		return ""
+	}
+	out, err := exec.Command("docker", "image", "inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", image).Output()
+	if err == nil {
+		if digest := strings.TrimSpace(strings.Split(string(out), "\n")[0]); digest != "" {
+			return digest
+		}
+	}
+	return image
+}
+
+// writeTarEntry adds a file named name with data to tw.
+func writeTarEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// runHandOff implements 'wt hand-off': it packages the worktree at dir into a
+// .tar.gz holding a manifest, a bundle of its unpushed commits, and a patch
+// of its uncommitted work, and prints the 'wt receive' command that rebuilds
+// it. out is the archive path; empty means handoff/ in the artifacts
+// directory.
+func runHandOff(dir, out string) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	st := getWorktreeStatus(dir)
+	head, err := revParse(dir, "HEAD")
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(filepath.Base(dir), filepath.Base(mainRoot)+"@")
+	m := handoffManifest{
+		Version: handoffVersion,
+		Repo:    filepath.Base(mainRoot),
+		Name:    name,
+		Branch:  st.branch,
+		Head:    head,
+		Image:   handoffImage(dir),
+		Created: time.Now().UTC().Truncate(time.Second),
+		Files:   map[string]string{},
+	}
+	if remote, err := exec.Command("git", "-C", mainRoot, "remote", "get-url", "origin").Output(); err == nil {
+		m.Remote = strings.TrimSpace(string(remote))
+	}
+
+	entries := map[string][]byte{}
+	tmp, err := os.MkdirTemp("", "wt-handoff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	bundlePath := filepath.Join(tmp, handoffBundleFile)
+	if ok, err := handoffBundle(dir, st.branch, bundlePath); err != nil {
+		return err
+	} else if ok {
+		if entries[handoffBundleFile], err = os.ReadFile(bundlePath); err != nil {
+			return err
+		}
+	}
+	patch, err := handoffPatch(dir)
+	if err != nil {
+		return err
+	}
+	if len(patch) > 0 {
+		entries[handoffPatchFile] = patch
+	}
+	for entry, data := range entries {
+		sum := sha256.Sum256(data)
+		m.Files[entry] = hex.EncodeToString(sum[:])
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		artifacts, err := artifactsDir(dir)
+		if err != nil {
+			return err
+		}
+		handoffDir := filepath.Join(artifacts, "handoff")
+		if err := os.MkdirAll(handoffDir, 0755); err != nil {
+			return fmt.Errorf("failed to create hand-off directory: %w", err)
+		}
+		out = filepath.Join(handoffDir, fmt.Sprintf("%s@%s-%s.tar.gz", m.Repo, name, time.Now().Format("20060102-150405")))
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = writeTarEntry(tw, handoffManifestFile, append(manifest, '\n'), m.Created)
+	for _, entry := range []string{handoffBundleFile, handoffPatchFile} {
+		if data, ok := entries[entry]; ok && err == nil {
+			err = writeTarEntry(tw, entry, data, m.Created)
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Packaged %s at %s", name, st.ref())
+	var parts []string
+	if _, ok := entries[handoffBundleFile]; ok {
+		parts = append(parts, "unpushed commits")
+	}
+	if _, ok := entries[handoffPatchFile]; ok {
+		parts = append(parts, "uncommitted changes")
+	}
+	if m.Image != "" {
+		parts = append(parts, "image "+m.Image)
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(os.Stderr, " with %s", strings.Join(parts, ", "))
+	}
+	fmt.Fprintf(os.Stderr, ". Env files are not included.\nSend the file to your teammate; they rebuild the worktree with:\n  wt receive %s\n", filepath.Base(out))
+	fmt.Println(out)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// hooksDir holds executable hooks, relative to a worktree or the main
+// repository root.
+const hooksDir = ".wt/hooks"
+
+const hookPostAdd = "post-add"
+
+// findHook returns the executable hook called name for the worktree at dir:
+// the one committed in the worktree itself, or else a local one in the main
+// repository, or else the extends source's. It returns "" when there is none.
+func findHook(dir, name string) string {
+	candidates := []string{filepath.Join(dir, hooksDir, name)}
+	if mainRoot, err := getMainRepoRoot(); err == nil && mainRoot != dir {
+		candidates = append(candidates, filepath.Join(mainRoot, hooksDir, name))
+	}
+	if root := extendsRoot(); root != "" {
+		candidates = append(candidates, filepath.Join(root, hooksDir, name))
+	}
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Mode()&0111 == 0 {
+			warnf("chmod +x "+path+" to enable it", "%s is not executable; skipped it", path)
+			continue
+		}
+		return path
+	}
+	return ""
+}
+
+// runHook runs the hook called name, if any, in the worktree at dir. The hook
+// gets the worktree's name, path, and branch, and the main repository root
+// in WT_* variables. Its output goes to stderr so that stdout stays the
+// command's own (e.g. the path printed by 'wt add'). With --non-interactive
+// the hook gets no stdin, since nobody is there to answer it.
+func runHook(dir, name string) error {
+	path := findHook(dir, name)
+	if path == "" {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "==> %s hook: %s\n", name, path)
+	mainRoot, _ := getMainRepoRoot()
+	artifacts, _ := artifactsDir(dir)
+	c := exec.Command(path)
+	c.Dir = dir
+	c.Env = append(os.Environ(),
+		worktreeNameEnv+"="+filepath.Base(dir),
+		"WT_WORKTREE_PATH="+dir,
+		"WT_MAIN_ROOT="+mainRoot,
+		"WT_BRANCH="+getWorktreeStatus(dir).branch,
+		"WT_HOOK="+name,
+		artifactsEnv+"="+artifacts,
+	)
+	if !nonInteractive {
+		c.Stdin = os.Stdin
+	}
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s hook %s failed: %w", name, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// hostsMarker tags the /etc/hosts lines wt manages inside a devcontainer.
+const hostsMarker = "# wt-hosts"
+
+// hostsFile returns the path of the worktree's saved host overrides.
+func hostsFile(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "hosts.json"), nil
+}
+
+// loadHostOverrides returns the saved hostname -> target overrides for the
+// worktree at dir. Targets are IP addresses or names resolvable inside the
+// container (e.g. a compose service).
+func loadHostOverrides(dir string) (map[string]string, error) {
+	path, err := hostsFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]string{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hosts, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return hosts, nil
+}
+
+func saveHostOverrides(dir string, hosts map[string]string) error {
+	path, err := hostsFile(dir)
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// applyHostOverrides rewrites the wt-managed block of /etc/hosts in the
+// running devcontainer for dir. The container's SOCKS5 proxy resolves names
+// through /etc/hosts, so proxied browsers and curl see the overrides too.
+func applyHostOverrides(dir string) error {
+	hosts, err := loadHostOverrides(dir)
+	if err != nil {
+		return err
+	}
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		ip, err := resolveInContainer(containerID, hosts[name])
+		if err != nil {
+			warnf("", "%v", err)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%s %s", ip, name, hostsMarker))
+	}
+	// /etc/hosts is bind-mounted, so it must be rewritten in place rather
+	// than replaced.
+	script := fmt.Sprintf(`grep -v '%s$' /etc/hosts > /tmp/wt-hosts; printf '%%s' "$1" >> /tmp/wt-hosts; cat /tmp/wt-hosts > /etc/hosts; rm -f /tmp/wt-hosts`, hostsMarker)
+	block := ""
+	if len(lines) > 0 {
+		block = strings.Join(lines, "\n") + "\n"
+	}
+	out, err := exec.Command("docker", "exec", "-u", "root", containerID, "sh", "-c", script, "sh", block).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to update /etc/hosts in %s: %v: %s", filepath.Base(dir), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resolveInContainer returns target if it is an IP address, otherwise the
+// address it resolves to inside the container.
+func resolveInContainer(containerID, target string) (string, error) {
+	if net.ParseIP(target) != nil {
+		return target, nil
+	}
+	out, err := exec.Command("docker", "exec", containerID, "getent", "hosts", target).Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %q inside the container", target)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("cannot resolve %q inside the container", target)
+	}
+	return fields[0], nil
+}
+
+func runHostsAdd(dir string, specs []string) error {
+	hosts, err := loadHostOverrides(dir)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		name, target, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || target == "" {
+			return fmt.Errorf("invalid override %q; expected host=ip or host=container-host", spec)
+		}
+		hosts[name] = target
+	}
+	if err := saveHostOverrides(dir, hosts); err != nil {
+		return err
+	}
+	return applyHostOverridesIfRunning(dir)
+}
+
+func runHostsRemove(dir string, names []string) error {
+	hosts, err := loadHostOverrides(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := hosts[name]; !ok {
+			return fmt.Errorf("no override for %q", name)
+		}
+		delete(hosts, name)
+	}
+	if err := saveHostOverrides(dir, hosts); err != nil {
+		return err
+	}
+	return applyHostOverridesIfRunning(dir)
+}
+
+// applyHostOverridesIfRunning applies the overrides now if the container is
+// up; otherwise they are applied the next time 'wt up' starts it.
+func applyHostOverridesIfRunning(dir string) error {
+	if _, err := getContainerID(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Saved; overrides will be applied on the next 'wt up'\n")
+		return nil
+	}
+	return applyHostOverrides(dir)
+}
+
+func runHostsList(dir string) error {
+	hosts, err := loadHostOverrides(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, hosts[name])
+	}
+	return w.Flush()
+}
+
+// hasHostOverrides reports whether the worktree at dir has saved overrides.
+func hasHostOverrides(dir string) bool {
+	hosts, err := loadHostOverrides(dir)
+	return err == nil && len(hosts) > 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// defaultHostAlias is the name containers use for the host unless
+// hostServices.alias overrides it.
+const defaultHostAlias = "host.wt.internal"
+
+// hostAliasMarker tags the /etc/hosts line wt manages for the host alias; it
+// differs from hostsMarker so 'wt hosts' leaves the line alone.
+const hostAliasMarker = "# wt-host-alias"
+
+func (c HostServicesConfig) alias() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+	return defaultHostAlias
+}
+
+// hostServiceEnvName turns a service name like "auth-api" into "AUTH_API".
+func hostServiceEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// hostServiceEnv returns the env assignments that tell code in the worktree's
+// container where the host services are: WT_HOST, plus WT_HOST_<NAME>
+// (host:port) and WT_HOST_<NAME>_PORT for each service. Per-worktree
+// services are shifted by the worktree's port offset.
+func hostServiceEnv(dir string, cfg *Config) []string {
+	if len(cfg.HostServices.Services) == 0 {
+		return nil
+	}
+	alias := cfg.HostServices.alias()
+	offset := 0
+	if slot, err := worktreeSlot(dir); err == nil {
+		offset = slot * cfg.Env.portStride()
+	}
+	env := []string{"WT_HOST=" + alias}
+	for _, svc := range cfg.HostServices.Services {
+		port := svc.Port
+		if svc.PerWorktree {
+			port += offset
+		}
+		name := "WT_HOST_" + hostServiceEnvName(svc.Name)
+		env = append(env,
+			fmt.Sprintf("%s=%s:%d", name, alias, port),
+			fmt.Sprintf("%s_PORT=%d", name, port))
+	}
+	return env
+}
+
+// hostGatewayIP finds the address of the host as seen from the container:
+// the runtime's own host name when it provides one (Docker Desktop, podman),
+// otherwise the container's default gateway.
+func hostGatewayIP(containerID string) (string, error) {
+	for _, name := range []string{"host.docker.internal", "host.containers.internal"} {
+		if ip, err := resolveInContainer(containerID, name); err == nil {
+			return ip, nil
+		}
+	}
+	out, err := exec.Command("docker", "exec", containerID, "cat", "/proc/net/route").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the container's routes: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("the container has no default route to the host")
+}
+
+// applyHostServices points the host alias at the host in the running
+// devcontainer's /etc/hosts and exports the host service env for login
+// shells through /etc/profile.d/wt-host.sh.
+func applyHostServices(dir string, cfg *Config) error {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	ip, err := hostGatewayIP(containerID)
+	if err != nil {
+		return err
+	}
+	var profile strings.Builder
+	for _, e := range hostServiceEnv(dir, cfg) {
+		fmt.Fprintf(&profile, "export %s\n", e)
+	}
+	line := fmt.Sprintf("%s\t%s %s\n", ip, cfg.HostServices.alias(), hostAliasMarker)
+	// /etc/hosts is bind-mounted, so it must be rewritten in place rather
+	// than replaced.
+	script := fmt.Sprintf(`grep -v '%s$' /etc/hosts > /tmp/wt-hosts; printf '%%s' "$1" >> /tmp/wt-hosts; cat /tmp/wt-hosts > /etc/hosts; rm -f /tmp/wt-hosts
+mkdir -p /etc/profile.d && printf '%%s' "$2" > /etc/profile.d/wt-host.sh`, hostAliasMarker)
+	out, err := exec.Command("docker", "exec", "-u", "root", containerID, "sh", "-c", script, "sh", line, profile.String()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set up host services in %s: %v: %s", filepath.Base(dir), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c HostServicesConfig) validate() error {
+	if strings.ContainsAny(c.Alias, " \t/") {
+		return fmt.Errorf("hostServices.alias %q is not a valid host name", c.Alias)
+	}
+	seen := map[string]bool{}
+	for _, svc := range c.Services {
+		if svc.Name == "" {
+			return fmt.Errorf("hostServices.services: every service needs a name")
+		}
+		env := hostServiceEnvName(svc.Name)
+		if seen[env] {
+			return fmt.Errorf("hostServices.services: %q clashes with another service name", svc.Name)
+		}
+		seen[env] = true
+		if svc.Port < 1 || svc.Port > 65535 {
+			return fmt.Errorf("hostServices.services: %s has invalid port %d", svc.Name, svc.Port)
+		}
+	}
+	return nil
+}
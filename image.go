@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// worktreeImage returns the image of the worktree's devcontainer, running or
+// stopped.
+func worktreeImage(dir string) (string, error) {
+	out, err := exec.Command("docker", "ps", "-a", "--filter", "label=devcontainer.local_folder="+dir, "--format", "{{.Image}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query docker: %w", err)
+	}
+	image := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	if image == "" {
+		return "", fmt.Errorf("no devcontainer found for %q; create one with: wt up %s", filepath.Base(dir), filepath.Base(dir))
+	}
+	return image, nil
+}
+
+// imageLayer is one entry of 'docker history'.
+type imageLayer struct {
+	size      int64
+	createdBy string
+}
+
+func imageLayers(image string) ([]imageLayer, error) {
+	out, err := exec.Command("docker", "history", "--no-trunc", "--human=false", "--format", "{{.Size}}\t{{.CreatedBy}}", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker history failed: %w", err)
+	}
+	var layers []imageLayer
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		sizeField, createdBy, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		size, _ := strconv.ParseInt(sizeField, 10, 64)
+		layers = append(layers, imageLayer{size: size, createdBy: createdBy})
+	}
+	return layers, nil
+}
+
+// summarizeLayerCommand shortens a Dockerfile step for display.
+func summarizeLayerCommand(createdBy string, width int) string {
+	s := strings.TrimPrefix(createdBy, "/bin/sh -c ")
+	s = strings.TrimPrefix(s, "#(nop) ")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > width {
+		s = s[:width-3] + "..."
+	}
+	return s
+}
+
+// runImageReport prints the size of the worktree's devcontainer image, its
+// largest layers, and optionally a trivy vulnerability scan.
+func runImageReport(dir string, top int, vulns bool) error {
+	image, err := worktreeImage(dir)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "image", "inspect", "--format", "{{.Size}}", image).Output()
+	if err != nil {
+		return fmt.Errorf("docker image inspect failed: %w", err)
+	}
+	total, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	layers, err := imageLayers(image)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Image:  %s\nSize:   %s in %d layers\n\n", image, formatSize(total), len(layers))
+	sort.SliceStable(layers, func(i, j int) bool { return layers[i].size > layers[j].size })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tSHARE\tSTEP")
+	for i, l := range layers {
+		if i == top || l.size == 0 {
+			break
+		}
+		share := 0.0
+		if total > 0 {
+			share = float64(l.size) * 100 / float64(total)
+		}
+		fmt.Fprintf(w, "%s\t%.0f%%\t%s\n", formatSize(l.size), share, summarizeLayerCommand(l.createdBy, 80))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !vulns {
+		return nil
+	}
+	fmt.Println()
+	if _, err := exec.LookPath("trivy"); err != nil {
+		warnf("install it from https://trivy.dev", "trivy is not installed; skipping the vulnerability scan")
+		return nil
+	}
+	trivy := exec.Command("trivy", "image", "--quiet", "--severity", "HIGH,CRITICAL", image)
+	trivy.Stdout = os.Stdout
+	trivy.Stderr = os.Stderr
+	return childExitError(trivy.Run())
+}
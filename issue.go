@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultIssueCommand fetches an issue with the GitHub CLI when
+// add.issueCommand is not configured.
+const defaultIssueCommand = "gh issue view {number} --json number,title,url"
+
+// issueSlugMax bounds the title part of a worktree name derived from an
+// issue.
+const issueSlugMax = 40
+
+// trackerIssue is an issue 'wt add --issue' creates a worktree for.
+type trackerIssue struct {
+	number int
+	title  string
+	url    string
+}
+
+// fetchIssue runs command, with {number} replaced, in the main repository
+// and reads the issue from its output: a JSON object with a title and a url
+// (or web_url) like gh and glab print, or else plain text whose first line
+// is the title.
+func fetchIssue(number int, command string) (trackerIssue, error) {
+	issue := trackerIssue{number: number}
+	if offline {
+		return issue, errOffline("--issue")
+	}
+	if command == "" {
+		command = defaultIssueCommand
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return issue, err
+	}
+	command = strings.ReplaceAll(command, "{number}", strconv.Itoa(number))
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = mainRoot
+	out, err := cmd.Output()
+	if err != nil {
+		msg := err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			msg = strings.TrimSpace(lastLine(string(exitErr.Stderr)))
+		}
+		return issue, fmt.Errorf("failed to fetch issue #%d with %q: %s", number, command, msg)
+	}
+	var fields struct {
+		Title  string `json:"title"`
+		URL    string `json:"url"`
+		WebURL string `json:"web_url"`
+	}
+	if json.Unmarshal(out, &fields) == nil {
+		issue.title, issue.url = fields.Title, fields.URL
+		if issue.url == "" {
+			issue.url = fields.WebURL
+		}
+	} else {
+		title, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		issue.title = strings.TrimSpace(title)
+	}
+	if issue.title == "" {
+		return issue, fmt.Errorf("issue #%d has no title in the output of %q", number, command)
+	}
+	return issue, nil
+}
+
+// worktreeName derives a name such as 1234-fix-login-timeout from the
+// issue's number and title, adjusted to the naming convention if it can be.
+func (i trackerIssue) worktreeName(naming NamingConfig) string {
+	slug := nameSlug(i.title)
+	if len(slug) > issueSlugMax {
+		slug = slug[:issueSlugMax]
+		if cut := strings.LastIndex(slug, "-"); cut > 0 {
+			slug = slug[:cut]
+		}
+		slug = strings.Trim(slug, "-")
+	}
+	name := fmt.Sprintf("issue-%d", i.number)
+	if slug != "" {
+		name = fmt.Sprintf("%d-%s", i.number, slug)
+	}
+	if naming.enabled() && !naming.matches(name) {
+		if suggestions := naming.suggest(name); len(suggestions) > 0 {
+			return suggestions[0]
+		}
+	}
+	return name
+}
+
+// recordIssue sets WT_ISSUE and WT_ISSUE_URL in the worktree's
+// .devcontainer/.env, so that tools in the container, and agents, know which
+// issue the worktree is for. The file is kept out of git status.
+func recordIssue(dir string, issue trackerIssue) error {
+	path := filepath.Join(dir, ".devcontainer", ".env")
+	vars := [][2]string{{"WT_ISSUE", strconv.Itoa(issue.number)}}
+	if issue.url != "" {
+		vars = append(vars, [2]string{"WT_ISSUE_URL", issue.url})
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	for _, v := range vars {
+		line := v[0] + "=" + v[1]
+		replaced := false
+		for i, l := range lines {
+			if envKey(l) == v[0] {
+				lines[i], replaced = line, true
+			}
+		}
+		if !replaced {
+			lines = append(lines, line)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return excludePaths(dir, []string{".devcontainer/.env"})
+}
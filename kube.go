@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// kubeconfigFile is the worktree's own kubeconfig, in its state
+	// directory.
+	kubeconfigFile = "kubeconfig"
+	// kubeconfigContainerPath is where 'wt up' mounts it in the container.
+	kubeconfigContainerPath = "/etc/wt/kubeconfig"
+)
+
+func (c KubeCredentialsConfig) enabled() bool {
+	return c.Context != "" || c.Namespace != ""
+}
+
+// writeKubeconfig generates the kubeconfig of the worktree at dir from the
+// host's: only the configured context, with its cluster and user inlined,
+// pinned to the configured namespace. It rewrites the file in place, so a
+// container that has it mounted sees the update, and returns its path.
+func writeKubeconfig(dir string, c KubeCredentialsConfig) (string, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return "", fmt.Errorf("credentials.kube needs kubectl on the host")
+	}
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"config", "view", "--minify", "--flatten"}
+	if c.Context != "" {
+		args = append(args, "--context", expandWorktreeFormat(c.Context, dir))
+	}
+	out, err := exec.Command("kubectl", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the kubeconfig: %s", kubectlError(err))
+	}
+	path := filepath.Join(stateDir, kubeconfigFile)
+	if c.Namespace != "" {
+		// Pin the namespace in a scratch copy, so that a failure never
+		// leaves an unpinned kubeconfig behind.
+		scratch := path + ".new"
+		defer os.Remove(scratch)
+		if err := os.WriteFile(scratch, out, 0600); err != nil {
+			return "", fmt.Errorf("failed to write the worktree's kubeconfig: %w", err)
+		}
+		ns := expandWorktreeFormat(c.Namespace, dir)
+		if _, err := exec.Command("kubectl", "--kubeconfig", scratch, "config", "set-context", "--current", "--namespace", ns).Output(); err != nil {
+			return "", fmt.Errorf("failed to pin namespace %s: %s", ns, kubectlError(err))
+		}
+		if out, err = os.ReadFile(scratch); err != nil {
+			return "", err
+		}
+	}
+	if err := os.WriteFile(path, out, 0600); err != nil {
+		return "", fmt.Errorf("failed to write the worktree's kubeconfig: %w", err)
+	}
+	return path, nil
+}
+
+// kubectlError returns kubectl's message for err.
+func kubectlError(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return err.Error()
+}
+
+// kubeMountArgs returns the 'devcontainer up' arguments that mount the
+// worktree's freshly generated kubeconfig into the container, read-only so
+// that 'kubectl config' in the container can't switch it elsewhere.
+func kubeMountArgs(dir string, c KubeCredentialsConfig) ([]string, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	path, err := writeKubeconfig(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"--mount", fmt.Sprintf("type=bind,source=%s,target=%s,readonly", path, kubeconfigContainerPath)}, nil
+}
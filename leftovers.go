@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// keepFile lists, one gitignore-style pattern per line, untracked files that
+// 'wt rm' backs up instead of deleting. It is read from the worktree and
+// from the main repository.
+const keepFile = ".wt/keep"
+
+// leftoverSkipped are wt's own untracked directories, which 'wt rm' deletes
+// without listing them.
+var leftoverSkipped = []string{".vscode-profile/**", ".chrome-profile/**"}
+
+// keepGlob turns a gitignore-style pattern into a matchGlob pattern: one
+// without a slash matches at any depth, a leading slash anchors it to the
+// worktree root, and a trailing slash (or a directory name) covers
+// everything below.
+func keepGlob(p string) string {
+	p = strings.TrimSuffix(p, "/")
+	if strings.HasPrefix(p, "/") {
+		p = strings.TrimPrefix(p, "/")
+	} else if !strings.Contains(p, "/") {
+		p = "**/" + p
+	}
+	return p
+}
+
+// matchesKeep reports whether rel, or a directory containing it, matches
+// one of the keepGlob patterns.
+func matchesKeep(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, rel) || matchGlob(p+"/**", rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepPatterns returns the keepGlob patterns for the worktree at dir: those
+// in its .wt/keep and the main repository's, plus extra (from --keep).
+func keepPatterns(dir string, extra []string) []string {
+	files := []string{filepath.Join(dir, keepFile)}
+	if mainRoot, err := getMainRepoRoot(); err == nil && mainRoot != dir {
+		files = append(files, filepath.Join(mainRoot, keepFile))
+	}
+	var lines []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+	lines = append(lines, extra...)
+	var patterns []string
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		patterns = append(patterns, keepGlob(l))
+	}
+	return patterns
+}
+
+// leftoverGroup sums untracked files under one top-level path.
+type leftoverGroup struct {
+	path  string
+	files int
+	size  int64
+}
+
+// groupLeftovers groups the files (relative to dir) by top-level path,
+// largest first.
+func groupLeftovers(dir string, files []string) ([]leftoverGroup, int64) {
+	groups := map[string]*leftoverGroup{}
+	var total int64
+	for _, rel := range files {
+		top, rest, nested := strings.Cut(rel, "/")
+		if nested && rest != "" {
+			top += "/"
+		}
+		g := groups[top]
+		if g == nil {
+			g = &leftoverGroup{path: top}
+			groups[top] = g
+		}
+		g.files++
+		if info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel))); err == nil {
+			g.size += info.Size()
+			total += info.Size()
+		}
+	}
+	list := make([]leftoverGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].size > list[j].size })
+	return list, total
+}
+
+// countFiles formats n as "1 file" or "n files".
+func countFiles(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+func printLeftoverGroups(groups []leftoverGroup) {
+	const shown = 10
+	for i, g := range groups {
+		if i == shown {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(groups)-shown)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  %8s  %s (%s)\n", formatSize(g.size), g.path, countFiles(g.files))
+	}
+}
+
+// reviewLeftovers runs before the worktree at dir is removed. It lists the
+// untracked and ignored files that removing it will delete and, on a
+// terminal and unless yes is set, asks before going on. Files matching the
+// keep patterns are copied to a backup directory in wt's state first.
+func reviewLeftovers(dir string, keep []string, yes bool) error {
+	files, err := untrackedFiles(dir)
+	if err != nil {
+		return err
+	}
+	skipped := append([]string{}, leftoverSkipped...)
+	if cfg, err := loadConfig(); err == nil {
+		for _, e := range cfg.Share {
+			skipped = append(skipped, strings.TrimPrefix(e.Path, "/"))
+		}
+	}
+	patterns := keepPatterns(dir, keep)
+	var kept, deleted []string
+	for _, rel := range files {
+		switch {
+		case matchesKeep(skipped, rel):
+		case matchesKeep(patterns, rel):
+			kept = append(kept, rel)
+		default:
+			deleted = append(deleted, rel)
+		}
+	}
+	name := filepath.Base(dir)
+	if len(deleted) > 0 {
+		groups, total := groupLeftovers(dir, deleted)
+		fmt.Fprintf(os.Stderr, "Removing %s deletes %s that git does not track (%s):\n", name, countFiles(len(deleted)), formatSize(total))
+		printLeftoverGroups(groups)
+		if !yes && !nonInteractive && term.IsTerminal(int(os.Stdin.Fd())) {
+			ok, err := promptYesNo("Delete them?", true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("aborted; keep files with --keep <pattern> or list them in %s", keepFile)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return backupKept(dir, kept)
+}
+
+// backupKept copies the files (relative to dir) into a new backup directory
+// under the repository's wt state. They are copied, not moved: should the
+// removal fail, the worktree is left as it was.
+func backupKept(dir string, files []string) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(repoDir, "backups", filepath.Base(dir)+"-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return fmt.Errorf("failed to create the backup directory: %w", err)
+	}
+	// A retried removal within the same second gets a directory of its own.
+	backup := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(backup, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create the backup directory: %w", err)
+		}
+		backup = fmt.Sprintf("%s-%d", base, i)
+	}
+	_, total := groupLeftovers(dir, files)
+	for _, rel := range files {
+		src := filepath.Join(dir, filepath.FromSlash(rel))
+		dst := filepath.Join(backup, filepath.FromSlash(rel))
+		if err := copyPreservingMode(src, dst); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", rel, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Kept %s (%s) in %s\n", countFiles(len(files)), formatSize(total), backup)
+	return nil
+}
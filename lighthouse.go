@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lighthouseCategories is the order Lighthouse lists its categories in.
+var lighthouseCategories = []string{"performance", "accessibility", "best-practices", "seo", "pwa"}
+
+// lighthouseOptions are the flags of 'wt lighthouse'.
+type lighthouseOptions struct {
+	outDir  string
+	only    []string // categories to audit; empty means all
+	desktop bool     // audit with the desktop preset instead of mobile emulation
+}
+
+// lighthouseCommand returns the command that runs Lighthouse: the installed
+// CLI, or else the npm package through npx.
+func lighthouseCommand() ([]string, error) {
+	if p, err := exec.LookPath("lighthouse"); err == nil {
+		return []string{p}, nil
+	}
+	if p, err := exec.LookPath("npx"); err == nil {
+		return []string{p, "--yes", "lighthouse"}, nil
+	}
+	return nil, fmt.Errorf("could not find lighthouse or npx; install it with 'npm install -g lighthouse'")
+}
+
+// lighthouseReportDir returns where reports for the worktree at dir go:
+// outDir, or else lighthouse/ in its artifacts directory.
+func lighthouseReportDir(dir, outDir string) (string, error) {
+	if outDir == "" {
+		artifacts, err := artifactsDir(dir)
+		if err != nil {
+			return "", err
+		}
+		outDir = filepath.Join(artifacts, "lighthouse")
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+	return outDir, nil
+}
+
+// lighthouseScores reads the category scores (0-100) from a JSON report.
+// A category Lighthouse could not score is -1.
+func lighthouseScores(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Categories map[string]struct {
+			Score *float64 `json:"score"`
+		} `json:"categories"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	scores := map[string]int{}
+	for id, c := range report.Categories {
+		scores[id] = -1
+		if c.Score != nil {
+			scores[id] = int(math.Round(*c.Score * 100))
+		}
+	}
+	return scores, nil
+}
+
+// runLighthouse implements 'wt lighthouse': it audits url with Lighthouse in
+// headless Chrome through the proxy of the worktree at dir, saves the HTML
+// and JSON reports named after the time and commit, and prints the scores.
+func runLighthouse(dir, arg string, opts lighthouseOptions) error {
+	lighthouse, err := lighthouseCommand()
+	if err != nil {
+		return err
+	}
+	chromeBin, err := findChromeBinary()
+	if err != nil {
+		return err
+	}
+	proxy, err := requireLiveProxy(dir)
+	if err != nil {
+		return err
+	}
+	outDir, err := lighthouseReportDir(dir, opts.outDir)
+	if err != nil {
+		return err
+	}
+	st := getWorktreeStatus(dir)
+	base := time.Now().Format("20060102-150405")
+	if st.head != "" {
+		base += "-" + st.head
+	}
+	base = filepath.Join(outDir, base)
+
+	url := screenshotURL(dir, arg)
+	chromeFlags := []string{
+		"--headless=new",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--proxy-server=" + proxy.proxyServer(),
+		"--proxy-bypass-list=<-loopback>",
+	}
+	args := append([]string{}, lighthouse[1:]...)
+	args = append(args, url,
+		"--output=json", "--output=html",
+		"--output-path="+base,
+		"--chrome-flags="+strings.Join(chromeFlags, " "))
+	if len(opts.only) > 0 {
+		args = append(args, "--only-categories="+strings.Join(opts.only, ","))
+	}
+	if opts.desktop {
+		args = append(args, "--preset=desktop")
+	}
+	if !verbose {
+		args = append(args, "--quiet")
+	}
+	cmd := exec.Command(lighthouse[0], args...)
+	cmd.Env = append(os.Environ(), "CHROME_PATH="+chromeBin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Running: %s %s\n", lighthouse[0], strings.Join(args, " "))
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	fmt.Fprintf(os.Stderr, "Auditing %s in %s\n", url, filepath.Base(dir))
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(lastLine(stderr.String())); msg != "" {
+			return fmt.Errorf("lighthouse failed: %s", msg)
+		}
+		return fmt.Errorf("lighthouse failed: %w", err)
+	}
+
+	// With several outputs, Lighthouse names them <base>.report.<ext>.
+	htmlReport, jsonReport := base+".report.html", base+".report.json"
+	scores, err := lighthouseScores(jsonReport)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	order := func(id string) int {
+		for i, c := range lighthouseCategories {
+			if c == id {
+				return i
+			}
+		}
+		return len(lighthouseCategories)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if order(ids[i]) != order(ids[j]) {
+			return order(ids[i]) < order(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	state := ""
+	if st.dirty {
+		state = " with uncommitted changes"
+	}
+	fmt.Printf("%s at %s%s\n", filepath.Base(dir), st.ref(), state)
+	for _, id := range ids {
+		score := "-"
+		if scores[id] >= 0 {
+			score = strconv.Itoa(scores[id])
+		}
+		fmt.Printf("  %-15s %3s\n", id, score)
+	}
+	fmt.Printf("%s\n%s\n", htmlReport, jsonReport)
+	return nil
+}
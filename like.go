@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// matchGlob reports whether the slash-separated relative path rel matches
+// pattern. Segments are matched with path.Match, and a "**" segment matches
+// any number of directories (including none).
+func matchGlob(pattern, rel string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// untrackedFiles lists the untracked and ignored files in the worktree at dir,
+// as slash-separated paths relative to dir.
+func untrackedFiles(dir string) ([]string, error) {
+	out, err := exec.Command("git", "-C", dir, "ls-files", "--others", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed in %s: %w", dir, err)
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// copyLikeFiles copies the untracked and ignored files of src that match any
+// of patterns into dst, preserving relative paths and permissions. Existing
+// files in dst are left alone. It returns the number of files copied.
+func copyLikeFiles(src, dst string, patterns []string) (int, error) {
+	entries := make([]CopyEntry, len(patterns))
+	for i, p := range patterns {
+		entries[i] = CopyEntry{Path: p}
+	}
+	return copyUntrackedFiles(src, dst, entries)
+}
+
+// copyUntrackedFiles copies the untracked and ignored files of src that match
+// any of entries into dst, preserving relative paths and permissions. A file
+// that already exists in dst is replaced only when the first entry matching
+// it has Overwrite set. It returns the number of files copied, and stops
+// with an error only on a hard failure such as a full disk.
+func copyUntrackedFiles(src, dst string, entries []CopyEntry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	files, err := untrackedFiles(src)
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, rel := range files {
+		var entry *CopyEntry
+		for i := range entries {
+			if matchGlob(entries[i].Path, rel) {
+				entry = &entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			continue
+		}
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if info, err := os.Lstat(target); err == nil {
+			if !entry.Overwrite || info.IsDir() {
+				continue
+			}
+			if err := os.Remove(target); err != nil {
+				warnf("", "failed to replace %s: %v", rel, err)
+				continue
+			}
+		}
+		if entry.Hardlink {
+			if err := linkFile(filepath.Join(src, filepath.FromSlash(rel)), target); err == nil {
+				copied++
+				continue
+			}
+		}
+		if err := copyPreservingMode(filepath.Join(src, filepath.FromSlash(rel)), target); isHardSetupError(err, dst) {
+			return copied, fmt.Errorf("failed to copy %s: %w", rel, err)
+		} else if err != nil {
+			warnf(fmt.Sprintf("copy it by hand: cp %s %s", filepath.Join(src, filepath.FromSlash(rel)), target), "failed to copy %s: %v", rel, err)
+			continue
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// copyPreservingMode copies a regular file or symlink, creating parent
+// directories as needed. Where the file system supports it, a file is
+// cloned copy-on-write instead of copying its bytes.
+func copyPreservingMode(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	}
+	if err := cloneFile(src, dst, info.Mode().Perm()); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkFile hard-links the regular file src at dst, creating parent
+// directories as needed. It fails across file systems, and for symlinks,
+// which are better copied.
+func linkFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Link(src, dst)
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// worktreeListing is a worktree as 'wt ls --json' reports it.
+type worktreeListing struct {
+	Repo         string       `json:"repo,omitempty"` // with --global
+	Name         string       `json:"name"`
+	Path         string       `json:"path"`
+	Branch       string       `json:"branch"` // empty when detached
+	Head         string       `json:"head"`
+	Detached     bool         `json:"detached"`
+	Devcontainer bool         `json:"devcontainer"`        // has .devcontainer/devcontainer.json
+	Container    *string      `json:"container"`           // docker state, e.g. "running"; null without a container
+	ProxyPort    string       `json:"proxyPort,omitempty"` // host port of the SOCKS5 proxy of a running container
+	Flags        []string     `json:"flags,omitempty"`     // with --all, e.g. "locked" or "orphaned"
+	LockReason   string       `json:"lockReason,omitempty"`
+	PruneReason  string       `json:"pruneReason,omitempty"`
+	Size         *int64       `json:"size,omitempty"` // bytes of the worktree's files, with --size
+	Base         *baseListing `json:"base,omitempty"` // with --base
+}
+
+// baseListing is how far a worktree's HEAD is from the base ref in 'wt ls
+// --json --base'.
+type baseListing struct {
+	Ref    string `json:"ref"`
+	Ahead  int    `json:"ahead"`
+	Behind int    `json:"behind"`
+}
+
+// newWorktreeListing describes wt, with its container's state from states
+// (see containerStates).
+func newWorktreeListing(wt siblingWorktree, states map[string]string) worktreeListing {
+	l := worktreeListing{Name: wt.name, Path: wt.path, Branch: wt.branch, Head: wt.head, Detached: wt.detached}
+	if flags := wt.flags(); len(flags) > 0 {
+		l.Flags, l.LockReason, l.PruneReason = flags, wt.lockReason, wt.pruneReason
+	}
+	if _, err := os.Stat(filepath.Join(wt.path, ".devcontainer", "devcontainer.json")); err == nil {
+		l.Devcontainer = true
+	}
+	if state, ok := states[wt.path]; ok {
+		l.Container = &state
+	}
+	return l
+}
+
+// printListingJSON writes listings to stdout as a JSON array.
+func printListingJSON(listings []worktreeListing) error {
+	if listings == nil {
+		listings = []worktreeListing{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(listings)
+}
+
+// porcelainVersion is the version of the 'wt ls --porcelain' format. It
+// changes only when a line is removed or its meaning changes; new attributes
+// may be added within a version.
+const porcelainVersion = 1
+
+// printListingPorcelain writes listings to stdout in the 'wt ls --porcelain'
+// format: a "version N" line, then one record per worktree of
+// "attribute value" lines, each record after an empty line. With nul, lines
+// end in NUL rather than newline, so that paths may hold any character.
+func printListingPorcelain(listings []worktreeListing, nul bool) error {
+	eol := "\n"
+	if nul {
+		eol = "\x00"
+	}
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString(eol)
+	}
+	line("version %d", porcelainVersion)
+	for _, l := range listings {
+		b.WriteString(eol)
+		line("worktree %s", l.Name)
+		line("path %s", l.Path)
+		if l.Repo != "" {
+			line("repo %s", l.Repo)
+		}
+		if l.Head != "" {
+			line("head %s", l.Head)
+		}
+		if l.Detached {
+			line("detached")
+		} else if l.Branch != "" {
+			line("branch refs/heads/%s", l.Branch)
+		}
+		for _, flag := range l.Flags {
+			switch flag {
+			case worktreeLocked:
+				line("%s", strings.TrimSpace(flag+" "+l.LockReason))
+			case worktreePrunable:
+				line("%s", strings.TrimSpace(flag+" "+l.PruneReason))
+			default:
+				line("%s", flag)
+			}
+		}
+		if l.Devcontainer {
+			line("devcontainer")
+		}
+		if l.Container != nil {
+			line("container %s", *l.Container)
+		}
+		if l.ProxyPort != "" {
+			line("proxy-port %s", l.ProxyPort)
+		}
+		if l.Size != nil {
+			line("size %d", *l.Size)
+		}
+		if l.Base != nil {
+			line("base %s %d %d", l.Base.Ref, l.Base.Ahead, l.Base.Behind)
+		}
+	}
+	_, err := os.Stdout.WriteString(b.String())
+	return err
+}
+
+// containerLabel describes the state of the devcontainer of the worktree at
+// path for the 'wt ls' tables: running, stopped, or no container.
+func containerLabel(states map[string]string, path string) string {
+	switch state, ok := states[path]; {
+	case !ok:
+		return "no container"
+	case state == "running":
+		return "running"
+	default:
+		return "stopped"
+	}
+}
+
+// The flags of 'wt ls --all'.
+const (
+	worktreeLocked     = "locked"
+	worktreePrunable   = "prunable"
+	worktreeOrphaned   = "orphaned"
+	worktreeNonSibling = "non-sibling"
+)
+
+// allWorktrees returns the worktrees 'wt ls --all' lists: the siblings,
+// worktrees git knows outside the repo@name layout, and repo@name
+// directories that git no longer knows (orphaned, e.g. left behind when
+// their metadata was pruned).
+func allWorktrees(mainRoot string) ([]siblingWorktree, error) {
+	worktrees, err := gitWorktrees(mainRoot, true)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, wt := range worktrees {
+		known[wt.path] = true
+	}
+	parentDir := filepath.Dir(mainRoot)
+	entries, err := os.ReadDir(parentDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		path := filepath.Join(parentDir, e.Name())
+		name := parseWorktreeName(e.Name(), filepath.Base(mainRoot))
+		if name == "" || !e.IsDir() || known[path] {
+			continue
+		}
+		worktrees = append(worktrees, siblingWorktree{name: name, path: path, orphaned: true})
+	}
+	return worktrees, nil
+}
+
+// flags returns the worktree's 'wt ls --all' flags.
+func (wt siblingWorktree) flags() []string {
+	var flags []string
+	if wt.locked {
+		flags = append(flags, worktreeLocked)
+	}
+	if wt.prunable {
+		flags = append(flags, worktreePrunable)
+	}
+	if wt.orphaned {
+		flags = append(flags, worktreeOrphaned)
+	}
+	if wt.nonSibling {
+		flags = append(flags, worktreeNonSibling)
+	}
+	return flags
+}
+
+// inspectable reports whether git can be asked about the worktree's files:
+// false when its directory or its git metadata is gone.
+func (wt siblingWorktree) inspectable() bool {
+	return !wt.orphaned && !wt.prunable
+}
+
+// flagsColumn renders the worktrees' flags as a listColumn, with a lock
+// reason when one was given.
+func flagsColumn(worktrees []siblingWorktree) listColumn {
+	c := listColumn{header: "FLAGS"}
+	for _, wt := range worktrees {
+		flags := wt.flags()
+		if wt.locked && wt.lockReason != "" {
+			flags[0] += " (" + wt.lockReason + ")"
+		}
+		cell := strings.Join(flags, ", ")
+		if cell == "" {
+			cell = "-"
+		}
+		c.cells = append(c.cells, cell)
+	}
+	return c
+}
+
+// worktreeSizes returns the size of each worktree's files for 'wt ls
+// --size', measured concurrently and cached (see cachedWorktreeSize).
+func worktreeSizes(worktrees []siblingWorktree) []int64 {
+	sizes := make([]int64, len(worktrees))
+	var wg sync.WaitGroup
+	for i, wt := range worktrees {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wt.inspectable() && !wt.nonSibling {
+				sizes[i] = cachedWorktreeSize(wt.path)
+			} else {
+				// Not a worktree wt keeps state for.
+				sizes[i] = dirSizes(wt.path)["."]
+			}
+		}()
+	}
+	wg.Wait()
+	return sizes
+}
+
+// listColumn is an optional column of the 'wt ls' tables, such as SIZE,
+// with a cell per worktree.
+type listColumn struct {
+	header string
+	cells  []string
+}
+
+// worktreeProxyPorts returns the host port of the SOCKS5 proxy of each
+// worktree whose devcontainer is running, discovered in parallel with
+// getProxyPort. Other worktrees, and those without a proxy, get "".
+func worktreeProxyPorts(worktrees []siblingWorktree, states map[string]string) []string {
+	ports := make([]string, len(worktrees))
+	var wg sync.WaitGroup
+	for i, wt := range worktrees {
+		if states[wt.path] != "running" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ports[i], _ = getProxyPort(wt.path)
+		}()
+	}
+	wg.Wait()
+	return ports
+}
+
+// proxyColumn renders worktreeProxyPorts as a listColumn; ok is false when
+// no worktree has a proxy port, so the tables only show it when it helps.
+func proxyColumn(ports []string) (c listColumn, ok bool) {
+	c.header = "PROXY"
+	for _, port := range ports {
+		if port == "" {
+			port = "-"
+		} else {
+			ok = true
+		}
+		c.cells = append(c.cells, port)
+	}
+	return c, ok
+}
+
+// sizeColumn renders worktreeSizes as a listColumn.
+func sizeColumn(sizes []int64) listColumn {
+	c := listColumn{header: "SIZE"}
+	for _, size := range sizes {
+		c.cells = append(c.cells, formatSize(size))
+	}
+	return c
+}
+
+// extraHeaders returns the headers of extra, each after a tab.
+func extraHeaders(extra []listColumn) string {
+	var b strings.Builder
+	for _, c := range extra {
+		b.WriteString("\t" + c.header)
+	}
+	return b.String()
+}
+
+// extraCells returns row i of extra, each cell after a tab.
+func extraCells(extra []listColumn, i int) string {
+	var b strings.Builder
+	for _, c := range extra {
+		b.WriteString("\t" + c.cells[i])
+	}
+	return b.String()
+}
+
+// runListShort implements plain 'wt ls': the worktree names with the state
+// of their devcontainers from states (see containerStates), followed by the
+// extra columns.
+func runListShort(worktrees []siblingWorktree, states map[string]string, extra []listColumn) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCONTAINER"+extraHeaders(extra))
+	for i, wt := range worktrees {
+		fmt.Fprintf(w, "%s\t%s%s\n", wt.name, containerLabel(states, wt.path), extraCells(extra, i))
+	}
+	return w.Flush()
+}
+
+// lsBaseFlagDefault is the --base value meaning "the configured base".
+const lsBaseFlagDefault = "default"
+
+// listSortKeys are the values of 'wt ls --sort'.
+var listSortKeys = []string{"name", "age", "size"}
+
+// listFilter selects the worktrees 'wt ls' shows. The filters compose: a
+// worktree must pass all that are set.
+type listFilter struct {
+	dirty   bool   // only worktrees with uncommitted changes
+	running bool   // only worktrees whose devcontainer is running
+	merged  string // only worktrees whose HEAD is contained in this ref
+}
+
+// worktreeStatuses inspects the worktrees in parallel.
+func worktreeStatuses(worktrees []siblingWorktree) []worktreeStatus {
+	statuses := make([]worktreeStatus, len(worktrees))
+	var wg sync.WaitGroup
+	for i, wt := range worktrees {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wt.inspectable() {
+				statuses[i] = getWorktreeStatus(wt.path)
+			}
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// filterWorktrees returns the worktrees that pass f, judging --running by
+// states (see containerStates).
+func filterWorktrees(mainRoot string, worktrees []siblingWorktree, f listFilter, states map[string]string) []siblingWorktree {
+	keep := make([]bool, len(worktrees))
+	for i := range keep {
+		keep[i] = true
+	}
+	if f.running {
+		for i, wt := range worktrees {
+			keep[i] = keep[i] && states[wt.path] == "running"
+		}
+	}
+	if f.dirty {
+		for i, st := range worktreeStatuses(worktrees) {
+			keep[i] = keep[i] && st.dirty
+		}
+	}
+	if f.merged != "" {
+		for i, c := range baseCounts(mainRoot, f.merged, worktrees) {
+			keep[i] = keep[i] && c.ok && c.ahead == 0
+		}
+	}
+	var kept []siblingWorktree
+	for i, wt := range worktrees {
+		if keep[i] {
+			kept = append(kept, wt)
+		}
+	}
+	return kept
+}
+
+// sortWorktrees orders the worktrees for 'wt ls --sort': by name, by age
+// (most recent commit first), or by size (biggest first).
+func sortWorktrees(worktrees []siblingWorktree, by string) {
+	keys := map[string]int64{}
+	switch by {
+	case "name":
+		sort.SliceStable(worktrees, func(i, j int) bool { return worktrees[i].name < worktrees[j].name })
+		return
+	case "age":
+		for i, st := range worktreeStatuses(worktrees) {
+			keys[worktrees[i].path] = st.lastCommit.Unix()
+		}
+	case "size":
+		for i, size := range worktreeSizes(worktrees) {
+			keys[worktrees[i].path] = size
+		}
+	default:
+		return
+	}
+	sort.SliceStable(worktrees, func(i, j int) bool { return keys[worktrees[i].path] > keys[worktrees[j].path] })
+}
+
+// resolveListBase returns the ref for --base or --merged: flag, unless it
+// asks for the default (see defaultBaseRef).
+func resolveListBase(mainRoot, flag string) (string, error) {
+	if flag != "" && flag != lsBaseFlagDefault {
+		if _, err := revParse(mainRoot, flag); err != nil {
+			return "", err
+		}
+		return flag, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return defaultBaseRef(mainRoot, cfg.Ls.Base)
+}
+
+// defaultBaseRef returns the ref 'wt ls --base' compares with: configured
+// (ls.base), or else the branch origin/HEAD points at, origin/main, or the
+// main worktree's branch.
+func defaultBaseRef(mainRoot, configured string) (string, error) {
+	candidates := []string{configured}
+	if configured == "" {
+		if out, err := exec.Command("git", "-C", mainRoot, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD").Output(); err == nil {
+			candidates = append(candidates, strings.TrimSpace(string(out)))
+		}
+		candidates = append(candidates, "origin/main", getWorktreeStatus(mainRoot).branch)
+	}
+	for _, ref := range candidates {
+		if ref == "" {
+			continue
+		}
+		if _, err := revParse(mainRoot, ref); err == nil {
+			return ref, nil
+		} else if configured != "" {
+			return "", fmt.Errorf("ls.base: %w", err)
+		}
+	}
+	return "", fmt.Errorf("cannot tell the default branch; set ls.base in %s or pass --base=<ref>", projectConfigFile)
+}
+
+// aheadBehind is how many commits a worktree has that a ref lacks, and the
+// reverse. ok is false when they could not be compared.
+type aheadBehind struct {
+	ahead, behind int
+	ok            bool
+}
+
+// baseCounts compares each worktree's HEAD with base. Worktrees on a branch
+// are compared in a single 'git for-each-ref' pass; detached worktrees, and
+// all of them with a git older than 2.41, fall back to a 'git rev-list' each,
+// run in parallel.
+func baseCounts(mainRoot, base string, worktrees []siblingWorktree) []aheadBehind {
+	counts := make([]aheadBehind, len(worktrees))
+	branches := map[string]aheadBehind{}
+	out, err := exec.Command("git", "-C", mainRoot, "for-each-ref",
+		"--format=%(refname:short)\t%(ahead-behind:"+base+")", "refs/heads/").Output()
+	if err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			branch, ab, _ := strings.Cut(line, "\t")
+			if fields := strings.Fields(ab); len(fields) == 2 {
+				ahead, err1 := strconv.Atoi(fields[0])
+				behind, err2 := strconv.Atoi(fields[1])
+				branches[branch] = aheadBehind{ahead, behind, err1 == nil && err2 == nil}
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for i, wt := range worktrees {
+		if c, ok := branches[wt.branch]; ok && wt.branch != "" {
+			counts[i] = c
+			continue
+		}
+		if !wt.inspectable() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := exec.Command("git", "-C", wt.path, "rev-list", "--left-right", "--count", "HEAD..."+base).Output()
+			if err != nil {
+				return
+			}
+			if fields := strings.Fields(string(out)); len(fields) == 2 {
+				ahead, err1 := strconv.Atoi(fields[0])
+				behind, err2 := strconv.Atoi(fields[1])
+				counts[i] = aheadBehind{ahead, behind, err1 == nil && err2 == nil}
+			}
+		}()
+	}
+	wg.Wait()
+	return counts
+}
+
+// baseColumn renders baseCounts as a listColumn headed by the base ref.
+func baseColumn(base string, counts []aheadBehind) listColumn {
+	c := listColumn{header: "VS " + strings.ToUpper(base)}
+	for _, ab := range counts {
+		c.cells = append(c.cells, formatUpstream(ab.ahead, ab.behind, ab.ok))
+	}
+	return c
+}
+
+// upstreamCounts returns how many commits the worktree at dir has that its
+// branch's upstream lacks, and the reverse. ok is false without an upstream.
+func upstreamCounts(dir string) (ahead, behind int, ok bool) {
+	out, err := exec.Command("git", "-C", dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}").Output()
+	if err != nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	ahead, err1 := strconv.Atoi(fields[0])
+	behind, err2 := strconv.Atoi(fields[1])
+	return ahead, behind, err1 == nil && err2 == nil
+}
+
+// formatUpstream renders upstreamCounts for 'wt ls -l'.
+func formatUpstream(ahead, behind int, ok bool) string {
+	switch {
+	case !ok:
+		return "-"
+	case ahead == 0 && behind == 0:
+		return "up to date"
+	case behind == 0:
+		return fmt.Sprintf("ahead %d", ahead)
+	case ahead == 0:
+		return fmt.Sprintf("behind %d", behind)
+	default:
+		return fmt.Sprintf("ahead %d, behind %d", ahead, behind)
+	}
+}
+
+// runListLong implements 'wt ls -l': a table of the worktrees with their
+// branch, dirty state, position relative to the upstream, the age of their
+// last commit, and their container state, followed by the extra columns.
+// The worktrees are inspected in parallel.
+func runListLong(worktrees []siblingWorktree, states map[string]string, extra []listColumn) error {
+	rows := make([]string, len(worktrees))
+	var wg sync.WaitGroup
+	for i, wt := range worktrees {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !wt.inspectable() {
+				rows[i] = fmt.Sprintf("%s\t-\t-\t-\t-\t%s", wt.name, containerLabel(states, wt.path)) + extraCells(extra, i)
+				return
+			}
+			st := getWorktreeStatus(wt.path)
+			state := "clean"
+			if st.dirty {
+				state = "dirty"
+			}
+			upstream := formatUpstream(upstreamCounts(wt.path))
+			rows[i] = fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", wt.name, st.ref(), state, upstream, formatAge(st.lastCommit), containerLabel(states, wt.path)) + extraCells(extra, i)
+		}()
+	}
+	wg.Wait()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBRANCH\tSTATUS\tUPSTREAM\tLAST COMMIT\tCONTAINER"+extraHeaders(extra))
+	for _, row := range rows {
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
@@ -15,12 +15,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 //go:embed SKILL.md
@@ -58,12 +61,18 @@ func main() {
 Each worktree lives at ../repo@name and can run its own isolated devcontainer
 with its own network, ports, and SOCKS5 proxy for accessing container services
 from the host.`,
+		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			configureCIMode(cmd)
+			configureOffline(cmd)
 			return nil
 		},
 	}
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "never prompt or spawn an interactive shell")
+	rootCmd.PersistentFlags().BoolVar(&ciMode, "ci", false, "CI mode: non-interactive, no TTY assumptions (default from $WT_CI or $CI)")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "never fetch from origin or pull images (default from $WT_OFFLINE)")
 
 	rootCmd.AddGroup(
 		&cobra.Group{ID: "worktree", Title: "Worktree commands:"},
@@ -74,28 +83,228 @@ from the host.`,
 
 	// Add command
 	addCmd := &cobra.Command{
-		Use:     "add <name>",
+		Use:     "add [name] [branch]",
 		Short:   "Create a new worktree",
 		GroupID: "worktree",
 		Long: `Creates a new git worktree at ../repo@<name> (a sibling of the main repo),
 detached at the current HEAD.
 
+With a branch (-b/--branch, or a second argument), the worktree is on that
+branch instead: an existing local branch is checked out, a branch that only
+exists on origin is created tracking it, and any other name is created at
+the current HEAD (or the --stack-on parent). Without a name, the worktree is
+named after the branch, with "/" replaced by "-".
+
+When origin has a branch named like the worktree, wt add asks whether to
+check it out with upstream tracking (on a terminal) or says how to.
+--track does so without asking, failing when origin has no such branch;
+--no-track keeps the detached HEAD.
+
+With --pr <number>, the head of that pull request (merge request on GitLab)
+is fetched from origin into origin/pr/<number> and the worktree starts there,
+named pr-<number> unless a name is given. Add -b to review on a local branch.
+
+With --issue <number>, the issue's title is fetched with 'gh issue view' (or
+the add.issueCommand in .wt.yaml) and the worktree and a new branch are named
+after it, e.g. 1234-fix-login-timeout, unless a name or -b is given. The
+issue number and URL are recorded as WT_ISSUE and WT_ISSUE_URL in the
+worktree's .devcontainer/.env.
+
 Automatically:
-  - Fetches from origin (if configured)
-  - Copies all .env* files from the root of the current worktree`,
-		Args: cobra.ExactArgs(1),
-		RunE: runAdd,
-	}
+  - Fetches every configured remote, in parallel (skip with --no-fetch)
+  - Copies all .env* files from the root of the current worktree, plus
+    .devcontainer/.env, filtered by the env.allow/env.deny rules in .wt.yaml,
+    substituting __WT_NAME__, __WT_REPO__, __WT_DIR__, __WT_SLOT__, and
+    __WT_PORT_OFFSET__ for the new worktree
+  - Copies untracked and ignored files matching the add.copy globs in
+    .wt.yaml (e.g. config/*.local.yaml); an entry with overwrite: true
+    replaces files the checkout already has
+  - Copies the contents of .wt/template (committed, or local to the main
+    repository; add.template in .wt.yaml points elsewhere), substituting
+    the same __WT_*__ placeholders in text files, without replacing files
+    the checkout already has
+  - Symlinks the share directories in .wt.yaml (e.g. node_modules) to one
+    copy shared by all worktrees
+  - Renders .env.wt.tmpl and .devcontainer/.env.wt.tmpl into .env files
+
+With --sparse <dir>,..., only those directories (and the files at the top
+level) are checked out, using a cone-mode sparse checkout. The paths are
+remembered in the repository's git config (wt.sparse) for later 'wt add'
+runs; add.sparse in .wt.yaml sets a default, and --no-sparse checks out
+everything.
+
+Shallow and partial clones are supported: a base ref missing from the local
+clone is fetched from origin on demand, --deepen <n> adds history to a shallow
+clone, and add.fetchFilter (e.g. blob:none) / add.fetchDepth in .wt.yaml are
+passed to the fetch.
+
+With --stack-on <worktree>, the new worktree starts at that worktree's HEAD
+and is recorded as stacked on it (as is a worktree whose base ref is another
+worktree's branch); 'wt restack' later rebases the stack in order.
+
+With --from-stash, the uncommitted changes of the current worktree
+(staged, unstaged, and untracked files) move into the new worktree through
+'git stash', leaving the current worktree clean. When they don't apply to
+the new worktree's base, or the new worktree is rolled back, they go back
+where they were.
+
+With --like <worktree>, env and add.copy files come from that worktree
+instead, along with its untracked and ignored files matching the add.like patterns in .wt.yaml.
+
+With --up, also starts the devcontainer and waits for its SOCKS5 proxy to
+accept connections; with --code, opens the worktree in VS Code afterwards;
+with --cd, finishes in the worktree, like 'wt cd'. Together they run in that
+order, e.g. 'wt add feature-x --up --code --cd'.
+
+The add.bootstrap commands from .wt.yaml then run in the new worktree, on the
+host or (with add.bootstrapIn: container) inside its devcontainer. The first
+failing command stops the sequence; the worktree is kept.
+
+Finally, an executable .wt/hooks/post-add (committed in the new worktree, or
+local to the main repository) runs in the new worktree on the host, with
+WT_WORKTREE, WT_WORKTREE_PATH, WT_BRANCH, WT_MAIN_ROOT, and WT_ARTIFACTS set.
+--no-hooks skips it.
+
+Without a name (or with --auto), a readable unique name is generated from
+add.namePattern in .wt.yaml (default "{adjective}-{noun}"; also {date},
+{time}, and {rand}) and reported on stderr.
+
+With --from-file <file> (- for stdin), creates a worktree for each
+"name [branch]" line, skipping blank lines and # comments. The other flags
+apply to every worktree; the remotes are fetched for the first entry (and
+the next ones, until a fetch succeeds), a failure doesn't stop the rest, and
+a per-name summary is printed at the end.
+
+With -i, first picks a branch from the local and remote branches, most
+recent first: type to narrow the list with a fuzzy filter and use the arrow
+keys to choose. A local branch is checked out, a remote one is checked out
+tracking it, and the name defaults to the branch's. Picking HEAD (or a
+branch another worktree has) starts there instead and asks whether to
+create a branch. It then asks whether to start the container and open
+VS Code.
+
+If setting up the new worktree fails hard after it was created (a full disk,
+no permission to write its files), wt offers to roll it back, removing the
+worktree and any branch it created; without a terminal it rolls back
+without asking. --no-rollback keeps the partial worktree instead.
+
+Problems that don't stop the add (e.g. a .env file that failed to copy) are
+listed at the end with what to do about them. With --json, stdout holds only
+the new worktree's name and path and those warnings, as JSON; everything
+else goes to stderr.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: runAddCommand,
+	}
+	addCmd.Flags().Bool("auto", false, "generate a name (the default when no name is given)")
+	addCmd.Flags().BoolP("interactive", "i", false, "pick a branch from a filterable list, then prompt for the name and follow-up actions")
+	addCmd.Flags().Bool("up", false, "start the devcontainer and wait until it is ready")
+	addCmd.Flags().Bool("code", false, "open the new worktree in VS Code")
+	addCmd.Flags().Bool("cd", false, "finish in the new worktree: cd there with the shell-init wrapper, otherwise open a shell")
+	addCmd.Flags().String("like", "", "copy env files and add.like untracked files from this worktree instead of the current one")
+	addCmd.Flags().Bool("no-bootstrap", false, "skip the add.bootstrap commands from .wt.yaml")
+	addCmd.Flags().Bool("no-hooks", false, "skip the .wt/hooks/post-add hook")
+	addCmd.Flags().Bool("from-stash", false, "move the current worktree's uncommitted changes, untracked files included, into the new worktree")
+	addCmd.Flags().Bool("no-rollback", false, "keep the worktree when setting it up fails hard (e.g. disk full)")
+	addCmd.Flags().String("from-file", "", "create a worktree for each \"name [branch]\" line of this file (- for stdin)")
+	addCmd.Flags().Bool("json", false, "print the new worktree's name, path, and any warnings as JSON")
+	addCmd.Flags().StringSlice("sparse", nil, "check out only these directories (cone mode); remembered for later worktrees")
+	addCmd.Flags().Bool("no-sparse", false, "check out everything, ignoring remembered sparse paths and add.sparse")
+	addCmd.Flags().Bool("no-fetch", false, "don't fetch the remotes first (a missing base ref is still fetched)")
+	addCmd.Flags().Int("deepen", 0, "in a shallow clone, fetch this many more commits of history first")
+	addCmd.Flags().String("stack-on", "", "start at another worktree's HEAD and record it as the parent for 'wt restack'")
+	addCmd.Flags().StringP("branch", "b", "", "create or check out this branch in the new worktree")
+	addCmd.Flags().Bool("track", false, "check out origin's branch of the same name (or -b) with upstream tracking")
+	addCmd.Flags().Bool("no-track", false, "detach even when origin has a branch named like the worktree")
+	addCmd.Flags().Int("pr", 0, "check out the head of this GitHub pull request (or GitLab merge request)")
+	addCmd.Flags().Bool("force", false, "override policy.maxWorktrees and policy.allowedImages (logged)")
+	addCmd.Flags().Int("issue", 0, "name the worktree and branch after this issue and record it in .devcontainer/.env")
 
 	// List command
 	lsCmd := &cobra.Command{
 		Use:     "ls",
 		Aliases: []string{"list"},
 		Short:   "List all sibling worktrees",
+		Long: `Lists the named sibling worktrees of the current repository and whether
+each one's devcontainer is running, stopped, or not created (no container),
+as docker reports it. While containers run, a PROXY column shows the host
+port of each one's SOCKS5 proxy, as 'wt proxy-port' prints it. With -q, or
+when the output is not a terminal and no column flags are given, prints
+only the names.
+
+With -l, shows a table of each worktree's branch (or short commit when
+detached), whether it has uncommitted changes, how far its branch is ahead
+of or behind its upstream, the age of its last commit, and its container
+state.
+
+With --size (also with -l or --json), adds the disk space each worktree's
+files take, measured in parallel. Sizes are cached for 10 minutes; 'wt du'
+measures afresh, and also counts containers and volumes.
+
+With --base, adds how many commits each worktree's HEAD is ahead of and
+behind the default branch: ls.base in .wt.yaml, or else the branch
+origin/HEAD points at (e.g. origin/main). --base=<ref> compares with another
+ref. Worktrees on a branch are compared in one git pass (git 2.41 or later).
+
+--dirty, --running, and --merged list only the worktrees with uncommitted
+changes, with a running devcontainer, or whose HEAD is already in the
+default branch (or --base=<ref>); given together, a worktree must match all
+of them. --sort orders the list by name, by age (most recent commit first),
+or by size (biggest first).
+
+With --all, the list also holds what it normally leaves out, flagged in a
+FLAGS column: worktrees git knows outside the repo@name layout (non-sibling,
+listed by directory name) and repo@name directories git no longer knows
+(orphaned; delete them once nothing in them is needed), and
+it flags locked worktrees and prunable ones whose directory or git metadata
+is gone ('git worktree prune' drops them).
+
+With --global, lists worktrees of every repository wt has been used with on
+this machine, along with their devcontainer status. Repositories are recorded
+in the registry whenever 'wt add' or 'wt ls' runs inside them.
+
+With --json, prints an array of objects with each worktree's name, path,
+branch, HEAD commit, whether HEAD is detached, whether it has a
+devcontainer, its container's state (null without one), and its proxyPort
+while the container runs; with --global, also its repo.
+
+With --porcelain, prints a line-based format for scripts that stays stable
+as the tables change. The first line is "version 1". Each worktree follows
+as an empty line and then one "attribute value" line per attribute:
+
+  worktree <name>
+  path <path>
+  repo <repo>                       with --global
+  head <commit>                     but for orphaned directories
+  branch refs/heads/<branch>        or "detached"; neither when orphaned
+  devcontainer                      when it has a devcontainer.json
+  container <state>                 docker's state, when a container exists
+  proxy-port <port>                 SOCKS5 proxy host port, while it runs
+  size <bytes>                      with --size
+  base <ref> <ahead> <behind>       with --base
+  locked [<reason>]                 with --all, and likewise prunable <reason>,
+                                    orphaned, and non-sibling
+
+Attributes may be added within a version, so skip unknown ones; the version
+changes if one is removed or changes meaning. With -z, every line ends with
+NUL instead of newline.`,
 		Args:    cobra.NoArgs,
 		RunE:    runList,
 		GroupID: "worktree",
 	}
+	lsCmd.Flags().Bool("global", false, "list worktrees across all registered repositories")
+	lsCmd.Flags().Bool("json", false, "print the worktrees as a JSON array")
+	lsCmd.Flags().Bool("porcelain", false, "print the worktrees in a stable, versioned format for scripts")
+	lsCmd.Flags().BoolP("null", "z", false, "with --porcelain, end lines with NUL instead of newline")
+	lsCmd.Flags().BoolP("long", "l", false, "show branch, dirty state, upstream, last commit age, container state, and proxy port")
+	lsCmd.Flags().BoolP("quiet", "q", false, "print only the worktree names")
+	lsCmd.Flags().BoolP("size", "s", false, "show the disk space each worktree's files take")
+	lsCmd.Flags().String("base", "", "show how far each worktree is ahead of or behind the default branch (or --base=<ref>)")
+	lsCmd.Flags().Lookup("base").NoOptDefVal = lsBaseFlagDefault
+	lsCmd.Flags().Bool("dirty", false, "list only worktrees with uncommitted changes")
+	lsCmd.Flags().Bool("running", false, "list only worktrees whose devcontainer is running")
+	lsCmd.Flags().Bool("merged", false, "list only worktrees whose HEAD is merged into the default branch (or --base=<ref>)")
+	lsCmd.Flags().BoolP("all", "a", false, "also list locked and prunable worktrees, worktrees outside the repo@name layout, and orphaned repo@name directories")
+	lsCmd.Flags().String("sort", "", "order by name, age (most recent commit first), or size (biggest first)")
 
 	// Remove command
 	rmCmd := &cobra.Command{
@@ -106,8 +315,27 @@ Automatically:
 		Long: `Removes the named worktree using 'git worktree remove', then deletes any
 remaining files in the worktree directory (e.g. .vscode-profile/, untracked files).
 
-Extra arguments are passed through to 'git worktree remove' (e.g. --force).`,
-		Args: cobra.MinimumNArgs(1),
+Extra arguments are passed through to 'git worktree remove' (e.g. --force).
+With policy.rmRequiresClean in .wt.yaml, a worktree with uncommitted changes
+is only removed with --force, and the override is logged.
+
+Removing the worktree you are standing in (e.g. 'wt rm .') also removes its
+devcontainer, then moves you to the main repo: through the 'wt shell-init'
+wrapper if loaded, otherwise by offering to open a shell there.
+
+Before removing, lists the untracked and ignored files that will be deleted
+with their sizes and, on a terminal, asks first (--yes skips the question).
+Files matching a --keep pattern, or a pattern in .wt/keep (gitignore-style,
+in the worktree or the main repo), are copied to a backup directory under
+wt's state first.
+
+With -i, shows the worktrees with their branch, dirty state, and age, lets you
+select several to remove, and asks for a final confirmation.
+
+Problems that don't stop the removal (e.g. a devcontainer that could not be
+removed) are listed at the end with what to do about them. With --json (given
+before the name), the result and those warnings are printed as JSON on stdout.`,
+		Args: cobra.ArbitraryArgs,
 		RunE: runRemove,
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			if len(args) != 0 {
@@ -117,6 +345,10 @@ Extra arguments are passed through to 'git worktree remove' (e.g. --force).`,
 		},
 	}
 	rmCmd.Flags().SetInterspersed(false)
+	rmCmd.Flags().BoolP("interactive", "i", false, "select worktrees to remove from a list")
+	rmCmd.Flags().StringArray("keep", nil, "back up untracked files matching this gitignore-style pattern instead of deleting them (repeatable)")
+	rmCmd.Flags().BoolP("yes", "y", false, "delete untracked files without asking")
+	rmCmd.Flags().Bool("json", false, "print the removed worktree and any warnings as JSON")
 
 	worktreeArgsCompletion := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) != 0 {
@@ -133,17 +365,59 @@ Extra arguments are passed through to 'git worktree remove' (e.g. --force).`,
 		Long: `Opens a new interactive shell in the named worktree directory.
 Without a name, opens a shell in the main repo root.
 
+Use repo/name to open a worktree of any registered repository (see
+'wt ls --global'), even from outside a git repository.
+
+With the 'wt shell-init' wrapper loaded, exiting the spawned shell leaves the
+calling shell in the worktree directory. Use --no-shell (or cd.noShell in
+.wt.yaml) to skip the subshell and change directory right away.
+
 Use -c to auto-create the worktree if it doesn't exist.`,
 		Args:              cobra.MaximumNArgs(1),
 		RunE:              runCD,
 		ValidArgsFunction: worktreeArgsCompletion,
 	}
 	cdCmd.Flags().BoolP("create", "c", false, "Create worktree if it doesn't exist")
+	cdCmd.Flags().Bool("no-shell", false, "With the shell-init wrapper, change directory without spawning a subshell")
+
+	// Shell-init command
+	shellInitCmd := &cobra.Command{
+		Use:     "shell-init [bash|zsh|fish]",
+		Short:   "Print a shell wrapper that lets 'wt cd' change the calling shell's directory",
+		GroupID: "setup",
+		Long: `Prints a 'wt' shell function to load from your shell's rc file.
+
+The wrapper passes a temp file to wt via $WT_CD_FILE; 'wt cd' records its
+target directory there, and the wrapper changes into it once wt exits. By
+default 'wt cd' still opens a subshell, and exiting it leaves you in the
+worktree; with --no-shell the directory changes immediately.
+
+Bash:
+  $ echo 'eval "$(wt shell-init bash)"' >> ~/.bashrc
+
+Zsh:
+  $ echo 'eval "$(wt shell-init zsh)"' >> ~/.zshrc
+
+Fish:
+  $ echo 'wt shell-init fish | source' >> ~/.config/fish/config.fish
+`,
+		DisableFlagsInUseLine: true,
+		ValidArgs:             []string{"bash", "zsh", "fish"},
+		Args:                  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			script, err := shellInitScript(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Print(script)
+			return nil
+		},
+	}
 
 	// Code command
 	codeCmd := &cobra.Command{
-		Use:     "code [name]",
-		Short:   "Open the worktree in VS Code",
+		Use:     "code [name] [-- editor-args...]",
+		Short:   "Open the worktree in VS Code or another editor",
 		GroupID: "worktree",
 		Long: `Opens the worktree directory in VS Code.
 
@@ -155,14 +429,44 @@ is available, this will:
   4. Route VS Code network traffic through the worktree's SOCKS5 proxy
 
 Without a devcontainer, opens the directory in VS Code directly.
-Use -c to auto-create the worktree if it doesn't exist.`,
-		Args:              cobra.MaximumNArgs(1),
+Use -c to auto-create the worktree if it doesn't exist.
+
+The editor is taken from --editor, then editor.command in .wt.yaml, then
+$VISUAL, and defaults to "code". VS Code-based editors (code, code-insiders,
+codium, cursor, windsurf, positron) can attach to the devcontainer; other
+editors open the host folder. --attach chooses the strategy: "auto" attaches
+when possible, "container" requires it, "host" never attaches. Arguments after
+-- are passed to the editor.
+
+Examples:
+  wt code feature --editor cursor --new-window
+  wt code feature --attach host -- --goto README.md`,
+		Args:              cobra.ArbitraryArgs,
 		RunE:              runCode,
 		ValidArgsFunction: worktreeArgsCompletion,
 	}
 	codeCmd.Flags().BoolP("create", "c", false, "Create worktree if it doesn't exist")
+	codeCmd.Flags().String("editor", "", "editor command (default: editor.command, $VISUAL, or code)")
+	codeCmd.Flags().String("attach", "", "attach strategy: auto, container, or host (default: editor.attach or auto)")
+	codeCmd.Flags().Bool("new-window", false, "pass --new-window to the editor")
+	codeCmd.Flags().Bool("force", false, "start a devcontainer whose base image policy.allowedImages does not approve (logged)")
+	addPrintCmdFlag(codeCmd)
 
 	// Completion command
+	genCompletion := func(shell string, w io.Writer) error {
+		switch shell {
+		case "bash":
+			return rootCmd.GenBashCompletion(w)
+		case "zsh":
+			return rootCmd.GenZshCompletion(w)
+		case "fish":
+			return rootCmd.GenFishCompletion(w, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletionWithDesc(w)
+		default:
+			return fmt.Errorf("unknown shell: %s", shell)
+		}
+	}
 	completionCmd := &cobra.Command{
 		Use:     "completion [bash|zsh|fish|powershell]",
 		Short:   "Generate shell completion scripts",
@@ -204,18 +508,7 @@ PowerShell:
 		ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
 		Args:                  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			switch args[0] {
-			case "bash":
-				return rootCmd.GenBashCompletion(os.Stdout)
-			case "zsh":
-				return rootCmd.GenZshCompletion(os.Stdout)
-			case "fish":
-				return rootCmd.GenFishCompletion(os.Stdout, true)
-			case "powershell":
-				return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
-			default:
-				return fmt.Errorf("unknown shell: %s", args[0])
-			}
+			return genCompletion(args[0], os.Stdout)
 		},
 	}
 
@@ -235,6 +528,31 @@ PowerShell:
 		},
 	}
 
+	// Reserve command
+	reserveCmd := &cobra.Command{
+		Use:     "reserve <prefix>",
+		Short:   "Reserve a unique worktree name without creating the worktree",
+		GroupID: "worktree",
+		Long: `Reserves the first free name of the form <prefix>-1, <prefix>-2, ... and prints
+it, so that orchestrators running agents in parallel can hand out names up
+front without racing each other on 'wt add':
+
+  name=$(wt reserve agent)
+  wt add "$name"
+
+The reservation is an exclusively created file in the repository's wt state
+directory, so concurrent 'wt reserve' calls never return the same name. A
+name is free when no worktree, local branch, or reservation uses it; names
+'wt add' generates skip reservations too. Creating the worktree consumes the
+reservation; unused ones expire after a day, or drop them with --release.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			release, _ := cmd.Flags().GetBool("release")
+			return runReserve(args, release)
+		},
+	}
+	reserveCmd.Flags().Bool("release", false, "drop the reservations of the given names instead")
+
 	// Dir command
 	dirCmd := &cobra.Command{
 		Use:     "dir",
@@ -242,6 +560,10 @@ PowerShell:
 		Args:    cobra.NoArgs,
 		GroupID: "worktree",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, folder, ok := sessionWorktree(); ok {
+				fmt.Println(folder)
+				return nil
+			}
 			root, err := getCurrentWorktreeRoot()
 			if err != nil {
 				return fmt.Errorf("not in a git repository")
@@ -251,6 +573,26 @@ PowerShell:
 		},
 	}
 
+	// Which command
+	whichCmd := &cobra.Command{
+		Use:     "which [path]",
+		Short:   "Print the worktree and repository a path belongs to",
+		GroupID: "worktree",
+		Long: `Maps a file or directory path (default: the current directory) back to the
+worktree that contains it, printing the repository, worktree name, and root.
+
+Paths that do not exist on the host, such as absolute paths reported from
+inside a devcontainer (/workspaces/repo@name/...), are matched against the
+worktrees of registered repositories by their repo@name directory.
+
+Examples:
+  wt which                          # worktree of the current directory
+  wt which --name src/main.go       # print only the worktree name`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runWhich,
+	}
+	whichCmd.Flags().Bool("name", false, "print only the worktree name")
+
 	// Exec command
 	execCmd := &cobra.Command{
 		Use:     "exec [name] [-- <command> [args...]]",
@@ -263,26 +605,254 @@ Without a command, opens an interactive shell inside the container.
 If the worktree has no .devcontainer/devcontainer.json, the command is run
 directly in the worktree directory instead.
 
+Commands in the devcontainer get WT_WORKTREE (repo@name) and
+WT_WORKSPACE_FOLDER, so that 'wt name' and 'wt dir' work inside it.
+
+exec.env.allow and exec.env.deny in .wt.yaml limit the host environment the
+session sees, through ${localEnv:...} in devcontainer.json or, without a
+devcontainer, directly.
+
+credentials.aws in .wt.yaml gives each worktree its own AWS role session (or
+profile), so parallel branches act under distinct, attributable identities.
+
+credentials.kube pins each worktree to a Kubernetes context and namespace:
+KUBECONFIG points at a kubeconfig of its own, which 'wt up' mounts read-only
+into the container.
+
+terraform.enabled sets TF_WORKSPACE (and, with terraform.backendKey, the
+backend's state key) per worktree, so parallel plans use separate state.
+
 Examples:
   wt exec                           # interactive shell in current worktree
   wt exec -- go test ./...          # run tests in current worktree's container
-  wt exec feature -- npm run dev    # run dev server in a named worktree`,
+  wt exec feature -- npm run dev    # run dev server in a named worktree
+  wt exec --record -- make test     # record the session for later replay
+
+With --record, the session is captured in asciinema v2 format under the
+worktree's state directory; browse recordings with 'wt sessions'.
+
+With --log-file, the command's output is also appended to a timestamped log
+in the worktree's state directory (or to --log-file=<path>); browse them with
+'wt logs --exec'. Set exec.log: true in .wt.yaml to log every command.
+
+With --ci (the default when $CI or $WT_CI is true), a command is required,
+stdin is detached from the terminal so no TTY is allocated, and CI=true is set
+for the command.
+
+With --service, the command runs with 'docker exec' in another container of
+a docker compose devcontainer, e.g. its db or worker service.
+
+With --prompt (or exec.prompt: true in .wt.yaml), an interactive shell's
+prompt starts with the worktree name, followed by the exit status of the last
+command when it failed.
+
+With --max-time (or exec.maxTime in .wt.yaml), a watchdog inside the
+container sends TERM, then KILL, to the command and every process it started
+once the wall-clock limit passes, and wt exits with status 152. Unlike
+--timeout, this also stops processes that detached from the 'wt exec'.
+Interactive shells are not limited.
+
+With --retries, a failed command is run again, e.g. a flaky test suite; add
+--retry-on <regexp> to retry only failures whose output matches, such as
+'ECONNRESET|flaky'. With --log-file, each attempt is logged separately.`,
 		Args:              cobra.ArbitraryArgs,
 		RunE:              runExec,
 		ValidArgsFunction: worktreeArgsCompletion,
 	}
 	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().Bool("record", false, "record the terminal session (asciinema v2) into the worktree's state")
+	execCmd.Flags().String("log-file", "", "tee output into a log in the worktree's state (or --log-file=<path>)")
+	execCmd.Flags().Lookup("log-file").NoOptDefVal = execLogFlagDefault
+	execCmd.Flags().Bool("prompt", false, "prefix the interactive shell's prompt with the worktree name (default from exec.prompt)")
+	execCmd.Flags().String("service", "", "run in this docker compose service instead of the devcontainer")
+	execCmd.Flags().Duration("max-time", 0, "kill the command and everything it started inside the container after this long (default from exec.maxTime)")
+	addRunPolicyFlags(execCmd)
+	addPrintCmdFlag(execCmd)
+
+	// Logs command
+	logsCmd := &cobra.Command{
+		Use:     "logs [--exec | --service <name>] [name] [id|last]",
+		Short:   "Show exec logs or the output of a docker compose service",
+		GroupID: "devcontainer",
+		Long: `With --exec (the default) and no id, lists the exec logs of a worktree with
+their exit status and duration. With an id (or unique id prefix, or "last"),
+prints that log.
+
+With --service, shows the container output of a service in a docker compose
+devcontainer, e.g. 'wt logs --service db -f'.`,
+		Args:              cobra.MaximumNArgs(2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, rest, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			if service, _ := cmd.Flags().GetString("service"); service != "" {
+				if len(rest) > 0 {
+					return fmt.Errorf("unexpected arguments: %s", strings.Join(rest, " "))
+				}
+				containerID, err := serviceContainerID(dir, service)
+				if err != nil {
+					return err
+				}
+				dockerArgs := []string{"logs"}
+				if follow, _ := cmd.Flags().GetBool("follow"); follow {
+					dockerArgs = append(dockerArgs, "--follow")
+				}
+				if tail, _ := cmd.Flags().GetString("tail"); tail != "" {
+					dockerArgs = append(dockerArgs, "--tail", tail)
+				}
+				return sysExec("docker", append(dockerArgs, containerID))
+			}
+			switch len(rest) {
+			case 0:
+				return runExecLogsList(dir)
+			case 1:
+				path, err := resolveExecLog(dir, rest[0])
+				if err != nil {
+					return err
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(data)
+				return err
+			default:
+				return fmt.Errorf("expected at most one log id")
+			}
+		},
+	}
+	logsCmd.Flags().Bool("exec", true, "show logs captured by 'wt exec --log-file'")
+	logsCmd.Flags().String("service", "", "show the output of this docker compose service")
+	logsCmd.Flags().BoolP("follow", "f", false, "with --service, follow the output")
+	logsCmd.Flags().String("tail", "", "with --service, number of lines to show from the end")
+
+	// Sessions command
+	sessionsCmd := &cobra.Command{
+		Use:     "sessions",
+		Short:   "List and replay sessions recorded with 'wt exec --record'",
+		GroupID: "devcontainer",
+	}
+	sessionsLsCmd := &cobra.Command{
+		Use:               "ls [name]",
+		Aliases:           []string{"list"},
+		Short:             "List recorded sessions for a worktree",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runSessionsList(dir)
+		},
+	}
+	sessionsPlayCmd := &cobra.Command{
+		Use:   "play [name] <id|last>",
+		Short: "Replay a recorded session in the terminal",
+		Long: `Replays a recording with its original timing. The recordings are standard
+asciinema v2 files, so 'asciinema play' works on them too; use
+'wt sessions ls' to find their ids.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, rest, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			if len(rest) != 1 {
+				return fmt.Errorf("expected a session id")
+			}
+			path, err := resolveSession(dir, rest[0])
+			if err != nil {
+				return err
+			}
+			speed, _ := cmd.Flags().GetFloat64("speed")
+			if speed <= 0 {
+				return fmt.Errorf("--speed must be positive")
+			}
+			maxIdle, _ := cmd.Flags().GetDuration("idle-limit")
+			return playSession(path, speed, maxIdle)
+		},
+	}
+	sessionsPlayCmd.Flags().Float64("speed", 1, "playback speed multiplier")
+	sessionsPlayCmd.Flags().Duration("idle-limit", 2*time.Second, "cap pauses between outputs (0 for none)")
+	sessionsCmd.AddCommand(sessionsLsCmd, sessionsPlayCmd)
 
 	// Up command
 	upCmd := &cobra.Command{
-		Use:               "up [name] [devcontainer-args...]",
-		Short:             "Start the worktree's devcontainer",
-		GroupID:           "devcontainer",
+		Use:     "up [name] [devcontainer-args...]",
+		Short:   "Start the worktree's devcontainer",
+		GroupID: "devcontainer",
+		Long: `Starts the worktree's devcontainer with 'devcontainer up'.
+
+If the worktree has a .env.wt.tmpl (or .devcontainer/.env.wt.tmpl), it is
+rendered before the container starts and again once the proxy port is known.
+
+Fails early if the devcontainer binds a fixed host port that another
+worktree's container already holds, or if a rendered *PORT* value collides
+with another worktree's env file.
+
+Directories listed under share in .wt.yaml with mode: mount are bind-mounted
+from one copy shared by all worktrees when the container is created.
+
+With shipWt.enabled in .wt.yaml, the wt binary is copied into the container
+as /usr/local/bin/wt, so that commands there can run 'wt name' or 'wt ports'.
+
+--timeout kills an attempt that runs too long (exit status 124) and --retries
+reruns a failed or timed-out attempt; defaults come from up.timeout and
+up.retries in .wt.yaml. The same flags apply to 'wt exec' and 'wt build'.
+
+--print-cmd prints the 'devcontainer up' command line, with the environment
+variables wt changes, instead of running it; so do 'wt exec', 'wt build',
+and 'wt code'.`,
 		Args:              cobra.ArbitraryArgs,
 		RunE:              runUp,
 		ValidArgsFunction: worktreeArgsCompletion,
 	}
 	upCmd.Flags().SetInterspersed(false)
+	addRunPolicyFlags(upCmd)
+	addPrintCmdFlag(upCmd)
+	upCmd.Flags().Bool("force", false, "start a devcontainer whose base image policy.allowedImages does not approve (logged)")
+
+	// Profile command
+	profileCmd := &cobra.Command{
+		Use:     "profile",
+		Short:   "Measure where devcontainer startup time goes",
+		GroupID: "devcontainer",
+	}
+	profileUpCmd := &cobra.Command{
+		Use:   "up [name] [devcontainer-args...]",
+		Short: "Run 'up' with phase timing and print a breakdown",
+		Long: `Runs 'devcontainer up' for the worktree, timing the image pull, build,
+container creation, lifecycle commands (postCreateCommand etc.), and SOCKS5
+proxy readiness, then prints a breakdown with suggestions for the slowest
+phases.
+
+Phases are recognized from the devcontainer CLI's log output, so the split is
+approximate. Pass --remove-existing-container to time a cold start.
+
+Examples:
+  wt profile up
+  wt profile up feature --remove-existing-container`,
+		Args:              cobra.ArbitraryArgs,
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireDevcontainerCLI(); err != nil {
+				return err
+			}
+			dir, extra, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			force, _ := cmd.Flags().GetBool("force")
+			return runProfileUp(dir, extra, force)
+		},
+	}
+	profileUpCmd.Flags().SetInterspersed(false)
+	profileUpCmd.Flags().Bool("force", false, "start a devcontainer whose base image policy.allowedImages does not approve (logged)")
+	profileCmd.AddCommand(profileUpCmd)
 
 	// Build command
 	buildCmd := &cobra.Command{
@@ -294,6 +864,9 @@ Examples:
 		ValidArgsFunction: worktreeArgsCompletion,
 	}
 	buildCmd.Flags().SetInterspersed(false)
+	addRunPolicyFlags(buildCmd)
+	addPrintCmdFlag(buildCmd)
+	buildCmd.Flags().Bool("force", false, "build a devcontainer whose base image policy.allowedImages does not approve (logged)")
 
 	// Proxy-port command
 	proxyPortCmd := &cobra.Command{
@@ -307,422 +880,2618 @@ Examples:
 			if err != nil {
 				return err
 			}
-			port, err := getProxyPort(dir)
+			kind, _ := cmd.Flags().GetString("kind")
+			p, err := getProxy(dir, kind)
 			if err != nil {
 				return err
 			}
-			fmt.Println(port)
+			fmt.Println(p.hostPort)
 			return nil
 		},
 	}
+	proxyPortCmd.Flags().String("kind", proxySOCKS5, "proxy to print: socks5, http, or dns")
 
-	// Skill command
-	skillCmd := &cobra.Command{
-		Use:     "skill [--install] [--force]",
-		GroupID: "setup",
-		Short:   "Print or install the AI assistant skill for worktree-isolated execution",
-		Long: `Print the AI assistant skill file that teaches your AI agent how to use wt exec
-for commands that could conflict across worktrees.
+	// Proxy command
+	proxyCmd := &cobra.Command{
+		Use:     "proxy",
+		Short:   "Inspect the worktree's proxies",
+		GroupID: "http",
+	}
+	proxyListCmd := &cobra.Command{
+		Use:   "ls [name]",
+		Short: "List the proxies discovered in the worktree's devcontainer",
+		Long: `Lists the SOCKS5, HTTP, and DNS proxies of the worktree's devcontainer and
+the host ports docker maps them to. Container ports come from, in order:
 
-With --install, writes the skill to any detected Codex and Claude skill directories.
-Use --force together with --install to overwrite an existing installed skill.`,
-		Args: cobra.NoArgs,
+  proxy.socks5, proxy.http, proxy.dns in .wt.yaml
+  wt.proxy.<kind> container labels (e.g. runArgs ["--label", "wt.proxy.http=3128"])
+  portsAttributes labels "socks5", "http-proxy", or "dns" in devcontainer.json
+
+A SOCKS5 proxy on container port 1080 is assumed otherwise. wt chrome,
+wt playwright, wt curl, and wt code route through the SOCKS5 proxy, or the
+HTTP proxy when there is none.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			install, err := cmd.Flags().GetBool("install")
+			dir, _, err := resolveWorkspaceFolder(args)
 			if err != nil {
 				return err
 			}
-			if !install {
-				fmt.Print(wtExecSkill)
-				return nil
-			}
+			return runProxyList(dir)
+		},
+	}
+	proxyStatusCmd := &cobra.Command{
+		Use:   "status [name]",
+		Short: "Check that the proxies answer, restarting a dead SOCKS5 proxy",
+		Long: `Connects to the worktree's proxies and performs a SOCKS5 handshake or an
+HTTP request, which also catches a proxy that died inside the container while
+docker still maps its port. A dead SOCKS5 proxy is restarted with
+supervisorctl (or started directly when supervisord has no control socket).
+Exits 1 when a proxy stays down.
 
-			force, err := cmd.Flags().GetBool("force")
+wt chrome, wt playwright, and wt curl run the same check before use.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
 			if err != nil {
 				return err
 			}
-
-			results, err := installSkillFile("wt", wtExecSkill, force)
-			if len(results) > 0 {
-				for _, result := range results {
-					switch result.status {
-					case "installed":
-						fmt.Printf("%s: installed %s\n", result.tool, result.path)
-					case "overwritten":
-						fmt.Printf("%s: overwritten %s\n", result.tool, result.path)
-					case "exists":
-						fmt.Printf("%s: already exists at %s\n", result.tool, result.path)
-					}
-				}
+			noRestart, _ := cmd.Flags().GetBool("no-restart")
+			return runProxyStatus(dir, !noRestart)
+		},
+	}
+	proxyStatusCmd.Flags().Bool("no-restart", false, "only report; do not restart a dead proxy")
+	proxyCheckCmd := &cobra.Command{
+		Use:   "check [name]",
+		Short: "Verify that DNS and traffic go through the container's proxy",
+		Long: `Sends test requests through the worktree's proxy with wt's own client and,
+when installed, with curl and headless Chrome, set up as 'wt curl' and
+'wt chrome' set them up. Each client requests:
+
+  - a name that only the container resolves (added to its /etc/hosts for the
+    check) pointing at a server wt runs on the host. If it arrives, the name
+    was resolved in the container; if not, the client resolves names on the
+    host (a DNS leak).
+  - 127.0.0.1 on the same port. It must not arrive: through the proxy it
+    goes to the container's loopback. If it does, loopback traffic bypasses
+    the proxy.
+
+Exits 1 when a check fails. If even wt's own request can't reach the host
+(e.g. a firewall between containers and the host), the result is reported as
+inconclusive.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
 			}
-			return err
+			return runProxyCheck(dir)
 		},
 	}
-	skillCmd.Flags().Bool("install", false, "install the skill into detected Codex and Claude directories")
-	skillCmd.Flags().Bool("force", false, "overwrite an existing installed skill when used with --install")
+	proxyCmd.AddCommand(proxyListCmd, proxyStatusCmd, proxyCheckCmd)
 
-	// Chrome command
-	chromeCmd := &cobra.Command{
-		Use:     "chrome [name] [-- chrome-args...]",
-		Short:   "Open Chrome with the worktree's proxy and an isolated profile",
-		GroupID: "http",
-		Long: `Launches Chrome pre-configured with:
-  - A per-worktree user profile (.chrome-profile/) for session isolation
-  - The worktree's SOCKS5 proxy so all traffic routes through the container
+	// Du command
+	duCmd := &cobra.Command{
+		Use:   "du [name]",
+		Short: "Show the disk space worktrees and their containers use",
+		Long: `Without a name, lists every worktree with the size of its files, its
+devcontainer's writable layer, and its docker volumes (those the container
+mounts and those of its compose project), biggest first. --top limits the
+list.
 
-Opens the devcontainer's default HTTP/HTTPS URL if no URL is specified.
-Always use 127.0.0.1 instead of localhost — the SOCKS5 proxy cannot resolve
-'localhost' reliably.
+With a name, lists the worktree's largest directories (a directory that is
+almost entirely one subdirectory is skipped in favor of it), the container's
+writable layer, and its volumes.
 
-Examples:
-  wt chrome                               # open default URL
-  wt chrome -- http://127.0.0.1:3000     # open a specific URL
-  wt chrome feature -- http://127.0.0.1:8080`,
-		Args:              cobra.ArbitraryArgs,
-		RunE:              runChrome,
+With quota.max in .wt.yaml, 'wt up' and 'wt exec' warn when a worktree is
+over the quota, or refuse to run with quota.action: block.`,
+		GroupID:           "worktree",
+		Args:              cobra.MaximumNArgs(1),
 		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			top, _ := cmd.Flags().GetInt("top")
+			var dir string
+			if len(args) > 0 {
+				if dir, _, err = resolveWorkspaceFolder(args); err != nil {
+					return err
+				}
+			}
+			return runDu(dir, top, cfg.Quota)
+		},
 	}
-	chromeCmd.Flags().SetInterspersed(false)
-
-	// Playwright command
-	playwrightCmd := &cobra.Command{
-		Use:     "playwright [name] [-- playwright-args...]",
-		Short:   "Open a Playwright browser with the worktree's proxy",
-		GroupID: "http",
-		Long: `Launches a browser via 'npx playwright open' with the worktree's SOCKS5 proxy
-configured, so the browser can reach services running inside the container.
-
-Always use 127.0.0.1 instead of localhost in URLs.
-
-Examples:
-  wt playwright                               # open default URL
-  wt playwright -- http://127.0.0.1:3000     # open a specific URL`,
-		Args:              cobra.ArbitraryArgs,
-		RunE:              runPlaywright,
+	duCmd.Flags().Int("top", 0, "show only this many entries (default 10 for a single worktree)")
+
+	// Artifacts command
+	artifactsCmd := &cobra.Command{
+		Use:   "artifacts",
+		Short: "Browse the files wt commands saved for a worktree",
+		Long: `Each worktree has an artifacts directory in its wt state directory, outside
+the checkout, for logs, screenshots, reports, and other scratch files. wt
+commands save there by default:
+
+  logs/          'wt exec --log-file' output
+  screenshots/   'wt screenshot'
+  visual-diff/   'wt visual-diff' (in the second worktree's directory)
+  lighthouse/    'wt lighthouse' reports
+  handoff/       'wt hand-off' archives
+  downloads/     Chrome downloads, with chrome.downloadDir: artifacts
+
+'wt up' mounts the directory at /wt/artifacts in the devcontainer, and
+WT_ARTIFACTS holds its path in 'wt exec' sessions and hooks, so that tests
+and agents can leave files there too. It is removed with the worktree.`,
+		GroupID: "worktree",
+	}
+	artifactsLsCmd := &cobra.Command{
+		Use:               "ls [name]",
+		Short:             "List the worktree's artifacts, newest first",
+		Args:              cobra.MaximumNArgs(1),
 		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runArtifactsLs(dir)
+		},
 	}
-	playwrightCmd.Flags().SetInterspersed(false)
-
-	// Curl command
-	curlCmd := &cobra.Command{
-		Use:     "curl [name] [-- curl-args...]",
-		Short:   "Run curl through the worktree's SOCKS5 proxy",
-		GroupID: "http",
-		Long: `Runs curl with the worktree's SOCKS5 proxy automatically configured,
-so requests reach services running inside the devcontainer.
-
-Always use 127.0.0.1 instead of localhost in URLs.
+	artifactsOpenCmd := &cobra.Command{
+		Use:   "open [name] [path]",
+		Short: "Open the artifacts directory, or a file in it",
+		Long: `Opens the worktree's artifacts directory, or the file or directory at path
+in it (as listed by 'wt artifacts ls'), with the desktop's default
+application.
 
 Examples:
-  wt curl -- http://127.0.0.1:8080/api
-  wt curl -- -X POST -d '{"key":"val"}' http://127.0.0.1:8080/api
-  wt curl feature -- http://127.0.0.1:8080`,
-		Args:              cobra.ArbitraryArgs,
-		RunE:              runCurl,
+  wt artifacts open
+  wt artifacts open feature screenshots/20260301-141502.png`,
+		Args:              cobra.MaximumNArgs(2),
 		ValidArgsFunction: worktreeArgsCompletion,
-	}
-	curlCmd.Flags().SetInterspersed(false)
-
-	// Init command
-	initCmd := &cobra.Command{
-		Use:     "init",
-		Short:   "Scaffold a .devcontainer/ with SOCKS5 proxy support",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(nil)
+			rel := ""
+			switch len(args) {
+			case 1:
+				// A path in the current worktree's artifacts, or a worktree.
+				rel = args[0]
+				if err != nil || !hasArtifact(dir, rel) {
+					dir, err = resolveWorktreeArg(rel)
+					rel = ""
+				}
+			case 2:
+				dir, err = resolveWorktreeArg(args[0])
+				rel = args[1]
+			}
+			if err != nil {
+				return err
+			}
+			return runArtifactsOpen(dir, rel)
+		},
+	}
+	artifactsCmd.AddCommand(artifactsLsCmd, artifactsOpenCmd)
+
+	// Hand-off command
+	handOffCmd := &cobra.Command{
+		Use:     "hand-off [name]",
+		Aliases: []string{"handoff"},
+		Short:   "Package a worktree for a teammate to rebuild with 'wt receive'",
+		GroupID: "worktree",
+		Long: `Packages the worktree into a single .tar.gz that a teammate can turn back
+into the same worktree and environment on their machine with 'wt receive'.
+The archive holds:
+
+  manifest.json       repo, origin URL, worktree name, branch, HEAD commit,
+                      devcontainer image, and the SHA-256 of each file below
+  commits.bundle      a git bundle of the commits no remote has yet
+  uncommitted.patch   a binary diff of the uncommitted changes, untracked
+                      files included
+
+The image is recorded by its registry digest when it has one, otherwise by
+name. Env files and other ignored files are not included, so secrets stay on
+your machine. The archive goes to handoff/ in the worktree's artifacts
+directory unless -o names another path; its path is printed on stdout.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			out, _ := cmd.Flags().GetString("output")
+			return runHandOff(dir, out)
+		},
+	}
+	handOffCmd.Flags().StringP("output", "o", "", "write the archive to this path")
+
+	// Receive command
+	receiveCmd := &cobra.Command{
+		Use:   "receive <archive> [name]",
+		Short: "Rebuild a worktree from a 'wt hand-off' archive",
+		Long: `Rebuilds, in the current repository, the worktree a teammate packaged with
+'wt hand-off': it checks each file of the archive against the SHA-256 in its
+manifest, fetches the commits from the bundle once git has verified it
+(or from origin when every commit was pushed), and checks that HEAD is the
+commit the sender had. It then creates the worktree under the sender's
+name and branch, or name and --branch, applies the uncommitted changes,
+pulls the devcontainer image, and starts the container unless --no-up is
+given.
+
+Env files are not part of a hand-off; the new worktree gets them from the
+current one, like any 'wt add'.`,
+		GroupID: "worktree",
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 2 {
+				name = args[1]
+			}
+			branch, _ := cmd.Flags().GetString("branch")
+			noUp, _ := cmd.Flags().GetBool("no-up")
+			return runReceive(args[0], name, branch, !noUp)
+		},
+	}
+	receiveCmd.Flags().StringP("branch", "b", "", "put the worktree on this branch instead of the sender's")
+	receiveCmd.Flags().Bool("no-up", false, "don't start the devcontainer")
+
+	// Clean command
+	cleanCmd := &cobra.Command{
+		Use:   "clean [name]",
+		Short: "Remove build artifacts from a worktree without removing it",
+		Long: `Slims a worktree: runs the clean.commands from .wt.yaml (e.g. "make clean")
+inside its devcontainer, or on the host with clean.in: host, then removes
+untracked and ignored files with 'git clean -xdf' when clean.git is true or
+no commands are configured.
+
+git clean keeps env files, the .vscode-profile and .chrome-profile
+directories, the add.like patterns, and clean.protect patterns. It lists
+what it would remove and asks first; --yes skips the question and
+--dry-run only shows the plan. Afterwards wt reports the space freed and
+warns if the worktree is still over quota.max.`,
+		GroupID:           "worktree",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var dir string
+			var err error
+			if len(args) == 1 && args[0] != "." && !isPathLikeArg(args[0]) {
+				name, err := resolveExactNameArg(args[0])
+				if err != nil {
+					return err
+				}
+				if dir, err = resolveWorktreePath(name); err != nil {
+					return err
+				}
+			} else if dir, _, err = resolveWorkspaceFolder(args); err != nil {
+				return err
+			}
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			yes, _ := cmd.Flags().GetBool("yes")
+			return runClean(dir, dryRun, yes)
+		},
+	}
+	cleanCmd.Flags().BoolP("dry-run", "n", false, "show what would be removed and run")
+	cleanCmd.Flags().BoolP("yes", "y", false, "do not ask before removing files")
+
+	// Ps command
+	psCmd := &cobra.Command{
+		Use:   "ps [name]",
+		Short: "List processes started by wt exec in the worktree's container",
+		Long: `Lists the processes inside the worktree's devcontainer (or, with --service,
+a compose service container) that 'wt exec' started, along with everything
+they spawned. Each 'wt exec' marks its processes with a WT_EXEC_ID
+environment variable and records itself in the worktree's state directory.
+
+STATE is "running" while the 'wt exec' on the host is still alive, and
+"orphaned" once it has exited (the terminal was closed, the agent crashed)
+and left processes behind. Stop them with 'wt kill'.`,
+		GroupID:           "devcontainer",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			service, _ := cmd.Flags().GetString("service")
+			return runPs(dir, service)
+		},
+	}
+	psCmd.Flags().String("service", "", "list processes in this compose service's container")
+
+	// Kill command
+	killCmd := &cobra.Command{
+		Use:   "kill [name] [exec-id...]",
+		Short: "Stop processes started by wt exec in the worktree's container",
+		Long: `Signals the processes 'wt exec' started in the worktree's container: those
+of the given exec ids (see 'wt ps'), every orphaned one with --orphans, or
+all of them with --all. The signal defaults to TERM.
+
+Examples:
+  wt kill --orphans
+  wt kill feature 20261016-101500-3f2a
+  wt kill feature --all --signal KILL`,
 		GroupID: "devcontainer",
-		Long: `Creates a .devcontainer/ directory in the current project with:
-  - devcontainer.json   devcontainer config with SOCKS5 proxy port mapping
-  - Dockerfile          base image with supervisord and microsocks installed
-  - supervisord.conf    starts the SOCKS5 proxy daemon on container start
+		Args:    cobra.ArbitraryArgs,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return worktreeArgsCompletion(cmd, args, toComplete)
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var dir string
+			var err error
+			if len(args) > 0 && !isExecID(args[0]) {
+				dir, _, err = resolveWorkspaceFolder(args[:1])
+				args = args[1:]
+			} else {
+				dir, _, err = resolveWorkspaceFolder(nil)
+			}
+			if err != nil {
+				return err
+			}
+			service, _ := cmd.Flags().GetString("service")
+			orphans, _ := cmd.Flags().GetBool("orphans")
+			all, _ := cmd.Flags().GetBool("all")
+			signal, _ := cmd.Flags().GetString("signal")
+			signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+			return runKill(dir, service, args, orphans, all, signal)
+		},
+	}
+	killCmd.Flags().String("service", "", "stop processes in this compose service's container")
+	killCmd.Flags().Bool("orphans", false, "stop processes whose wt exec has exited")
+	killCmd.Flags().Bool("all", false, "stop every process started by wt exec")
+	killCmd.Flags().StringP("signal", "s", "TERM", "signal to send, e.g. TERM, INT, or KILL")
+
+	// Ports command
+	portsCmd := &cobra.Command{
+		Use:   "ports [name]",
+		Short: "List the ports the worktree's devcontainer forwards",
+		Long: `Lists the forwardPorts of the worktree's devcontainer.json, followed by any
+other port docker publishes, with their portsAttributes labels. FROM HOST
+shows the published host address, or the container address reached through
+the proxy (wt chrome, wt playwright, wt curl) for ports that are only
+forwarded.`,
+		Args:              cobra.MaximumNArgs(1),
+		GroupID:           "http",
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runPorts(dir)
+		},
+	}
 
-Use --force to overwrite existing files.`,
+	// Hosts command
+	hostsCmd := &cobra.Command{
+		Use:     "hosts",
+		Short:   "Manage per-worktree hostname overrides resolved by the proxy",
+		GroupID: "http",
+		Long: `Manages hostname overrides written to /etc/hosts inside the worktree's
+devcontainer. The SOCKS5 proxy resolves names inside the container, so
+proxied browsers and curl reach the overridden addresses.
+
+Targets are IP addresses or names resolvable inside the container, such as
+a compose service. Overrides are saved per worktree and re-applied by 'wt up'.
+
+Examples:
+  wt hosts add api.example.com=172.18.0.5
+  wt hosts add feature api.example.com=api
+  wt hosts rm api.example.com
+  wt hosts ls`,
+	}
+	hostsAddCmd := &cobra.Command{
+		Use:               "add [name] <host=target>...",
+		Short:             "Add or replace hostname overrides",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, specs, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			if len(specs) == 0 {
+				return fmt.Errorf("expected at least one host=target override")
+			}
+			return runHostsAdd(dir, specs)
+		},
+	}
+	hostsRmCmd := &cobra.Command{
+		Use:               "rm [name] <host>...",
+		Aliases:           []string{"remove"},
+		Short:             "Remove hostname overrides",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, names, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				return fmt.Errorf("expected at least one host")
+			}
+			return runHostsRemove(dir, names)
+		},
+	}
+	hostsLsCmd := &cobra.Command{
+		Use:               "ls [name]",
+		Aliases:           []string{"list"},
+		Short:             "List hostname overrides",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runHostsList(dir)
+		},
+	}
+	hostsCmd.AddCommand(hostsAddCmd, hostsRmCmd, hostsLsCmd)
+
+	// Changelog command
+	changelogCmd := &cobra.Command{
+		Use:     "changelog",
+		Short:   "Summarize what each worktree has that main does not",
+		GroupID: "worktree",
+		Long: `For every worktree, lists the commits that are not in the base branch
+(default: the branch checked out in the main worktree) with their subjects and
+the files they touch. Worktrees without such commits are left out.
+
+Use --since for a daily digest of recent work and --json for reporting.
+
+Examples:
+  wt changelog
+  wt changelog --since 24h
+  wt changelog --base origin/main --json`,
 		Args: cobra.NoArgs,
-		RunE: runInit,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, _ := cmd.Flags().GetString("base")
+			since, _ := cmd.Flags().GetDuration("since")
+			asJSON, _ := cmd.Flags().GetBool("json")
+			return runChangelog(base, since, asJSON)
+		},
 	}
-	initCmd.Flags().Bool("force", false, "overwrite existing .devcontainer/ files")
+	changelogCmd.Flags().String("base", "", "compare against this ref (default: the main worktree's branch)")
+	changelogCmd.Flags().Duration("since", 0, "only include commits made within this long (e.g. 24h)")
+	changelogCmd.Flags().Bool("json", false, "print JSON, including worktrees without new commits")
 
-	// Down command
-	downCmd := &cobra.Command{
-		Use:               "down [name]",
-		Short:             "Stop and remove the worktree's devcontainer",
-		GroupID:           "devcontainer",
+	// Stack commands
+	stackCmd := &cobra.Command{
+		Use:               "stack [name]",
+		Short:             "Show the stack of worktrees a worktree belongs to",
+		GroupID:           "worktree",
 		Args:              cobra.MaximumNArgs(1),
-		RunE:              runDown,
 		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runStackShow(dir)
+		},
 	}
+	restackCmd := &cobra.Command{
+		Use:     "restack [name]",
+		Short:   "Rebase a stack of worktrees onto their parents, bottom up",
+		GroupID: "worktree",
+		Long: `Rebases every layer of the stack containing the worktree onto its parent
+worktree's current HEAD, in order from the bottom of the stack. Only each
+layer's own commits (since it was started or last restacked) are replayed.
 
-	// Bounce command
-	bounceCmd := &cobra.Command{
-		Use:     "bounce [name]",
-		Short:   "Recreate the worktree's devcontainer",
-		GroupID: "devcontainer",
-		Long: `Stops and removes the devcontainer, then starts a fresh one.
-Equivalent to running 'wt down' followed by 'wt up'.
+A layer that conflicts is left mid-rebase so you can resolve it in that
+worktree; the layers above it are skipped. Run 'git rebase --continue' there
+and rerun 'wt restack' to finish the stack.
 
-Useful after changes to .devcontainer/ configuration.`,
+Stacks are recorded by 'wt add --stack-on <worktree>'.`,
 		Args:              cobra.MaximumNArgs(1),
 		ValidArgsFunction: worktreeArgsCompletion,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := runDown(cmd, args); err != nil {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
 				return err
 			}
-			return runUp(cmd, args)
+			return runRestack(dir)
 		},
 	}
 
-	rootCmd.AddCommand(addCmd, lsCmd, rmCmd, cdCmd, codeCmd, chromeCmd, playwrightCmd, curlCmd, nameCmd, dirCmd, execCmd, upCmd, downCmd, buildCmd, bounceCmd, proxyPortCmd, skillCmd, completionCmd, initCmd)
+	// CI command
+	ciCmd := &cobra.Command{
+		Use:     "ci",
+		Short:   "Run the repository's CI pipeline for a worktree",
+		GroupID: "worktree",
+	}
+	ciRunCmd := &cobra.Command{
+		Use:   "run [name]",
+		Short: "Trigger a CI run against the worktree's pushed branch",
+		Long: `Triggers CI against the branch checked out in the worktree, prints the run
+URL, and streams the run's status until it finishes. The run is recorded in
+the worktree's state; list past runs with 'wt ci ls'.
+
+The provider is forge.provider in .wt.yaml, or is guessed from the origin
+remote's host:
+  github     dispatches a workflow with 'gh workflow run'. The workflow comes
+             from --workflow, then ci.workflow, then the only file in
+             .github/workflows, and needs a workflow_dispatch trigger.
+  gitlab     creates a pipeline with 'glab api'; -f fields become variables.
+  bitbucket  starts Bitbucket Pipelines through the REST API, using
+             $BITBUCKET_TOKEN (or $BITBUCKET_USERNAME and
+             $BITBUCKET_APP_PASSWORD); --workflow names a custom pipeline.
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+Examples:
+  wt ci run
+  wt ci run feature --push --workflow test.yml -f suite=e2e
+  wt ci run --no-watch`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			var opts ciRunOptions
+			opts.workflow, _ = cmd.Flags().GetString("workflow")
+			opts.fields, _ = cmd.Flags().GetStringArray("field")
+			opts.push, _ = cmd.Flags().GetBool("push")
+			noWatch, _ := cmd.Flags().GetBool("no-watch")
+			opts.watch = !noWatch
+			return runCIRun(dir, opts)
+		},
 	}
-}
+	ciRunCmd.Flags().String("workflow", "", "GitHub workflow file or Bitbucket custom pipeline (default: ci.workflow or the only workflow)")
+	ciRunCmd.Flags().StringArrayP("field", "f", nil, "workflow input or pipeline variable as key=value (repeatable)")
+	ciRunCmd.Flags().Bool("push", false, "push the branch to origin before triggering")
+	ciRunCmd.Flags().Bool("no-watch", false, "print the run URL without waiting for the run to finish")
+	ciLsCmd := &cobra.Command{
+		Use:               "ls [name]",
+		Aliases:           []string{"list"},
+		Short:             "List workflow runs triggered for a worktree",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			return runCIList(dir)
+		},
+	}
+	ciCmd.AddCommand(ciRunCmd, ciLsCmd)
 
-// getMainRepoRoot returns the absolute path to the main repository root.
-// Works from the main repo, any worktree, or any subdirectory thereof.
-func getMainRepoRoot() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--git-common-dir")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("not in a git repository: %w", err)
+	// Cache command
+	cacheCmd := &cobra.Command{
+		Use:     "cache",
+		Short:   "Manage the shared apt/npm/pip caches used by devcontainers",
+		GroupID: "devcontainer",
+		Long: `Runs dependency caches once for all worktrees so postCreate installs do not
+download the same packages for every worktree. Enable them in .wt.yaml:
+
+  cache:
+    services: [apt, npm, pip]
+
+'wt up' then starts the caches as needed, joins the devcontainer to the
+wt-cache docker network before its lifecycle commands run, and points apt,
+npm/yarn, and pip/uv at the caches (also for later shells, through
+/etc/profile.d/wt-cache.sh). Cached packages are kept in docker volumes.`,
 	}
-	commonDir := strings.TrimSpace(string(output))
-	if !filepath.IsAbs(commonDir) {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return "", err
-		}
-		commonDir = filepath.Join(cwd, commonDir)
+	cacheUpCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Start the caches enabled in .wt.yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if len(cfg.Cache.Services) == 0 {
+				return fmt.Errorf("no caches enabled; set cache.services in %s", projectConfigFile)
+			}
+			return ensureCaches(cfg.Cache)
+		},
 	}
-	return filepath.Dir(filepath.Clean(commonDir)), nil
-}
+	cacheDownCmd := &cobra.Command{
+		Use:   "down",
+		Short: "Stop and remove the cache containers (cached data is kept)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheDown()
+		},
+	}
+	cacheStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show which caches are enabled and running",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runCacheStatus(cfg.Cache)
+		},
+	}
+	cacheCmd.AddCommand(cacheUpCmd, cacheDownCmd, cacheStatusCmd)
 
-// getWorktreeParentDir returns the parent directory where sibling worktrees live.
-func getWorktreeParentDir() (string, error) {
-	mainRoot, err := getMainRepoRoot()
-	if err != nil {
-		return "", err
+	servicesCmd := &cobra.Command{
+		Use:     "services",
+		Short:   "Manage the shared services all devcontainers use",
+		GroupID: "devcontainer",
+		Long: `Runs services such as a local registry or an S3 mock once on the host for
+all worktrees, instead of as a sidecar per worktree. Declare them in
+.wt.yaml:
+
+  sharedServices:
+    - name: registry
+      image: registry:2
+      port: 5000
+      volumes: [/var/lib/registry]
+    - name: s3
+      image: minio/minio
+      command: [server, /data]
+      port: 9000
+      volumes: [/data]
+
+'wt up' then starts the services as needed and joins the devcontainer to
+the wt-services docker network before its lifecycle commands run. Code in
+the container reaches each service at wt-svc-<name>:<port>, which 'wt up'
+and 'wt exec' also pass as WT_SERVICE_<NAME>. Data in the listed volumes is
+kept in docker volumes.`,
+	}
+	servicesUpCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Start the shared services declared in .wt.yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if len(cfg.SharedServices) == 0 {
+				return fmt.Errorf("no shared services declared; add sharedServices to %s", projectConfigFile)
+			}
+			return ensureSharedServices(cfg.SharedServices)
+		},
 	}
-	return filepath.Dir(mainRoot), nil
-}
+	servicesDownCmd := &cobra.Command{
+		Use:   "down",
+		Short: "Stop and remove the shared service containers (their volumes are kept)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runServicesDown(cfg.SharedServices)
+		},
+	}
+	servicesStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the declared shared services and whether they run",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runServicesStatus(cfg.SharedServices)
+		},
+	}
+	servicesCmd.AddCommand(servicesUpCmd, servicesDownCmd, servicesStatusCmd)
 
-// getCurrentWorktreeRoot returns the toplevel of the current working tree.
-func getCurrentWorktreeRoot() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
+	// Image command
+	imageCmd := &cobra.Command{
+		Use:     "image",
+		Short:   "Inspect the worktree's devcontainer image",
+		GroupID: "devcontainer",
 	}
-	return strings.TrimSpace(string(output)), nil
-}
+	imageReportCmd := &cobra.Command{
+		Use:   "report [name]",
+		Short: "Show the image's size by layer and, with --vulns, its vulnerabilities",
+		Long: `Shows the size of the worktree's devcontainer image and its largest layers,
+with the Dockerfile step that created each, to find what bloats it.
 
-// worktreeDirName returns the directory name for a worktree: "repo@name".
-func worktreeDirName(repoBasename, name string) string {
-	return repoBasename + worktreeDelimiter + name
-}
+With --vulns, also scans the image for HIGH and CRITICAL vulnerabilities with
+trivy, if it is installed.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			top, _ := cmd.Flags().GetInt("top")
+			vulns, _ := cmd.Flags().GetBool("vulns")
+			return runImageReport(dir, top, vulns)
+		},
+	}
+	imageReportCmd.Flags().Int("top", 10, "number of largest layers to show")
+	imageReportCmd.Flags().Bool("vulns", false, "scan for vulnerabilities with trivy")
+	imageCmd.AddCommand(imageReportCmd)
 
-// parseWorktreeName extracts the worktree name from a directory name like "repo@name".
-// Returns empty string if the directory doesn't match the repo prefix.
-func parseWorktreeName(dirName, repoBasename string) string {
-	prefix := repoBasename + worktreeDelimiter
-	if strings.HasPrefix(dirName, prefix) {
-		return strings.TrimPrefix(dirName, prefix)
+	// Serve command
+	serveCmd := &cobra.Command{
+		Use:     "serve --stdio",
+		Short:   "Serve wt operations as line-delimited JSON requests",
+		GroupID: "setup",
+		Long: `Runs a long-lived wt process for editor extensions and agent frameworks.
+With --stdio, reads one JSON-RPC 2.0 style request per line from stdin and
+writes one JSON response per line to stdout. Human-readable progress goes to
+stderr.
+
+Methods and params:
+  api                                         -> {version, methods}
+  worktrees                                   -> [{name, path, main, current, branch,
+                                                   head, dirty, container, proxyPort}]
+  container   {name}                          -> {state, containerId, proxyPort}
+  open        {name}                          -> {folderUri, proxyPort}
+  ls                                          -> [{name, path}]
+  add         {name, base, branch, like}      -> {name, path}
+  rm          {name, force}                   -> {name}
+  exec        {name, command: [...], stdin}   -> {exitCode, stdout, stderr}
+  proxy-port  {name}                          -> {port}
+
+An empty name refers to the worktree serve was started in; rm takes exact
+names only. rm and exec behave like 'wt rm --yes' and 'wt exec', policies,
+leftover backups, exec.env, and credentials included, and never prompt.
+"open" starts the
+devcontainer if needed and returns the URI for the editor to open (a
+vscode-remote URI, or a file URI without a devcontainer) rather than launching
+an editor itself. Editor extensions should check "api" version before use.
+
+Example:
+  $ echo '{"jsonrpc":"2.0","id":1,"method":"ls"}' | wt serve --stdio
+  {"jsonrpc":"2.0","id":1,"result":[{"name":"feature","path":"/src/app@feature"}]}`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if stdio, _ := cmd.Flags().GetBool("stdio"); !stdio {
+				return fmt.Errorf("specify a transport; only --stdio is supported")
+			}
+			return runServeStdio(os.Stdin, os.Stdout)
+		},
+	}
+	serveCmd.Flags().Bool("stdio", false, "serve requests over stdin/stdout")
+
+	// Selftest command
+	selftestCmd := &cobra.Command{
+		Use:     "selftest",
+		Short:   "Check that wt works on this machine",
+		GroupID: "setup",
+		Long: `Creates a temporary git repository with scratch worktrees and exercises
+add, ls, name, which, exec, and rm, reporting each check as PASS or FAIL.
+
+With --docker, also scaffolds a devcontainer and checks up, exec, proxy-port,
+and down (skipped if docker or the devcontainer CLI is missing).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			withDocker, _ := cmd.Flags().GetBool("docker")
+			keep, _ := cmd.Flags().GetBool("keep")
+			return runSelftest(withDocker, keep)
+		},
+	}
+	selftestCmd.Flags().Bool("docker", false, "also exercise the devcontainer path")
+	selftestCmd.Flags().Bool("keep", false, "keep the scratch directory for inspection")
+
+	// Doctor command
+	doctorCmd := &cobra.Command{
+		Use:     "doctor",
+		Short:   "Diagnose and fix common wt setup problems",
+		GroupID: "setup",
+		Long: `Checks the tools wt needs, the repository's worktree.useRelativePaths
+setting, dangling worktree admin entries, missing wt state directories,
+devcontainers labeled for directories that no longer exist, and shell
+completion for $SHELL.
+
+With --fix, offers to fix each problem it can: setting the git config,
+pruning admin entries, creating state directories, removing stale containers
+so 'wt up' recreates them, and installing completion. --yes applies the fixes
+without asking. Exits 1 when problems remain.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fix, _ := cmd.Flags().GetBool("fix")
+			yes, _ := cmd.Flags().GetBool("yes")
+			return runDoctor(genCompletion, fix, yes)
+		},
+	}
+	doctorCmd.Flags().Bool("fix", false, "fix the problems found, asking before each")
+	doctorCmd.Flags().BoolP("yes", "y", false, "with --fix, do not ask for confirmation")
+
+	// Extends command
+	extendsCmd := &cobra.Command{
+		Use:     "extends",
+		Short:   "Show or refresh the shared config this repository extends",
+		GroupID: "setup",
+		Long: `A repository can build on a config shared across an organization by naming
+it in .wt.yaml:
+
+  extends: git@github.com:org/wt-config      # or ...wt-config#v2, or ../wt-config
+
+The source is laid out like a project: its .wt.yaml supplies defaults, such
+as naming conventions, quotas, and run policies, that the repository's own
+.wt.yaml overrides key by key (lists are replaced whole), and its
+.wt/template and .wt/hooks are used when the repository has none. A git
+source is cloned into wt's state directory on first use and only updated by
+'wt extends refresh'.`,
+	}
+	extendsShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the extends source, its cached commit, and what it provides",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runExtendsShow(cfg)
+		},
+	}
+	extendsRefreshCmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Fetch the latest version of a git extends source",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runExtendsRefresh(cfg)
+		},
+	}
+	extendsCmd.AddCommand(extendsShowCmd, extendsRefreshCmd)
+
+	// Policy command
+	policyCmd := &cobra.Command{
+		Use:     "policy",
+		Short:   "Show the guardrails in force and their logged overrides",
+		GroupID: "setup",
+		Long: `Lists the policies set under policy in .wt.yaml, usually by the shared config
+of extends, and the overrides of them recorded for this repository:
+
+  policy:
+    rmRequiresClean: true         # 'wt rm' refuses worktrees with uncommitted changes
+    maxWorktrees: 8               # 'wt add' refuses to create a 9th worktree
+    allowedImages:                # 'wt up' refuses other devcontainer base images
+      - mcr.microsoft.com/devcontainers/*
+    contact: "ask in #platform"   # added to every refusal
+
+allowedImages applies wherever wt starts or builds a devcontainer: 'wt up',
+'wt code', 'wt add --up', 'wt build', and 'wt profile up'.
+
+A command refused by a policy can be forced with --force ('wt rm <name>
+--force', 'wt add --force', 'wt up --force', and so on for the commands
+above). Every override is appended to
+policy-audit.log in wt's state directory with the time, user, repository,
+worktree, policy, and command. Policies from extends cannot be loosened by
+the repository's own .wt.yaml, only tightened.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			return runPolicyShow(cfg)
+		},
+	}
+
+	// Skill command
+	skillCmd := &cobra.Command{
+		Use:     "skill [--install] [--force]",
+		GroupID: "setup",
+		Short:   "Print or install the AI assistant skill for worktree-isolated execution",
+		Long: `Print the AI assistant skill file that teaches your AI agent how to use wt exec
+for commands that could conflict across worktrees.
+
+With --install, writes the skill to any detected Codex and Claude skill directories.
+Use --force together with --install to overwrite an existing installed skill.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			install, err := cmd.Flags().GetBool("install")
+			if err != nil {
+				return err
+			}
+			if !install {
+				fmt.Print(wtExecSkill)
+				return nil
+			}
+
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return err
+			}
+
+			results, err := installSkillFile("wt", wtExecSkill, force)
+			if len(results) > 0 {
+				for _, result := range results {
+					switch result.status {
+					case "installed":
+						fmt.Printf("%s: installed %s\n", result.tool, result.path)
+					case "overwritten":
+						fmt.Printf("%s: overwritten %s\n", result.tool, result.path)
+					case "exists":
+						fmt.Printf("%s: already exists at %s\n", result.tool, result.path)
+					}
+				}
+			}
+			return err
+		},
+	}
+	skillCmd.Flags().Bool("install", false, "install the skill into detected Codex and Claude directories")
+	skillCmd.Flags().Bool("force", false, "overwrite an existing installed skill when used with --install")
+
+	// Chrome command
+	chromeCmd := &cobra.Command{
+		Use:     "chrome [name] [-- chrome-args...]",
+		Short:   "Open Chrome with the worktree's proxy and an isolated profile",
+		GroupID: "http",
+		Long: `Launches Chrome pre-configured with:
+  - A per-worktree user profile (.chrome-profile/) for session isolation
+  - The worktree's SOCKS5 proxy so all traffic routes through the container
+  - Downloads saved to .downloads/ in the worktree (see chrome.downloadDir)
+
+Opens the devcontainer's default HTTP/HTTPS URL if no URL is specified.
+Always use 127.0.0.1 instead of localhost — the SOCKS5 proxy cannot resolve
+'localhost' reliably.
+
+Examples:
+  wt chrome                               # open default URL
+  wt chrome -- http://127.0.0.1:3000     # open a specific URL
+  wt chrome feature -- http://127.0.0.1:8080`,
+		Args:              cobra.ArbitraryArgs,
+		RunE:              runChrome,
+		ValidArgsFunction: worktreeArgsCompletion,
+	}
+	chromeCmd.Flags().SetInterspersed(false)
+
+	// Playwright command
+	playwrightCmd := &cobra.Command{
+		Use:     "playwright [name] [-- playwright-args...]",
+		Short:   "Open a Playwright browser with the worktree's proxy",
+		GroupID: "http",
+		Long: `Launches a browser via 'npx playwright open' with the worktree's SOCKS5 proxy
+configured, so the browser can reach services running inside the container.
+
+Always use 127.0.0.1 instead of localhost in URLs.
+
+Examples:
+  wt playwright                               # open default URL
+  wt playwright -- http://127.0.0.1:3000     # open a specific URL`,
+		Args:              cobra.ArbitraryArgs,
+		RunE:              runPlaywright,
+		ValidArgsFunction: worktreeArgsCompletion,
+	}
+	playwrightCmd.Flags().SetInterspersed(false)
+
+	// Curl command
+	curlCmd := &cobra.Command{
+		Use:     "curl [name] [-- curl-args...]",
+		Short:   "Run curl through the worktree's SOCKS5 proxy",
+		GroupID: "http",
+		Long: `Runs curl with the worktree's SOCKS5 proxy automatically configured,
+so requests reach services running inside the devcontainer.
+
+Always use 127.0.0.1 instead of localhost in URLs.
+
+Examples:
+  wt curl -- http://127.0.0.1:8080/api
+  wt curl -- -X POST -d '{"key":"val"}' http://127.0.0.1:8080/api
+  wt curl feature -- http://127.0.0.1:8080`,
+		Args:              cobra.ArbitraryArgs,
+		RunE:              runCurl,
+		ValidArgsFunction: worktreeArgsCompletion,
+	}
+	curlCmd.Flags().SetInterspersed(false)
+
+	// Replay command
+	replayCmd := &cobra.Command{
+		Use:     "replay <har-or-curl-file> [name]",
+		Short:   "Re-send captured requests through the worktree's proxy and diff worktrees",
+		GroupID: "http",
+		Long: `Re-sends previously captured requests, in order, through the worktree's
+proxy, so they reach the services in its devcontainer. The file is either a
+HAR export (e.g. "Save all as HAR" in browser dev tools) or curl commands, one
+per line, as copied with "Copy as cURL". Requests to localhost go to
+127.0.0.1 in the container; redirects are not followed.
+
+With --against, each request is also sent to the other worktree and the
+responses are compared: status codes, and bodies line by line (JSON is
+re-indented first). Use --ignore to mask text that always differs, such as
+timestamps or request IDs. Exits 1 when any response differs.
+
+Examples:
+  wt replay checkout.har
+  wt replay checkout.har feature --against main
+  wt replay requests.curl --against fix-login --ignore '"requestId": "[^"]*"'`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args[1:])
+			if err != nil {
+				return err
+			}
+			against, _ := cmd.Flags().GetString("against")
+			if against != "" {
+				if against, err = resolveWorktreeArg(against); err != nil {
+					return err
+				}
+			}
+			ignore, _ := cmd.Flags().GetStringArray("ignore")
+			insecure, _ := cmd.Flags().GetBool("insecure")
+			return runReplay(args[0], dir, against, ignore, insecure)
+		},
+	}
+	replayCmd.Flags().String("against", "", "also send each request to this worktree and diff the responses")
+	replayCmd.Flags().StringArray("ignore", nil, "regexp of response text to ignore when comparing (repeatable)")
+	replayCmd.Flags().BoolP("insecure", "k", false, "don't verify TLS certificates")
+
+	// API diff command
+	apidiffCmd := &cobra.Command{
+		Use:     "apidiff <a> <b> --paths /api/x,/api/y",
+		Short:   "Call the same endpoints in two worktrees and diff the responses",
+		GroupID: "http",
+		Long: `Sends the same requests through the proxies of worktrees a and b (e.g. a
+branch and main) and shows how the responses differ: status code, headers,
+and body. JSON bodies are compared field by field and reported by path, such
+as .items[2].price; other bodies line by line. Exits 1 when any endpoint
+differs.
+
+Each entry of --paths is a path on 127.0.0.1 at --port (default: the first
+port in forwardPorts of a's devcontainer.json) or a full URL.
+
+Headers that differ on every call (Date, Age, Expires, Last-Modified, ETag,
+Content-Length, X-Request-Id) are not compared; --all-headers compares them
+too, and --ignore-header skips more. --ignore skips JSON fields: .* and [*]
+match any key or index, e.g. --ignore '.items[*].updatedAt'.
+
+Examples:
+  wt apidiff main feature --paths /api/users,/api/orders
+  wt apidiff main . --port 3000 --paths /health -H 'Authorization: Bearer dev'
+  wt apidiff main feature -X POST -d '{"q":"x"}' --paths /api/search --ignore .took`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := resolveWorktreeArg(args[0])
+			if err != nil {
+				return err
+			}
+			b, err := resolveWorktreeArg(args[1])
+			if err != nil {
+				return err
+			}
+			var opts apidiffOptions
+			opts.paths, _ = cmd.Flags().GetStringSlice("paths")
+			if len(opts.paths) == 0 {
+				return fmt.Errorf("--paths is required")
+			}
+			opts.port, _ = cmd.Flags().GetInt("port")
+			opts.method, _ = cmd.Flags().GetString("request")
+			opts.headers, _ = cmd.Flags().GetStringArray("header")
+			opts.data, _ = cmd.Flags().GetString("data")
+			opts.ignore, _ = cmd.Flags().GetStringArray("ignore")
+			opts.ignoreHeaders, _ = cmd.Flags().GetStringArray("ignore-header")
+			opts.compareAll, _ = cmd.Flags().GetBool("all-headers")
+			opts.insecure, _ = cmd.Flags().GetBool("insecure")
+			return runAPIDiff(a, b, opts)
+		},
+	}
+	apidiffCmd.Flags().StringSlice("paths", nil, "paths (or full URLs) to call in both worktrees")
+	apidiffCmd.Flags().Int("port", 0, "container port the paths are on (default: the first forwardPorts entry)")
+	apidiffCmd.Flags().StringP("request", "X", "GET", "HTTP method")
+	apidiffCmd.Flags().StringArrayP("header", "H", nil, "request header as \"Name: value\" (repeatable)")
+	apidiffCmd.Flags().StringP("data", "d", "", "request body (sent as JSON unless -H sets Content-Type)")
+	apidiffCmd.Flags().StringArray("ignore", nil, "JSON path not to compare, e.g. .meta.requestId or .items[*].id (repeatable)")
+	apidiffCmd.Flags().StringArray("ignore-header", nil, "response header not to compare (repeatable)")
+	apidiffCmd.Flags().Bool("all-headers", false, "also compare headers that differ on every call, such as Date")
+	apidiffCmd.Flags().BoolP("insecure", "k", false, "don't verify TLS certificates")
+
+	// Screenshot command
+	screenshotCmd := &cobra.Command{
+		Use:     "screenshot [name] <url>",
+		Short:   "Save a screenshot of a page rendered through the worktree's proxy",
+		GroupID: "http",
+		Long: `Renders the URL in headless Chrome, set up like 'wt chrome' with the
+worktree's proxy and profile (so logins carry over), and saves a PNG of the
+window to --out (default screenshots/<time>.png in the worktree's artifacts
+directory; see 'wt artifacts'). A URL that is just a path, such
+as /login, opens on the devcontainer's default URL. While a 'wt chrome'
+window has the profile open, a fresh profile is used instead.
+
+--wait gives scripts on the page that much virtual time to render first.
+
+Examples:
+  wt screenshot /login
+  wt screenshot feature http://127.0.0.1:3000/cart --out cart.png --size 390x844`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(nil)
+			if len(args) == 2 {
+				dir, err = resolveWorktreeArg(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			opts, err := screenshotOptionsFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			out, _ := cmd.Flags().GetString("out")
+			return runScreenshot(dir, args[len(args)-1], out, opts)
+		},
+	}
+	screenshotCmd.Flags().StringP("out", "o", "", "PNG file to write (default: in the worktree's artifacts directory)")
+	screenshotCmd.Flags().String("size", "1280x800", "window size as WIDTHxHEIGHT")
+	screenshotCmd.Flags().Duration("wait", 2*time.Second, "virtual time the page gets to render before the screenshot")
+
+	// Visual diff command
+	visualDiffCmd := &cobra.Command{
+		Use:     "visual-diff <a> <b> <url>",
+		Short:   "Compare screenshots of a page in two worktrees",
+		GroupID: "http",
+		Long: `Takes a screenshot of the URL in worktrees a and b, as 'wt screenshot'
+does, and compares them pixel by pixel: a quick visual regression check
+without an e2e suite. It writes the screenshots next to --out (default
+visual-diff/<time>.png in b's artifacts directory) as <out>-<worktree>.png,
+and --out itself shows b's page dimmed with the differing pixels in red.
+
+Pixels whose colors differ by at most --tolerance (0-255 per channel) count
+as the same, which absorbs anti-aliasing noise. Exits 1 when more than
+--threshold percent of the pixels differ.
+
+Examples:
+  wt visual-diff main feature /
+  wt visual-diff main . /settings --size 390x844 --threshold 0.5`,
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := resolveWorktreeArg(args[0])
+			if err != nil {
+				return err
+			}
+			b, err := resolveWorktreeArg(args[1])
+			if err != nil {
+				return err
+			}
+			opts, err := screenshotOptionsFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			out, _ := cmd.Flags().GetString("out")
+			tolerance, _ := cmd.Flags().GetInt("tolerance")
+			threshold, _ := cmd.Flags().GetFloat64("threshold")
+			return runVisualDiff(a, b, args[2], out, opts, tolerance, threshold)
+		},
+	}
+	visualDiffCmd.Flags().StringP("out", "o", "", "PNG file for the image of the differences (default: in b's artifacts directory)")
+	visualDiffCmd.Flags().String("size", "1280x800", "window size as WIDTHxHEIGHT")
+	visualDiffCmd.Flags().Duration("wait", 2*time.Second, "virtual time the page gets to render before the screenshot")
+	visualDiffCmd.Flags().Int("tolerance", 8, "how much a color channel (0-255) may differ for a pixel to count as the same")
+	visualDiffCmd.Flags().Float64("threshold", 0, "percentage of differing pixels still accepted")
+
+	// Lighthouse command
+	lighthouseCmd := &cobra.Command{
+		Use:     "lighthouse [name] <url>",
+		Short:   "Audit a page with Lighthouse through the worktree's proxy",
+		GroupID: "http",
+		Long: `Runs a Lighthouse audit (performance, accessibility, best practices, SEO)
+of the URL in headless Chrome routed through the worktree's proxy, so the
+page is served by the worktree's devcontainer. A URL that is just a path,
+such as /login, opens on the devcontainer's default URL.
+
+The HTML and JSON reports are saved as <time>-<commit>.report.html and
+.report.json in lighthouse/ in the worktree's artifacts directory (or
+--out-dir), so the scores stay tied to the commit they were measured on,
+and the scores are printed. Uses the lighthouse CLI, or npx lighthouse when
+it is not installed.
+
+Examples:
+  wt lighthouse /
+  wt lighthouse feature /checkout --only accessibility --desktop`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(nil)
+			if len(args) == 2 {
+				dir, err = resolveWorktreeArg(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			var opts lighthouseOptions
+			opts.outDir, _ = cmd.Flags().GetString("out-dir")
+			opts.only, _ = cmd.Flags().GetStringSlice("only")
+			opts.desktop, _ = cmd.Flags().GetBool("desktop")
+			return runLighthouse(dir, args[len(args)-1], opts)
+		},
+	}
+	lighthouseCmd.Flags().StringP("out-dir", "o", "", "directory for the reports (default: lighthouse/ in the worktree's artifacts directory)")
+	lighthouseCmd.Flags().StringSlice("only", nil, "categories to audit: performance, accessibility, best-practices, seo")
+	lighthouseCmd.Flags().Bool("desktop", false, "audit as a desktop browser instead of an emulated phone")
+
+	// Init command
+	initCmd := &cobra.Command{
+		Use:     "init",
+		Short:   "Scaffold a .devcontainer/ with SOCKS5 proxy support",
+		GroupID: "devcontainer",
+		Long: `Creates a .devcontainer/ directory in the current project with:
+  - devcontainer.json   devcontainer config with SOCKS5 proxy port mapping
+  - Dockerfile          base image with supervisord and microsocks installed
+  - supervisord.conf    starts the SOCKS5 proxy daemon on container start
+
+Use --force to overwrite existing files.`,
+		Args: cobra.NoArgs,
+		RunE: runInit,
+	}
+	initCmd.Flags().Bool("force", false, "overwrite existing .devcontainer/ files")
+
+	// Clone command
+	cloneCmd := &cobra.Command{
+		Use:     "clone <url> [dir] [-- git-clone-args...]",
+		Short:   "Clone a repository set up for sibling worktrees",
+		GroupID: "worktree",
+		Long: `Clones the repository into dir (default: its name), sets
+worktree.useRelativePaths so worktree links keep working in devcontainers,
+and registers it for 'wt ls -g'. Worktrees are then created next to it as
+<dir>@<name> with 'wt add'.
+
+Arguments after '--' go to 'git clone', e.g. -- --filter=blob:none. With
+--init, also scaffolds a .devcontainer/ like 'wt init' unless the repository
+has one. With the 'wt shell-init' wrapper, the shell moves into the clone.
+
+Examples:
+  wt clone https://github.com/org/app.git
+  wt clone git@github.com:org/app.git app --init -- --depth 50`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var gitArgs []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, gitArgs = args[:dash], args[dash:]
+			}
+			if len(args) == 0 || len(args) > 2 {
+				return fmt.Errorf("expected <url> [dir]")
+			}
+			dir := ""
+			if len(args) == 2 {
+				dir = args[1]
+			}
+			initDevcontainer, _ := cmd.Flags().GetBool("init")
+			return runClone(args[0], dir, gitArgs, initDevcontainer)
+		},
+	}
+	cloneCmd.Flags().Bool("init", false, "scaffold a .devcontainer/ like 'wt init' after cloning")
+
+	// Down command
+	downCmd := &cobra.Command{
+		Use:               "down [name]",
+		Short:             "Stop and remove the worktree's devcontainer",
+		GroupID:           "devcontainer",
+		Args:              cobra.MaximumNArgs(1),
+		RunE:              runDown,
+		ValidArgsFunction: worktreeArgsCompletion,
+	}
+
+	// Bounce command
+	bounceCmd := &cobra.Command{
+		Use:     "bounce [name]",
+		Short:   "Recreate the worktree's devcontainer",
+		GroupID: "devcontainer",
+		Long: `Stops and removes the devcontainer, then starts a fresh one.
+Equivalent to running 'wt down' followed by 'wt up'.
+
+Useful after changes to .devcontainer/ configuration.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runDown(cmd, args); err != nil {
+				return err
+			}
+			return runUp(cmd, args)
+		},
+	}
+	addRunPolicyFlags(bounceCmd)
+
+	// Schedule command
+	scheduleCmd := &cobra.Command{
+		Use:     "schedule",
+		Short:   "Run repository maintenance on a daily timer",
+		GroupID: "worktree",
+		Long: `Installs a systemd user timer (Linux) or launchd agent (macOS) that runs
+'wt schedule run' in the main repository once a day, keeping a farm of
+worktrees fresh. The maintenance tasks are:
+
+  fetch    git fetch of every remote, in parallel
+  update   fast-forward clean worktrees whose branch has an upstream
+  prune    git worktree prune
+  gc       git gc --auto and docker image prune (dangling images)
+
+Configure the time and tasks in .wt.yaml:
+
+  schedule:
+    at: "04:30"
+    tasks: [fetch, update, prune]`,
+	}
+	scheduleInstallCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the daily maintenance timer for this repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mainRoot, err := getMainRepoRoot()
+			if err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			at := cfg.Schedule.at()
+			if cmd.Flags().Changed("at") {
+				at, _ = cmd.Flags().GetString("at")
+			}
+			return runScheduleInstall(mainRoot, at)
+		},
+	}
+	scheduleInstallCmd.Flags().String("at", defaultScheduleAt, "time of day to run (HH:MM)")
+	scheduleUninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove this repository's maintenance timer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mainRoot, err := getMainRepoRoot()
+			if err != nil {
+				return err
+			}
+			return runScheduleUninstall(mainRoot)
+		},
+	}
+	scheduleStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether a maintenance timer is installed and when it runs next",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mainRoot, err := getMainRepoRoot()
+			if err != nil {
+				return err
+			}
+			return runScheduleStatus(mainRoot)
+		},
+	}
+	scheduleRunCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the maintenance tasks now",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mainRoot, err := getMainRepoRoot()
+			if err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			tasks := cfg.Schedule.tasks()
+			if cmd.Flags().Changed("task") {
+				tasks, _ = cmd.Flags().GetStringSlice("task")
+				if err := validateTasks(tasks); err != nil {
+					return err
+				}
+			}
+			return runMaintenance(mainRoot, cfg, tasks)
+		},
+	}
+	scheduleRunCmd.Flags().StringSlice("task", nil, "tasks to run instead of schedule.tasks (fetch, update, prune, gc)")
+	scheduleCmd.AddCommand(scheduleInstallCmd, scheduleUninstallCmd, scheduleStatusCmd, scheduleRunCmd)
+
+	driftCmd := &cobra.Command{
+		Use:     "drift [name]",
+		Short:   "Flag devcontainers that are stale against their .devcontainer files",
+		GroupID: "devcontainer",
+		Long: `Compares each worktree's devcontainer with the current files under its
+.devcontainer directory. wt records a hash of those files whenever 'wt up'
+creates a container; a container whose files have changed since is stale.
+Containers created some other way are judged by file modification times.
+
+Without a name, checks every worktree that has a container. Exits 1 when a
+stale container is found; --fix removes and recreates stale containers.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			worktrees, err := driftTargets(args)
+			if err != nil {
+				return err
+			}
+			fix, _ := cmd.Flags().GetBool("fix")
+			if fix {
+				if err := requireDevcontainerCLI(); err != nil {
+					return err
+				}
+			}
+			return runDrift(worktrees, fix)
+		},
+	}
+	driftCmd.Flags().Bool("fix", false, "recreate stale containers")
+
+	restartCmd := &cobra.Command{
+		Use:     "restart [name]",
+		Short:   "Restart the worktree's devcontainer or one of its compose services",
+		GroupID: "devcontainer",
+		Long: `Restarts the worktree's running devcontainer with 'docker restart', keeping
+the container. With --service, restarts that service of a docker compose
+devcontainer instead, e.g. 'wt restart --service worker'.
+
+Use 'wt bounce' to recreate the devcontainer from scratch.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: worktreeArgsCompletion,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _, err := resolveWorkspaceFolder(args)
+			if err != nil {
+				return err
+			}
+			service, _ := cmd.Flags().GetString("service")
+			containerID, err := targetContainerID(dir, service)
+			if err != nil {
+				return err
+			}
+			out, err := exec.Command("docker", "restart", containerID).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("docker restart failed: %s", strings.TrimSpace(string(out)))
+			}
+			return nil
+		},
+	}
+	restartCmd.Flags().String("service", "", "restart this docker compose service instead of the devcontainer")
+
+	rootCmd.AddCommand(addCmd, cloneCmd, lsCmd, rmCmd, cdCmd, codeCmd, chromeCmd, playwrightCmd, curlCmd, replayCmd, apidiffCmd, screenshotCmd, visualDiffCmd, lighthouseCmd, nameCmd, reserveCmd, dirCmd, whichCmd, execCmd, logsCmd, sessionsCmd, stackCmd, restackCmd, changelogCmd, scheduleCmd, ciCmd, upCmd, downCmd, buildCmd, bounceCmd, restartCmd, psCmd, killCmd, duCmd, artifactsCmd, handOffCmd, receiveCmd, cleanCmd, driftCmd, profileCmd, imageCmd, cacheCmd, servicesCmd, proxyCmd, proxyPortCmd, portsCmd, hostsCmd, skillCmd, completionCmd, shellInitCmd, serveCmd, selftestCmd, doctorCmd, extendsCmd, policyCmd, initCmd)
+
+	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// getMainRepoRoot returns the absolute path to the main repository root.
+// Works from the main repo, any worktree, or any subdirectory thereof.
+func getMainRepoRoot() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-common-dir")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("not in a git repository: %w", err)
+	}
+	commonDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(commonDir) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		commonDir = filepath.Join(cwd, commonDir)
+	}
+	return filepath.Dir(filepath.Clean(commonDir)), nil
+}
+
+// getWorktreeParentDir returns the parent directory where sibling worktrees live.
+func getWorktreeParentDir() (string, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(mainRoot), nil
+}
+
+// getCurrentWorktreeRoot returns the toplevel of the current working tree.
+func getCurrentWorktreeRoot() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// worktreeDirName returns the directory name for a worktree: "repo@name".
+func worktreeDirName(repoBasename, name string) string {
+	return repoBasename + worktreeDelimiter + name
+}
+
+// parseWorktreeName extracts the worktree name from a directory name like "repo@name".
+// Returns empty string if the directory doesn't match the repo prefix.
+func parseWorktreeName(dirName, repoBasename string) string {
+	prefix := repoBasename + worktreeDelimiter
+	if strings.HasPrefix(dirName, prefix) {
+		return strings.TrimPrefix(dirName, prefix)
+	}
+	return ""
+}
+
+// resolveCurrentWorktreeName returns the name of the current worktree based on cwd.
+// Returns an error if the user is not inside a named worktree.
+func resolveCurrentWorktreeName() (string, error) {
+	if name, ok, err := sessionWorktreeName(); ok {
+		return name, err
+	}
+	wtRoot, err := getCurrentWorktreeRoot()
+	if err != nil {
+		return "", fmt.Errorf("not in a git worktree")
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	if wtRoot == mainRoot {
+		return "", fmt.Errorf("currently in the main worktree, not a named worktree")
+	}
+	repoBasename := filepath.Base(mainRoot)
+	name := parseWorktreeName(filepath.Base(wtRoot), repoBasename)
+	if name == "" {
+		return "", fmt.Errorf("current directory is not in a recognized worktree")
+	}
+	return name, nil
+}
+
+// resolveNameArg resolves a name argument, treating "." as the current worktree.
+// Unique prefixes and fuzzy matches of existing worktree names are expanded;
+// a name matching nothing is returned unchanged. Commands that delete things
+// use resolveExactNameArg instead.
+func resolveNameArg(name string) (string, error) {
+	if name == "." {
+		return resolveCurrentWorktreeName()
+	}
+	if err := validateWorktreeName(name); err != nil {
+		return "", err
+	}
+	return matchWorktreeName(name)
+}
+
+// resolveExactNameArg resolves a name argument for a command that deletes
+// things, treating "." as the current worktree. Names are not abbreviated:
+// a typo must not pick out another worktree, so a name that is neither a
+// worktree nor a directory next to the main repository is an error, which
+// suggests the worktree it would match loosely.
+func resolveExactNameArg(name string) (string, error) {
+	if name == "." {
+		return resolveCurrentWorktreeName()
+	}
+	if err := validateWorktreeName(name); err != nil {
+		return "", err
+	}
+	if slices.Contains(getWorktreeNames(""), name) {
+		return name, nil
+	}
+	dir, err := resolveWorktreePath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return name, nil
+	}
+	if matched, err := matchWorktreeName(name); err == nil && matched != name {
+		return "", fmt.Errorf("worktree %q does not exist (names are not abbreviated here; did you mean %q?)", name, matched)
+	}
+	return "", fmt.Errorf("worktree %q does not exist", name)
+}
+
+// matchWorktreeName expands arg to an existing worktree name. It tries, in
+// order, an exact match, a unique prefix, a unique substring, and a unique
+// subsequence (e.g. "fl" for "fix-login"). Several candidates at the first
+// tier that matches is an error; no candidates returns arg unchanged.
+func matchWorktreeName(arg string) (string, error) {
+	names := getWorktreeNames("")
+	tiers := []func(name string) bool{
+		func(name string) bool { return name == arg },
+		func(name string) bool { return strings.HasPrefix(name, arg) },
+		func(name string) bool { return strings.Contains(name, arg) },
+		func(name string) bool { return isSubsequence(arg, name) },
+	}
+	for _, match := range tiers {
+		var matches []string
+		for _, name := range names {
+			if match(name) {
+				matches = append(matches, name)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			if verbose && matches[0] != arg {
+				fmt.Fprintf(os.Stderr, "Matched %q to worktree %q\n", arg, matches[0])
+			}
+			return matches[0], nil
+		default:
+			return "", fmt.Errorf("%q matches several worktrees: %s; use a longer name", arg, strings.Join(matches, ", "))
+		}
+	}
+	return arg, nil
+}
+
+// isSubsequence reports whether the characters of needle appear in order in
+// haystack.
+func isSubsequence(needle, haystack string) bool {
+	i := 0
+	for j := 0; j < len(haystack) && i < len(needle); j++ {
+		if needle[i] == haystack[j] {
+			i++
+		}
+	}
+	return i == len(needle)
+}
+
+// resolveWorktreePath returns the full path for a worktree by name.
+func resolveWorktreePath(name string) (string, error) {
+	if err := validateWorktreeName(name); err != nil {
+		return "", err
+	}
+	parentDir, err := getWorktreeParentDir()
+	if err != nil {
+		return "", err
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	dirName := worktreeDirName(filepath.Base(mainRoot), name)
+	return filepath.Join(parentDir, dirName), nil
+}
+
+func getWorktreeNames(prefix string) []string {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, wt := range worktrees {
+		if strings.HasPrefix(wt.name, prefix) {
+			names = append(names, wt.name)
+		}
+	}
+	return names
+}
+
+// siblingWorktree is a named worktree living next to the main repository.
+type siblingWorktree struct {
+	name     string
+	path     string
+	head     string // HEAD commit, as 'git worktree list' reports it
+	branch   string // checked-out branch without refs/heads/; empty when detached
+	detached bool
+
+	locked      bool
+	lockReason  string
+	prunable    bool // its directory or git metadata is gone; 'git worktree prune' drops it
+	pruneReason string
+	// Only in allWorktrees:
+	orphaned   bool // a repo@name directory git does not know
+	nonSibling bool // a worktree outside the repo@name layout, named after its directory
+}
+
+// siblingWorktrees returns the named sibling worktrees of the repository
+// rooted at mainRoot, in 'git worktree list' order.
+func siblingWorktrees(mainRoot string) ([]siblingWorktree, error) {
+	return gitWorktrees(mainRoot, false)
+}
+
+// gitWorktrees returns the worktrees 'git worktree list' reports for the
+// repository rooted at mainRoot, but the main one: the named siblings and,
+// with nonSiblings, the others too.
+func gitWorktrees(mainRoot string, nonSiblings bool) ([]siblingWorktree, error) {
+	parentDir := filepath.Dir(mainRoot)
+	repoBasename := filepath.Base(mainRoot)
+
+	cmd := exec.Command("git", "-C", mainRoot, "worktree", "list", "--porcelain")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git worktree list failed: %w", err)
+	}
+
+	var worktrees []siblingWorktree
+	// Each worktree is a block of lines starting with "worktree <path>".
+	var current *siblingWorktree
+	for _, line := range strings.Split(string(output), "\n") {
+		if wtPath, ok := strings.CutPrefix(line, "worktree "); ok {
+			current = nil
+			if wtPath == mainRoot {
+				continue
+			}
+			name := ""
+			if filepath.Dir(wtPath) == parentDir {
+				name = parseWorktreeName(filepath.Base(wtPath), repoBasename)
+			}
+			if name != "" {
+				worktrees = append(worktrees, siblingWorktree{name: name, path: wtPath})
+				current = &worktrees[len(worktrees)-1]
+			} else if nonSiblings {
+				worktrees = append(worktrees, siblingWorktree{name: filepath.Base(wtPath), path: wtPath, nonSibling: true})
+				current = &worktrees[len(worktrees)-1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if head, ok := strings.CutPrefix(line, "HEAD "); ok {
+			current.head = head
+		} else if branch, ok := strings.CutPrefix(line, "branch "); ok {
+			current.branch = strings.TrimPrefix(branch, "refs/heads/")
+		} else if line == "detached" {
+			current.detached = true
+		} else if reason, ok := strings.CutPrefix(line, "locked"); ok {
+			current.locked = true
+			current.lockReason = strings.TrimSpace(reason)
+		} else if reason, ok := strings.CutPrefix(line, "prunable"); ok {
+			current.prunable = true
+			current.pruneReason = strings.TrimSpace(reason)
+		}
+	}
+	return worktrees, nil
+}
+
+// addOptions controls how addWorktree creates a worktree and what runs after.
+type addOptions struct {
+	like        string   // worktree to copy env and untracked files from
+	base        string   // commit-ish the worktree starts at (default HEAD)
+	branch      string   // branch to create or check out; empty for a detached worktree
+	track       bool     // the branch must come from origin, tracking it
+	up          bool     // start the devcontainer after creating
+	code        bool     // open VS Code after creating
+	cd          bool     // change into the worktree (or open a shell there) last
+	noBootstrap bool     // skip add.bootstrap commands
+	noHooks     bool     // skip the post-add hook
+	noRollback  bool     // keep a worktree whose setup failed hard
+	deepen      int      // commits of history to add to a shallow clone first
+	stackOn     string   // worktree to stack the new one on
+	fetched     *bool    // shared by the adds of one run: set once a fetch succeeds, then skips fetching
+	noFetch     bool     // don't fetch remotes first
+	sparse      []string // directories to check out sparsely (cone mode)
+	noSparse    bool     // check out everything despite remembered sparse paths
+	carry       bool     // move the current worktree's uncommitted changes in
+	force       bool     // override policies, which is logged
+}
+
+// addOptionsFromFlags reads addOptions from cmd's flags. Flags that cmd does
+// not define (e.g. when 'wt cd -c' creates a worktree) keep their defaults.
+func addOptionsFromFlags(cmd *cobra.Command) addOptions {
+	var opts addOptions
+	opts.like, _ = cmd.Flags().GetString("like")
+	opts.up, _ = cmd.Flags().GetBool("up")
+	opts.code, _ = cmd.Flags().GetBool("code")
+	opts.cd, _ = cmd.Flags().GetBool("cd")
+	opts.noBootstrap, _ = cmd.Flags().GetBool("no-bootstrap")
+	opts.noHooks, _ = cmd.Flags().GetBool("no-hooks")
+	opts.noRollback, _ = cmd.Flags().GetBool("no-rollback")
+	opts.noFetch, _ = cmd.Flags().GetBool("no-fetch")
+	opts.sparse, _ = cmd.Flags().GetStringSlice("sparse")
+	opts.noSparse, _ = cmd.Flags().GetBool("no-sparse")
+	opts.deepen, _ = cmd.Flags().GetInt("deepen")
+	opts.stackOn, _ = cmd.Flags().GetString("stack-on")
+	opts.branch, _ = cmd.Flags().GetString("branch")
+	opts.carry, _ = cmd.Flags().GetBool("from-stash")
+	opts.force, _ = cmd.Flags().GetBool("force")
+	return opts
+}
+
+func runAdd(cmd *cobra.Command, args []string) error {
+	return addWorktree(args[0], addOptionsFromFlags(cmd))
+}
+
+// addWorktree creates the sibling worktree for name and copies config files
+// into it. Follow-up actions (up, bootstrap, code) are run by finishAdd.
+func addWorktree(name string, opts addOptions) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := checkNewWorktreeName(name, cfg.Naming); err != nil {
+		return err
+	}
+
+	worktreePath, err := resolveWorktreePath(name)
+	if err != nil {
+		return err
+	}
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		registerRepo(mainRoot)
+	}
+
+	// Check if target path already exists
+	if info, err := os.Stat(worktreePath); err == nil {
+		if info.IsDir() {
+			gitPath := filepath.Join(worktreePath, ".git")
+			if _, err := os.Stat(gitPath); err == nil {
+				return fmt.Errorf("'%s' already exists with a .git entry; choose a different name or remove it first", filepath.Base(worktreePath))
+			}
+			return fmt.Errorf("'%s' already exists but is not a git worktree; choose a different name or remove it first", filepath.Base(worktreePath))
+		}
+		return fmt.Errorf("'%s' already exists as a file; choose a different name or remove it first", filepath.Base(worktreePath))
+	}
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		if err := enforceWorktreeLimit(mainRoot, cfg.Quota); err != nil {
+			return err
+		}
+		if err := checkWorktreeCountPolicy(mainRoot, name, cfg.Policy, opts.force); err != nil {
+			return err
+		}
+		if err := checkPinnedSlot(mainRoot); err != nil {
+			return err
+		}
+	}
+
+	// Determine source directory for copying config files
+	projectDir, err := getCurrentWorktreeRoot()
+	if err != nil {
+		if opts.carry {
+			return fmt.Errorf("--from-stash must be run in the worktree whose changes to carry: %w", err)
+		}
+		projectDir, _ = os.Getwd()
+	}
+	carryFrom := projectDir
+	like := opts.like
+	if like != "" {
+		likeName, err := resolveNameArg(like)
+		if err != nil {
+			return err
+		}
+		if projectDir, err = resolveWorktreePath(likeName); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(projectDir, ".git")); err != nil {
+			return fmt.Errorf("worktree %q passed to --like does not exist", likeName)
+		}
+	}
+
+	// Ensure relative paths for worktree links (devcontainer compatibility)
+	_ = exec.Command("git", "config", "worktree.useRelativePaths", "true").Run()
+
+	// Best-effort fetch of the remotes, if any.
+	shape := detectCloneShape()
+	if isOffline(cfg) {
+		offline = true
+		if opts.deepen > 0 {
+			return errOffline("--deepen")
+		}
+		fmt.Fprintln(os.Stderr, "Offline: skipping git fetch")
+	} else if opts.fetched != nil && *opts.fetched {
+		// An earlier add in the same run fetched already.
+	} else if remotes := gitRemotes(); len(remotes) > 0 {
+		if opts.noFetch {
+			fmt.Fprintln(os.Stderr, "Skipping git fetch (--no-fetch)")
+		} else {
+			mainRoot, _ := getMainRepoRoot()
+			if err := fetchRemotes(mainRoot, remotes, cfg.Add.fetchMaxAge(), cfg.Add.fetchTimeout(), shape.fetchArgs(cfg.Add)); err != nil {
+				warnf("check your network and credentials, or pass --no-fetch", "git fetch failed: %v", err)
+			} else if opts.fetched != nil {
+				*opts.fetched = true
+			}
+		}
+		if opts.deepen > 0 {
+			if !shape.shallow {
+				warnf("", "--deepen has no effect; the repository is not a shallow clone")
+			} else if err := deepenClone(opts.deepen); err != nil {
+				return err
+			}
+		}
+	} else if !opts.noFetch {
+		warnf("add one with 'git remote add origin <url>' to fetch before creating worktrees", "no git remote configured; skipping fetch")
+	}
+
+	// Create worktree off the base ref (current HEAD by default)
+	base := opts.base
+	var stackParent *siblingWorktree
+	if opts.stackOn != "" {
+		if base != "" {
+			return fmt.Errorf("--stack-on starts the worktree at the parent's HEAD and cannot be combined with a base ref")
+		}
+		parentName, err := resolveNameArg(opts.stackOn)
+		if err != nil {
+			return err
+		}
+		parentDir, err := resolveWorktreePath(parentName)
+		if err != nil {
+			return err
+		}
+		if base, err = revParse(parentDir, "HEAD"); err != nil {
+			return fmt.Errorf("worktree %q passed to --stack-on does not exist", parentName)
+		}
+		stackParent = &siblingWorktree{name: parentName, path: parentDir}
+	} else if base != "" {
+		if mainRoot, err := getMainRepoRoot(); err == nil {
+			if wt, ok := stackParentForBase(mainRoot, base); ok {
+				stackParent = &wt
+			}
+		}
+	}
+	explicitBase := base != ""
+	if base == "" {
+		base = "HEAD"
+	}
+	if base, err = ensureBaseAvailable(base, shape, cfg.Add); err != nil {
+		return err
+	}
+	gitArgs := []string{"worktree", "add", "--detach", worktreePath, base}
+	if opts.branch != "" {
+		if opts.track && !refExists("refs/remotes/origin/"+opts.branch) {
+			return fmt.Errorf("origin has no branch %q to track", opts.branch)
+		}
+		if gitArgs, err = branchWorktreeArgs(opts.branch, worktreePath, base, explicitBase); err != nil {
+			return err
+		}
+	}
+	sparse := opts.sparsePaths(cfg.Add)
+	if len(sparse) > 0 {
+		// Check out only once the sparse paths are set.
+		gitArgs = append([]string{"worktree", "add", "--no-checkout"}, gitArgs[2:]...)
+	}
+	gitCmd := exec.Command("git", gitArgs...)
+	gitCmd.Stdout = os.Stdout
+	gitCmd.Stderr = os.Stderr
+	if err := gitCmd.Run(); err != nil {
+		return fmt.Errorf("git worktree add failed: %w", err)
+	}
+	// From here on a hard failure offers to roll the new worktree back
+	// rather than leave it half configured.
+	createdBranch := ""
+	for _, arg := range gitArgs {
+		if arg == "-b" {
+			createdBranch = opts.branch
+		}
+	}
+	var carried *carriedStash
+	fail := func(err error) error {
+		return rollbackAdd(name, worktreePath, createdBranch, carried, opts.noRollback, err)
+	}
+	if len(sparse) > 0 {
+		if err := applySparseCheckout(worktreePath, sparse); err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(os.Stderr, "Sparse checkout of %s\n", strings.Join(sparse, ", "))
+		if len(opts.sparse) > 0 {
+			if err := rememberSparsePaths(opts.sparse); err != nil {
+				warnf("pass --sparse again to later 'wt add' runs", "%v", err)
+			}
+		}
+	}
+	if stackParent != nil {
+		if head, err := revParse(worktreePath, "HEAD"); err == nil {
+			if err := saveStackLink(worktreePath, stackLink{Parent: stackParent.name, Base: head}); err != nil {
+				warnf("'wt restack' will not know the parent; check the wt state directory is writable", "failed to record stack parent: %v", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Stacked on %s; keep it current with 'wt restack'\n", stackParent.name)
+			}
+		}
+	}
+
+	// Before any files are copied in, which would block applying untracked
+	// ones.
+	if opts.carry {
+		if carried, err = carryChanges(carryFrom, worktreePath); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Copy all .env* files from root of project, plus .devcontainer/.env
+	envFiles, _ := filepath.Glob(filepath.Join(projectDir, ".env*"))
+	if _, err := os.Stat(filepath.Join(projectDir, ".devcontainer", ".env")); err == nil {
+		envFiles = append(envFiles, filepath.Join(projectDir, ".devcontainer", ".env"))
+	}
+	var envData *envTemplateData
+	if len(envFiles) > 0 {
+		if envData, err = newEnvTemplateData(worktreePath, cfg.Env); err != nil {
+			warnf("", "__WT_*__ placeholders in env files are left as is: %v", err)
+		}
+	}
+	for _, src := range envFiles {
+		rel, _ := filepath.Rel(projectDir, src)
+		dst := filepath.Join(worktreePath, rel)
+		if err := copyEnvFile(src, dst, cfg.Env, envData); isHardSetupError(err, worktreePath) {
+			return fail(fmt.Errorf("failed to copy %s: %w", rel, err))
+		} else if err != nil {
+			warnf(fmt.Sprintf("copy it by hand: cp %s %s", src, dst), "failed to copy %s: %v", rel, err)
+		}
+	}
+
+	if n, err := copyUntrackedFiles(projectDir, worktreePath, cfg.Add.Copy); isHardSetupError(err, worktreePath) {
+		return fail(err)
+	} else if err != nil {
+		warnf("", "%v", err)
+	} else if verbose && n > 0 {
+		fmt.Fprintf(os.Stderr, "Copied %d add.copy files from %s\n", n, filepath.Base(projectDir))
+	}
+
+	if n, err := seedFromTemplate(worktreePath, cfg); isHardSetupError(err, worktreePath) {
+		return fail(err)
+	} else if err != nil {
+		warnf("", "%v", err)
+	} else if verbose && n > 0 {
+		fmt.Fprintf(os.Stderr, "Seeded %d files from the worktree template\n", n)
+	}
+
+	if like != "" {
+		if len(cfg.Add.Like) == 0 {
+			warnf(fmt.Sprintf("list the files to copy under add.like in %s", projectConfigFile), "no add.like patterns; only env files were copied from %s", filepath.Base(projectDir))
+		} else if n, err := copyLikeFiles(projectDir, worktreePath, cfg.Add.Like); isHardSetupError(err, worktreePath) {
+			return fail(err)
+		} else if err != nil {
+			warnf("", "%v", err)
+		} else if verbose {
+			fmt.Fprintf(os.Stderr, "Copied %d untracked files from %s\n", n, filepath.Base(projectDir))
+		}
+	}
+
+	if err := linkSharedDirs(worktreePath, cfg.Share); isHardSetupError(err, worktreePath) {
+		return fail(err)
+	} else if err != nil {
+		warnf("the worktree gets its own copy instead; check the wt state directory is writable", "%v", err)
+	}
+
+	if rendered, err := renderEnvTemplates(worktreePath, cfg.Env); isHardSetupError(err, worktreePath) {
+		return fail(err)
+	} else if err != nil {
+		warnf("fix the template and re-render it with 'wt up'", "%v", err)
+	} else {
+		if verbose && len(rendered) > 0 {
+			fmt.Fprintf(os.Stderr, "Rendered %s\n", strings.Join(rendered, ", "))
+		}
+		if err := checkEnvPortConflicts(worktreePath, rendered); err != nil {
+			warnf("", "%v", err)
+		}
+	}
+
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		if err := releaseReservation(mainRoot, name); err != nil {
+			warnf("", "%v", err)
+		}
+	}
+	if carried != nil {
+		carried.drop()
+	}
+
+	fmt.Println(worktreePath)
+	return nil
+}
+
+// refExists reports whether the full ref (e.g. refs/heads/main) exists.
+func refExists(ref string) bool {
+	return exec.Command("git", "show-ref", "--verify", "--quiet", ref).Run() == nil
+}
+
+// branchWorktreeArgs returns the 'git worktree add' arguments that put
+// branch in a new worktree at path: an existing local branch is checked out,
+// a branch that only exists on origin is created tracking it, and any other
+// name is created at base. Only a new branch can start at an explicit base.
+func branchWorktreeArgs(branch, path, base string, explicitBase bool) ([]string, error) {
+	if err := exec.Command("git", "check-ref-format", "--branch", branch).Run(); err != nil {
+		return nil, fmt.Errorf("invalid branch name %q", branch)
+	}
+	switch {
+	case refExists("refs/heads/" + branch):
+		if explicitBase {
+			return nil, fmt.Errorf("branch %q already exists; check it out without a base ref or --stack-on", branch)
+		}
+		return []string{"worktree", "add", path, branch}, nil
+	case !explicitBase && refExists("refs/remotes/origin/"+branch):
+		return []string{"worktree", "add", "--track", "-b", branch, path, "origin/" + branch}, nil
+	default:
+		return []string{"worktree", "add", "-b", branch, path, base}, nil
+	}
+}
+
+// runAddCommand implements 'wt add': it creates the worktree, then runs any
+// follow-up actions requested by flags.
+func runAddCommand(cmd *cobra.Command, args []string) error {
+	jsonOut, _ := cmd.Flags().GetBool("json")
+	deferWarnings = true
+	if fromFile, _ := cmd.Flags().GetString("from-file"); fromFile != "" {
+		if jsonOut {
+			return fmt.Errorf("--json cannot be combined with --from-file")
+		}
+		err := runBulkAdd(cmd, args, fromFile)
+		printWarningSummary()
+		return err
+	}
+	if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
+		if jsonOut {
+			return fmt.Errorf("--json cannot be combined with -i")
+		}
+		err := runAddWizard(cmd, args)
+		printWarningSummary()
+		return err
+	}
+	if cd, _ := cmd.Flags().GetBool("cd"); cd && jsonOut {
+		return fmt.Errorf("--json cannot be combined with --cd")
+	}
+	// With --json, everything but the final result goes to stderr.
+	stdout := os.Stdout
+	if jsonOut {
+		os.Stdout = os.Stderr
+	}
+	name, err := addFromFlags(cmd, args)
+	os.Stdout = stdout
+	if err != nil || !jsonOut {
+		printWarningSummary()
+		return err
+	}
+	dir, err := resolveWorktreePath(name)
+	if err != nil {
+		return err
+	}
+	return printJSONResult(map[string]any{"name": name, "path": dir})
+}
+
+// addFromFlags creates the worktree described by the 'wt add' flags and
+// arguments, runs the follow-up actions, and returns its name.
+func addFromFlags(cmd *cobra.Command, args []string) (string, error) {
+	auto, _ := cmd.Flags().GetBool("auto")
+	if auto && len(args) > 0 {
+		return "", fmt.Errorf("--auto cannot be combined with a name")
+	}
+	opts := addOptionsFromFlags(cmd)
+	if len(args) == 2 {
+		if opts.branch != "" && opts.branch != args[1] {
+			return "", fmt.Errorf("branch given both as an argument (%s) and with --branch (%s)", args[1], opts.branch)
+		}
+		opts.branch = args[1]
+	}
+	pr, _ := cmd.Flags().GetInt("pr")
+	if pr < 0 {
+		return "", fmt.Errorf("--pr must be a pull request number")
+	}
+	issueNumber, _ := cmd.Flags().GetInt("issue")
+	if issueNumber < 0 {
+		return "", fmt.Errorf("--issue must be an issue number")
+	}
+	if issueNumber > 0 && pr > 0 {
+		return "", fmt.Errorf("--issue and --pr cannot be combined")
+	}
+	var issue trackerIssue
+	if issueNumber > 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		offline = isOffline(cfg)
+		if issue, err = fetchIssue(issueNumber, cfg.Add.IssueCommand); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(os.Stderr, "Issue #%d: %s\n", issue.number, issue.title)
+	}
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	} else if pr > 0 && !auto {
+		name = fmt.Sprintf("pr-%d", pr)
+	} else if issueNumber > 0 && opts.branch == "" && !auto {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		name = issue.worktreeName(cfg.Naming)
+	} else if opts.branch != "" && !auto {
+		name = worktreeNameForBranch(opts.branch)
+	} else {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		if name, err = generateWorktreeName(cfg.Add.NamePattern, cfg.Naming); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(os.Stderr, "Generated worktree name: %s\n", name)
+	}
+	track, _ := cmd.Flags().GetBool("track")
+	noTrack, _ := cmd.Flags().GetBool("no-track")
+	if track && noTrack {
+		return "", fmt.Errorf("--track and --no-track cannot be combined")
+	}
+	if track {
+		if pr > 0 || opts.stackOn != "" {
+			return "", fmt.Errorf("--track cannot be combined with --pr or --stack-on")
+		}
+		if opts.branch == "" {
+			opts.branch = name
+		}
+		opts.track = true
+	} else if !noTrack && opts.branch == "" && pr == 0 && opts.stackOn == "" && len(args) > 0 && refExists("refs/remotes/origin/"+name) {
+		checkout := false
+		if !nonInteractive && term.IsTerminal(int(os.Stdin.Fd())) {
+			var err error
+			if checkout, err = promptYesNo(fmt.Sprintf("origin has a branch %q. Check it out with tracking?", name), true); err != nil {
+				return "", err
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "origin has a branch %q; pass --track to check it out instead of detaching\n", name)
+		}
+		if checkout {
+			opts.branch = name
+		}
+	}
+	if issueNumber > 0 && opts.branch == "" {
+		opts.branch = name
+	}
+	if pr > 0 {
+		if opts.stackOn != "" {
+			return "", fmt.Errorf("--pr and --stack-on cannot be combined")
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		offline = isOffline(cfg)
+		if opts.base, err = fetchPullRequest(pr); err != nil {
+			return "", err
+		}
+	}
+	if err := addWorktree(name, opts); err != nil {
+		return "", err
+	}
+	if issueNumber > 0 {
+		dir, err := resolveWorktreePath(name)
+		if err == nil {
+			err = recordIssue(dir, issue)
+		}
+		if err != nil {
+			warnf(fmt.Sprintf("add WT_ISSUE=%d to .devcontainer/.env by hand", issue.number), "failed to record issue #%d: %v", issue.number, err)
+		}
 	}
-	return ""
+	return name, finishAdd(name, opts)
 }
 
-// resolveCurrentWorktreeName returns the name of the current worktree based on cwd.
-// Returns an error if the user is not inside a named worktree.
-func resolveCurrentWorktreeName() (string, error) {
-	wtRoot, err := getCurrentWorktreeRoot()
+// finishAdd runs the post-creation steps for a new worktree: starting the
+// devcontainer, bootstrap commands, and opening the editor.
+func finishAdd(name string, opts addOptions) error {
+	up, code := opts.up, opts.code
+	cfg, err := loadConfig()
 	if err != nil {
-		return "", fmt.Errorf("not in a git worktree")
+		return err
 	}
-	mainRoot, err := getMainRepoRoot()
+	if opts.noBootstrap {
+		cfg.Add.Bootstrap = nil
+	}
+	dir, err := resolveWorktreePath(name)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if wtRoot == mainRoot {
-		return "", fmt.Errorf("currently in the main worktree, not a named worktree")
+	hook := ""
+	if !opts.noHooks {
+		hook = findHook(dir, hookPostAdd)
 	}
-	repoBasename := filepath.Base(mainRoot)
-	name := parseWorktreeName(filepath.Base(wtRoot), repoBasename)
-	if name == "" {
-		return "", fmt.Errorf("current directory is not in a recognized worktree")
+	if !up && !code && !opts.cd && len(cfg.Add.Bootstrap) == 0 && hook == "" {
+		return nil
 	}
-	return name, nil
-}
-
-// resolveNameArg resolves a name argument, treating "." as the current worktree.
-func resolveNameArg(name string) (string, error) {
-	if name == "." {
-		return resolveCurrentWorktreeName()
+	hasDevcontainer := false
+	if _, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json")); err == nil {
+		hasDevcontainer = true
 	}
-	if err := validateWorktreeName(name); err != nil {
-		return "", err
+	if up && !hasDevcontainer {
+		warnf("scaffold one with 'wt init'", "%s has no .devcontainer/devcontainer.json; skipping --up", filepath.Base(dir))
+	} else if up || bootstrapNeedsContainer(dir, cfg.Add) {
+		if err := requireDevcontainerCLI(); err != nil {
+			return err
+		}
+		if err := devcontainerUp(dir, nil, cfg.Up.policy(), opts.force); err != nil {
+			return err
+		}
+		if err := waitForProxy(dir, 30*time.Second); err != nil {
+			return err
+		}
 	}
-	return name, nil
-}
-
-// resolveWorktreePath returns the full path for a worktree by name.
-func resolveWorktreePath(name string) (string, error) {
-	if err := validateWorktreeName(name); err != nil {
-		return "", err
+	if err := runBootstrap(dir, cfg.Add); err != nil {
+		return err
 	}
-	parentDir, err := getWorktreeParentDir()
-	if err != nil {
-		return "", err
+	if hook != "" {
+		if err := runHook(dir, hookPostAdd); err != nil {
+			return fmt.Errorf("%w\nThe worktree was created at %s", err, dir)
+		}
 	}
-	mainRoot, err := getMainRepoRoot()
-	if err != nil {
-		return "", err
+	shell := false
+	if opts.cd {
+		recorded, err := recordCDTarget(dir)
+		if err != nil {
+			return err
+		}
+		// Without the shell-init wrapper, a shell in the worktree stands in
+		// for changing directory. Non-interactive callers have the path.
+		shell = !recorded && !nonInteractive
 	}
-	dirName := worktreeDirName(filepath.Base(mainRoot), name)
-	return filepath.Join(parentDir, dirName), nil
+	if code {
+		ed, err := resolveEditor(cfg.Editor, "", "", nil)
+		if err != nil {
+			return err
+		}
+		// The editor may replace wt; report warnings first.
+		printWarningSummary()
+		if !shell {
+			return openInEditor(dir, ed, opts.force)
+		}
+		// Open the editor from a child wt so that the shell can follow.
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		codeArgs := []string{"code", name}
+		if opts.force {
+			codeArgs = append(codeArgs, "--force")
+		}
+		codeCmd := exec.Command(exe, codeArgs...)
+		codeCmd.Stdin = os.Stdin
+		codeCmd.Stdout = os.Stdout
+		codeCmd.Stderr = os.Stderr
+		if err := codeCmd.Run(); err != nil {
+			return fmt.Errorf("wt code failed: %w", err)
+		}
+	}
+	if shell {
+		printWarningSummary()
+		return execShellInDir(dir)
+	}
+	return nil
 }
 
-func getWorktreeNames(prefix string) []string {
+func runList(cmd *cobra.Command, args []string) error {
+	jsonOut, _ := cmd.Flags().GetBool("json")
+	long, _ := cmd.Flags().GetBool("long")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	global, _ := cmd.Flags().GetBool("global")
+	porcelain, _ := cmd.Flags().GetBool("porcelain")
+	nul, _ := cmd.Flags().GetBool("null")
+	if porcelain && (long || quiet || jsonOut) {
+		return fmt.Errorf("--porcelain cannot be combined with -l, -q, or --json")
+	}
+	if nul && !porcelain {
+		return fmt.Errorf("-z requires --porcelain")
+	}
+	if long && (jsonOut || global) {
+		return fmt.Errorf("-l cannot be combined with --json or --global")
+	}
+	if quiet && (long || jsonOut || global) {
+		return fmt.Errorf("-q cannot be combined with -l, --json, or --global")
+	}
+	var printListings func([]worktreeListing) error
+	switch {
+	case porcelain:
+		printListings = func(listings []worktreeListing) error { return printListingPorcelain(listings, nul) }
+	case jsonOut:
+		printListings = printListingJSON
+	}
+	size, _ := cmd.Flags().GetBool("size")
+	if size && (quiet || global) {
+		return fmt.Errorf("--size cannot be combined with -q or --global")
+	}
+	base, _ := cmd.Flags().GetString("base")
+	if base != "" && global {
+		return fmt.Errorf("--base cannot be combined with --global")
+	}
+	var filter listFilter
+	filter.dirty, _ = cmd.Flags().GetBool("dirty")
+	filter.running, _ = cmd.Flags().GetBool("running")
+	merged, _ := cmd.Flags().GetBool("merged")
+	sortBy, _ := cmd.Flags().GetString("sort")
+	if sortBy != "" && !slices.Contains(listSortKeys, sortBy) {
+		return fmt.Errorf("invalid --sort %q: must be one of %s", sortBy, strings.Join(listSortKeys, ", "))
+	}
+	if global && (filter.dirty || filter.running || merged || sortBy != "") {
+		return fmt.Errorf("--dirty, --running, --merged, and --sort cannot be combined with --global")
+	}
+	all, _ := cmd.Flags().GetBool("all")
+	if all && global {
+		return fmt.Errorf("--all cannot be combined with --global")
+	}
+	if global {
+		return runListGlobal(printListings)
+	}
+	// Scripts that read plain 'wt ls' get the names alone, as they always
+	// have; the container column is for people.
+	if !quiet && !long && printListings == nil && !all && !size && base == "" && !term.IsTerminal(int(os.Stdout.Fd())) {
+		quiet = true
+	}
+
 	mainRoot, err := getMainRepoRoot()
 	if err != nil {
-		return nil
+		return err
 	}
-	parentDir := filepath.Dir(mainRoot)
-	repoBasename := filepath.Base(mainRoot)
+	registerRepo(mainRoot)
 
-	cmd := exec.Command("git", "worktree", "list", "--porcelain")
-	output, err := cmd.Output()
+	list := siblingWorktrees
+	if all {
+		list = allWorktrees
+	}
+	worktrees, err := list(mainRoot)
 	if err != nil {
-		return nil
+		return err
 	}
-
-	var names []string
-	for _, line := range strings.Split(string(output), "\n") {
-		if !strings.HasPrefix(line, "worktree ") {
-			continue
+	if base != "" || merged {
+		ref, err := resolveListBase(mainRoot, base)
+		if err != nil {
+			return err
 		}
-		wtPath := strings.TrimPrefix(line, "worktree ")
-		if wtPath == mainRoot {
-			continue
+		if base != "" {
+			base = ref
 		}
-		if filepath.Dir(wtPath) != parentDir {
-			continue
+		if merged {
+			filter.merged = ref
 		}
-		name := parseWorktreeName(filepath.Base(wtPath), repoBasename)
-		if name != "" && strings.HasPrefix(name, prefix) {
-			names = append(names, name)
+	}
+	var states map[string]string
+	if !quiet || filter.running {
+		states = containerStates()
+	}
+	worktrees = filterWorktrees(mainRoot, worktrees, filter, states)
+	sortWorktrees(worktrees, sortBy)
+	var extra []listColumn
+	if all {
+		extra = append(extra, flagsColumn(worktrees))
+	}
+	var ports []string
+	if !quiet {
+		ports = worktreeProxyPorts(worktrees, states)
+		if c, ok := proxyColumn(ports); ok {
+			extra = append(extra, c)
 		}
 	}
-	return names
+	var sizes []int64
+	if size {
+		sizes = worktreeSizes(worktrees)
+		extra = append(extra, sizeColumn(sizes))
+	}
+	var counts []aheadBehind
+	if base != "" {
+		counts = baseCounts(mainRoot, base, worktrees)
+		extra = append(extra, baseColumn(base, counts))
+	}
+	if printListings != nil {
+		var listings []worktreeListing
+		for i, wt := range worktrees {
+			l := newWorktreeListing(wt, states)
+			l.ProxyPort = ports[i]
+			if sizes != nil {
+				l.Size = &sizes[i]
+			}
+			if counts != nil && counts[i].ok {
+				l.Base = &baseListing{Ref: base, Ahead: counts[i].ahead, Behind: counts[i].behind}
+			}
+			listings = append(listings, l)
+		}
+		return printListings(listings)
+	}
+	if long {
+		return runListLong(worktrees, states, extra)
+	}
+	if quiet {
+		for _, wt := range worktrees {
+			fmt.Println(wt.name)
+		}
+		return nil
+	}
+	return runListShort(worktrees, states, extra)
 }
 
-func runAdd(cmd *cobra.Command, args []string) error {
-	name := args[0]
-	if err := validateWorktreeName(name); err != nil {
+func runRemove(cmd *cobra.Command, args []string) error {
+	jsonOut, _ := cmd.Flags().GetBool("json")
+	deferWarnings = true
+	if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
+		if jsonOut {
+			return fmt.Errorf("--json cannot be combined with -i")
+		}
+		keep, _ := cmd.Flags().GetStringArray("keep")
+		err := runRemoveInteractive(args, keep)
+		printWarningSummary()
 		return err
 	}
-
-	worktreePath, err := resolveWorktreePath(name)
+	if len(args) == 0 {
+		return fmt.Errorf("requires a worktree name (or -i to pick interactively)")
+	}
+	name, err := resolveExactNameArg(args[0])
 	if err != nil {
 		return err
 	}
-
-	// Check if target path already exists
-	if info, err := os.Stat(worktreePath); err == nil {
-		if info.IsDir() {
-			gitPath := filepath.Join(worktreePath, ".git")
-			if _, err := os.Stat(gitPath); err == nil {
-				return fmt.Errorf("'%s' already exists with a .git entry; choose a different name or remove it first", filepath.Base(worktreePath))
-			}
-			return fmt.Errorf("'%s' already exists but is not a git worktree; choose a different name or remove it first", filepath.Base(worktreePath))
-		}
-		return fmt.Errorf("'%s' already exists as a file; choose a different name or remove it first", filepath.Base(worktreePath))
+	// With --json, everything but the final result goes to stderr.
+	stdout := os.Stdout
+	if jsonOut {
+		os.Stdout = os.Stderr
+	}
+	keep, _ := cmd.Flags().GetStringArray("keep")
+	yes, _ := cmd.Flags().GetBool("yes")
+	err = removeNamed(name, args[1:], removeOptions{keep: keep, yes: yes || jsonOut, quiet: jsonOut})
+	os.Stdout = stdout
+	if err != nil || !jsonOut {
+		printWarningSummary()
+		return err
 	}
+	return printJSONResult(map[string]any{"removed": name})
+}
 
-	// Determine source directory for copying config files
-	projectDir, err := getCurrentWorktreeRoot()
+// removeOptions are the 'wt rm' flags for removeNamed.
+type removeOptions struct {
+	keep  []string // extra patterns of untracked files to back up
+	yes   bool     // delete untracked files without asking
+	quiet bool     // don't offer a shell after removing the current worktree
+}
+
+// removeNamed removes the worktree called name, passing gitArgs on to 'git
+// worktree remove'. It first reviews the untracked files that will go. When
+// the current directory is inside the worktree, it steps out first and,
+// unless quiet, offers a shell in the main repository.
+func removeNamed(name string, gitArgs []string, opts removeOptions) error {
+	worktreePath, err := resolveWorktreePath(name)
 	if err != nil {
-		projectDir, _ = os.Getwd()
+		return err
 	}
-
-	// Ensure relative paths for worktree links (devcontainer compatibility)
-	_ = exec.Command("git", "config", "worktree.useRelativePaths", "true").Run()
-
-	// Best-effort fetch from origin, if configured.
-	if err := exec.Command("git", "remote", "get-url", "origin").Run(); err == nil {
-		fetchCmd := exec.Command("git", "fetch", "origin")
-		fetchCmd.Stdout = os.Stdout
-		fetchCmd.Stderr = os.Stderr
-		if err := fetchCmd.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: git fetch origin failed: %v\n", err)
-		}
-	} else {
-		fmt.Fprintln(os.Stderr, "Warning: git remote 'origin' not configured; skipping fetch")
+	if err := checkRemovePolicy(name, gitArgs); err != nil {
+		return err
 	}
-
-	// Create worktree off current HEAD
-	gitCmd := exec.Command("git", "worktree", "add", "--detach", worktreePath, "HEAD")
-	gitCmd.Stdout = os.Stdout
-	gitCmd.Stderr = os.Stderr
-	if err := gitCmd.Run(); err != nil {
-		return fmt.Errorf("git worktree add failed: %w", err)
+	if err := reviewLeftovers(worktreePath, opts.keep, opts.yes); err != nil {
+		return err
 	}
-
-	// Copy all .env* files from root of project
-	envFiles, _ := filepath.Glob(filepath.Join(projectDir, ".env*"))
-	for _, src := range envFiles {
-		base := filepath.Base(src)
-		dst := filepath.Join(worktreePath, base)
-		if err := copyFile(src, dst); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to copy %s: %v\n", base, err)
-		}
+	if !isInsideDir(worktreePath) {
+		return removeWorktree(name, gitArgs)
 	}
 
-	fmt.Println(worktreePath)
-	return nil
-}
-
-func runList(cmd *cobra.Command, args []string) error {
+	// Removing the worktree we're standing in: step out of it first, and
+	// make sure the user's shell doesn't end up in a deleted directory.
 	mainRoot, err := getMainRepoRoot()
 	if err != nil {
 		return err
 	}
-	parentDir := filepath.Dir(mainRoot)
-	repoBasename := filepath.Base(mainRoot)
-
-	gitCmd := exec.Command("git", "worktree", "list", "--porcelain")
-	output, err := gitCmd.Output()
-	if err != nil {
-		return fmt.Errorf("git worktree list failed: %w", err)
+	if err := os.Chdir(mainRoot); err != nil {
+		return fmt.Errorf("failed to change to directory %q: %w", mainRoot, err)
 	}
-
-	for _, line := range strings.Split(string(output), "\n") {
-		if !strings.HasPrefix(line, "worktree ") {
-			continue
-		}
-		wtPath := strings.TrimPrefix(line, "worktree ")
-		if wtPath == mainRoot {
-			continue
-		}
-		if filepath.Dir(wtPath) != parentDir {
-			continue
+	if err := removeWorktree(name, gitArgs); err != nil {
+		return err
+	}
+	// Only now that git removed the worktree: a failed removal leaves the
+	// worktree and its container as they were.
+	warnf("", "removed the current worktree %s", name)
+	if _, err := exec.LookPath("docker"); err == nil {
+		if removed, err := removeDevcontainer(worktreePath); err != nil {
+			warnf("remove it by hand with 'docker rm -f', or run 'wt doctor --fix'", "%v", err)
+		} else if removed {
+			fmt.Fprintf(os.Stderr, "Removed devcontainer for %s\n", name)
 		}
-		name := parseWorktreeName(filepath.Base(wtPath), repoBasename)
-		if name != "" {
-			fmt.Println(name)
+	}
+
+	if recorded, err := recordCDTarget(mainRoot); err == nil && recorded {
+		// The shell-init wrapper moves the calling shell to the main repo.
+		return nil
+	}
+	if !opts.quiet && !nonInteractive && term.IsTerminal(int(os.Stdin.Fd())) {
+		ok, err := promptYesNo(fmt.Sprintf("Your shell's directory was removed. Open a shell in %s?", mainRoot), true)
+		if err == nil && ok {
+			// The shell replaces wt; report warnings first.
+			printWarningSummary()
+			return execShellInDir(mainRoot)
 		}
 	}
+	fmt.Fprintf(os.Stderr, "Your shell's directory was removed; run: cd %s\n", mainRoot)
 	return nil
 }
 
-func runRemove(cmd *cobra.Command, args []string) error {
-	name, err := resolveNameArg(args[0])
+// isInsideDir reports whether the current directory is dir or inside it.
+func isInsideDir(dir string) bool {
+	cwd, err := os.Getwd()
 	if err != nil {
-		return err
+		return false
 	}
+	rel, err := filepath.Rel(normalizePathForCompare(dir), normalizePathForCompare(cwd))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeWorktree removes the named worktree with 'git worktree remove',
+// passing gitArgs through, then deletes leftover files and wt state.
+func removeWorktree(name string, gitArgs []string) error {
 	worktreePath, err := resolveWorktreePath(name)
 	if err != nil {
 		return err
 	}
 
-	gitArgs := append([]string{"worktree", "remove", worktreePath}, args[1:]...)
-	gitCmd := exec.Command("git", gitArgs...)
+	gitCmd := exec.Command("git", append([]string{"worktree", "remove", worktreePath}, gitArgs...)...)
 	gitCmd.Stdout = os.Stdout
 	gitCmd.Stderr = os.Stderr
 	if err := gitCmd.Run(); err != nil {
@@ -732,9 +3501,12 @@ func runRemove(cmd *cobra.Command, args []string) error {
 	// Clean up any leftover files (e.g. .vscode-profile, untracked files)
 	if _, err := os.Stat(worktreePath); err == nil {
 		if err := os.RemoveAll(worktreePath); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", worktreePath, err)
+			warnf(fmt.Sprintf("delete it by hand: rm -rf %s", worktreePath), "failed to remove %s: %v", worktreePath, err)
 		}
 	}
+	if stateDir, err := worktreeStateDir(worktreePath); err == nil {
+		_ = os.RemoveAll(stateDir)
+	}
 	return nil
 }
 
@@ -746,8 +3518,19 @@ func resolveWorktreeDir(cmd *cobra.Command, args []string) (string, error) {
 		return getMainRepoRoot()
 	}
 
-	name, err := resolveNameArg(args[0])
-	if err != nil {
+	if repo, name, ok := strings.Cut(args[0], "/"); ok && repo != "" && repo != "." && repo != ".." {
+		return resolveGlobalWorktree(repo, name)
+	}
+
+	name := args[0]
+	if !create || name == "." {
+		// With -c, the name is taken literally so a new worktree is not
+		// mistaken for an existing one.
+		var err error
+		if name, err = resolveNameArg(name); err != nil {
+			return "", err
+		}
+	} else if err := validateWorktreeName(name); err != nil {
 		return "", err
 	}
 	dir, err := resolveWorktreePath(name)
@@ -757,14 +3540,17 @@ func resolveWorktreeDir(cmd *cobra.Command, args []string) (string, error) {
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if create {
-			if err := runAdd(cmd, args); err != nil {
+			if err := runAdd(cmd, []string{name}); err != nil {
 				return "", err
 			}
 		} else {
+			if nonInteractive {
+				return "", fmt.Errorf("worktree %q does not exist; pass -c to create it", name)
+			}
 			if !confirmCreate(name) {
 				return "", fmt.Errorf("aborted")
 			}
-			if err := runAdd(cmd, args); err != nil {
+			if err := runAdd(cmd, []string{name}); err != nil {
 				return "", err
 			}
 		}
@@ -778,23 +3564,58 @@ func runCD(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	recorded, err := recordCDTarget(dir)
+	if err != nil {
+		return err
+	}
+	if recorded {
+		noShell, _ := cmd.Flags().GetBool("no-shell")
+		if !noShell {
+			if cfg, err := loadConfig(); err == nil {
+				noShell = cfg.CD.NoShell
+			}
+		}
+		if noShell {
+			// The shell-init wrapper changes directory in the calling shell.
+			return nil
+		}
+	}
+	if nonInteractive {
+		fmt.Println(dir)
+		return nil
+	}
 	return execShellInDir(dir)
 }
 
 func runCode(cmd *cobra.Command, args []string) error {
+	var extra []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		args, extra = args[:dash], args[dash:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("accepts at most 1 worktree name, received %d", len(args))
+	}
+	if newWindow, _ := cmd.Flags().GetBool("new-window"); newWindow {
+		extra = append([]string{"--new-window"}, extra...)
+	}
+
 	dir, err := resolveWorktreeDir(cmd, args)
 	if err != nil {
 		return err
 	}
 
-	devcontainerJSON := filepath.Join(dir, ".devcontainer", "devcontainer.json")
-	if _, err := os.Stat(devcontainerJSON); err == nil {
-		if _, err := exec.LookPath("devcontainer"); err == nil {
-			return openDevcontainer(dir)
-		}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
-
-	return sysExec("code", []string{dir})
+	command, _ := cmd.Flags().GetString("editor")
+	attach, _ := cmd.Flags().GetString("attach")
+	ed, err := resolveEditor(cfg.Editor, command, attach, extra)
+	if err != nil {
+		return err
+	}
+	force, _ := cmd.Flags().GetBool("force")
+	return openInEditor(dir, ed, force)
 }
 
 func findChromeBinary() (string, error) {
@@ -830,6 +3651,19 @@ func runChrome(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create Chrome profile directory: %w", err)
 	}
 
+	// Keep downloads with the worktree instead of ~/Downloads.
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	downloadsDir, err := chromeDownloadsDir(dir, cfg.Chrome)
+	if err != nil {
+		return err
+	}
+	if err := setChromeDownloadDir(profileDir, downloadsDir); err != nil {
+		warnf("", "failed to set Chrome download directory: %v", err)
+	}
+
 	chromeArgs := []string{
 		"--user-data-dir=" + profileDir,
 		// Skip onboarding UI in fresh profiles.
@@ -839,13 +3673,13 @@ func runChrome(cmd *cobra.Command, args []string) error {
 		"--disable-features=ChromeSignin",
 	}
 
-	// Require a proxy port so all traffic is forced through it.
-	port, err := getProxyPort(dir)
+	// Require a working proxy so all traffic is forced through it.
+	proxy, err := requireLiveProxy(dir)
 	if err != nil {
 		return err
 	}
-	chromeArgs = append(chromeArgs, "--proxy-server=socks5://127.0.0.1:"+port)
-	// Proxy everything, including loopback targets, through SOCKS.
+	chromeArgs = append(chromeArgs, "--proxy-server="+proxy.proxyServer())
+	// Proxy everything, including loopback targets, through the proxy.
 	chromeArgs = append(chromeArgs, "--proxy-bypass-list=<-loopback>")
 
 	if len(extra) == 0 {
@@ -880,8 +3714,8 @@ func runPlaywright(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("could not find npx; install Node.js and Playwright")
 	}
 
-	// Require a proxy port so all traffic is forced through it.
-	port, err := getProxyPort(dir)
+	// Require a working proxy so all traffic is forced through it.
+	proxy, err := requireLiveProxy(dir)
 	if err != nil {
 		return err
 	}
@@ -896,7 +3730,7 @@ func runPlaywright(cmd *cobra.Command, args []string) error {
 	playwrightArgs := []string{
 		"playwright",
 		"open",
-		"--proxy-server=socks5://127.0.0.1:" + port,
+		"--proxy-server=" + proxy.proxyServer(),
 	}
 	playwrightArgs = append(playwrightArgs, extra...)
 
@@ -924,8 +3758,8 @@ func runCurl(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("could not find curl; install curl first")
 	}
 
-	// Require a proxy port so all traffic is forced through it.
-	port, err := getProxyPort(dir)
+	// Require a working proxy so all traffic is forced through it.
+	proxy, err := requireLiveProxy(dir)
 	if err != nil {
 		return err
 	}
@@ -935,7 +3769,7 @@ func runCurl(cmd *cobra.Command, args []string) error {
 	}
 
 	curlArgs := []string{
-		"--proxy", "socks5h://127.0.0.1:" + port,
+		"--proxy", proxy.curlProxy(),
 		"--noproxy", "",
 	}
 	curlArgs = append(curlArgs, extra...)
@@ -948,55 +3782,248 @@ func runCurl(cmd *cobra.Command, args []string) error {
 		}
 		fmt.Fprintf(os.Stderr, "Launching curl: %s %s\n", strconv.Quote(curlBin), strings.Join(quotedArgs, " "))
 	}
-	curlCmd.Stdout = os.Stdout
-	curlCmd.Stderr = os.Stderr
-	return curlCmd.Run()
-}
-
-func normalizeLocalhostURL(arg string) string {
-	parsed, err := url.Parse(arg)
-	if err != nil || parsed.Host == "" || parsed.Hostname() != "localhost" {
-		return arg
+	curlCmd.Stdout = os.Stdout
+	curlCmd.Stderr = os.Stderr
+	return curlCmd.Run()
+}
+
+func normalizeLocalhostURL(arg string) string {
+	parsed, err := url.Parse(arg)
+	if err != nil || parsed.Host == "" || parsed.Hostname() != "localhost" {
+		return arg
+	}
+	if parsed.Port() == "" {
+		parsed.Host = "127.0.0.1"
+	} else {
+		parsed.Host = net.JoinHostPort("127.0.0.1", parsed.Port())
+	}
+	return parsed.String()
+}
+
+func runExec(cmd *cobra.Command, args []string) error {
+	record, _ := cmd.Flags().GetBool("record")
+	dir, cmdArgs, err := resolveWorkspaceFolder(args)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := checkQuota(dir, cfg.Quota); err != nil {
+		return err
+	}
+	policy, err := runPolicyFromFlags(cmd, cfg.Exec.RunPolicyConfig)
+	if err != nil {
+		return err
+	}
+	logPath, err := execLogPath(cmd, dir, cfg.Exec)
+	if err != nil {
+		return err
+	}
+	if logPath != "" {
+		if record {
+			return fmt.Errorf("--log-file and --record cannot be combined")
+		}
+		if len(cmdArgs) == 0 {
+			return fmt.Errorf("--log-file needs a command to run")
+		}
+	}
+	if record && policy.active() {
+		return fmt.Errorf("--record cannot be combined with a timeout or retries")
+	}
+	if nonInteractive && len(cmdArgs) == 0 {
+		return fmt.Errorf("a command is required; interactive shells are disabled in non-interactive mode")
+	}
+	maxTime := cfg.Exec.MaxTime
+	if cmd.Flags().Changed("max-time") {
+		maxTime, _ = cmd.Flags().GetDuration("max-time")
+	}
+	if maxTime < 0 {
+		return fmt.Errorf("--max-time must not be negative")
 	}
-	if parsed.Port() == "" {
-		parsed.Host = "127.0.0.1"
-	} else {
-		parsed.Host = net.JoinHostPort("127.0.0.1", parsed.Port())
+	if len(cmdArgs) == 0 {
+		maxTime = 0
 	}
-	return parsed.String()
-}
-
-func runExec(cmd *cobra.Command, args []string) error {
-	dir, cmdArgs, err := resolveWorkspaceFolder(args)
+	// Credentials come first: the host's AWS_* may be withheld below.
+	scopedEnv, err := credentialEnv(dir, cfg.Credentials)
 	if err != nil {
 		return err
 	}
+	scopedEnv = append(scopedEnv, terraformEnv(dir, cfg.Terraform)...)
+	scopedEnv = append(scopedEnv, sharedServiceEnv(cfg.SharedServices)...)
+	applyExecEnv(cfg.Exec.Env)
+	if ciMode {
+		if record {
+			return fmt.Errorf("--record needs a terminal and cannot be used in CI mode")
+		}
+		if err := ciExecStdin(); err != nil {
+			return err
+		}
+	}
 	if len(cmdArgs) > 0 {
 		if err := detachStdinIfBackgroundTTY(); err != nil {
 			return err
 		}
 	}
-	devcontainerJSON := filepath.Join(dir, ".devcontainer", "devcontainer.json")
-	if _, err := os.Stat(devcontainerJSON); err == nil {
-		if err := requireDevcontainerCLI(); err != nil {
+	var identity *terminalIdentity
+	if len(cmdArgs) == 0 {
+		identity = newTerminalIdentity(cfg.Terminal, dir)
+	}
+	prompt := cfg.Exec.Prompt
+	if cmd.Flags().Changed("prompt") {
+		prompt, _ = cmd.Flags().GetBool("prompt")
+	}
+	promptEnv := worktreeNameEnv + "=" + filepath.Base(dir)
+	if service, _ := cmd.Flags().GetString("service"); service != "" {
+		containerID, err := serviceContainerID(dir, service)
+		if err != nil {
 			return err
 		}
+		dockerArgs := append(dockerExecArgs(record), "-e", startExecRecord(dir, service, cmdArgs))
+		cmdArgs = withMaxTime(maxTime, cmdArgs)
 		if len(cmdArgs) == 0 {
-			cmdArgs = []string{"/bin/sh", "-c", "command -v bash >/dev/null 2>&1 && exec bash || exec sh"}
+			cmdArgs = interactiveShellArgv(prompt)
+			dockerArgs = append(dockerArgs, "-e", promptEnv+"/"+service)
 		}
-		dcArgs := append([]string{"exec", "--workspace-folder", dir}, cmdArgs...)
+		for _, e := range scopedEnv {
+			dockerArgs = append(dockerArgs, "-e", e)
+		}
+		dockerArgs = append(append(dockerArgs, containerID), cmdArgs...)
 		os.Setenv("DOCKER_CLI_HINTS", "false")
-		return sysExec("devcontainer", dcArgs)
+		return runExecArgv(dir, append([]string{"docker"}, dockerArgs...), record, logPath, policy, identity)
+	}
+	dcConfig, err := readDevcontainerConfig(dir)
+	if err != nil {
+		return err
+	}
+	if dcConfig != nil {
+		if err := requireDevcontainerCLI(); err != nil {
+			// Without the devcontainer CLI a running container is still
+			// reachable through docker, as devcontainer.json's user and in
+			// its workspace folder.
+			containerID, cerr := getContainerID(dir)
+			if cerr != nil {
+				return err
+			}
+			dockerArgs := append(dockerExecArgs(record), "-w", dcConfig.remoteWorkspaceFolder(dir), "-e", startExecRecord(dir, "", cmdArgs))
+			cmdArgs = withMaxTime(maxTime, cmdArgs)
+			if user := dcConfig.user(); user != "" {
+				dockerArgs = append(dockerArgs, "-u", user)
+			}
+			for _, e := range append(append(hostServiceEnv(dir, cfg), execSessionEnv(dir, dcConfig)...), scopedEnv...) {
+				dockerArgs = append(dockerArgs, "-e", e)
+			}
+			if len(cmdArgs) == 0 {
+				cmdArgs = interactiveShellArgv(prompt)
+			}
+			dockerArgs = append(append(dockerArgs, containerID), cmdArgs...)
+			os.Setenv("DOCKER_CLI_HINTS", "false")
+			return runExecArgv(dir, append([]string{"docker"}, dockerArgs...), record, logPath, policy, identity)
+		}
+		// The devcontainer CLI runs commands as remoteUser itself.
+		dcArgs := []string{"exec", "--workspace-folder", dir, "--remote-env", startExecRecord(dir, "", cmdArgs)}
+		cmdArgs = withMaxTime(maxTime, cmdArgs)
+		if ciMode {
+			dcArgs = append(dcArgs, "--remote-env", "CI=true")
+		}
+		for _, e := range append(append(hostServiceEnv(dir, cfg), execSessionEnv(dir, dcConfig)...), scopedEnv...) {
+			dcArgs = append(dcArgs, "--remote-env", e)
+		}
+		if len(cmdArgs) == 0 {
+			cmdArgs = interactiveShellArgv(prompt)
+		}
+		dcArgs = append(dcArgs, cmdArgs...)
+		os.Setenv("DOCKER_CLI_HINTS", "false")
+		return runExecArgv(dir, append([]string{"devcontainer"}, dcArgs...), record, logPath, policy, identity)
 	}
 
 	// No devcontainer config — run the command directly in the worktree
+	if maxTime > 0 {
+		return fmt.Errorf("--max-time is enforced inside a devcontainer and %s has none; use --timeout", filepath.Base(dir))
+	}
+	for _, e := range scopedEnv {
+		k, v, _ := strings.Cut(e, "=")
+		os.Setenv(k, v)
+	}
+	if cfg.Credentials.Kube.enabled() {
+		stateDir, err := worktreeStateDir(dir)
+		if err != nil {
+			return err
+		}
+		os.Setenv("KUBECONFIG", filepath.Join(stateDir, kubeconfigFile))
+	}
 	if len(cmdArgs) == 0 {
-		return execShellInDir(dir)
+		if record {
+			cmdArgs = []string{getParentShell()}
+		} else {
+			return execShellInDir(dir)
+		}
 	}
 	if err := os.Chdir(dir); err != nil {
 		return fmt.Errorf("failed to change to directory %q: %w", dir, err)
 	}
-	return sysExec(cmdArgs[0], cmdArgs[1:])
+	if ciMode {
+		os.Setenv("CI", "true")
+	}
+	return runExecArgv(dir, cmdArgs, record, logPath, policy, identity)
+}
+
+// dockerExecArgs starts a 'docker exec' argument list for 'wt exec',
+// allocating a TTY when recording or attached to a terminal.
+func dockerExecArgs(record bool) []string {
+	args := []string{"exec", "-i"}
+	if record || (!ciMode && term.IsTerminal(int(os.Stdin.Fd()))) {
+		args = append(args, "-t")
+	}
+	if ciMode {
+		args = append(args, "-e", "CI=true")
+	}
+	return args
+}
+
+// runExecArgv runs argv for 'wt exec': recorded, logged, supervised by a
+// timeout/retry policy, or by replacing wt. A non-nil identity labels the
+// terminal for the duration of an interactive shell.
+func runExecArgv(dir string, argv []string, record bool, logPath string, policy runPolicy, identity *terminalIdentity) error {
+	if printCmd {
+		printCommand(argv)
+		return nil
+	}
+	if identity != nil {
+		if !record && logPath == "" && !policy.active() {
+			return runTerminalSession(identity, argv)
+		}
+		identity.set()
+		defer identity.clear()
+	}
+	if record {
+		return runRecorded(dir, argv)
+	}
+	if logPath != "" {
+		return runLogged(argv, logPath, policy)
+	}
+	if policy.active() {
+		return runWithPolicy(argv, policy, nil, nil)
+	}
+	return sysExec(argv[0], argv[1:])
+}
+
+// execLogPath returns where 'wt exec' should log output: the --log-file
+// path, a new file in the worktree's logs directory for a bare --log-file or
+// exec.log in .wt.yaml, or "" to not log.
+func execLogPath(cmd *cobra.Command, dir string, cfg ExecConfig) (string, error) {
+	if cmd.Flags().Changed("log-file") {
+		path, _ := cmd.Flags().GetString("log-file")
+		if path != execLogFlagDefault {
+			return filepath.Abs(path)
+		}
+		return newExecLogPath(dir)
+	}
+	if cfg.Log {
+		return newExecLogPath(dir)
+	}
+	return "", nil
 }
 
 // resolveExecArgs splits args into (worktreeName, commandArgs).
@@ -1036,8 +4063,196 @@ func runUp(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	dcArgs := append([]string{"up", "--workspace-folder", dir}, extra...)
-	return sysExec("devcontainer", dcArgs)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	force, _ := cmd.Flags().GetBool("force")
+	policy, err := runPolicyFromFlags(cmd, cfg.Up)
+	if err != nil {
+		return err
+	}
+	if !hasEnvTemplates(dir) && !hasHostOverrides(dir) && !policy.active() && len(cfg.Cache.Services) == 0 && len(cfg.HostServices.Services) == 0 && len(cfg.SharedServices) == 0 && !cfg.ShipWt.Enabled {
+		// Nothing to do once the container is up: wt can hand over to
+		// 'devcontainer up'.
+		mounts, err := upPreflight(dir, cfg, force)
+		if err != nil {
+			return err
+		}
+		dcArgs := append(append([]string{"up", "--workspace-folder", dir}, mounts...), extra...)
+		if printCmd {
+			printCommand(append([]string{"devcontainer"}, dcArgs...))
+			return nil
+		}
+		recordDevcontainerUp(dir, extra)
+		return sysExec("devcontainer", dcArgs)
+	}
+	return devcontainerUp(dir, extra, policy, force)
+}
+
+// upPreflight runs the checks and preparation that every start of the
+// devcontainer for dir goes through: the offline image, quota, image policy
+// (which force overrides), and port checks, and rendering the env
+// templates. It returns the share, kube, and artifacts mounts to pass to
+// 'devcontainer up'.
+func upPreflight(dir string, cfg *Config, force bool) ([]string, error) {
+	if isOffline(cfg) {
+		offline = true
+		if err := checkOfflineImage(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkQuota(dir, cfg.Quota); err != nil {
+		return nil, err
+	}
+	if err := checkImagePolicy(dir, cfg.Policy, force); err != nil {
+		return nil, err
+	}
+	if err := checkContainerPortConflicts(dir); err != nil {
+		return nil, err
+	}
+	if !printCmd {
+		rendered, err := renderEnvTemplates(dir, cfg.Env)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkEnvPortConflicts(dir, rendered); err != nil {
+			return nil, err
+		}
+	}
+	mounts, err := shareMountArgs(dir, cfg.Share)
+	if err != nil {
+		return nil, err
+	}
+	kubeMounts, err := kubeMountArgs(dir, cfg.Credentials.Kube)
+	if err != nil {
+		return nil, err
+	}
+	mounts = append(mounts, kubeMounts...)
+	artifactMounts, err := artifactsMountArgs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return append(mounts, artifactMounts...), nil
+}
+
+// devcontainerUp starts the devcontainer for dir as a child process and
+// returns once 'devcontainer up' completes. Env templates are rendered before
+// starting so the container sees the generated env files, then again
+// afterwards once the proxy port is known. force overrides the image policy.
+func devcontainerUp(dir string, extra []string, policy runPolicy, force bool) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	mounts, err := upPreflight(dir, cfg, force)
+	if err != nil {
+		return err
+	}
+	dcArgs := append([]string{"up", "--workspace-folder", dir}, mounts...)
+	useCache := len(cfg.Cache.Services) > 0
+	useServices := len(cfg.SharedServices) > 0
+	lifecycleEnv := append(cfg.Cache.cacheEnv(), sharedServiceEnv(cfg.SharedServices)...)
+	userArgs := []string{"run-user-commands", "--workspace-folder", dir}
+	for _, e := range lifecycleEnv {
+		userArgs = append(userArgs, "--remote-env", e)
+	}
+	if useCache && !printCmd {
+		if err := ensureCaches(cfg.Cache); err != nil {
+			return err
+		}
+	}
+	if useServices && !printCmd {
+		if err := ensureSharedServices(cfg.SharedServices); err != nil {
+			return err
+		}
+	}
+	if useCache || useServices {
+		// Lifecycle commands run after the container joins the cache and
+		// services networks.
+		dcArgs = append(dcArgs, "--skip-post-create")
+		for _, e := range lifecycleEnv {
+			dcArgs = append(dcArgs, "--remote-env", e)
+		}
+	}
+	dcArgs = append(dcArgs, extra...)
+	if printCmd {
+		printCommand(append([]string{"devcontainer"}, dcArgs...))
+		if useCache || useServices {
+			// Between the two, wt connects the container to the cache and
+			// services networks.
+			printCommand(append([]string{"devcontainer"}, userArgs...))
+		}
+		return nil
+	}
+	recordDevcontainerUp(dir, extra)
+	if policy.active() {
+		if err := runWithPolicy(append([]string{"devcontainer"}, dcArgs...), policy, nil, nil); err != nil {
+			return err
+		}
+	} else {
+		upCmd := exec.Command("devcontainer", dcArgs...)
+		upCmd.Stdout = os.Stdout
+		upCmd.Stderr = os.Stderr
+		if err := upCmd.Run(); err != nil {
+			return fmt.Errorf("devcontainer up failed: %w", err)
+		}
+	}
+	if useCache {
+		if err := attachCaches(dir, cfg.Cache); err != nil {
+			return err
+		}
+	}
+	if useServices {
+		if err := attachSharedServices(dir); err != nil {
+			return err
+		}
+	}
+	if useCache || useServices {
+		userCmd := exec.Command("devcontainer", userArgs...)
+		userCmd.Stdout = os.Stdout
+		userCmd.Stderr = os.Stderr
+		if err := userCmd.Run(); err != nil {
+			return fmt.Errorf("devcontainer lifecycle commands failed: %w", err)
+		}
+	}
+	if hasHostOverrides(dir) {
+		if err := applyHostOverrides(dir); err != nil {
+			warnf("", "%v", err)
+		}
+	}
+	if len(cfg.HostServices.Services) > 0 {
+		if err := applyHostServices(dir, cfg); err != nil {
+			warnf("", "%v", err)
+		}
+	}
+	if cfg.ShipWt.Enabled {
+		if err := shipWt(dir, cfg.ShipWt); err != nil {
+			warnf("", "wt is not available in the container: %v", err)
+		}
+	}
+	_, err = renderEnvTemplates(dir, cfg.Env)
+	return err
+}
+
+// waitForProxy waits until the devcontainer's SOCKS5 proxy answers.
+// Containers without a mapped proxy port are considered ready.
+func waitForProxy(dir string, timeout time.Duration) error {
+	proxy, err := routingProxy(dir)
+	if err != nil {
+		return nil
+	}
+	addr := net.JoinHostPort("127.0.0.1", proxy.hostPort)
+	deadline := time.Now().Add(timeout)
+	for {
+		if probeProxy(proxy, time.Second) == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("SOCKS5 proxy for %s did not accept connections on %s within %s", filepath.Base(dir), addr, timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
 }
 
 func runDown(cmd *cobra.Command, args []string) error {
@@ -1046,14 +4261,27 @@ func runDown(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	removed, err := removeDevcontainer(dir)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("no devcontainer found for %q", filepath.Base(dir))
+	}
+	return nil
+}
+
+// removeDevcontainer force-removes the devcontainer for dir, if one exists,
+// and reports whether it found one.
+func removeDevcontainer(dir string) (bool, error) {
 	// Find the container by devcontainer label
 	out, err := exec.Command("docker", "ps", "-aq", "--filter", "label=devcontainer.local_folder="+dir).Output()
 	if err != nil {
-		return fmt.Errorf("failed to query docker: %w", err)
+		return false, fmt.Errorf("failed to query docker: %w", err)
 	}
 	containerID := strings.TrimSpace(strings.Split(string(out), "\n")[0])
 	if containerID == "" {
-		return fmt.Errorf("no devcontainer found for %q", filepath.Base(dir))
+		return false, nil
 	}
 
 	if verbose {
@@ -1062,7 +4290,7 @@ func runDown(cmd *cobra.Command, args []string) error {
 	rmCmd := exec.Command("docker", "rm", "-f", containerID)
 	rmCmd.Stdout = os.Stdout
 	rmCmd.Stderr = os.Stderr
-	return rmCmd.Run()
+	return true, rmCmd.Run()
 }
 
 func runBuild(cmd *cobra.Command, args []string) error {
@@ -1074,6 +4302,31 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	dcArgs := append([]string{"build", "--workspace-folder", dir}, extra...)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if isOffline(cfg) {
+		offline = true
+		if err := checkOfflineImage(dir); err != nil {
+			return err
+		}
+	}
+	force, _ := cmd.Flags().GetBool("force")
+	if err := checkImagePolicy(dir, cfg.Policy, force); err != nil {
+		return err
+	}
+	policy, err := runPolicyFromFlags(cmd, cfg.Build)
+	if err != nil {
+		return err
+	}
+	if printCmd {
+		printCommand(append([]string{"devcontainer"}, dcArgs...))
+		return nil
+	}
+	if policy.active() {
+		return runWithPolicy(append([]string{"devcontainer"}, dcArgs...), policy, nil, nil)
+	}
 	return sysExec("devcontainer", dcArgs)
 }
 
@@ -1149,10 +4402,22 @@ func resolveWorkspaceFolder(args []string) (string, []string, error) {
 		return dir, args[1:], nil
 	}
 
-	if dir, ok, err := resolveSiblingNameArg(args[0]); err != nil {
+	// Only match names loosely when the argument can't be a command: it is
+	// followed by "--", or it is the sole argument and not an executable.
+	fuzzy := len(args) > 1 && args[1] == "--"
+	if len(args) == 1 {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			fuzzy = true
+		}
+	}
+	if dir, ok, err := resolveSiblingNameArg(args[0], fuzzy); err != nil {
 		return "", nil, err
 	} else if ok {
-		return dir, args[1:], nil
+		rest := args[1:]
+		if len(rest) > 0 && rest[0] == "--" {
+			rest = rest[1:]
+		}
+		return dir, rest, nil
 	}
 
 	if currentErr != nil {
@@ -1178,10 +4443,17 @@ func resolveWorktreePathArg(arg string) (string, bool, error) {
 	return "", false, nil
 }
 
-func resolveSiblingNameArg(arg string) (string, bool, error) {
+func resolveSiblingNameArg(arg string, fuzzy bool) (string, bool, error) {
 	if err := validateWorktreeName(arg); err != nil {
 		return "", false, nil
 	}
+	if fuzzy {
+		name, err := matchWorktreeName(arg)
+		if err != nil {
+			return "", false, err
+		}
+		arg = name
+	}
 	dir, err := resolveWorktreePath(arg)
 	if err != nil {
 		return "", false, err
@@ -1280,49 +4552,23 @@ func setupVSCodeProfile(userDataDir string) {
 	}
 }
 
-func openDevcontainer(dir string) error {
-	if err := requireDevcontainerCLI(); err != nil {
+func openDevcontainer(dir string, e editor, force bool) error {
+	folderURI, err := devcontainerFolderURI(dir, force)
+	if err != nil {
 		return err
 	}
-	// Start the devcontainer, streaming output while capturing it for JSON parsing
-	var buf bytes.Buffer
-	upCmd := exec.Command("devcontainer", "up", "--workspace-folder", dir)
-	upCmd.Stdout = io.MultiWriter(os.Stdout, &buf)
-	upCmd.Stderr = os.Stderr
-	if err := upCmd.Run(); err != nil {
-		return fmt.Errorf("devcontainer up failed: %w", err)
-	}
-	out := buf.Bytes()
-
-	// devcontainer up may mix progress text with JSON on stdout;
-	// find the last line that looks like a JSON object
-	var jsonLine []byte
-	for _, line := range strings.Split(string(out), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "{") {
-			jsonLine = []byte(trimmed)
-		}
-	}
-	if jsonLine == nil {
-		return fmt.Errorf("devcontainer up produced no JSON output")
-	}
-
-	var result struct {
-		ContainerID           string `json:"containerId"`
-		RemoteWorkspaceFolder string `json:"remoteWorkspaceFolder"`
-	}
-	if err := json.Unmarshal(jsonLine, &result); err != nil {
-		return fmt.Errorf("failed to parse devcontainer up output: %w", err)
-	}
 
 	// Build VS Code arguments
-	hexID := hex.EncodeToString([]byte(result.ContainerID))
-	folderURI := fmt.Sprintf("vscode-remote://attached-container+%s%s", hexID, result.RemoteWorkspaceFolder)
-
 	codeArgs := []string{
 		"--folder-uri", folderURI,
 	}
 
+	// The shared extensions and per-worktree profile below are laid out for
+	// VS Code itself; forks keep their data elsewhere.
+	if e.name() != defaultEditor {
+		return e.exec(codeArgs...)
+	}
+
 	// Share extensions from default VS Code installation
 	defaultExtDir := defaultVSCodeExtensionsDir()
 	if defaultExtDir != "" {
@@ -1331,23 +4577,73 @@ func openDevcontainer(dir string) error {
 		}
 	}
 
-	// If the devcontainer has a SOCKS proxy, use a per-worktree VS Code profile
+	// If the devcontainer has a proxy, use a per-worktree VS Code profile
 	// and route VS Code traffic through it.
-	port, err := getProxyPort(dir)
-	if err == nil {
-		userDataDir := filepath.Join(dir, ".vscode-profile")
-		setupVSCodeProfile(userDataDir)
+	userDataDir := defaultVSCodeUserDataDir()
+	if proxy, err := routingProxy(dir); err == nil {
+		userDataDir = filepath.Join(dir, ".vscode-profile")
+		if !printCmd {
+			setupVSCodeProfile(userDataDir)
+		}
 		codeArgs = append(codeArgs,
 			"--user-data-dir", userDataDir,
-			"--proxy-server=socks5://127.0.0.1:"+port,
+			"--proxy-server="+proxy.proxyServer(),
 		)
 	}
+	if userDataDir != "" && !printCmd {
+		if err := writeAttachedContainerConfig(userDataDir, dir, e.extensions); err != nil {
+			warnf("", "could not configure extensions for the container: %v", err)
+		}
+	}
 
-	return sysExec("code", codeArgs)
+	return e.exec(codeArgs...)
+}
+
+// devcontainerFolderURI starts the devcontainer for dir, unless it is
+// running already, and returns the vscode-remote URI that attaches VS Code
+// to it. force overrides the image policy.
+func devcontainerFolderURI(dir string, force bool) (string, error) {
+	if err := requireDevcontainerCLI(); err != nil {
+		return "", err
+	}
+	if _, err := getContainerID(dir); err != nil {
+		cfg, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		if err := devcontainerUp(dir, nil, cfg.Up.policy(), force); err != nil {
+			return "", err
+		}
+	}
+	return attachedFolderURI(dir)
+}
+
+// attachedFolderURI returns the vscode-remote URI of the running devcontainer
+// for dir. With --print-cmd, a container that is not running yet gets a
+// placeholder for the id that 'devcontainer up' would report.
+func attachedFolderURI(dir string) (string, error) {
+	dc, err := readDevcontainerConfig(dir)
+	if err != nil {
+		return "", err
+	}
+	folder := "/workspaces/" + filepath.Base(dir)
+	if dc != nil {
+		folder = dc.remoteWorkspaceFolder(dir)
+	}
+	id, err := getContainerID(dir)
+	if err != nil {
+		if !printCmd {
+			return "", err
+		}
+		return fmt.Sprintf("vscode-remote://attached-container+<hex-container-id>%s", folder), nil
+	}
+	if out, err := exec.Command("docker", "inspect", "--format", "{{.Id}}", id).Output(); err == nil {
+		id = strings.TrimSpace(string(out))
+	}
+	hexID := hex.EncodeToString([]byte(id))
+	return fmt.Sprintf("vscode-remote://attached-container+%s%s", hexID, folder), nil
 }
 
-// getProxyPort discovers the host port mapped to the SOCKS5 proxy (container port 1080)
-// by inspecting the running devcontainer for the given workspace directory.
 func getContainerID(dir string) (string, error) {
 	out, err := exec.Command("docker", "ps", "-q", "--filter", "label=devcontainer.local_folder="+dir).Output()
 	if err != nil {
@@ -1360,23 +4656,14 @@ func getContainerID(dir string) (string, error) {
 	return containerID, nil
 }
 
+// getProxyPort discovers the host port mapped to the SOCKS5 proxy by
+// inspecting the running devcontainer for the given workspace directory.
 func getProxyPort(dir string) (string, error) {
-	containerID, err := getContainerID(dir)
+	p, err := getProxy(dir, proxySOCKS5)
 	if err != nil {
 		return "", err
 	}
-
-	out, err := exec.Command("docker", "port", containerID, "1080").Output()
-	if err != nil {
-		return "", fmt.Errorf("no proxy port mapped for devcontainer %q", filepath.Base(dir))
-	}
-	// Output format: "0.0.0.0:32768\n[::]:32768\n" — take the first line
-	addr := strings.TrimSpace(strings.Split(string(out), "\n")[0])
-	_, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse port from %q: %w", addr, err)
-	}
-	return port, nil
+	return p.hostPort, nil
 }
 
 // getDefaultURL inspects the running devcontainer's metadata for port labels.
@@ -1448,6 +4735,9 @@ func validateWorktreeName(name string) error {
 }
 
 func confirmCreate(name string) bool {
+	if nonInteractive {
+		return false
+	}
 	fmt.Printf("Worktree '%s' doesn't exist. Create it now? [y/N] ", name)
 	reader := bufio.NewReader(os.Stdin)
 	reply, _ := reader.ReadString('\n')
@@ -1463,6 +4753,35 @@ func copyFile(src, dst string) error {
 	return os.WriteFile(dst, data, 0644)
 }
 
+// copyEnvFile copies a dotenv file, dropping or redacting keys that the env
+// config does not allow and, unless vars is nil, substituting the __WT_*__
+// placeholders for the new worktree. It warns when the copied content looks
+// like it carries credentials.
+func copyEnvFile(src, dst string, cfg EnvConfig, vars *envTemplateData) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	data, withheld := cfg.filterEnv(data)
+	if vars != nil {
+		data = []byte(vars.placeholders().Replace(string(data)))
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		return err
+	}
+	base := filepath.Base(src)
+	if len(withheld) > 0 && verbose {
+		fmt.Fprintf(os.Stderr, "Withheld from %s: %s\n", base, strings.Join(withheld, ", "))
+	}
+	if keys := credentialKeys(data); len(keys) > 0 {
+		warnf(fmt.Sprintf("restrict it with env.allow/env.deny in %s", projectConfigFile), "%s looks like it contains credentials (%s)", base, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
 func installSkillFile(name, content string, force bool) ([]skillInstallResult, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -1615,9 +4934,21 @@ func requireDevcontainerCLI() error {
 }
 
 func execShellInDir(dir string) error {
+	if nonInteractive {
+		return fmt.Errorf("not opening a shell in %s in non-interactive mode", dir)
+	}
 	shell := getParentShell()
 	if err := os.Chdir(dir); err != nil {
 		return fmt.Errorf("failed to change to directory %q: %w", dir, err)
 	}
+	if printCmd {
+		printCommand([]string{shell})
+		return nil
+	}
+	if cfg, err := loadConfig(); err == nil {
+		if identity := newTerminalIdentity(cfg.Terminal, dir); identity != nil {
+			return runTerminalSession(identity, []string{shell})
+		}
+	}
 	return sysExec(shell, nil)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// maxTimeExitCode is the exit status of a 'wt exec --max-time' command that
+// ran out of time: 128+SIGXCPU, as for a process killed for exceeding a
+// resource limit. It differs from the 124 of --timeout, which is enforced on
+// the host.
+const maxTimeExitCode = 152
+
+// maxTimeScript runs "$@" in the container under a watchdog. When the limit
+// (in seconds) passes, the watchdog sends TERM, then KILL five seconds later,
+// to every process carrying the exec's WT_EXEC_ID, which catches children
+// that started their own process group or session. The watchdog itself runs
+// without the marker, and exits as soon as the command finishes. The exit
+// status on expiry is maxTimeExitCode.
+const maxTimeScript = `limit=$1; shift
+flag=/tmp/.wt-max-time-$` + execIDEnv + `
+rm -f "$flag"
+env -u ` + execIDEnv + ` sh -c '
+  end=$(( $(date +%s) + $1 ))
+  while [ "$(date +%s)" -lt "$end" ]; do
+    sleep 1
+    kill -0 "$2" 2>/dev/null || exit 0
+  done
+  : > "$4"
+  for sig in TERM KILL; do
+    for d in /proc/[0-9]*; do
+      pid=${d#/proc/}
+      [ "$pid" = "$2" ] && continue
+      tr "\0" "\n" 2>/dev/null < "$d/environ" | grep -qx "` + execIDEnv + `=$3" && kill -s $sig "$pid" 2>/dev/null
+    done
+    [ $sig = TERM ] && sleep 5
+  done
+' wt-watchdog "$limit" $$ "$` + execIDEnv + `" "$flag" &
+"$@"
+status=$?
+if [ -e "$flag" ]; then
+  rm -f "$flag"
+  echo "wt: command exceeded --max-time of ${limit}s" >&2
+  exit 152
+fi
+exit $status`
+
+// withMaxTime wraps argv in maxTimeScript when limit is set. argv must run
+// with execIDEnv in its environment.
+func withMaxTime(limit time.Duration, argv []string) []string {
+	if limit <= 0 {
+		return argv
+	}
+	secs := int((limit + time.Second - 1) / time.Second)
+	return append([]string{"sh", "-c", maxTimeScript, "wt-max-time", strconv.Itoa(secs)}, argv...)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultNamePattern is used for generated worktree names when add.namePattern
+// is not configured.
+const defaultNamePattern = "{adjective}-{noun}"
+
+var nameAdjectives = []string{
+	"amber", "bold", "brisk", "calm", "clever", "cosmic", "crisp", "dapper",
+	"eager", "fancy", "gentle", "glad", "golden", "happy", "humble", "jolly",
+	"keen", "lively", "lucky", "mellow", "merry", "misty", "nimble", "noble",
+	"plucky", "proud", "quick", "quiet", "rapid", "rosy", "shiny", "silent",
+	"snappy", "spry", "steady", "sunny", "swift", "tidy", "vivid", "witty",
+}
+
+var nameNouns = []string{
+	"badger", "beacon", "birch", "brook", "canyon", "cedar", "comet", "coral",
+	"falcon", "fern", "finch", "fjord", "glacier", "harbor", "heron", "island",
+	"lark", "lynx", "maple", "meadow", "otter", "owl", "panda", "pebble",
+	"pine", "puffin", "quartz", "raven", "reef", "river", "robin", "sparrow",
+	"spruce", "summit", "tiger", "tulip", "valley", "walrus", "willow", "zebra",
+}
+
+// expandNamePattern replaces the placeholders {adjective}, {noun}, {date}
+// (YYYYMMDD), {time} (HHMMSS), and {rand} (4 hex digits) in pattern.
+func expandNamePattern(pattern string, now time.Time) string {
+	r := strings.NewReplacer(
+		"{adjective}", nameAdjectives[rand.IntN(len(nameAdjectives))],
+		"{noun}", nameNouns[rand.IntN(len(nameNouns))],
+		"{date}", now.Format("20060102"),
+		"{time}", now.Format("150405"),
+		"{rand}", fmt.Sprintf("%04x", rand.IntN(0x10000)),
+	)
+	return r.Replace(pattern)
+}
+
+// generateWorktreeName returns a valid name from pattern that no existing
+// sibling directory or 'wt reserve' reservation uses and that follows the
+// naming convention. Patterns
+// that keep colliding get a numeric suffix.
+func generateWorktreeName(pattern string, naming NamingConfig) (string, error) {
+	if pattern == "" {
+		pattern = defaultNamePattern
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	taken := func(name string) bool {
+		dir, err := resolveWorktreePath(name)
+		if err != nil {
+			return true
+		}
+		_, err = os.Stat(dir)
+		return err == nil || nameReserved(mainRoot, name)
+	}
+	now := time.Now()
+	var name string
+	for attempt := 0; attempt < 20; attempt++ {
+		name = expandNamePattern(pattern, now)
+		if err := validateWorktreeName(name); err != nil {
+			return "", fmt.Errorf("add.namePattern %q produces an invalid name: %w", pattern, err)
+		}
+		if !naming.matches(name) {
+			err := fmt.Errorf("add.namePattern %q produces names like %q, which don't follow the naming convention: %s", pattern, name, naming.describe())
+			if len(naming.Prefixes) > 0 {
+				err = fmt.Errorf("%w; set add.namePattern to e.g. %q", err, naming.Prefixes[0]+pattern)
+			}
+			return "", err
+		}
+		if !taken(name) {
+			return name, nil
+		}
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if !taken(candidate) {
+			return candidate, nil
+		}
+		if i > 1000 {
+			return "", fmt.Errorf("could not find a free name for pattern %q", pattern)
+		}
+	}
+}
+
+// enabled reports whether the repository declares a naming convention.
+func (c NamingConfig) enabled() bool {
+	return len(c.Prefixes) > 0 || c.Pattern != ""
+}
+
+// matches reports whether name follows the convention. A prefix alone is
+// not a name.
+func (c NamingConfig) matches(name string) bool {
+	if len(c.Prefixes) > 0 {
+		ok := false
+		for _, p := range c.Prefixes {
+			if strings.HasPrefix(name, p) && len(name) > len(p) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if c.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + c.Pattern + ")$")
+		if err != nil || !re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// describe returns the convention in words for error messages.
+func (c NamingConfig) describe() string {
+	var rules []string
+	switch n := len(c.Prefixes); n {
+	case 0:
+	case 1:
+		rules = append(rules, "start with "+c.Prefixes[0])
+	default:
+		rules = append(rules, "start with "+strings.Join(c.Prefixes[:n-1], ", ")+" or "+c.Prefixes[n-1])
+	}
+	if c.Pattern != "" {
+		rules = append(rules, fmt.Sprintf("match %q", c.Pattern))
+	}
+	return "names must " + strings.Join(rules, " and ")
+}
+
+// suggest returns up to three names close to name that follow the
+// convention: with a mistyped prefix (feature-login) replaced by the
+// allowed one (feat-login), or else with each prefix added, in lowercase
+// with other characters turned into dashes if the pattern needs it.
+func (c NamingConfig) suggest(name string) []string {
+	candidates := []string{name}
+	if len(c.Prefixes) > 0 {
+		candidates = nil
+		if i := strings.IndexAny(name, "-_."); i > 0 {
+			head, rest := strings.ToLower(name[:i]), name[i+1:]
+			for _, p := range c.Prefixes {
+				word := strings.ToLower(strings.TrimRight(p, "-_."))
+				if word != "" && (strings.HasPrefix(head, word) || strings.HasPrefix(word, head)) {
+					candidates = append(candidates, p+rest)
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			for _, p := range c.Prefixes {
+				candidates = append(candidates, p+name)
+			}
+		}
+	}
+	var suggestions []string
+	for _, candidate := range candidates {
+		if !c.matches(candidate) {
+			candidate = nameSlug(candidate)
+		}
+		if c.matches(candidate) && validateWorktreeName(candidate) == nil && !slices.Contains(suggestions, candidate) {
+			suggestions = append(suggestions, candidate)
+		}
+		if len(suggestions) == 3 {
+			break
+		}
+	}
+	return suggestions
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// nameSlug lowercases name and turns runs of other characters than letters,
+// digits, and dashes into a dash.
+func nameSlug(name string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// checkNewWorktreeName validates the name of a worktree about to be created,
+// including the repository's naming convention.
+func checkNewWorktreeName(name string, naming NamingConfig) error {
+	if err := validateWorktreeName(name); err != nil {
+		return err
+	}
+	if !naming.enabled() || naming.matches(name) {
+		return nil
+	}
+	msg := fmt.Sprintf("worktree name %q does not follow the naming convention in %s: %s", name, projectConfigFile, naming.describe())
+	if suggestions := naming.suggest(name); len(suggestions) > 0 {
+		msg += "; try " + strings.Join(suggestions, " or ")
+	}
+	return errors.New(msg)
+}
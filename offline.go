@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// offline is set by --offline or $WT_OFFLINE; offline: true in .wt.yaml turns
+// it on for a repository. wt then never fetches from origin or pulls images.
+var offline bool
+
+const offlineEnv = "WT_OFFLINE"
+
+// configureOffline falls back to $WT_OFFLINE when --offline is not given.
+func configureOffline(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("offline") {
+		offline = envTrue(offlineEnv)
+	}
+}
+
+// isOffline reports whether wt must avoid the network for the repository
+// configured by cfg.
+func isOffline(cfg *Config) bool {
+	return offline || (cfg != nil && cfg.Offline)
+}
+
+// errOffline reports an operation that needs the network.
+func errOffline(what string) error {
+	return fmt.Errorf("%s needs network access, but wt is offline (--offline, $%s, or offline in %s)", what, offlineEnv, projectConfigFile)
+}
+
+// checkOfflineImage fails early when the worktree's devcontainer would have
+// to pull its image. A container that already exists is restarted without
+// pulling; Dockerfile and compose builds reuse local base images.
+func checkOfflineImage(dir string) error {
+	if out, err := exec.Command("docker", "ps", "-aq", "--filter", "label=devcontainer.local_folder="+dir).Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+		return nil
+	}
+	dc, err := readDevcontainerConfig(dir)
+	if err != nil || dc == nil || dc.Image == "" {
+		return err
+	}
+	if exec.Command("docker", "image", "inspect", dc.Image).Run() != nil {
+		return errors.Join(errOffline("pulling "+dc.Image), fmt.Errorf("pull the image while online: docker pull %s", dc.Image))
+	}
+	if len(dc.Features) > 0 {
+		warnf("", "devcontainer features may be downloaded while building the image and fail offline")
+	}
+	return nil
+}
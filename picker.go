@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// pickerRows is how many items the picker shows at once.
+const pickerRows = 12
+
+// fuzzyScore reports whether the characters of query appear in item in
+// order, ignoring case, and scores the match: lower is better, favoring
+// matches that are contiguous and start early.
+func fuzzyScore(query, item string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	q := []rune(strings.ToLower(query))
+	s := []rune(strings.ToLower(item))
+	if i := strings.Index(string(s), string(q)); i >= 0 {
+		return i, true
+	}
+	start, qi := -1, 0
+	for i, r := range s {
+		if r != q[qi] {
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		qi++
+		if qi == len(q) {
+			// Scattered matches rank after every substring match.
+			return len(s) + (i - start), true
+		}
+	}
+	return 0, false
+}
+
+// fuzzyFilter returns the indexes of the items matching query, best first.
+func fuzzyFilter(query string, items []string) []int {
+	type match struct{ index, score int }
+	var matches []match
+	for i, item := range items {
+		if score, ok := fuzzyScore(query, item); ok {
+			matches = append(matches, match{i, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score < matches[b].score })
+	indexes := make([]int, len(matches))
+	for i, m := range matches {
+		indexes[i] = m.index
+	}
+	return indexes
+}
+
+// promptPick lets the user pick one of items and returns its index. On a
+// terminal, typing narrows the list with a fuzzy filter, and the arrow keys
+// (or Ctrl-P/Ctrl-N) move the selection; otherwise it falls back to the
+// numbered list of promptChoice.
+func promptPick(question string, items []string) (picked int, err error) {
+	if nonInteractive {
+		return 0, errNonInteractive
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stderr.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		choice, err := promptChoice(question, items)
+		if err != nil {
+			return 0, err
+		}
+		for i, item := range items {
+			if item == choice {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("aborted")
+	}
+	oldState, err := term.MakeRaw(in)
+	if err != nil {
+		return 0, err
+	}
+	defer term.Restore(in, oldState)
+	width, _, err := term.GetSize(out)
+	if err != nil || width < 20 {
+		width = 80
+	}
+
+	var query []rune
+	matches := fuzzyFilter("", items)
+	cursor, top, drawn := 0, 0, 0
+	fmt.Fprint(os.Stderr, "\x1b[?25l")
+	defer func() {
+		// Leave only the answer on screen.
+		fmt.Fprintf(os.Stderr, "\x1b[%dA\r\x1b[J\x1b[?25h", drawn)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\r\n", question, items[picked])
+		}
+	}()
+	draw := func() {
+		var b strings.Builder
+		if drawn > 0 {
+			fmt.Fprintf(&b, "\x1b[%dA", drawn)
+		}
+		b.WriteString("\r\x1b[J")
+		fmt.Fprintf(&b, "%s (type to filter, ↑/↓ to move, Enter to pick): %s\r\n", question, string(query))
+		if cursor < top {
+			top = cursor
+		} else if cursor >= top+pickerRows {
+			top = cursor - pickerRows + 1
+		}
+		lines := 1
+		for i := top; i < len(matches) && i < top+pickerRows; i++ {
+			line := items[matches[i]]
+			if len(line) > width-3 {
+				line = line[:width-3]
+			}
+			if i == cursor {
+				fmt.Fprintf(&b, "\x1b[7m> %s\x1b[0m\r\n", line)
+			} else {
+				fmt.Fprintf(&b, "  %s\r\n", line)
+			}
+			lines++
+		}
+		if len(matches) == 0 {
+			b.WriteString("  (no match)\r\n")
+			lines++
+		} else if len(matches) > pickerRows {
+			fmt.Fprintf(&b, "  [%d/%d]\r\n", cursor+1, len(matches))
+			lines++
+		}
+		drawn = lines
+		fmt.Fprint(os.Stderr, b.String())
+	}
+	refilter := func() {
+		matches = fuzzyFilter(string(query), items)
+		cursor, top = 0, 0
+	}
+	for {
+		draw()
+		c, err := stdinReader.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("aborted")
+		}
+		switch c {
+		case '\r', '\n':
+			if len(matches) > 0 {
+				return matches[cursor], nil
+			}
+		case 3, 4: // Ctrl-C, Ctrl-D
+			return 0, fmt.Errorf("aborted")
+		case 0x1b:
+			// A lone Esc aborts; arrow keys arrive as Esc [ A/B at once.
+			if stdinReader.Buffered() == 0 {
+				return 0, fmt.Errorf("aborted")
+			}
+			seq := make([]byte, 2)
+			seq[0], _ = stdinReader.ReadByte()
+			seq[1], _ = stdinReader.ReadByte()
+			switch string(seq) {
+			case "[A", "OA":
+				cursor = max(cursor-1, 0)
+			case "[B", "OB":
+				cursor = min(cursor+1, max(len(matches)-1, 0))
+			}
+		case 16: // Ctrl-P
+			cursor = max(cursor-1, 0)
+		case 14: // Ctrl-N
+			cursor = min(cursor+1, max(len(matches)-1, 0))
+		case 127, 8: // Backspace
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				refilter()
+			}
+		case 21: // Ctrl-U
+			query = nil
+			refilter()
+		default:
+			if c >= 0x80 {
+				// The rest of a UTF-8 character.
+				_ = stdinReader.UnreadByte()
+				r, _, err := stdinReader.ReadRune()
+				if err != nil || !unicode.IsPrint(r) {
+					continue
+				}
+				query = append(query, r)
+				refilter()
+			} else if c >= ' ' {
+				query = append(query, rune(c))
+				refilter()
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// policyAuditFile is the log of policy overrides in wt's state directory,
+// one JSON object per line.
+const policyAuditFile = "policy-audit.log"
+
+// policyOverride is an entry of the audit log.
+type policyOverride struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Email    string    `json:"email,omitempty"` // git user.email
+	Repo     string    `json:"repo"`            // main repository path
+	Worktree string    `json:"worktree,omitempty"`
+	Policy   string    `json:"policy"`
+	Reason   string    `json:"reason"`
+	Command  string    `json:"command"`
+}
+
+// tightenedBy returns p, the project's policies, made at least as strict as
+// shared, those of the extends source: a project can add guardrails but not
+// drop or widen the organization's.
+func (p PolicyConfig) tightenedBy(shared PolicyConfig) PolicyConfig {
+	p.RmRequiresClean = p.RmRequiresClean || shared.RmRequiresClean
+	if shared.MaxWorktrees > 0 && (p.MaxWorktrees <= 0 || shared.MaxWorktrees < p.MaxWorktrees) {
+		p.MaxWorktrees = shared.MaxWorktrees
+	}
+	if len(shared.AllowedImages) > 0 && !slices.Equal(p.AllowedImages, shared.AllowedImages) {
+		p.sharedImages = shared.AllowedImages
+	}
+	return p
+}
+
+// active reports whether any policy is set.
+func (p PolicyConfig) active() bool {
+	return p.RmRequiresClean || p.MaxWorktrees > 0 || len(p.AllowedImages) > 0 || len(p.sharedImages) > 0
+}
+
+// policyAuditPath returns the path of the audit log.
+func policyAuditPath() (string, error) {
+	home, err := stateHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, policyAuditFile), nil
+}
+
+// recordPolicyOverride appends an override of policy to the audit log.
+func recordPolicyOverride(policy, worktree, reason string) (string, error) {
+	logPath, err := policyAuditPath()
+	if err != nil {
+		return "", err
+	}
+	entry := policyOverride{
+		Time:     time.Now().UTC().Truncate(time.Second),
+		Worktree: worktree,
+		Policy:   policy,
+		Reason:   reason,
+		Command:  strings.Join(append([]string{"wt"}, os.Args[1:]...), " "),
+	}
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		entry.Repo = mainRoot
+	}
+	if out, err := exec.Command("git", "config", "user.email").Output(); err == nil {
+		entry.Email = strings.TrimSpace(string(out))
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return "", err
+	}
+	return logPath, f.Close()
+}
+
+// enforcePolicy handles a violation of policy (e.g. "rmRequiresClean") by
+// the worktree: it refuses with reason and what to do instead, or with force,
+// records the override and lets the command go on. An override that cannot
+// be recorded is refused too. With --print-cmd nothing runs, so nothing is
+// recorded.
+func enforcePolicy(cfg PolicyConfig, policy, worktree, reason, fix string, force bool) error {
+	if !force {
+		msg := fmt.Sprintf("policy.%s: %s; %s, or override with --force (overrides are logged)", policy, reason, fix)
+		if cfg.Contact != "" {
+			msg += "; " + cfg.Contact
+		}
+		return errors.New(msg)
+	}
+	if printCmd {
+		warnf("", "the command would override policy.%s: %s", policy, reason)
+		return nil
+	}
+	logPath, err := recordPolicyOverride(policy, worktree, reason)
+	if err != nil {
+		return fmt.Errorf("policy.%s: %s, and the override could not be logged: %w", policy, reason, err)
+	}
+	warnf("", "overriding policy.%s: %s (logged in %s)", policy, reason, logPath)
+	return nil
+}
+
+// checkRemovePolicy enforces policy.rmRequiresClean before the worktree
+// called name is removed. gitArgs are the 'git worktree remove' arguments,
+// whose --force is the override.
+func checkRemovePolicy(name string, gitArgs []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.Policy.RmRequiresClean {
+		return nil
+	}
+	dir, err := resolveWorktreePath(name)
+	if err != nil {
+		return err
+	}
+	if !getWorktreeStatus(dir).dirty {
+		return nil
+	}
+	force := slices.Contains(gitArgs, "--force") || slices.Contains(gitArgs, "-f")
+	return enforcePolicy(cfg.Policy, "rmRequiresClean", name,
+		fmt.Sprintf("%s has uncommitted changes or untracked files", name),
+		"commit, stash, or delete them first", force)
+}
+
+// checkWorktreeCountPolicy enforces policy.maxWorktrees before the worktree
+// called name is added to the repository at mainRoot.
+func checkWorktreeCountPolicy(mainRoot, name string, cfg PolicyConfig, force bool) error {
+	if cfg.MaxWorktrees <= 0 {
+		return nil
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil || len(worktrees) < cfg.MaxWorktrees {
+		return nil
+	}
+	return enforcePolicy(cfg, "maxWorktrees", name,
+		fmt.Sprintf("%s has %d worktrees, and at most %d are allowed", filepath.Base(mainRoot), len(worktrees), cfg.MaxWorktrees),
+		"remove one with 'wt rm <name>' first", force)
+}
+
+// devcontainerBaseImages returns the images the devcontainer of the worktree
+// at dir is built from: its image, or the FROM images of its Dockerfile,
+// with ARG defaults substituted and references to earlier stages left out.
+// Compose-based devcontainers yield none.
+func devcontainerBaseImages(dir string) ([]string, error) {
+	dc, err := readDevcontainerConfig(dir)
+	if err != nil || dc == nil {
+		return nil, err
+	}
+	if dc.Image != "" {
+		return []string{dc.Image}, nil
+	}
+	dockerfile := dc.Build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = dc.DockerFile
+	}
+	if dockerfile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(dir, ".devcontainer", dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var images []string
+	args := map[string]string{}
+	stages := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if name, value, ok := strings.Cut(fields[1], "="); ok {
+				args[name] = strings.Trim(value, `"'`)
+			}
+		case "FROM":
+			fields = slices.DeleteFunc(fields[1:], func(f string) bool { return strings.HasPrefix(f, "--") })
+			if len(fields) == 0 {
+				continue
+			}
+			image := os.Expand(fields[0], func(name string) string { return args[name] })
+			if !stages[strings.ToLower(image)] && image != "scratch" {
+				images = append(images, image)
+			}
+			if len(fields) >= 3 && strings.EqualFold(fields[1], "as") {
+				stages[strings.ToLower(fields[2])] = true
+			}
+		}
+	}
+	return images, sc.Err()
+}
+
+// imageAllowed reports whether image matches one of patterns, as a whole or
+// without its tag or digest.
+func imageAllowed(image string, patterns []string) bool {
+	bare := image
+	if i := strings.Index(bare, "@"); i >= 0 {
+		bare = bare[:i]
+	}
+	if i := strings.LastIndex(bare, ":"); i > strings.LastIndex(bare, "/") {
+		bare = bare[:i]
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, image); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, bare); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImagePolicy enforces policy.allowedImages before the devcontainer of
+// the worktree at dir is started.
+func checkImagePolicy(dir string, cfg PolicyConfig, force bool) error {
+	if len(cfg.AllowedImages) == 0 && len(cfg.sharedImages) == 0 {
+		return nil
+	}
+	images, err := devcontainerBaseImages(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read the devcontainer's base images: %w", err)
+	}
+	for _, image := range images {
+		allowed := len(cfg.AllowedImages) == 0 || imageAllowed(image, cfg.AllowedImages)
+		if len(cfg.sharedImages) > 0 && !imageAllowed(image, cfg.sharedImages) {
+			allowed = false
+		}
+		if allowed {
+			continue
+		}
+		patterns := cfg.AllowedImages
+		if len(patterns) == 0 {
+			patterns = cfg.sharedImages
+		}
+		if err := enforcePolicy(cfg, "allowedImages", filepath.Base(dir),
+			fmt.Sprintf("%s is not an approved base image", image),
+			"base the devcontainer on one of "+strings.Join(patterns, ", "), force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPolicyShow implements 'wt policy': it lists the policies in force for
+// the repository and the overrides recorded for it.
+func runPolicyShow(cfg *Config) error {
+	p := cfg.Policy
+	if !p.active() {
+		fmt.Fprintf(os.Stderr, "No policies are set in %s\n", projectConfigFile)
+	} else {
+		if p.RmRequiresClean {
+			fmt.Println("rmRequiresClean  'wt rm' refuses worktrees with uncommitted changes")
+		}
+		if p.MaxWorktrees > 0 {
+			fmt.Printf("maxWorktrees     at most %d worktrees\n", p.MaxWorktrees)
+		}
+		if len(p.AllowedImages) > 0 {
+			fmt.Printf("allowedImages    %s\n", strings.Join(p.AllowedImages, ", "))
+		}
+		if len(p.sharedImages) > 0 {
+			fmt.Printf("                 and %s (from extends)\n", strings.Join(p.sharedImages, ", "))
+		}
+	}
+
+	logPath, err := policyAuditPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mainRoot, _ := getMainRepoRoot()
+	var overrides []policyOverride
+	for _, line := range strings.Split(string(data), "\n") {
+		var o policyOverride
+		if json.Unmarshal([]byte(line), &o) == nil && o.Repo == mainRoot {
+			overrides = append(overrides, o)
+		}
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	fmt.Printf("\nOverrides (%s):\n", logPath)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, o := range overrides {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.Time.Local().Format("2006-01-02 15:04"), o.User, o.Policy, o.Reason)
+	}
+	return tw.Flush()
+}
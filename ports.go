@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// fixedHostPorts returns the host ports a devcontainer config binds at fixed
+// numbers. Ephemeral publications such as appPort "1080" (no host part) are
+// skipped because docker picks a free host port for them.
+func fixedHostPorts(cfg *devcontainerConfig) []int {
+	if cfg == nil {
+		return nil
+	}
+	var specs []string
+	var appPorts []any
+	if len(cfg.AppPort) > 0 {
+		var single any
+		if err := json.Unmarshal(cfg.AppPort, &single); err == nil {
+			if list, ok := single.([]any); ok {
+				appPorts = list
+			} else {
+				appPorts = []any{single}
+			}
+		}
+	}
+	var ports []int
+	for _, p := range appPorts {
+		switch v := p.(type) {
+		case float64:
+			// A numeric appPort is published on the same host port.
+			ports = append(ports, int(v))
+		case string:
+			specs = append(specs, v)
+		}
+	}
+	for i := 0; i < len(cfg.RunArgs); i++ {
+		arg := cfg.RunArgs[i]
+		switch {
+		case (arg == "-p" || arg == "--publish") && i+1 < len(cfg.RunArgs):
+			specs = append(specs, cfg.RunArgs[i+1])
+			i++
+		case strings.HasPrefix(arg, "--publish="):
+			specs = append(specs, strings.TrimPrefix(arg, "--publish="))
+		case strings.HasPrefix(arg, "-p") && len(arg) > 2:
+			specs = append(specs, strings.TrimPrefix(arg, "-p"))
+		}
+	}
+	for _, spec := range specs {
+		if port := publishSpecHostPort(spec); port > 0 {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// publishSpecHostPort returns the fixed host port of a docker publish spec
+// ("[ip:]host:container[/proto]"), or 0 if the host port is ephemeral.
+func publishSpecHostPort(spec string) int {
+	spec, _, _ = strings.Cut(spec, "/")
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 {
+		return 0
+	}
+	port, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+// publishedHostPorts maps host ports published by running devcontainers to
+// the workspace folder of the container that owns them.
+func publishedHostPorts() (map[int]string, error) {
+	out, err := exec.Command("docker", "ps", "--filter", "label=devcontainer.local_folder",
+		"--format", `{{.Label "devcontainer.local_folder"}}`+"\t{{.Ports}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query docker: %w", err)
+	}
+	owners := map[int]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		folder, ports, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		// Ports look like "0.0.0.0:8080->8080/tcp, :::8080->8080/tcp".
+		for _, mapping := range strings.Split(ports, ",") {
+			hostSide, _, ok := strings.Cut(strings.TrimSpace(mapping), "->")
+			if !ok {
+				continue
+			}
+			if i := strings.LastIndex(hostSide, ":"); i >= 0 {
+				if port, err := strconv.Atoi(hostSide[i+1:]); err == nil {
+					owners[port] = folder
+				}
+			}
+		}
+	}
+	return owners, nil
+}
+
+// checkContainerPortConflicts fails when the devcontainer for dir would bind
+// a fixed host port that another worktree's container, or some other host
+// process, already holds.
+func checkContainerPortConflicts(dir string) error {
+	if _, err := getContainerID(dir); err == nil {
+		// Already running; 'devcontainer up' will reuse it.
+		return nil
+	}
+	cfg, err := readDevcontainerConfig(dir)
+	if err != nil {
+		return err
+	}
+	ports := fixedHostPorts(cfg)
+	if len(ports) == 0 {
+		return nil
+	}
+	owners, err := publishedHostPorts()
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for _, port := range ports {
+		if owner, ok := owners[port]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("  port %d is already published by the devcontainer for %s (stop it with: wt down %s)", port, filepath.Base(owner), owner))
+			continue
+		}
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			conflicts = append(conflicts, fmt.Sprintf("  port %d is already in use on the host", port))
+			continue
+		}
+		ln.Close()
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("devcontainer for %s binds fixed host ports that are taken:\n%s\nUse an ephemeral publication (e.g. \"appPort\": [\"8080\"]) or the SOCKS5 proxy instead",
+			filepath.Base(dir), strings.Join(conflicts, "\n"))
+	}
+	return nil
+}
+
+// envPorts returns the numeric values of *PORT* keys in a dotenv file.
+func envPorts(path string) map[string]int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	ports := map[string]int{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key := envKey(line)
+		if key == "" || !strings.Contains(strings.ToUpper(key), "PORT") {
+			continue
+		}
+		_, value, _ := strings.Cut(line, "=")
+		if port, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"'`)); err == nil && port > 0 {
+			ports[key] = port
+		}
+	}
+	return ports
+}
+
+// checkEnvPortConflicts compares the *PORT* values in the rendered env files
+// of dir against every other worktree of the repository and reports ports
+// that two worktrees would both bind.
+func checkEnvPortConflicts(dir string, rendered []string) error {
+	if len(rendered) == 0 {
+		return nil
+	}
+	worktrees, err := listGitWorktreePaths()
+	if err != nil {
+		return nil
+	}
+	var conflicts []string
+	for _, rel := range rendered {
+		mine := envPorts(filepath.Join(dir, rel))
+		for _, other := range worktrees {
+			if normalizePathForCompare(other) == normalizePathForCompare(dir) {
+				continue
+			}
+			theirs := envPorts(filepath.Join(other, rel))
+			for key, port := range mine {
+				for otherKey, otherPort := range theirs {
+					if port == otherPort {
+						conflicts = append(conflicts, fmt.Sprintf("  %s=%d in %s collides with %s in %s", key, port, rel, otherKey, filepath.Base(other)))
+					}
+				}
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("port conflicts with other worktrees:\n%s\nDerive ports from {{.PortOffset}} in the env template", strings.Join(conflicts, "\n"))
+	}
+	return nil
+}
+
+// containerPublishedPorts maps the container ports docker publishes for
+// containerID ("8080", or "53/udp" for non-TCP ports) to their host ports.
+func containerPublishedPorts(containerID string) map[string]string {
+	out, err := exec.Command("docker", "port", containerID).Output()
+	if err != nil {
+		return nil
+	}
+	published := map[string]string{}
+	// Lines look like "8080/tcp -> 0.0.0.0:32768".
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		containerSide, hostSide, ok := strings.Cut(line, " -> ")
+		if !ok {
+			continue
+		}
+		port := strings.TrimSuffix(strings.TrimSpace(containerSide), "/tcp")
+		if _, hostPort, err := net.SplitHostPort(strings.TrimSpace(hostSide)); err == nil && published[port] == "" {
+			published[port] = hostPort
+		}
+	}
+	return published
+}
+
+// runPorts lists the ports of the worktree's devcontainer: forwardPorts from
+// devcontainer.json followed by any other published port, with their
+// portsAttributes labels and where the host reaches them.
+func runPorts(dir string) error {
+	cfg, err := readDevcontainerConfig(dir)
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return fmt.Errorf("no .devcontainer/devcontainer.json in %s", filepath.Base(dir))
+	}
+	containerID, _ := getContainerID(dir)
+	var published map[string]string
+	proxied := false
+	if containerID != "" {
+		published = containerPublishedPorts(containerID)
+		_, err := routingProxy(dir)
+		proxied = err == nil
+	}
+	ports := cfg.forwardedPorts()
+	forwarded := map[string]bool{}
+	for _, p := range ports {
+		forwarded[p] = true
+	}
+	var others []string
+	for p := range published {
+		if !forwarded[p] {
+			others = append(others, p)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.Split(others[i], "/")[0])
+		b, _ := strconv.Atoi(strings.Split(others[j], "/")[0])
+		return a < b
+	})
+	ports = append(ports, others...)
+	if len(ports) == 0 {
+		fmt.Println("The devcontainer forwards and publishes no ports.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tLABEL\tFROM HOST")
+	for _, p := range ports {
+		label := cfg.PortsAttributes[p].Label
+		if label == "" {
+			label = "-"
+		}
+		reach := "-"
+		switch {
+		case published[p] != "":
+			reach = "127.0.0.1:" + published[p]
+		case containerID == "":
+			reach = "(not running)"
+		case proxied:
+			addr := p
+			if !strings.Contains(addr, ":") {
+				addr = "127.0.0.1:" + addr
+			}
+			reach = addr + " via proxy"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p, label, reach)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// fetchPullRequest fetches the head of pull request number from origin into
+// refs/remotes/origin/pr/<number> and returns that ref, for 'wt add --pr'.
+func fetchPullRequest(number int) (string, error) {
+	if offline {
+		return "", errOffline("--pr")
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	f, err := detectForge(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	src, err := f.pullRequestRef(number)
+	if err != nil {
+		return "", err
+	}
+	ref := "origin/pr/" + strconv.Itoa(number)
+	fmt.Fprintf(os.Stderr, "Fetching pull request #%d from origin\n", number)
+	fetchCmd := exec.Command("git", "fetch", "origin", "+"+src+":refs/remotes/"+ref)
+	fetchCmd.Stdout = os.Stdout
+	fetchCmd.Stderr = os.Stderr
+	if err := fetchCmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to fetch pull request #%d (%s) from origin: %w", number, src, err)
+	}
+	return ref, nil
+}
+
+func (githubForge) pullRequestRef(number int) (string, error) {
+	return fmt.Sprintf("refs/pull/%d/head", number), nil
+}
+
+func (gitlabForge) pullRequestRef(number int) (string, error) {
+	return fmt.Sprintf("refs/merge-requests/%d/head", number), nil
+}
+
+func (bitbucketForge) pullRequestRef(number int) (string, error) {
+	return "", fmt.Errorf("Bitbucket does not publish pull request refs; check out the source branch of #%d with 'wt add -b <branch>'", number)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// printCmd, set by --print-cmd, makes wt print the devcontainer, docker, or
+// editor commands it would run instead of running them.
+var printCmd bool
+
+// startEnv and startDir are the environment and directory wt started with;
+// printCommand shows how wt changed them.
+var (
+	startEnv    = os.Environ()
+	startDir, _ = os.Getwd()
+)
+
+// addPrintCmdFlag adds --print-cmd to cmd.
+func addPrintCmdFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&printCmd, "print-cmd", false, "print the command that would run, with the environment wt changes, instead of running it")
+}
+
+// shellSafe matches words that need no quoting in a POSIX shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// environDiff returns the variables of env that are new or changed since
+// startEnv, as KEY=value, and the names of those removed.
+func environDiff(env []string) (set, unset []string) {
+	before := map[string]string{}
+	for _, e := range startEnv {
+		k, v, _ := strings.Cut(e, "=")
+		before[k] = v
+	}
+	after := map[string]bool{}
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		after[k] = true
+		if old, ok := before[k]; !ok || old != v {
+			set = append(set, e)
+		}
+	}
+	for k := range before {
+		if !after[k] {
+			unset = append(unset, k)
+		}
+	}
+	slices.Sort(set)
+	slices.Sort(unset)
+	return set, unset
+}
+
+// printCommand prints argv for --print-cmd as a shell command line that runs
+// it the way wt would: from the directory wt changed to, if any, and with the
+// environment variables wt set or removed.
+func printCommand(argv []string) {
+	var parts []string
+	if dir, err := os.Getwd(); err == nil && dir != startDir {
+		parts = append(parts, "cd", shellQuote(dir), "&&")
+	}
+	set, unset := environDiff(os.Environ())
+	if len(set) > 0 || len(unset) > 0 {
+		parts = append(parts, "env")
+		for _, k := range unset {
+			parts = append(parts, "-u", shellQuote(k))
+		}
+		for _, e := range set {
+			parts = append(parts, shellQuote(e))
+		}
+	}
+	for _, arg := range argv {
+		parts = append(parts, shellQuote(arg))
+	}
+	fmt.Println(strings.Join(parts, " "))
+}
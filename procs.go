@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// execIDEnv marks every process started by 'wt exec' inside a container (and
+// its descendants, which inherit the environment) with the id of the exec.
+const execIDEnv = "WT_EXEC_ID"
+
+// execIDPattern matches the ids startExecRecord generates.
+var execIDPattern = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{4}$`)
+
+// isExecID tells exec ids apart from worktree names on the command line.
+func isExecID(s string) bool {
+	return execIDPattern.MatchString(s)
+}
+
+// execRecord is the host-side record of a 'wt exec' into a container. PID is
+// the host process that runs it; once that is gone, container processes
+// still carrying the exec id are orphans.
+type execRecord struct {
+	ID      string    `json:"id"`
+	PID     int       `json:"pid"`
+	Service string    `json:"service,omitempty"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// execsDir returns the directory holding exec records for the worktree at dir.
+func execsDir(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "execs"), nil
+}
+
+// startExecRecord records a 'wt exec' of argv into the worktree's
+// devcontainer (or a compose service) and returns the environment entry that
+// marks its processes. The record keeps the current PID, which the exec
+// keeps when wt replaces itself with the devcontainer CLI or docker.
+func startExecRecord(dir, service string, argv []string) string {
+	id := time.Now().Format("20060102-150405") + fmt.Sprintf("-%04x", rand.IntN(0x10000))
+	entry := execIDEnv + "=" + id
+	execs, err := execsDir(dir)
+	if err != nil || printCmd {
+		return entry
+	}
+	command := strings.Join(argv, " ")
+	if command == "" {
+		command = "(shell)"
+	}
+	data, err := json.MarshalIndent(execRecord{ID: id, PID: os.Getpid(), Service: service, Command: command, Started: time.Now()}, "", "  ")
+	if err == nil && os.MkdirAll(execs, 0755) == nil {
+		_ = os.WriteFile(filepath.Join(execs, id+".json"), data, 0644)
+	}
+	return entry
+}
+
+// loadExecRecords reads the worktree's exec records, keyed by id.
+func loadExecRecords(dir string) map[string]execRecord {
+	records := map[string]execRecord{}
+	execs, err := execsDir(dir)
+	if err != nil {
+		return records
+	}
+	paths, _ := filepath.Glob(filepath.Join(execs, "*.json"))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var r execRecord
+		if json.Unmarshal(data, &r) == nil && r.ID != "" {
+			records[r.ID] = r
+		}
+	}
+	return records
+}
+
+// hostProcessAlive reports whether the host process pid still exists.
+func hostProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// containerProc is a process inside a container started by 'wt exec'.
+type containerProc struct {
+	pid     int
+	execID  string
+	command string
+}
+
+// execProcsScript lists "pid<TAB>exec id<TAB>command line" for each process
+// whose environment carries execIDEnv, using only /proc and POSIX tools so
+// it works in minimal images.
+const execProcsScript = `for d in /proc/[0-9]*; do
+  id=$(tr '\0' '\n' 2>/dev/null < "$d/environ" | sed -n 's/^` + execIDEnv + `=//p')
+  [ -n "$id" ] || continue
+  printf '%s\t%s\t%s\n' "${d#/proc/}" "$id" "$(tr '\0' ' ' 2>/dev/null < "$d/cmdline")"
+done`
+
+// containerExecProcs finds the processes in containerID started by 'wt exec'.
+func containerExecProcs(containerID string) ([]containerProc, error) {
+	out, err := exec.Command("docker", "exec", "-u", "0", containerID, "sh", "-c", execProcsScript).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes in container %s: %w", containerID, err)
+	}
+	var procs []containerProc
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		procs = append(procs, containerProc{pid: pid, execID: fields[1], command: strings.TrimSpace(fields[2])})
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// execProcState returns "running" while the host side of an exec is alive
+// and "orphaned" once it has exited and left processes behind.
+func execProcState(records map[string]execRecord, id string) string {
+	if r, ok := records[id]; ok && hostProcessAlive(r.PID) {
+		return "running"
+	}
+	return "orphaned"
+}
+
+// pruneExecRecords removes records of execs whose host process has exited
+// and which left no processes behind in the container.
+func pruneExecRecords(dir string, records map[string]execRecord, procs []containerProc) {
+	execs, err := execsDir(dir)
+	if err != nil {
+		return
+	}
+	live := map[string]bool{}
+	for _, p := range procs {
+		live[p.execID] = true
+	}
+	for id, r := range records {
+		if !live[id] && !hostProcessAlive(r.PID) {
+			os.Remove(filepath.Join(execs, id+".json"))
+		}
+	}
+}
+
+// runPs lists the processes 'wt exec' started in the worktree's devcontainer
+// or compose service, marking those whose 'wt exec' has exited as orphaned.
+func runPs(dir, service string) error {
+	containerID, err := targetContainerID(dir, service)
+	if err != nil {
+		return err
+	}
+	procs, err := containerExecProcs(containerID)
+	if err != nil {
+		return err
+	}
+	records := loadExecRecords(dir)
+	pruneExecRecords(dir, records, procs)
+	if len(procs) == 0 {
+		fmt.Fprintf(os.Stderr, "No wt exec processes running in %s\n", filepath.Base(dir))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXEC\tSTATE\tSTARTED\tPID\tCOMMAND")
+	for _, p := range procs {
+		started := "-"
+		if r, ok := records[p.execID]; ok {
+			started = formatAge(r.Started)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", p.execID, execProcState(records, p.execID), started, p.pid, p.command)
+	}
+	return w.Flush()
+}
+
+// runKill signals the processes 'wt exec' started in the worktree's
+// container: those of the given exec ids, the orphaned ones, or all of them.
+func runKill(dir, service string, ids []string, orphans, all bool, signal string) error {
+	if len(ids) == 0 && !orphans && !all {
+		return fmt.Errorf("name exec ids to kill (see 'wt ps'), or pass --orphans or --all")
+	}
+	containerID, err := targetContainerID(dir, service)
+	if err != nil {
+		return err
+	}
+	procs, err := containerExecProcs(containerID)
+	if err != nil {
+		return err
+	}
+	records := loadExecRecords(dir)
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var pids []string
+	killed := map[string]bool{}
+	for _, p := range procs {
+		if all || wanted[p.execID] || (orphans && execProcState(records, p.execID) == "orphaned") {
+			pids = append(pids, strconv.Itoa(p.pid))
+			killed[p.execID] = true
+		}
+	}
+	for _, id := range ids {
+		if !killed[id] {
+			warnf("", "no processes of exec %s are running", id)
+		}
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+	killArgs := append([]string{"exec", "-u", "0", containerID, "kill", "-s", signal}, pids...)
+	if out, err := exec.Command("docker", killArgs...).CombinedOutput(); err != nil {
+		// Processes that exited meanwhile make kill fail; report the rest.
+		warnf("", "kill: %s", strings.TrimSpace(string(out)))
+	}
+	fmt.Fprintf(os.Stderr, "Sent SIG%s to %d processes of %d execs in %s\n", signal, len(pids), len(killed), filepath.Base(dir))
+	return nil
+}
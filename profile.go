@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// upPhase is a stage of 'devcontainer up' recognized from its log output.
+type upPhase struct {
+	name    string
+	markers []string // substrings that mark the start of the phase
+}
+
+// upPhases lists the phases in the order devcontainer up runs them.
+var upPhases = []upPhase{
+	{name: "resolve", markers: nil},
+	{name: "image pull", markers: []string{"docker pull", "Pulling from", "Pulling fs layer"}},
+	{name: "build", markers: []string{"docker buildx build", "docker build", "Run: docker buildx", "[internal] load build definition"}},
+	{name: "create", markers: []string{"Run: docker run", "Start: Run: docker run", "docker run --sig-proxy"}},
+	{name: "lifecycle commands", markers: []string{"onCreateCommand", "updateContentCommand", "postCreateCommand", "postStartCommand", "Running the "}},
+}
+
+// phaseTimer records when each phase was first seen in the output.
+type phaseTimer struct {
+	mu      sync.Mutex
+	current int
+	starts  map[int]time.Time
+	seen    []int
+}
+
+func newPhaseTimer(start time.Time) *phaseTimer {
+	return &phaseTimer{starts: map[int]time.Time{0: start}, seen: []int{0}}
+}
+
+func (p *phaseTimer) observe(line string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Only move forward; later phases never hand control back.
+	for i := len(upPhases) - 1; i > p.current; i-- {
+		for _, m := range upPhases[i].markers {
+			if strings.Contains(line, m) {
+				p.current = i
+				p.starts[i] = at
+				p.seen = append(p.seen, i)
+				return
+			}
+		}
+	}
+}
+
+// scanTimed copies r to w line by line, passing each line to the timer.
+func (p *phaseTimer) scanTimed(r io.Reader, w io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		p.observe(line, time.Now())
+		fmt.Fprintln(w, line)
+	}
+}
+
+// runProfileUp runs 'devcontainer up' for dir, after the same checks as 'wt
+// up' (force overrides the image policy), timing its phases and the wait for
+// the SOCKS5 proxy, then prints a breakdown with suggestions.
+func runProfileUp(dir string, extra []string, force bool) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	mounts, err := upPreflight(dir, cfg, force)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	timer := newPhaseTimer(start)
+
+	dcArgs := append(append([]string{"up", "--workspace-folder", dir}, mounts...), extra...)
+	recordDevcontainerUp(dir, extra)
+	upCmd := exec.Command("devcontainer", dcArgs...)
+	stdout, err := upCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := upCmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := upCmd.Start(); err != nil {
+		return fmt.Errorf("failed to start devcontainer up: %w", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go timer.scanTimed(stdout, os.Stdout, &wg)
+	go timer.scanTimed(stderr, os.Stderr, &wg)
+	wg.Wait()
+	if err := upCmd.Wait(); err != nil {
+		return fmt.Errorf("devcontainer up failed: %w", err)
+	}
+	upDone := time.Now()
+
+	proxyErr := waitForProxy(dir, 60*time.Second)
+	end := time.Now()
+
+	type row struct {
+		name string
+		d    time.Duration
+	}
+	var rows []row
+	for i, phase := range timer.seen {
+		until := upDone
+		if i+1 < len(timer.seen) {
+			until = timer.starts[timer.seen[i+1]]
+		}
+		rows = append(rows, row{upPhases[phase].name, until.Sub(timer.starts[phase])})
+	}
+	rows = append(rows, row{"proxy readiness", end.Sub(upDone)})
+
+	total := end.Sub(start)
+	fmt.Fprintln(os.Stderr)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "PHASE\tTIME\tSHARE\t")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%.0f%%\t\n", r.name, r.d.Round(100*time.Millisecond), 100*r.d.Seconds()/total.Seconds())
+	}
+	fmt.Fprintf(w, "total\t%s\t\t\n", total.Round(100*time.Millisecond))
+	w.Flush()
+	if proxyErr != nil {
+		warnf("", "%v", proxyErr)
+	}
+
+	var suggestions []string
+	for _, r := range rows {
+		switch {
+		case r.name == "image pull" && r.d > 30*time.Second:
+			suggestions = append(suggestions, "Image pulls are slow: pre-pull the base image or mirror it in a local registry.")
+		case r.name == "build" && r.d > 60*time.Second:
+			suggestions = append(suggestions, "The image build dominates: prebuild it once ('devcontainer build --image-name <tag>') and reference the tag in devcontainer.json, or order Dockerfile steps so dependency layers stay cached.")
+		case r.name == "lifecycle commands" && r.d > 60*time.Second:
+			suggestions = append(suggestions, "Lifecycle commands (postCreateCommand etc.) are slow: move dependency installs into the Dockerfile, or mount a shared cache volume (e.g. for ~/.npm, ~/.cache/pip, ~/go/pkg/mod).")
+		case r.name == "proxy readiness" && r.d > 5*time.Second:
+			suggestions = append(suggestions, "The SOCKS5 proxy takes a while to accept connections: check that supervisord starts it early.")
+		}
+	}
+	if len(suggestions) > 0 {
+		fmt.Fprintln(os.Stderr, "\nSuggestions:")
+		for _, s := range suggestions {
+			fmt.Fprintf(os.Stderr, "  - %s\n", s)
+		}
+	}
+	return nil
+}
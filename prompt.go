@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// stdinReader is shared by all prompts so buffered input is not lost between
+// questions.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptLine asks a question on stderr and returns the trimmed answer, or def
+// if the answer is empty.
+func promptLine(question, def string) (string, error) {
+	if nonInteractive {
+		return "", errNonInteractive
+	}
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	reply, err := stdinReader.ReadString('\n')
+	reply = strings.TrimSpace(reply)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("aborted")
+	}
+	if reply == "" {
+		return def, nil
+	}
+	return reply, nil
+}
+
+// promptYesNo asks a yes/no question with the given default.
+func promptYesNo(question string, def bool) (bool, error) {
+	if nonInteractive {
+		return false, errNonInteractive
+	}
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Fprintf(os.Stderr, "%s [%s] ", question, hint)
+		reply, err := stdinReader.ReadString('\n')
+		reply = strings.TrimSpace(strings.ToLower(reply))
+		if err != nil && reply == "" {
+			return false, fmt.Errorf("aborted")
+		}
+		switch reply {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// promptChoice shows a numbered list and returns the chosen item. Typing text
+// instead of a number narrows the list to items containing it; an exact match
+// is accepted directly.
+func promptChoice(question string, items []string) (string, error) {
+	if nonInteractive {
+		return "", errNonInteractive
+	}
+	shown := items
+	for {
+		for i, item := range shown {
+			fmt.Fprintf(os.Stderr, "  %2d) %s\n", i+1, item)
+		}
+		reply, err := promptLine(question+" (number, or text to filter)", "")
+		if err != nil {
+			return "", err
+		}
+		if reply == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(reply); err == nil && n >= 1 && n <= len(shown) {
+			return shown[n-1], nil
+		}
+		var filtered []string
+		for _, item := range items {
+			if item == reply {
+				return item, nil
+			}
+			if strings.Contains(strings.ToLower(item), strings.ToLower(reply)) {
+				filtered = append(filtered, item)
+			}
+		}
+		switch len(filtered) {
+		case 0:
+			fmt.Fprintf(os.Stderr, "No match for %q\n", reply)
+			shown = items
+		case 1:
+			return filtered[0], nil
+		default:
+			shown = filtered
+		}
+	}
+}
+
+// promptMultiSelect shows a numbered list and returns the indexes the user
+// selects. Selections are space- or comma-separated numbers and ranges
+// ("1 3 5-7"), or "all"; an empty answer selects nothing.
+func promptMultiSelect(question string, items []string) ([]int, error) {
+	if nonInteractive {
+		return nil, errNonInteractive
+	}
+	for {
+		for i, item := range items {
+			fmt.Fprintf(os.Stderr, "  %2d) %s\n", i+1, item)
+		}
+		reply, err := promptLine(question+" (e.g. 1 3 5-7, all)", "")
+		if err != nil {
+			return nil, err
+		}
+		selected, err := parseSelection(reply, len(items))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		return selected, nil
+	}
+}
+
+func parseSelection(reply string, n int) ([]int, error) {
+	if strings.TrimSpace(reply) == "all" {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	}
+	seen := map[int]bool{}
+	var selected []int
+	for _, field := range strings.FieldsFunc(reply, func(r rune) bool { return r == ' ' || r == ',' }) {
+		lo, hi := field, field
+		if a, b, ok := strings.Cut(field, "-"); ok {
+			lo, hi = a, b
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || from < 1 || to > n || from > to {
+			return nil, fmt.Errorf("invalid selection %q", field)
+		}
+		for i := from; i <= to; i++ {
+			if !seen[i-1] {
+				seen[i-1] = true
+				selected = append(selected, i-1)
+			}
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Proxy kinds a devcontainer can expose to the host.
+const (
+	proxySOCKS5 = "socks5"
+	proxyHTTP   = "http"
+	proxyDNS    = "dns"
+)
+
+// proxyKinds lists the kinds in order of preference for routing traffic.
+var proxyKinds = []string{proxySOCKS5, proxyHTTP, proxyDNS}
+
+// defaultSOCKSPort is the container port of the SOCKS5 proxy set up by
+// 'wt init', used when nothing else declares one.
+const defaultSOCKSPort = "1080"
+
+// proxyLabelPrefix names the container labels that declare proxy ports, e.g.
+// "wt.proxy.http=3128" (set through runArgs: ["--label", "wt.proxy.http=3128"]).
+const proxyLabelPrefix = "wt.proxy."
+
+// proxyProgram is the supervisord program running the SOCKS5 proxy in
+// containers created from 'wt init'.
+const proxyProgram = "microsocks"
+
+// proxyEndpoint is a proxy inside a devcontainer and its port on the host.
+type proxyEndpoint struct {
+	kind          string
+	containerPort string // e.g. "1080" or "53/udp"
+	hostPort      string
+}
+
+// proxyServer returns the proxy URL for Chrome, Playwright, and VS Code.
+func (p proxyEndpoint) proxyServer() string {
+	return p.kind + "://127.0.0.1:" + p.hostPort
+}
+
+// curlProxy returns the proxy URL for curl; socks5h resolves names in the
+// container.
+func (p proxyEndpoint) curlProxy() string {
+	if p.kind == proxySOCKS5 {
+		return "socks5h://127.0.0.1:" + p.hostPort
+	}
+	return p.proxyServer()
+}
+
+// proxyKindForLabel maps a devcontainer.json portsAttributes label to a proxy
+// kind.
+func proxyKindForLabel(label string) string {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "socks5", "socks":
+		return proxySOCKS5
+	case "http-proxy", "http proxy":
+		return proxyHTTP
+	case "dns":
+		return proxyDNS
+	}
+	return ""
+}
+
+// declaredProxyPorts returns the container port of each proxy kind. In order
+// of precedence, ports come from proxy in .wt.yaml, wt.proxy.<kind> container
+// labels, and portsAttributes labels in the devcontainer metadata; a SOCKS5
+// proxy on port 1080 is assumed otherwise.
+func declaredProxyPorts(containerID string) map[string]string {
+	ports := map[string]string{}
+	set := func(kind, port string) {
+		if kind != "" && port != "" && ports[kind] == "" {
+			ports[kind] = port
+		}
+	}
+	if cfg, err := loadConfig(); err == nil {
+		set(proxySOCKS5, cfg.Proxy.SOCKS5)
+		set(proxyHTTP, cfg.Proxy.HTTP)
+		set(proxyDNS, cfg.Proxy.DNS)
+	}
+	out, err := exec.Command("docker", "inspect", "--format", "{{json .Config.Labels}}", containerID).Output()
+	if err == nil {
+		var labels map[string]string
+		if json.Unmarshal(bytes.TrimSpace(out), &labels) == nil {
+			for _, kind := range proxyKinds {
+				set(kind, labels[proxyLabelPrefix+kind])
+			}
+			var metadata []struct {
+				PortsAttributes map[string]struct {
+					Label string `json:"label"`
+				} `json:"portsAttributes"`
+			}
+			if json.Unmarshal([]byte(labels["devcontainer.metadata"]), &metadata) == nil {
+				for _, m := range metadata {
+					ports := make([]string, 0, len(m.PortsAttributes))
+					for port := range m.PortsAttributes {
+						ports = append(ports, port)
+					}
+					sort.Strings(ports)
+					for _, port := range ports {
+						set(proxyKindForLabel(m.PortsAttributes[port].Label), port)
+					}
+				}
+			}
+		}
+	}
+	set(proxySOCKS5, defaultSOCKSPort)
+	return ports
+}
+
+// getProxies discovers the proxies of the worktree's running devcontainer
+// that are mapped to host ports, in order of preference.
+func getProxies(dir string) ([]proxyEndpoint, error) {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return nil, err
+	}
+	ports := declaredProxyPorts(containerID)
+	var proxies []proxyEndpoint
+	for _, kind := range proxyKinds {
+		containerPort := ports[kind]
+		if containerPort == "" {
+			continue
+		}
+		out, err := exec.Command("docker", "port", containerID, containerPort).Output()
+		if err != nil {
+			continue
+		}
+		// Output format: "0.0.0.0:32768\n[::]:32768\n" — take the first line
+		addr := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			proxies = append(proxies, proxyEndpoint{kind: kind, containerPort: containerPort, hostPort: port})
+		}
+	}
+	return proxies, nil
+}
+
+// getProxy returns the worktree's proxy of the given kind.
+func getProxy(dir, kind string) (proxyEndpoint, error) {
+	proxies, err := getProxies(dir)
+	if err != nil {
+		return proxyEndpoint{}, err
+	}
+	for _, p := range proxies {
+		if p.kind == kind {
+			return p, nil
+		}
+	}
+	return proxyEndpoint{}, fmt.Errorf("no %s proxy port mapped for devcontainer %q", kind, filepath.Base(dir))
+}
+
+// routingProxy returns the proxy browsers and curl route traffic through:
+// the SOCKS5 proxy, or else an HTTP proxy.
+func routingProxy(dir string) (proxyEndpoint, error) {
+	proxies, err := getProxies(dir)
+	if err != nil {
+		return proxyEndpoint{}, err
+	}
+	for _, p := range proxies {
+		if p.kind == proxySOCKS5 || p.kind == proxyHTTP {
+			return p, nil
+		}
+	}
+	return proxyEndpoint{}, fmt.Errorf("no proxy port mapped for devcontainer %q", filepath.Base(dir))
+}
+
+// probeProxy checks that the proxy answers its protocol. A TCP connect alone
+// is not enough: docker accepts connections on a mapped port even when
+// nothing listens inside the container. DNS proxies are not probed.
+func probeProxy(p proxyEndpoint, timeout time.Duration) error {
+	switch p.kind {
+	case proxySOCKS5:
+		return probeSOCKS(p.hostPort, timeout)
+	case proxyHTTP:
+		return probeHTTPProxy(p.hostPort, timeout)
+	}
+	return nil
+}
+
+// probeSOCKS checks that a SOCKS5 server answers on 127.0.0.1:port.
+func probeSOCKS(port string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	// Greeting: version 5, one method, "no authentication".
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("no SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != 0x05 || reply[1] != 0x00 {
+		return fmt.Errorf("unexpected SOCKS5 greeting %x", reply)
+	}
+	return nil
+}
+
+// probeHTTPProxy checks that an HTTP server answers on 127.0.0.1:port.
+func probeHTTPProxy(port string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("OPTIONS * HTTP/1.0\r\n\r\n")); err != nil {
+		return err
+	}
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("no HTTP response: %w", err)
+	}
+	if string(reply) != "HTTP/" {
+		return fmt.Errorf("unexpected HTTP response %q", reply)
+	}
+	return nil
+}
+
+// restartProxy restarts the SOCKS5 proxy inside the container through
+// supervisorctl, falling back to starting microsocks directly for containers
+// whose supervisord has no control socket.
+func restartProxy(containerID string, p proxyEndpoint) error {
+	if p.kind != proxySOCKS5 {
+		return fmt.Errorf("wt only restarts the SOCKS5 proxy; restart the %s proxy inside the container", p.kind)
+	}
+	if exec.Command("docker", "exec", "-u", "root", containerID, "supervisorctl", "restart", proxyProgram).Run() == nil {
+		return nil
+	}
+	if out, err := exec.Command("docker", "exec", "-d", containerID, "/usr/local/bin/microsocks", "-p", p.containerPort).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restart the proxy: %s", out)
+	}
+	return nil
+}
+
+// requireLiveProxy returns the worktree's routing proxy after checking that
+// it answers, restarting a dead SOCKS5 proxy once.
+func requireLiveProxy(dir string) (proxyEndpoint, error) {
+	p, err := routingProxy(dir)
+	if err != nil {
+		return p, err
+	}
+	if probeProxy(p, 2*time.Second) == nil {
+		return p, nil
+	}
+	fmt.Fprintf(os.Stderr, "%s proxy for %s is not answering; restarting it\n", p.kind, filepath.Base(dir))
+	if err := reviveProxy(dir, p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// reviveProxy restarts the proxy and waits for it to answer.
+func reviveProxy(dir string, p proxyEndpoint) error {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	if err := restartProxy(containerID, p); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := probeProxy(p, time.Second)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s proxy for %s on 127.0.0.1:%s still not answering after a restart (%v); see /tmp/%s.log in the container", p.kind, filepath.Base(dir), p.hostPort, err, proxyProgram)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// runProxyStatus reports whether the worktree's proxies answer, restarting a
+// dead SOCKS5 proxy unless restart is false.
+func runProxyStatus(dir string, restart bool) error {
+	proxies, err := getProxies(dir)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(dir)
+	if len(proxies) == 0 {
+		return fmt.Errorf("no proxy port mapped for devcontainer %q", name)
+	}
+	down := false
+	for _, p := range proxies {
+		if p.kind == proxyDNS {
+			fmt.Printf("%s: %s proxy on 127.0.0.1:%s (not probed)\n", name, p.kind, p.hostPort)
+			continue
+		}
+		probeErr := probeProxy(p, 2*time.Second)
+		if probeErr == nil {
+			fmt.Printf("%s: %s proxy on 127.0.0.1:%s is up\n", name, p.kind, p.hostPort)
+			continue
+		}
+		fmt.Printf("%s: %s proxy on 127.0.0.1:%s is down (%v)\n", name, p.kind, p.hostPort, probeErr)
+		if !restart || p.kind != proxySOCKS5 {
+			down = true
+			continue
+		}
+		if err := reviveProxy(dir, p); err != nil {
+			return err
+		}
+		fmt.Printf("%s: restarted the %s proxy; it is up again\n", name, p.kind)
+	}
+	if down {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// runProxyList prints the proxies discovered for the worktree.
+func runProxyList(dir string) error {
+	proxies, err := getProxies(dir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tCONTAINER PORT\tHOST PORT")
+	for _, p := range proxies {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.kind, p.containerPort, p.hostPort)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyCheckHost is a name that only the container resolves, to the
+	// host, while 'wt proxy check' runs.
+	proxyCheckHost   = "wt-proxy-check.internal"
+	proxyCheckMarker = "# wt-proxy-check"
+	proxyCheckWait   = 15 * time.Second
+)
+
+// proxyCheckServer is the host side of 'wt proxy check': it records which
+// probe requests arrive and from where.
+type proxyCheckServer struct {
+	mu      sync.Mutex
+	arrived map[string]string // probe path -> remote address
+	port    int
+}
+
+func (s *proxyCheckServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.arrived[r.URL.Path] = r.RemoteAddr
+	s.mu.Unlock()
+	fmt.Fprintln(w, "wt proxy check")
+}
+
+// from returns the address the probe at path came from, if it arrived.
+func (s *proxyCheckServer) from(path string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addr, ok := s.arrived[path]
+	return addr, ok
+}
+
+// proxyClient sends a probe request through the worktree's proxy the way
+// one of wt's clients does. It returns an error only when the client could
+// not run at all.
+type proxyClient struct {
+	name  string
+	fetch func(ctx context.Context, target string) error
+}
+
+// proxyCheckClients returns wt's own HTTP client and, when installed, curl
+// and headless Chrome, each set up as 'wt curl' and 'wt chrome' set them up.
+func proxyCheckClients(p proxyEndpoint) []proxyClient {
+	proxyURL, _ := url.Parse(p.curlProxy())
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}
+	clients := []proxyClient{{name: "wt", fetch: func(ctx context.Context, target string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := transport.RoundTrip(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return nil
+	}}}
+	if curl, err := exec.LookPath("curl"); err == nil {
+		clients = append(clients, proxyClient{name: "curl", fetch: func(ctx context.Context, target string) error {
+			_ = exec.CommandContext(ctx, curl, "-sS", "-o", os.DevNull, "--proxy", p.curlProxy(), "--noproxy", "", target).Run()
+			return nil
+		}})
+	}
+	if chrome, err := findChromeBinary(); err == nil {
+		clients = append(clients, proxyClient{name: "chrome", fetch: func(ctx context.Context, target string) error {
+			profile, err := os.MkdirTemp("", "wt-proxy-check-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(profile)
+			_ = exec.CommandContext(ctx, chrome, "--headless=new", "--disable-gpu", "--no-first-run",
+				"--user-data-dir="+profile,
+				"--proxy-server="+p.proxyServer(), "--proxy-bypass-list=<-loopback>",
+				"--dump-dom", target).Run()
+			return nil
+		}})
+	}
+	return clients
+}
+
+// setProxyCheckHost adds (or, with ip empty, removes) the proxyCheckHost
+// line in the container's /etc/hosts.
+func setProxyCheckHost(containerID, ip string) error {
+	line := ""
+	if ip != "" {
+		line = fmt.Sprintf("%s\t%s %s\n", ip, proxyCheckHost, proxyCheckMarker)
+	}
+	// /etc/hosts is bind-mounted, so it must be rewritten in place.
+	script := fmt.Sprintf(`grep -v '%s$' /etc/hosts > /tmp/wt-hosts; printf '%%s' "$1" >> /tmp/wt-hosts; cat /tmp/wt-hosts > /etc/hosts; rm -f /tmp/wt-hosts`, proxyCheckMarker)
+	if out, err := exec.Command("docker", "exec", "-u", "root", containerID, "sh", "-c", script, "sh", line).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update /etc/hosts in the container: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runProxyCheck implements 'wt proxy check': it sends test requests through
+// the worktree's proxy with each client wt launches and reports whether
+// names are resolved, and traffic leaves, in the container. A request to a
+// name only the container knows must reach the host's check server; one to
+// 127.0.0.1 must not, since the container's loopback is not the host's.
+func runProxyCheck(dir string) error {
+	name := filepath.Base(dir)
+	p, err := routingProxy(dir)
+	if err != nil {
+		return err
+	}
+	if err := probeProxy(p, 2*time.Second); err != nil {
+		return fmt.Errorf("%s proxy on 127.0.0.1:%s is down (%v); try 'wt proxy status'", p.kind, p.hostPort, err)
+	}
+	fmt.Printf("%s: %s proxy on 127.0.0.1:%s answers\n", name, p.kind, p.hostPort)
+
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	gateway, err := hostGatewayIP(containerID)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return fmt.Errorf("failed to start the check server: %w", err)
+	}
+	server := &proxyCheckServer{arrived: map[string]string{}, port: ln.Addr().(*net.TCPAddr).Port}
+	go http.Serve(ln, server)
+	defer ln.Close()
+	if err := setProxyCheckHost(containerID, gateway); err != nil {
+		return err
+	}
+	defer setProxyCheckHost(containerID, "")
+
+	failed := false
+	reachable := true
+clients:
+	for _, c := range proxyCheckClients(p) {
+		named := fmt.Sprintf("http://%s:%d/%s", proxyCheckHost, server.port, c.name)
+		loopback := fmt.Sprintf("http://127.0.0.1:%d/%s-loopback", server.port, c.name)
+		for _, target := range []string{named, loopback} {
+			ctx, cancel := context.WithTimeout(context.Background(), proxyCheckWait)
+			err := c.fetch(ctx, target)
+			cancel()
+			if err != nil {
+				fmt.Printf("  %-6s  skipped: %v\n", c.name, err)
+				continue clients
+			}
+		}
+		from, ok := server.from("/" + c.name)
+		switch {
+		case ok:
+			fmt.Printf("  %-6s  ok: %s resolved in the container, request arrived from %s\n", c.name, proxyCheckHost, from)
+		case c.name == "wt":
+			// wt's own client sets the baseline: if its request did not
+			// arrive, the others' can't be judged.
+			fmt.Printf("  %-6s  inconclusive: the container could not reach the host on %s:%d (a firewall?)\n", c.name, gateway, server.port)
+			reachable = false
+		case !reachable:
+			fmt.Printf("  %-6s  inconclusive: %s did not arrive\n", c.name, proxyCheckHost)
+		default:
+			fmt.Printf("  %-6s  FAIL: %s did not resolve through the proxy; names are resolved on the host (DNS leak)\n", c.name, proxyCheckHost)
+			failed = true
+		}
+		if from, ok := server.from("/" + c.name + "-loopback"); ok {
+			fmt.Printf("  %-6s  FAIL: a request to 127.0.0.1 bypassed the proxy and reached the host (from %s)\n", c.name, from)
+			failed = true
+		} else {
+			fmt.Printf("  %-6s  ok: requests to 127.0.0.1 go to the container\n", c.name)
+		}
+	}
+	if failed {
+		return &exitCodeError{code: 1}
+	}
+	if !reachable {
+		return fmt.Errorf("could not verify name resolution for %s", name)
+	}
+	return nil
+}
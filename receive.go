@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// readHandoff reads a 'wt hand-off' archive and checks every file in it
+// against the SHA-256 its manifest records. It returns the manifest and the
+// other files by name.
+func readHandoff(path string) (handoffManifest, map[string][]byte, error) {
+	var m handoffManifest
+	f, err := os.Open(path)
+	if err != nil {
+		return m, nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return m, nil, fmt.Errorf("%s is not a hand-off archive: %w", path, err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return m, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return m, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	manifest, ok := files[handoffManifestFile]
+	if !ok {
+		return m, nil, fmt.Errorf("%s has no %s; is it from 'wt hand-off'?", path, handoffManifestFile)
+	}
+	delete(files, handoffManifestFile)
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return m, nil, fmt.Errorf("failed to read %s: %w", handoffManifestFile, err)
+	}
+	if m.Version != handoffVersion {
+		return m, nil, fmt.Errorf("%s is hand-off format %d; this wt reads format %d", path, m.Version, handoffVersion)
+	}
+	if m.Name == "" || m.Head == "" {
+		return m, nil, fmt.Errorf("%s in %s lacks the worktree name or HEAD", handoffManifestFile, path)
+	}
+	for name, data := range files {
+		want, ok := m.Files[name]
+		if !ok {
+			return m, nil, fmt.Errorf("%s holds %s, which its manifest does not list", path, name)
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return m, nil, fmt.Errorf("%s in %s is corrupt: SHA-256 %s, expected %s", name, path, got, want)
+		}
+	}
+	for name := range m.Files {
+		if _, ok := files[name]; !ok {
+			return m, nil, fmt.Errorf("%s lacks %s, which its manifest lists", path, name)
+		}
+	}
+	return m, files, nil
+}
+
+// receiveCommits makes the hand-off's HEAD commit available in the
+// repository at mainRoot: from its bundle, after git has verified it, or
+// else from origin.
+func receiveCommits(mainRoot string, m handoffManifest, bundle []byte, tmp string) error {
+	if bundle != nil {
+		path := filepath.Join(tmp, handoffBundleFile)
+		if err := os.WriteFile(path, bundle, 0644); err != nil {
+			return err
+		}
+		if out, err := exec.Command("git", "-C", mainRoot, "bundle", "verify", "--quiet", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("the hand-off's commits build on ones this repository lacks; fetch them (e.g. 'git fetch origin') and retry: %s", strings.TrimSpace(string(out)))
+		}
+		if out, err := exec.Command("git", "-C", mainRoot, "fetch", "--quiet", "--no-tags", path, "HEAD").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to fetch the hand-off's commits: %s", strings.TrimSpace(string(out)))
+		}
+	} else if _, err := revParse(mainRoot, m.Head); err != nil && !offline {
+		fmt.Fprintf(os.Stderr, "Fetching %s from origin...\n", m.Head[:min(len(m.Head), 12)])
+		_ = exec.Command("git", "-C", mainRoot, "fetch", "--quiet", "origin").Run()
+	}
+	got, err := revParse(mainRoot, m.Head)
+	if err != nil {
+		return fmt.Errorf("commit %s of the hand-off is not in this repository; push it, or fetch it from the sender", m.Head)
+	}
+	if got != m.Head {
+		return fmt.Errorf("commit %s resolved to %s; the hand-off is inconsistent", m.Head, got)
+	}
+	return nil
+}
+
+// runReceive implements 'wt receive': it rebuilds the worktree packaged by
+// 'wt hand-off' in archive, named name (default: the sender's name) and on
+// branch (default: the sender's branch), applies the uncommitted changes,
+// pulls the devcontainer image, and, with up, starts the container.
+func runReceive(archive, name, branch string, up bool) error {
+	m, files, err := readHandoff(archive)
+	if err != nil {
+		return err
+	}
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		hint := ""
+		if m.Remote != "" {
+			hint = fmt.Sprintf("; clone it first with 'wt clone %s'", m.Remote)
+		}
+		return fmt.Errorf("run 'wt receive' in a clone of %s%s", m.Repo, hint)
+	}
+	if filepath.Base(mainRoot) != m.Repo {
+		warnf("", "the hand-off comes from %s, not %s", m.Repo, filepath.Base(mainRoot))
+	}
+	if name == "" {
+		name = m.Name
+	}
+	if branch == "" {
+		branch = m.Branch
+	}
+	fmt.Fprintf(os.Stderr, "Verified %s: %s at %s\n", filepath.Base(archive), m.Name, m.Head[:min(len(m.Head), 12)])
+
+	tmp, err := os.MkdirTemp("", "wt-receive-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := receiveCommits(mainRoot, m, files[handoffBundleFile], tmp); err != nil {
+		return err
+	}
+
+	opts := addOptions{base: m.Head, branch: branch, noFetch: true}
+	if branch != "" && refExists("refs/heads/"+branch) {
+		if tip, err := revParse(mainRoot, "refs/heads/"+branch); err != nil || tip != m.Head {
+			return fmt.Errorf("branch %q already exists at another commit; receive onto a new branch with --branch", branch)
+		}
+		worktrees, _ := siblingWorktrees(mainRoot)
+		for _, wt := range worktrees {
+			if wt.branch == branch {
+				return fmt.Errorf("branch %q is checked out in %s; receive onto a new branch with --branch", branch, wt.name)
+			}
+		}
+		// Check the existing branch out as is.
+		opts.base = ""
+	}
+	if err := addWorktree(name, opts); err != nil {
+		return err
+	}
+	dir, err := resolveWorktreePath(name)
+	if err != nil {
+		return err
+	}
+
+	if patch, ok := files[handoffPatchFile]; ok {
+		path := filepath.Join(tmp, handoffPatchFile)
+		if err := os.WriteFile(path, patch, 0644); err != nil {
+			return err
+		}
+		if out, err := exec.Command("git", "-C", dir, "apply", "--binary", "--whitespace=nowarn", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to apply the uncommitted changes: %s\nThe worktree was created at %s", strings.TrimSpace(string(out)), dir)
+		}
+		fmt.Fprintf(os.Stderr, "Applied the uncommitted changes of %s\n", m.Name)
+	}
+
+	if m.Image != "" {
+		if offline {
+			fmt.Fprintf(os.Stderr, "Offline: not pulling %s\n", m.Image)
+		} else if out, err := exec.Command("docker", "pull", "--quiet", m.Image).CombinedOutput(); err != nil {
+			warnf("the sender's image is probably local to their machine; 'wt up' builds it from the devcontainer config instead",
+				"failed to pull %s: %s", m.Image, strings.TrimSpace(lastLine(string(out))))
+		} else {
+			fmt.Fprintf(os.Stderr, "Pulled %s\n", m.Image)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json")); err != nil {
+		up = false
+	}
+	return finishAdd(name, addOptions{up: up})
+}
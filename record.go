@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// exitCodeError carries a child process's exit status back to main so wt
+// exits with the same code instead of a generic 1.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// childExitError converts an exec error into an exitCodeError when the child
+// ran and exited non-zero.
+func childExitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &exitCodeError{code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// castHeader is the first line of an asciinema v2 recording.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castWriter appends asciinema v2 output events to a recording. The pty
+// hands over output in arbitrary chunks, so a multi-byte UTF-8 character
+// split across two reads is held back until the rest of it arrives; writing
+// it as is would turn it into replacement characters.
+type castWriter struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	start   time.Time
+	pending []byte
+}
+
+func (c *castWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := append(c.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	c.pending = append([]byte(nil), data[cut:]...)
+	if err := c.event(data[:cut]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// event writes an output event for data, if there is any.
+func (c *castWriter) event(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	event, err := json.Marshal([]any{time.Since(c.start).Seconds(), "o", string(data)})
+	if err != nil {
+		return err
+	}
+	c.w.Write(event)
+	c.w.WriteByte('\n')
+	return nil
+}
+
+// Flush writes out whatever output is still held back, such as a character
+// the child never finished, and flushes the recording.
+func (c *castWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.event(c.pending)
+	c.pending = nil
+	if ferr := c.w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// sessionsDir returns the directory holding recordings for the worktree at dir.
+func sessionsDir(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "sessions"), nil
+}
+
+// createCastFile creates the recording of a session started at start in
+// sessDir. Sessions started within the same second get -2, -3, ... suffixes
+// rather than overwriting each other.
+func createCastFile(sessDir string, start time.Time) (*os.File, string, error) {
+	id := start.Format("20060102-150405")
+	path := filepath.Join(sessDir, id+".cast")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for n := 2; errors.Is(err, os.ErrExist); n++ {
+		path = filepath.Join(sessDir, fmt.Sprintf("%s-%d.cast", id, n))
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	return f, path, err
+}
+
+// runRecorded runs argv in a pseudo-terminal, mirroring it to the user's
+// terminal while recording the output to a new asciinema v2 file in the
+// worktree's sessions directory.
+func runRecorded(dir string, argv []string) error {
+	sessDir, err := sessionsDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sessDir, 0755); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	start := time.Now()
+	f, castPath, err := createCastFile(sessDir, start)
+	if err != nil {
+		return fmt.Errorf("failed to create recording: %w", err)
+	}
+	defer f.Close()
+
+	width, height := 80, 24
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width, height = w, h
+	}
+	bw := bufio.NewWriter(f)
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Command:   strings.Join(argv, " "),
+		Title:     filepath.Base(dir),
+		Env:       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	})
+	bw.Write(header)
+	bw.WriteByte('\n')
+	cast := &castWriter{w: bw, start: start}
+
+	child := exec.Command(argv[0], argv[1:]...)
+	ptmx, err := pty.StartWithSize(child, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", argv[0], err)
+	}
+	defer ptmx.Close()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			_ = pty.InheritSize(os.Stdin, ptmx)
+		}
+	}()
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err == nil {
+			defer term.Restore(int(os.Stdin.Fd()), oldState)
+		}
+	}
+	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	// The copy ends with EIO once the child closes the terminal.
+	_, _ = io.Copy(io.MultiWriter(os.Stdout, cast), ptmx)
+
+	waitErr := child.Wait()
+	cast.Flush()
+	fmt.Fprintf(os.Stderr, "\r\nRecorded session to %s\r\n", castPath)
+	return childExitError(waitErr)
+}
+
+// sessionInfo summarizes a recording for 'wt sessions ls'.
+type sessionInfo struct {
+	id       string
+	path     string
+	command  string
+	started  time.Time
+	duration time.Duration
+}
+
+func listSessions(dir string) ([]sessionInfo, error) {
+	sessDir, err := sessionsDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := filepath.Glob(filepath.Join(sessDir, "*.cast"))
+	var sessions []sessionInfo
+	for _, p := range paths {
+		info := sessionInfo{id: strings.TrimSuffix(filepath.Base(p), ".cast"), path: p}
+		if f, err := os.Open(p); err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+			if scanner.Scan() {
+				var h castHeader
+				if json.Unmarshal(scanner.Bytes(), &h) == nil {
+					info.command = h.Command
+					info.started = time.Unix(h.Timestamp, 0)
+				}
+			}
+			var last []byte
+			for scanner.Scan() {
+				last = append(last[:0], scanner.Bytes()...)
+			}
+			var event []any
+			if json.Unmarshal(last, &event) == nil && len(event) > 0 {
+				if secs, ok := event[0].(float64); ok {
+					info.duration = time.Duration(secs * float64(time.Second))
+				}
+			}
+			f.Close()
+		}
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions, nil
+}
+
+func runSessionsList(dir string) error {
+	sessions, err := listSessions(dir)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintf(os.Stderr, "No recorded sessions for %s\n", filepath.Base(dir))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED\tDURATION\tCOMMAND")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.id, s.started.Format(time.DateTime), s.duration.Round(time.Second), s.command)
+	}
+	return w.Flush()
+}
+
+// playSession replays a recording to stdout, honoring the recorded timing
+// scaled by speed. Pauses longer than maxIdle are shortened to maxIdle.
+func playSession(path string, speed float64, maxIdle time.Duration) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return fmt.Errorf("%s is empty", path)
+	}
+	var prev float64
+	for scanner.Scan() {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			continue
+		}
+		at, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if kind != "o" {
+			continue
+		}
+		delay := time.Duration((at - prev) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		time.Sleep(delay)
+		prev = at
+		os.Stdout.WriteString(data)
+	}
+	return scanner.Err()
+}
+
+// resolveSession finds a recording by id (or unique id prefix); "last" picks
+// the most recent one.
+func resolveSession(dir, id string) (string, error) {
+	sessions, err := listSessions(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(sessions) == 0 {
+		return "", fmt.Errorf("no recorded sessions for %s", filepath.Base(dir))
+	}
+	if id == "last" {
+		return sessions[len(sessions)-1].path, nil
+	}
+	var matches []sessionInfo
+	for _, s := range sessions {
+		if s.id == id {
+			return s.path, nil
+		}
+		if strings.HasPrefix(s.id, id) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no session %q; see 'wt sessions ls'", id)
+	case 1:
+		return matches[0].path, nil
+	default:
+		return "", fmt.Errorf("session id %q is ambiguous", id)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCastWriterHoldsBackSplitRunes(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string // output of each event, then of the flush
+	}{
+		{"ascii", []string{"ab", "c"}, []string{"ab", "c"}},
+		{"whole runes", []string{"→", "✓x"}, []string{"→", "✓x"}},
+		{"split after first byte", []string{"a\xe2", "\x86\x92b"}, []string{"a", "→b"}},
+		{"split over three writes", []string{"\xe2", "\x9c", "\x93"}, []string{"✓"}},
+		{"four-byte rune", []string{"\xf0\x9f", "\x98\x80!"}, []string{"😀!"}},
+		{"unfinished at the end", []string{"ok\xe2\x86"}, []string{"ok", "��"}},
+		{"invalid byte passes through", []string{"\xff", "a"}, []string{"�", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := &castWriter{w: bufio.NewWriter(&buf), start: time.Now()}
+			for _, chunk := range tt.chunks {
+				if n, err := c.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+			}
+			if err := c.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event []any
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("bad event %q: %v", line, err)
+				}
+				got = append(got, event[2].(string))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateCastFileSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 10, 16, 9, 30, 5, 0, time.UTC)
+	want := []string{"20261016-093005.cast", "20261016-093005-2.cast", "20261016-093005-3.cast"}
+	for i, name := range want {
+		f, path, err := createCastFile(dir, start.Add(time.Duration(i)*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(name)
+		f.Close()
+		if filepath.Base(path) != name {
+			t.Errorf("recording %d is %s, want %s", i+1, filepath.Base(path), name)
+		}
+	}
+	for _, name := range want {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != name {
+			t.Errorf("%s holds %q, %v; a later recording overwrote it", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// registryFile returns the path of the machine-wide list of repositories wt
+// has been used with.
+func registryFile() (string, error) {
+	home, err := stateHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "repos.json"), nil
+}
+
+// loadRegistry returns the registered main repository roots, dropping any
+// that no longer exist on disk.
+func loadRegistry() ([]string, error) {
+	path, err := registryFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var roots []string
+	if err := json.Unmarshal(data, &roots); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	var live []string
+	for _, root := range roots {
+		if _, err := os.Stat(filepath.Join(root, ".git")); err == nil {
+			live = append(live, root)
+		}
+	}
+	return live, nil
+}
+
+// registerRepo records mainRoot in the registry. Failures are ignored; the
+// registry is a convenience index, not a source of truth.
+func registerRepo(mainRoot string) {
+	roots, err := loadRegistry()
+	if err != nil {
+		return
+	}
+	for _, root := range roots {
+		if root == mainRoot {
+			return
+		}
+	}
+	roots = append(roots, mainRoot)
+	sort.Strings(roots)
+	path, err := registryFile()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(roots, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, path)
+}
+
+// containerStates maps devcontainer workspace folders to their docker state
+// ("running", "exited", ...). It returns an empty map if docker is unavailable.
+func containerStates() map[string]string {
+	states := map[string]string{}
+	out, err := exec.Command("docker", "ps", "-a", "--filter", "label=devcontainer.local_folder",
+		"--format", `{{.Label "devcontainer.local_folder"}}`+"\t{{.State}}").Output()
+	if err != nil {
+		return states
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		folder, state, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		// Prefer a running container if several exist for the same folder.
+		if states[folder] != "running" {
+			states[folder] = state
+		}
+	}
+	return states
+}
+
+// runListGlobal implements 'wt ls --global'. printListings, if not nil,
+// prints the worktrees instead of the table (e.g. as JSON).
+func runListGlobal(printListings func([]worktreeListing) error) error {
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		registerRepo(mainRoot)
+	}
+	roots, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+	states := containerStates()
+
+	if printListings != nil {
+		var listings []worktreeListing
+		for _, root := range roots {
+			worktrees, err := siblingWorktrees(root)
+			if err != nil {
+				warnf("", "%s: %v", root, err)
+				continue
+			}
+			ports := worktreeProxyPorts(worktrees, states)
+			for i, wt := range worktrees {
+				l := newWorktreeListing(wt, states)
+				l.Repo = filepath.Base(root)
+				l.ProxyPort = ports[i]
+				listings = append(listings, l)
+			}
+		}
+		return printListings(listings)
+	}
+	var rows [][]string
+	var ports []string
+	for _, root := range roots {
+		worktrees, err := siblingWorktrees(root)
+		if err != nil {
+			warnf("", "%s: %v", root, err)
+			continue
+		}
+		for _, wt := range worktrees {
+			rows = append(rows, []string{filepath.Base(root), wt.name, containerLabel(states, wt.path), wt.path})
+		}
+		ports = append(ports, worktreeProxyPorts(worktrees, states)...)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	proxy, showProxy := proxyColumn(ports)
+	if showProxy {
+		fmt.Fprintln(w, "REPO\tNAME\tCONTAINER\tPROXY\tPATH")
+	} else {
+		fmt.Fprintln(w, "REPO\tNAME\tCONTAINER\tPATH")
+	}
+	for i, row := range rows {
+		if showProxy {
+			row = slices.Insert(row, 3, proxy.cells[i])
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// resolveGlobalWorktree resolves a "repo/name" reference through the registry,
+// so it works from outside any repository. An empty name refers to the main
+// repository itself.
+func resolveGlobalWorktree(repo, name string) (string, error) {
+	roots, err := loadRegistry()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, root := range roots {
+		if filepath.Base(root) == repo {
+			matches = append(matches, root)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no registered repository named %q; run 'wt ls' inside it once to register it", repo)
+	case 1:
+	default:
+		return "", fmt.Errorf("repository name %q is ambiguous: %s", repo, strings.Join(matches, ", "))
+	}
+	root := matches[0]
+	if name == "" {
+		return root, nil
+	}
+	if err := validateWorktreeName(name); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(root), worktreeDirName(filepath.Base(root), name))
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return "", fmt.Errorf("worktree %q does not exist in %s", name, repo)
+	}
+	return dir, nil
+}
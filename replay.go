@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	replayTimeout = 30 * time.Second
+	// replayMaxDiffLines bounds the bodies diffed line by line; larger
+	// ones are only reported as different.
+	replayMaxDiffLines = 5000
+)
+
+// replayRequest is one captured request to send again.
+type replayRequest struct {
+	method string
+	url    string
+	header http.Header
+	body   string
+}
+
+// replayResponse is what a worktree answered to a replayed request.
+type replayResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	elapsed time.Duration
+	err     error
+}
+
+// replaySkippedHeaders are request headers not replayed: HTTP/2 pseudo
+// headers are skipped too, and the transport sets these itself.
+var replaySkippedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Accept-Encoding":   true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Proxy-Connection":  true,
+}
+
+func (r *replayRequest) addHeader(name, value string) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(name, ":") || replaySkippedHeaders[http.CanonicalHeaderKey(name)] {
+		return
+	}
+	r.header.Add(name, strings.TrimSpace(value))
+}
+
+// loadReplayRequests reads the requests of a HAR file or of a file of curl
+// commands, as copied with "Copy as cURL" from browser dev tools.
+func loadReplayRequests(path string) ([]replayRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []replayRequest
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		reqs, err = parseHAR(trimmed)
+	} else {
+		reqs, err = parseCurlCommands(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no requests found in %s", path)
+	}
+	for i := range reqs {
+		reqs[i].url = normalizeLocalhostURL(reqs[i].url)
+	}
+	return reqs, nil
+}
+
+func parseHAR(data []byte) ([]replayRequest, error) {
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					Method  string `json:"method"`
+					URL     string `json:"url"`
+					Headers []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"headers"`
+					PostData *struct {
+						Text string `json:"text"`
+					} `json:"postData"`
+				} `json:"request"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR: %w", err)
+	}
+	var reqs []replayRequest
+	for _, e := range har.Log.Entries {
+		r := replayRequest{method: e.Request.Method, url: e.Request.URL, header: http.Header{}}
+		for _, h := range e.Request.Headers {
+			r.addHeader(h.Name, h.Value)
+		}
+		if e.Request.PostData != nil {
+			r.body = e.Request.PostData.Text
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// parseCurlCommands parses one curl command per line (continued with a
+// trailing backslash), skipping blank lines and # comments. The options
+// that shape a request are understood; others, like --compressed, are
+// ignored.
+func parseCurlCommands(text string) ([]replayRequest, error) {
+	var reqs []replayRequest
+	var cmd strings.Builder
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if cont, ok := strings.CutSuffix(line, `\`); ok {
+			cmd.WriteString(cont + " ")
+			if i < len(lines)-1 {
+				continue
+			}
+		} else {
+			cmd.WriteString(line)
+		}
+		command := strings.TrimSpace(cmd.String())
+		cmd.Reset()
+		if command == "" || strings.HasPrefix(command, "#") {
+			continue
+		}
+		r, err := parseCurlCommand(command)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+func parseCurlCommand(command string) (replayRequest, error) {
+	words, err := shellWords(command)
+	if err != nil {
+		return replayRequest{}, err
+	}
+	if len(words) == 0 || filepath.Base(words[0]) != "curl" {
+		return replayRequest{}, fmt.Errorf("not a curl command: %s", command)
+	}
+	r := replayRequest{header: http.Header{}}
+	var data []string
+	for i := 1; i < len(words); i++ {
+		arg := words[i]
+		value := func() (string, error) {
+			if i+1 >= len(words) {
+				return "", fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			return words[i], nil
+		}
+		switch arg {
+		case "-X", "--request":
+			if r.method, err = value(); err != nil {
+				return r, err
+			}
+		case "-H", "--header":
+			h, err := value()
+			if err != nil {
+				return r, err
+			}
+			name, v, _ := strings.Cut(h, ":")
+			r.addHeader(name, v)
+		case "-b", "--cookie":
+			c, err := value()
+			if err != nil {
+				return r, err
+			}
+			r.addHeader("Cookie", c)
+		case "-A", "--user-agent":
+			ua, err := value()
+			if err != nil {
+				return r, err
+			}
+			r.addHeader("User-Agent", ua)
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii":
+			d, err := value()
+			if err != nil {
+				return r, err
+			}
+			data = append(data, d)
+		case "--json":
+			d, err := value()
+			if err != nil {
+				return r, err
+			}
+			data = append(data, d)
+			r.header.Set("Content-Type", "application/json")
+			r.header.Set("Accept", "application/json")
+		case "--url":
+			if r.url, err = value(); err != nil {
+				return r, err
+			}
+		case "-o", "--output", "-u", "--user", "-e", "--referer", "--proxy", "-x", "--noproxy", "-m", "--max-time", "--connect-timeout":
+			// Options with a value that don't change what is compared.
+			if _, err := value(); err != nil {
+				return r, err
+			}
+		default:
+			if !strings.HasPrefix(arg, "-") && r.url == "" {
+				r.url = arg
+			}
+		}
+	}
+	if r.url == "" {
+		return r, fmt.Errorf("no URL in: %s", command)
+	}
+	if len(data) > 0 {
+		r.body = strings.Join(data, "&")
+		if r.header.Get("Content-Type") == "" {
+			r.header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if r.method == "" {
+		r.method = http.MethodGet
+		if len(data) > 0 {
+			r.method = http.MethodPost
+		}
+	}
+	return r, nil
+}
+
+// shellWords splits a command line into words as a POSIX shell would,
+// handling single and double quotes, backslash escapes, and bash's $'...'
+// quoting that browsers use for "Copy as cURL".
+func shellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			i += 2
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					switch s[i] {
+					case 'n':
+						word.WriteByte('\n')
+					case 't':
+						word.WriteByte('\t')
+					case 'r':
+						word.WriteByte('\r')
+					default:
+						word.WriteByte(s[i])
+					}
+					continue
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// resolveWorktreeArg returns the directory of the worktree arg names: a
+// path, "." for the current worktree, or a name as for --like.
+func resolveWorktreeArg(arg string) (string, error) {
+	if dir, ok, err := resolveWorktreePathArg(arg); err != nil || ok {
+		return dir, err
+	}
+	name, err := resolveNameArg(arg)
+	if err != nil {
+		return "", err
+	}
+	dir, err := resolveWorktreePath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("worktree %q does not exist", name)
+	}
+	return dir, nil
+}
+
+// replayClient returns an HTTP client that sends requests through the
+// worktree's proxy, as 'wt curl' does, without following redirects.
+func replayClient(dir string, insecure bool) (*http.Client, error) {
+	p, err := requireLiveProxy(dir)
+	if err != nil {
+		return nil, err
+	}
+	proxyURL, err := url.Parse(p.curlProxy())
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Timeout:       replayTimeout,
+	}, nil
+}
+
+func sendReplayRequest(client *http.Client, r replayRequest) replayResponse {
+	var body io.Reader
+	if r.body != "" {
+		body = strings.NewReader(r.body)
+	}
+	req, err := http.NewRequest(r.method, r.url, body)
+	if err != nil {
+		return replayResponse{err: err}
+	}
+	req.Header = r.header.Clone()
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return replayResponse{err: err, elapsed: time.Since(start)}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return replayResponse{status: resp.StatusCode, header: resp.Header, body: data, elapsed: time.Since(start), err: err}
+}
+
+func (r replayResponse) summary() string {
+	if r.err != nil {
+		return "error: " + r.err.Error()
+	}
+	return fmt.Sprintf("%d (%d bytes, %s)", r.status, len(r.body), r.elapsed.Round(time.Millisecond))
+}
+
+// comparableBody returns the response body as lines to compare: JSON is
+// re-indented so differences show up line by line, and text matching the
+// ignore patterns (timestamps, request IDs) is masked.
+func comparableBody(body []byte, ignore []*regexp.Regexp) []string {
+	var indented bytes.Buffer
+	if json.Valid(body) && json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	text := string(body)
+	for _, re := range ignore {
+		text = re.ReplaceAllString(text, "<ignored>")
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the lines removed from a ("-") and added in b ("+"),
+// in order, from a longest-common-subsequence alignment.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+// compareReplayResponses returns how b's response differs from a's, or nil
+// if they match.
+func compareReplayResponses(a, b replayResponse, ignore []*regexp.Regexp) []string {
+	if a.err != nil || b.err != nil {
+		if a.err != nil && b.err != nil {
+			return nil
+		}
+		return []string{"-" + a.summary(), "+" + b.summary()}
+	}
+	var out []string
+	if a.status != b.status {
+		out = append(out, fmt.Sprintf("-status %d", a.status), fmt.Sprintf("+status %d", b.status))
+	}
+	al, bl := comparableBody(a.body, ignore), comparableBody(b.body, ignore)
+	if strings.Join(al, "\n") == strings.Join(bl, "\n") {
+		return out
+	}
+	if len(al) > replayMaxDiffLines || len(bl) > replayMaxDiffLines {
+		return append(out, fmt.Sprintf("bodies differ (%d vs %d bytes)", len(a.body), len(b.body)))
+	}
+	return append(out, diffLines(al, bl)...)
+}
+
+// runReplay re-sends the captured requests in file through the proxy of the
+// worktree at dir, in order. With against set, each request is also sent
+// to that worktree and the responses are diffed; it exits 1 if any differ.
+func runReplay(file, dir, against string, ignorePatterns []string, insecure bool) error {
+	var ignore []*regexp.Regexp
+	for _, p := range ignorePatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid --ignore pattern %q: %w", p, err)
+		}
+		ignore = append(ignore, re)
+	}
+	reqs, err := loadReplayRequests(file)
+	if err != nil {
+		return err
+	}
+	client, err := replayClient(dir, insecure)
+	if err != nil {
+		return err
+	}
+	var otherClient *http.Client
+	if against != "" {
+		if otherClient, err = replayClient(against, insecure); err != nil {
+			return err
+		}
+		fmt.Printf("--- %s\n+++ %s\n", filepath.Base(dir), filepath.Base(against))
+	}
+	failed, differ := 0, 0
+	for _, r := range reqs {
+		resp := sendReplayRequest(client, r)
+		if resp.err != nil {
+			failed++
+		}
+		if otherClient == nil {
+			fmt.Printf("%-7s %s -> %s\n", r.method, r.url, resp.summary())
+			continue
+		}
+		other := sendReplayRequest(otherClient, r)
+		diff := compareReplayResponses(resp, other, ignore)
+		if diff == nil {
+			fmt.Printf("same    %s %s -> %s\n", r.method, r.url, other.summary())
+			continue
+		}
+		differ++
+		fmt.Printf("DIFF    %s %s\n", r.method, r.url)
+		for _, line := range diff {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	switch {
+	case otherClient != nil:
+		fmt.Printf("%d of %d responses differ\n", differ, len(reqs))
+		if differ > 0 {
+			return &exitCodeError{code: 1}
+		}
+	case failed > 0:
+		return fmt.Errorf("%d of %d requests failed", failed, len(reqs))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// reservationTTL is how long a name reserved with 'wt reserve' stays
+// reserved when no worktree is created for it.
+const reservationTTL = 24 * time.Hour
+
+// reservationsDir returns the directory holding one file per reserved name
+// for the repository at mainRoot.
+func reservationsDir(mainRoot string) (string, error) {
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(repoDir, "reservations")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create reservations directory: %w", err)
+	}
+	return dir, nil
+}
+
+// nameReserved reports whether name holds an unexpired reservation in the
+// repository at mainRoot.
+func nameReserved(mainRoot, name string) bool {
+	dir, err := reservationsDir(mainRoot)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	return err == nil && time.Since(info.ModTime()) < reservationTTL
+}
+
+// reserveWorktreeName atomically claims the first of prefix-1, prefix-2, ...
+// that no worktree, local branch, or live reservation uses. Claims are made
+// under a lock in the repository's wt state, so concurrent callers never get
+// the same name, even when they find the same expired reservation.
+func reserveWorktreeName(mainRoot, prefix string) (string, error) {
+	dir, err := reservationsDir(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(dir+".lock", "")
+	if err != nil {
+		return "", fmt.Errorf("failed to lock reservations: %w", err)
+	}
+	defer unlock()
+	for i := 1; i <= 10000; i++ {
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		if wtPath, err := resolveWorktreePath(name); err != nil {
+			return "", err
+		} else if _, err := os.Stat(wtPath); err == nil {
+			continue
+		}
+		if refExists("refs/heads/" + name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil {
+			if time.Since(info.ModTime()) < reservationTTL {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to free the expired reservation of %s: %w", name, err)
+			}
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to reserve %s: %w", name, err)
+		}
+		fmt.Fprintf(f, "pid %d at %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+		f.Close()
+		return name, nil
+	}
+	return "", fmt.Errorf("could not find a free name with prefix %q", prefix)
+}
+
+// releaseReservation drops the reservation of name, if any.
+func releaseReservation(mainRoot, name string) error {
+	dir, err := reservationsDir(mainRoot)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release %s: %w", name, err)
+	}
+	return nil
+}
+
+// runReserve implements 'wt reserve': it prints a freshly reserved name for
+// 'wt add', or with release, drops the given reservations.
+func runReserve(args []string, release bool) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	if release {
+		for _, name := range args {
+			if err := releaseReservation(mainRoot, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("wt reserve takes one prefix")
+	}
+	prefix := strings.TrimRight(args[0], "-")
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := checkNewWorktreeName(prefix+"-1", cfg.Naming); err != nil {
+		return err
+	}
+	name, err := reserveWorktreeName(mainRoot, prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Println(name)
+	return nil
+}
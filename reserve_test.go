@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReserveWorktreeName(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, repo, reservations string)
+		want  string
+	}{
+		{"first free name", nil, "agent-1"},
+		{"skips a live reservation", func(t *testing.T, repo, reservations string) {
+			writeReservation(t, reservations, "agent-1", time.Now())
+		}, "agent-2"},
+		{"takes over an expired reservation", func(t *testing.T, repo, reservations string) {
+			writeReservation(t, reservations, "agent-1", time.Now().Add(-2*reservationTTL))
+		}, "agent-1"},
+		{"skips an existing worktree", func(t *testing.T, repo, reservations string) {
+			if err := os.Mkdir(repo+"@agent-1", 0755); err != nil {
+				t.Fatal(err)
+			}
+		}, "agent-2"},
+		{"skips an existing branch", func(t *testing.T, repo, reservations string) {
+			gitIn(t, repo, "branch", "agent-1")
+		}, "agent-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			reservations, err := reservationsDir(repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, repo, reservations)
+			}
+			got, err := reserveWorktreeName(repo, "agent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("reserved %s, want %s", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(reservations, got)); err != nil {
+				t.Errorf("no reservation recorded for %s: %v", got, err)
+			}
+		})
+	}
+}
+
+func TestReserveWorktreeNameConcurrent(t *testing.T) {
+	repo := newTestRepo(t)
+	reservations, err := reservationsDir(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every caller finds the same expired reservation first.
+	writeReservation(t, reservations, "agent-1", time.Now().Add(-2*reservationTTL))
+
+	const callers = 8
+	names := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			names[i], errs[i] = reserveWorktreeName(repo, "agent")
+		}()
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for i, name := range names {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if seen[name] {
+			t.Errorf("%s was reserved twice: %v", name, names)
+		}
+		seen[name] = true
+	}
+	for i := 1; i <= callers; i++ {
+		if name := fmt.Sprintf("agent-%d", i); !seen[name] {
+			t.Errorf("%s was skipped: %v", name, names)
+		}
+	}
+}
+
+func TestReserveWorktreeNameWaitsForTheLock(t *testing.T) {
+	repo := newTestRepo(t)
+	reservations, err := reservationsDir(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(reservations), 0755); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(reservations+".lock", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string)
+	go func() {
+		name, _ := reserveWorktreeName(repo, "agent")
+		done <- name
+	}()
+	select {
+	case name := <-done:
+		t.Fatalf("reserved %s while another process held the lock", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case name := <-done:
+		if name != "agent-1" {
+			t.Errorf("reserved %s, want agent-1", name)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("still waiting after the lock was released")
+	}
+}
+
+// writeReservation records a reservation of name in dir, last touched at.
+func writeReservation(t *testing.T, dir, name string, at time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("pid 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// hardSetupErrnos are failures of the environment rather than of one file:
+// setting up the rest of a new worktree would fail the same way.
+var hardSetupErrnos = []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT, syscall.EROFS, syscall.EIO}
+
+// isHardSetupError reports whether err, from setting up the new worktree at
+// dir, means the worktree can't be finished: a full disk, a read-only file
+// system, or no permission to write into dir. An unreadable source file is
+// a problem with that one file, and not hard.
+func isHardSetupError(err error, dir string) bool {
+	for _, errno := range hardSetupErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && (errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)) {
+		rel, relErr := filepath.Rel(dir, pathErr.Path)
+		return relErr == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+	}
+	return false
+}
+
+// rollbackAdd handles a hard failure while setting up the worktree called
+// name that 'wt add' just created at path. Unless keep is set or the user
+// declines, it removes the worktree, its wt state, and createdBranch (the
+// branch 'git worktree add' created for it, if any), so that the add can
+// simply be retried, and gives changes carried into it by --from-stash back
+// to their worktree. It returns cause, annotated with what was done.
+func rollbackAdd(name, path, createdBranch string, carried *carriedStash, keep bool, cause error) error {
+	err := fmt.Errorf("setting up %s failed: %w", filepath.Base(path), cause)
+	// Without a terminal, roll back without asking: nobody is there to clean
+	// up, and stdin may carry a script or the serve protocol.
+	if !keep && !nonInteractive && term.IsTerminal(int(os.Stdin.Fd())) {
+		ok, perr := promptYesNo(fmt.Sprintf("%v\nRemove the partially created worktree %s?", err, filepath.Base(path)), true)
+		keep = perr == nil && !ok
+	}
+	if keep {
+		if carried != nil {
+			return fmt.Errorf("%w; kept the partial worktree at %s with the carried changes, which are also in 'git stash list' (remove it with 'wt rm %s --force')", err, path, name)
+		}
+		return fmt.Errorf("%w; kept the partial worktree at %s (remove it with 'wt rm %s --force')", err, path, name)
+	}
+	if rmErr := removeWorktree(name, []string{"--force"}); rmErr != nil {
+		return fmt.Errorf("%w; rolling back also failed: %v (remove it with 'wt rm %s --force')", err, rmErr, name)
+	}
+	if carried != nil {
+		if rsErr := carried.restore(); rsErr != nil {
+			err = fmt.Errorf("%w; %v", err, rsErr)
+		} else {
+			fmt.Fprintf(os.Stderr, "Restored the carried changes in %s\n", filepath.Base(carried.src))
+		}
+	}
+	if createdBranch != "" {
+		if out, brErr := exec.Command("git", "branch", "-D", createdBranch).CombinedOutput(); brErr != nil {
+			warnf(fmt.Sprintf("delete it with 'git branch -D %s'", createdBranch), "failed to delete branch %s: %s", createdBranch, out)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Rolled back %s\n", filepath.Base(path))
+	return err
+}
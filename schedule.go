@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Maintenance tasks run by 'wt schedule run'.
+const (
+	taskFetch  = "fetch"
+	taskUpdate = "update"
+	taskPrune  = "prune"
+	taskGC     = "gc"
+)
+
+var maintenanceTasks = []string{taskFetch, taskUpdate, taskPrune, taskGC}
+
+const defaultScheduleAt = "03:00"
+
+func (c ScheduleConfig) at() string {
+	if c.At != "" {
+		return c.At
+	}
+	return defaultScheduleAt
+}
+
+func (c ScheduleConfig) tasks() []string {
+	if len(c.Tasks) > 0 {
+		return c.Tasks
+	}
+	return maintenanceTasks
+}
+
+// scheduleTime parses an "HH:MM" time of day.
+func scheduleTime(at string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q; use HH:MM", at)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// scheduleName names the timer of the repository rooted at mainRoot after
+// its state directory, so each repository gets its own.
+func scheduleName(mainRoot string) (string, error) {
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	return "wt-maintain-" + filepath.Base(repoDir), nil
+}
+
+// runMaintenance runs the given tasks for the repository rooted at mainRoot.
+// A failing task is reported and the others still run.
+func runMaintenance(mainRoot string, cfg *Config, tasks []string) error {
+	fmt.Printf("wt maintenance of %s at %s\n", mainRoot, time.Now().Format(time.DateTime))
+	failed := 0
+	for _, task := range tasks {
+		if isOffline(cfg) && (task == taskFetch || task == taskUpdate) {
+			fmt.Printf("%s: skipped (offline)\n", task)
+			continue
+		}
+		var err error
+		switch task {
+		case taskFetch:
+			err = maintainFetch(mainRoot, cfg)
+		case taskUpdate:
+			err = maintainUpdate(mainRoot)
+		case taskPrune:
+			err = runMaintenanceCommand(exec.Command("git", "-C", mainRoot, "worktree", "prune", "--verbose"))
+		case taskGC:
+			err = maintainGC(mainRoot)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", task, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+func runMaintenanceCommand(cmd *exec.Cmd) error {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stdout
+	return cmd.Run()
+}
+
+func maintainFetch(mainRoot string, cfg *Config) error {
+	remotes := gitRemotes()
+	if len(remotes) == 0 {
+		fmt.Printf("%s: skipped (no remote)\n", taskFetch)
+		return nil
+	}
+	return fetchRemotes(mainRoot, remotes, cfg.Add.fetchMaxAge(), cfg.Add.fetchTimeout(), detectCloneShape().fetchArgs(cfg.Add))
+}
+
+// maintainUpdate fast-forwards the branch of every clean worktree to its
+// upstream. Dirty, detached, and diverged worktrees are left alone.
+func maintainUpdate(mainRoot string) error {
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return err
+	}
+	worktrees = append([]siblingWorktree{{name: filepath.Base(mainRoot), path: mainRoot}}, worktrees...)
+	for _, wt := range worktrees {
+		st := getWorktreeStatus(wt.path)
+		switch {
+		case st.branch == "":
+			fmt.Printf("%s: %s: skipped (detached)\n", taskUpdate, wt.name)
+			continue
+		case st.dirty:
+			fmt.Printf("%s: %s: skipped (uncommitted changes)\n", taskUpdate, wt.name)
+			continue
+		}
+		if exec.Command("git", "-C", wt.path, "rev-parse", "--verify", "--quiet", "@{upstream}").Run() != nil {
+			fmt.Printf("%s: %s: skipped (no upstream)\n", taskUpdate, wt.name)
+			continue
+		}
+		if out, err := exec.Command("git", "-C", wt.path, "merge", "--ff-only", "@{upstream}").CombinedOutput(); err != nil {
+			fmt.Printf("%s: %s: not fast-forwarded: %s\n", taskUpdate, wt.name, lastLine(string(out)))
+			continue
+		}
+		fmt.Printf("%s: %s: up to date with upstream\n", taskUpdate, wt.name)
+	}
+	return nil
+}
+
+// maintainGC compacts the repository and removes dangling docker images
+// left behind by devcontainer rebuilds.
+func maintainGC(mainRoot string) error {
+	if err := runMaintenanceCommand(exec.Command("git", "-C", mainRoot, "gc", "--auto")); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil
+	}
+	return runMaintenanceCommand(exec.Command("docker", "image", "prune", "--force"))
+}
+
+var systemdServiceTemplate = template.Must(template.New("service").Parse(`[Unit]
+Description=wt maintenance of {{.Root}}
+
+[Service]
+Type=oneshot
+WorkingDirectory={{.Root}}
+ExecStart={{.Exe}} --non-interactive schedule run
+`))
+
+var systemdTimerTemplate = template.Must(template.New("timer").Parse(`[Unit]
+Description=Daily wt maintenance of {{.Root}}
+
+[Timer]
+OnCalendar=*-*-* {{printf "%02d:%02d" .Hour .Minute}}:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+var launchdTemplate = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>Label</key>
+  <string>{{.Name}}</string>
+  <key>ProgramArguments</key>
+  <array>
+    <string>{{.Exe}}</string>
+    <string>--non-interactive</string>
+    <string>schedule</string>
+    <string>run</string>
+  </array>
+  <key>WorkingDirectory</key>
+  <string>{{.Root}}</string>
+  <key>StartCalendarInterval</key>
+  <dict>
+    <key>Hour</key>
+    <integer>{{.Hour}}</integer>
+    <key>Minute</key>
+    <integer>{{.Minute}}</integer>
+  </dict>
+  <key>StandardOutPath</key>
+  <string>{{.Log}}</string>
+  <key>StandardErrorPath</key>
+  <string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+type scheduleUnit struct {
+	Name, Root, Exe, Log string
+	Hour, Minute         int
+}
+
+// scheduleFiles returns the unit files wt writes for the repository's timer
+// on this platform.
+func scheduleFiles(name string) ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			configDir = filepath.Join(home, ".config")
+		}
+		dir := filepath.Join(configDir, "systemd", "user")
+		return []string{filepath.Join(dir, name+".service"), filepath.Join(dir, name+".timer")}, nil
+	case "darwin":
+		return []string{filepath.Join(home, "Library", "LaunchAgents", name+".plist")}, nil
+	}
+	return nil, fmt.Errorf("wt schedule supports systemd (Linux) and launchd (macOS), not %s", runtime.GOOS)
+}
+
+func runScheduleInstall(mainRoot, at string) error {
+	hour, minute, err := scheduleTime(at)
+	if err != nil {
+		return err
+	}
+	name, err := scheduleName(mainRoot)
+	if err != nil {
+		return err
+	}
+	files, err := scheduleFiles(name)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the wt binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		return err
+	}
+	unit := scheduleUnit{Name: name, Root: mainRoot, Exe: exe, Log: filepath.Join(repoDir, "maintenance.log"), Hour: hour, Minute: minute}
+	templates := []*template.Template{systemdServiceTemplate, systemdTimerTemplate}
+	if runtime.GOOS == "darwin" {
+		templates = []*template.Template{launchdTemplate}
+	}
+	for i, path := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		var b strings.Builder
+		if err := templates[i].Execute(&b, unit); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	var cmds [][]string
+	if runtime.GOOS == "darwin" {
+		cmds = [][]string{{"launchctl", "unload", files[0]}, {"launchctl", "load", "-w", files[0]}}
+	} else {
+		cmds = [][]string{{"systemctl", "--user", "daemon-reload"}, {"systemctl", "--user", "enable", "--now", name + ".timer"}}
+	}
+	for i, argv := range cmds {
+		out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+		// Unloading a job that was never loaded fails harmlessly.
+		if err != nil && !(runtime.GOOS == "darwin" && i == 0) {
+			return fmt.Errorf("%s failed: %s", strings.Join(argv, " "), strings.TrimSpace(string(out)))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Scheduled daily maintenance of %s at %02d:%02d (%s)\n", filepath.Base(mainRoot), hour, minute, strings.Join(files, ", "))
+	return nil
+}
+
+func runScheduleUninstall(mainRoot string) error {
+	name, err := scheduleName(mainRoot)
+	if err != nil {
+		return err
+	}
+	files, err := scheduleFiles(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(files[0]); os.IsNotExist(err) {
+		return fmt.Errorf("no maintenance schedule installed for %s", filepath.Base(mainRoot))
+	}
+	if runtime.GOOS == "darwin" {
+		_ = exec.Command("launchctl", "unload", "-w", files[0]).Run()
+	} else {
+		_ = exec.Command("systemctl", "--user", "disable", "--now", name+".timer").Run()
+	}
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if runtime.GOOS != "darwin" {
+		_ = exec.Command("systemctl", "--user", "daemon-reload").Run()
+	}
+	fmt.Fprintf(os.Stderr, "Removed the maintenance schedule of %s\n", filepath.Base(mainRoot))
+	return nil
+}
+
+func runScheduleStatus(mainRoot string) error {
+	name, err := scheduleName(mainRoot)
+	if err != nil {
+		return err
+	}
+	files, err := scheduleFiles(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(files[0]); os.IsNotExist(err) {
+		fmt.Printf("No maintenance schedule installed for %s\n", filepath.Base(mainRoot))
+		return nil
+	}
+	fmt.Printf("Installed: %s\n", strings.Join(files, ", "))
+	statusCmd := exec.Command("systemctl", "--user", "list-timers", name+".timer")
+	if runtime.GOOS == "darwin" {
+		statusCmd = exec.Command("launchctl", "list", name)
+	}
+	if err := runMaintenanceCommand(statusCmd); err != nil {
+		warnf("", "%s failed: %v", strings.Join(statusCmd.Args, " "), err)
+	}
+	return nil
+}
+
+// validateTasks checks maintenance task names.
+func validateTasks(tasks []string) error {
+	for _, t := range tasks {
+		if !slices.Contains(maintenanceTasks, t) {
+			return fmt.Errorf("unknown maintenance task %q (known: %s)", t, strings.Join(maintenanceTasks, ", "))
+		}
+	}
+	return nil
+}
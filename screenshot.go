@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// screenshotOptions are the flags shared by 'wt screenshot' and
+// 'wt visual-diff'.
+type screenshotOptions struct {
+	width, height int
+	wait          time.Duration // virtual time the page gets to render
+}
+
+// screenshotOptionsFromFlags reads screenshotOptions from cmd's flags.
+func screenshotOptionsFromFlags(cmd *cobra.Command) (screenshotOptions, error) {
+	var opts screenshotOptions
+	size, _ := cmd.Flags().GetString("size")
+	var err error
+	if opts.width, opts.height, err = parseWindowSize(size); err != nil {
+		return opts, err
+	}
+	opts.wait, _ = cmd.Flags().GetDuration("wait")
+	return opts, nil
+}
+
+// parseWindowSize parses a WIDTHxHEIGHT size such as 1280x800.
+func parseWindowSize(s string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if !ok || err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q: expected WIDTHxHEIGHT, e.g. 1280x800", s)
+	}
+	return width, height, nil
+}
+
+// screenshotURL returns the URL to load in the worktree at dir: arg itself,
+// or for a path such as /login, that path on the devcontainer's default URL.
+func screenshotURL(dir, arg string) string {
+	if strings.HasPrefix(arg, "/") {
+		return strings.TrimSuffix(getDefaultURL(dir), "/") + arg
+	}
+	return normalizeLocalhostURL(arg)
+}
+
+// screenshotProfile returns the Chrome profile to render with: the
+// worktree's own, so pages see its cookies and logins, unless a 'wt chrome'
+// window has it open. Then it is a fresh temporary profile, removed by the
+// returned cleanup.
+func screenshotProfile(dir string) (string, func(), error) {
+	profileDir := filepath.Join(dir, ".chrome-profile")
+	if _, err := os.Lstat(filepath.Join(profileDir, "SingletonLock")); err != nil {
+		if err := os.MkdirAll(profileDir, 0755); err != nil {
+			return "", nil, fmt.Errorf("failed to create Chrome profile directory: %w", err)
+		}
+		return profileDir, func() {}, nil
+	}
+	fmt.Fprintf(os.Stderr, "Chrome has the profile of %s open; using a fresh profile\n", filepath.Base(dir))
+	tmp, err := os.MkdirTemp("", "wt-screenshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	return tmp, func() { os.RemoveAll(tmp) }, nil
+}
+
+// takeScreenshot renders url in headless Chrome through the proxy of the
+// worktree at dir and saves a PNG of the window to out.
+func takeScreenshot(dir, url, out string, opts screenshotOptions) error {
+	chromeBin, err := findChromeBinary()
+	if err != nil {
+		return err
+	}
+	proxy, err := requireLiveProxy(dir)
+	if err != nil {
+		return err
+	}
+	profileDir, cleanup, err := screenshotProfile(dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	// Chrome leaves an older file alone if it fails, which would pass for
+	// a fresh screenshot.
+	os.Remove(out)
+	chromeArgs := []string{
+		"--headless=new",
+		"--user-data-dir=" + profileDir,
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--disable-sync",
+		"--hide-scrollbars",
+		"--proxy-server=" + proxy.proxyServer(),
+		"--proxy-bypass-list=<-loopback>",
+		fmt.Sprintf("--window-size=%d,%d", opts.width, opts.height),
+		"--screenshot=" + out,
+	}
+	if opts.wait > 0 {
+		chromeArgs = append(chromeArgs, fmt.Sprintf("--virtual-time-budget=%d", opts.wait.Milliseconds()))
+	}
+	chromeArgs = append(chromeArgs, url)
+	if verbose {
+		quotedArgs := make([]string, len(chromeArgs))
+		for i, arg := range chromeArgs {
+			quotedArgs[i] = strconv.Quote(arg)
+		}
+		fmt.Fprintf(os.Stderr, "Launching Chrome: %s %s\n", strconv.Quote(chromeBin), strings.Join(quotedArgs, " "))
+	}
+	output, err := exec.Command(chromeBin, chromeArgs...).CombinedOutput()
+	if _, statErr := os.Stat(out); err != nil || statErr != nil {
+		return fmt.Errorf("headless Chrome did not save a screenshot of %s: %s", url, strings.TrimSpace(lastLine(string(output))))
+	}
+	return nil
+}
+
+// runScreenshot implements 'wt screenshot'.
+func runScreenshot(dir, arg, out string, opts screenshotOptions) error {
+	if out == "" {
+		var err error
+		if out, err = newArtifactPath(dir, "screenshots", ".png"); err != nil {
+			return err
+		}
+	}
+	url := screenshotURL(dir, arg)
+	if err := takeScreenshot(dir, url, out, opts); err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// diffImages compares a and b pixel by pixel. A pixel differs when a color
+// channel differs by more than tolerance (0-255), or when it lies outside
+// one of the images. It returns an image of b's dimmed, grayscale pixels
+// with the differing ones in red, how many differ, and the rectangle
+// around them.
+func diffImages(a, b image.Image, tolerance int) (*image.RGBA, int, image.Rectangle) {
+	ab, bb := a.Bounds(), b.Bounds()
+	bounds := image.Rect(0, 0, max(ab.Dx(), bb.Dx()), max(ab.Dy(), bb.Dy()))
+	diff := image.NewRGBA(bounds)
+	changed := 0
+	var box image.Rectangle
+	red := color.RGBA{R: 255, A: 255}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			pa, pb := image.Pt(ab.Min.X+x, ab.Min.Y+y), image.Pt(bb.Min.X+x, bb.Min.Y+y)
+			inA, inB := pa.In(ab), pb.In(bb)
+			same := inA && inB
+			if same {
+				r1, g1, b1, a1 := a.At(pa.X, pa.Y).RGBA()
+				r2, g2, b2, a2 := b.At(pb.X, pb.Y).RGBA()
+				for _, d := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+					if delta := int(d[0]>>8) - int(d[1]>>8); delta > tolerance || -delta > tolerance {
+						same = false
+						break
+					}
+				}
+			}
+			if !same {
+				diff.Set(x, y, red)
+				changed++
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+				continue
+			}
+			gray := color.GrayModel.Convert(b.At(pb.X, pb.Y)).(color.Gray)
+			// Dim the unchanged page so that the red stands out.
+			v := 128 + gray.Y/2
+			diff.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return diff, changed, box
+}
+
+// runVisualDiff implements 'wt visual-diff': it screenshots url in the
+// worktrees at a and b and writes their screenshots and an image of the
+// differences next to out (by default in b's artifacts directory). It exits
+// 1 when more than threshold percent of the pixels differ.
+func runVisualDiff(a, b, arg, out string, opts screenshotOptions, tolerance int, threshold float64) error {
+	if out == "" {
+		var err error
+		if out, err = newArtifactPath(b, "visual-diff", ".png"); err != nil {
+			return err
+		}
+	}
+	stem := strings.TrimSuffix(out, filepath.Ext(out))
+	shots := [2]string{stem + "-" + filepath.Base(a) + ".png", stem + "-" + filepath.Base(b) + ".png"}
+	if shots[0] == shots[1] {
+		shots = [2]string{stem + "-a.png", stem + "-b.png"}
+	}
+	var imgs [2]image.Image
+	for i, dir := range []string{a, b} {
+		if err := takeScreenshot(dir, screenshotURL(dir, arg), shots[i], opts); err != nil {
+			return err
+		}
+		img, err := readPNG(shots[i])
+		if err != nil {
+			return err
+		}
+		imgs[i] = img
+	}
+	diff, changed, box := diffImages(imgs[0], imgs[1], tolerance)
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, diff); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	total := diff.Bounds().Dx() * diff.Bounds().Dy()
+	percent := 100 * float64(changed) / float64(total)
+	fmt.Printf("%s: %s, %s\n", out, shots[0], shots[1])
+	if changed == 0 {
+		fmt.Println("no pixels differ")
+		return nil
+	}
+	fmt.Printf("%d of %d pixels differ (%.2f%%), within x %d-%d, y %d-%d\n",
+		changed, total, percent, box.Min.X, box.Max.X-1, box.Min.Y, box.Max.Y-1)
+	if percent > threshold {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selftest runs wt commands against a scratch repository and records the
+// results of each check.
+type selftest struct {
+	wtBin    string
+	root     string // temp directory holding the repo and its worktrees
+	repo     string // main repository
+	env      []string
+	failures int
+}
+
+// run executes wt with args in dir and returns its combined output.
+func (t *selftest) run(dir string, args ...string) (string, error) {
+	c := exec.Command(t.wtBin, args...)
+	c.Dir = dir
+	c.Env = t.env
+	c.Stdin = nil
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.String(), err
+}
+
+// check reports a named step, printing the output of failed steps.
+func (t *selftest) check(name string, err error, output string) {
+	if err == nil {
+		fmt.Printf("PASS  %s\n", name)
+		return
+	}
+	t.failures++
+	fmt.Printf("FAIL  %s: %v\n", name, err)
+	if output = strings.TrimSpace(output); output != "" {
+		for _, line := range strings.Split(output, "\n") {
+			fmt.Printf("      | %s\n", line)
+		}
+	}
+}
+
+func (t *selftest) git(args ...string) error {
+	c := exec.Command("git", args...)
+	c.Dir = t.repo
+	c.Env = t.env
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// runSelftest exercises add/ls/exec/name/which/rm in a temporary repository,
+// plus the devcontainer path when withDocker is set and the tools exist.
+func runSelftest(withDocker, keep bool) error {
+	wtBin, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate the wt binary: %w", err)
+	}
+	root, err := os.MkdirTemp("", "wt-selftest-")
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return err
+	}
+	if keep {
+		fmt.Printf("Scratch directory: %s\n", root)
+	} else {
+		defer os.RemoveAll(root)
+	}
+
+	t := &selftest{
+		wtBin: wtBin,
+		root:  root,
+		repo:  filepath.Join(root, "selftest"),
+		env: append(os.Environ(),
+			"XDG_STATE_HOME="+filepath.Join(root, "state"),
+			"GIT_AUTHOR_NAME=wt selftest", "GIT_AUTHOR_EMAIL=selftest@example.com",
+			"GIT_COMMITTER_NAME=wt selftest", "GIT_COMMITTER_EMAIL=selftest@example.com",
+			cdFileEnv+"=",
+		),
+	}
+	start := time.Now()
+
+	err = os.MkdirAll(t.repo, 0755)
+	if err == nil {
+		err = t.git("init", "-q")
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.repo, "README"), []byte("selftest\n"), 0644)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.repo, ".env"), []byte("SELFTEST=1\n"), 0644)
+	}
+	if err == nil {
+		err = t.git("add", "README")
+	}
+	if err == nil {
+		err = t.git("commit", "-q", "-m", "selftest")
+	}
+	t.check("create scratch repository", err, "")
+	if err != nil {
+		return fmt.Errorf("selftest could not set up a repository")
+	}
+
+	alpha := filepath.Join(root, "selftest@alpha")
+	out, err := t.run(t.repo, "add", "alpha", "--no-bootstrap")
+	if err == nil {
+		if _, statErr := os.Stat(filepath.Join(alpha, "README")); statErr != nil {
+			err = fmt.Errorf("worktree directory was not created")
+		}
+	}
+	t.check("add creates a sibling worktree", err, out)
+
+	_, statErr := os.Stat(filepath.Join(alpha, ".env"))
+	t.check("add copies .env files", statErr, "")
+
+	out, err = t.run(t.repo, "ls", "-q")
+	if err == nil && strings.TrimSpace(out) != "alpha" {
+		err = fmt.Errorf("expected \"alpha\", got %q", strings.TrimSpace(out))
+	}
+	t.check("ls lists the worktree", err, out)
+
+	out, err = t.run(alpha, "name")
+	if err == nil && strings.TrimSpace(out) != "alpha" {
+		err = fmt.Errorf("expected \"alpha\", got %q", strings.TrimSpace(out))
+	}
+	t.check("name reports the current worktree", err, out)
+
+	out, err = t.run(t.repo, "which", "--name", filepath.Join(alpha, "README"))
+	if err == nil && strings.TrimSpace(out) != "alpha" {
+		err = fmt.Errorf("expected \"alpha\", got %q", strings.TrimSpace(out))
+	}
+	t.check("which maps a path to its worktree", err, out)
+
+	out, err = t.run(t.repo, "exec", "alpha", "--", "pwd")
+	if err == nil && strings.TrimSpace(out) != alpha {
+		err = fmt.Errorf("expected %q, got %q", alpha, strings.TrimSpace(out))
+	}
+	t.check("exec runs in the worktree", err, out)
+
+	if withDocker {
+		t.dockerChecks()
+	}
+
+	// The copied .env is untracked, so removal needs --force.
+	out, err = t.run(t.repo, "rm", "alpha", "--force")
+	if err == nil {
+		if _, statErr := os.Stat(alpha); statErr == nil {
+			err = fmt.Errorf("worktree directory still exists")
+		}
+	}
+	t.check("rm removes the worktree", err, out)
+
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if t.failures > 0 {
+		fmt.Printf("\n%d check(s) failed in %s\n", t.failures, elapsed)
+		return &exitCodeError{code: 1}
+	}
+	fmt.Printf("\nAll checks passed in %s\n", elapsed)
+	return nil
+}
+
+// dockerChecks exercises the devcontainer path: init, up, exec, proxy-port,
+// and down. It is skipped when docker or the devcontainer CLI is missing.
+func (t *selftest) dockerChecks() {
+	for _, tool := range []string{"docker", "devcontainer"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			fmt.Printf("SKIP  devcontainer checks: %s not found\n", tool)
+			return
+		}
+	}
+	out, err := t.run(t.repo, "init")
+	if err == nil {
+		if err = t.git("add", ".devcontainer"); err == nil {
+			err = t.git("commit", "-q", "-m", "devcontainer")
+		}
+	}
+	t.check("init scaffolds .devcontainer", err, out)
+	if err != nil {
+		return
+	}
+
+	beta := filepath.Join(t.root, "selftest@beta")
+	out, err = t.run(t.repo, "add", "beta", "--up", "--no-bootstrap")
+	t.check("add --up starts the devcontainer", err, out)
+	if err == nil {
+		out, err = t.run(t.repo, "exec", "beta", "--", "true")
+		t.check("exec runs inside the devcontainer", err, out)
+
+		out, err = t.run(t.repo, "proxy-port", "beta")
+		t.check("proxy-port finds the SOCKS5 port", err, out)
+
+		out, err = t.run(t.repo, "down", "beta")
+		t.check("down removes the devcontainer", err, out)
+	}
+	out, err = t.run(t.repo, "rm", "beta", "--force")
+	if err == nil {
+		if _, statErr := os.Stat(beta); statErr == nil {
+			err = fmt.Errorf("worktree directory still exists")
+		}
+	}
+	t.check("rm removes the devcontainer worktree", err, out)
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// rpcRequest is one line of input to 'wt serve --stdio' (JSON-RPC 2.0 shaped).
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcWorktreeParams names the worktree a request targets; empty means the
+// worktree serve was started in.
+type rpcWorktreeParams struct {
+	Name string `json:"name"`
+}
+
+type rpcAddParams struct {
+	Name   string `json:"name"`
+	Base   string `json:"base"`
+	Branch string `json:"branch"`
+	Like   string `json:"like"`
+}
+
+type rpcRemoveParams struct {
+	Name  string `json:"name"`
+	Force bool   `json:"force"`
+}
+
+type rpcExecParams struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	Stdin   string   `json:"stdin"`
+}
+
+type rpcWorktree struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type rpcExecResult struct {
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// rpcAPIVersion is bumped when a method's params or result change
+// incompatibly, so clients such as the VS Code extension can check it.
+const rpcAPIVersion = 1
+
+// rpcWorktreeInfo is the detailed view of a worktree returned by "worktrees".
+type rpcWorktreeInfo struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Main      bool   `json:"main"`
+	Current   bool   `json:"current"`
+	Branch    string `json:"branch,omitempty"`
+	Head      string `json:"head,omitempty"`
+	Dirty     bool   `json:"dirty"`
+	Container string `json:"container"`
+	ProxyPort string `json:"proxyPort,omitempty"`
+}
+
+type rpcContainerInfo struct {
+	State       string `json:"state"`
+	ContainerID string `json:"containerId,omitempty"`
+	ProxyPort   string `json:"proxyPort,omitempty"`
+}
+
+type rpcOpenResult struct {
+	FolderURI string `json:"folderUri"`
+	ProxyPort string `json:"proxyPort,omitempty"`
+}
+
+// rpcMethods maps method names to their handlers. It is filled in init
+// because "api" reports the method list itself.
+var rpcMethods map[string]func(params json.RawMessage) (any, error)
+
+func init() {
+	rpcMethods = map[string]func(params json.RawMessage) (any, error){
+		"api":        rpcAPI,
+		"worktrees":  rpcWorktrees,
+		"container":  rpcContainer,
+		"open":       rpcOpen,
+		"ls":         rpcList,
+		"add":        rpcAdd,
+		"rm":         rpcRemove,
+		"exec":       rpcExec,
+		"proxy-port": rpcProxyPort,
+	}
+}
+
+// runServeStdio reads one JSON request per line from in and writes one JSON
+// response per line to out. Requests are handled one at a time. While a
+// request runs, anything wt or its children would print to stdout goes to
+// stderr so the response stream stays clean, and they read stdin from
+// /dev/null so that a hook cannot consume the requests.
+func runServeStdio(in io.Reader, out *os.File) error {
+	// Nothing may prompt: stdin carries the requests.
+	nonInteractive = true
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+	if devNull, err := os.Open(os.DevNull); err == nil {
+		stdin := os.Stdin
+		os.Stdin = devNull
+		defer func() {
+			os.Stdin = stdin
+			devNull.Close()
+		}()
+	}
+
+	enc := json.NewEncoder(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		resp := handleRPC(line)
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func handleRPC(line []byte) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+		return resp
+	}
+	if len(req.ID) > 0 {
+		resp.ID = req.ID
+	}
+	handler, ok := rpcMethods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+		return resp
+	}
+	result, err := handler(req.Params)
+	if err != nil {
+		code := rpcServerError
+		var paramsErr *rpcParamsError
+		if errors.As(err, &paramsErr) {
+			code = rpcInvalidParams
+		}
+		resp.Error = &rpcError{Code: code, Message: err.Error()}
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+type rpcParamsError struct{ err error }
+
+func (e *rpcParamsError) Error() string { return "invalid params: " + e.err.Error() }
+
+func decodeParams(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &rpcParamsError{err}
+	}
+	return nil
+}
+
+// rpcWorktreeDir resolves a request's worktree name to its directory.
+func rpcWorktreeDir(name string) (string, error) {
+	if name == "" || name == "." {
+		return getCurrentWorktreeRoot()
+	}
+	resolved, err := resolveNameArg(name)
+	if err != nil {
+		return "", err
+	}
+	dir, err := resolveWorktreePath(resolved)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return "", fmt.Errorf("worktree %q does not exist", name)
+	}
+	return dir, nil
+}
+
+func rpcList(params json.RawMessage) (any, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return nil, err
+	}
+	result := []rpcWorktree{}
+	for _, wt := range worktrees {
+		result = append(result, rpcWorktree{Name: wt.name, Path: wt.path})
+	}
+	return result, nil
+}
+
+func rpcAdd(params json.RawMessage) (any, error) {
+	var p rpcAddParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		if p.Name, err = generateWorktreeName(cfg.Add.NamePattern, cfg.Naming); err != nil {
+			return nil, err
+		}
+	}
+	opts := addOptions{like: p.Like, base: p.Base, branch: p.Branch}
+	if err := addWorktree(p.Name, opts); err != nil {
+		return nil, err
+	}
+	if err := finishAdd(p.Name, opts); err != nil {
+		return nil, err
+	}
+	dir, err := resolveWorktreePath(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	return rpcWorktree{Name: p.Name, Path: dir}, nil
+}
+
+func rpcRemove(params json.RawMessage) (any, error) {
+	var p rpcRemoveParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, &rpcParamsError{fmt.Errorf("name is required")}
+	}
+	name, err := resolveExactNameArg(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	var gitArgs []string
+	if p.Force {
+		gitArgs = append(gitArgs, "--force")
+	}
+	if err := removeNamed(name, gitArgs, removeOptions{yes: true, quiet: true}); err != nil {
+		return nil, err
+	}
+	return map[string]string{"name": name}, nil
+}
+
+func rpcExec(params json.RawMessage) (any, error) {
+	var p rpcExecParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Command) == 0 {
+		return nil, &rpcParamsError{fmt.Errorf("command is required")}
+	}
+	dir, err := rpcWorktreeDir(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	// A child 'wt exec' applies exec.env, credentials, and the rest exactly
+	// as on the command line, without touching serve's own environment.
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(exe, append([]string{"--non-interactive", "exec", dir}, p.Command...)...)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	c.Stdin = bytes.NewReader([]byte(p.Stdin))
+	result := rpcExecResult{}
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+func rpcProxyPort(params json.RawMessage) (any, error) {
+	var p rpcWorktreeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dir, err := rpcWorktreeDir(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	port, err := getProxyPort(dir)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"port": port}, nil
+}
+
+func rpcAPI(params json.RawMessage) (any, error) {
+	methods := make([]string, 0, len(rpcMethods))
+	for name := range rpcMethods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	return map[string]any{"version": rpcAPIVersion, "methods": methods}, nil
+}
+
+// rpcWorktrees lists every worktree of the repository, including the main
+// one, with its git and container state.
+func rpcWorktrees(params json.RawMessage) (any, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return nil, err
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := getCurrentWorktreeRoot()
+	states := containerStates()
+	all := append([]siblingWorktree{{name: "", path: mainRoot}}, worktrees...)
+	result := make([]rpcWorktreeInfo, 0, len(all))
+	for _, wt := range all {
+		st := getWorktreeStatus(wt.path)
+		info := rpcWorktreeInfo{
+			Name:      wt.name,
+			Path:      wt.path,
+			Main:      wt.path == mainRoot,
+			Current:   normalizePathForCompare(wt.path) == normalizePathForCompare(current),
+			Branch:    st.branch,
+			Head:      st.head,
+			Dirty:     st.dirty,
+			Container: containerStateOrNone(states, wt.path),
+		}
+		if info.Container == "running" {
+			info.ProxyPort, _ = getProxyPort(wt.path)
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func containerStateOrNone(states map[string]string, dir string) string {
+	if state, ok := states[dir]; ok {
+		return state
+	}
+	return "none"
+}
+
+func rpcContainer(params json.RawMessage) (any, error) {
+	var p rpcWorktreeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dir, err := rpcWorktreeDir(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	info := rpcContainerInfo{State: containerStateOrNone(containerStates(), dir)}
+	if id, err := getContainerID(dir); err == nil {
+		info.ContainerID = id
+		info.ProxyPort, _ = getProxyPort(dir)
+	}
+	return info, nil
+}
+
+// rpcOpen returns the URI an editor should open for a worktree instead of
+// launching one: a vscode-remote URI attached to its devcontainer (started
+// if needed), or a file URI when the worktree has no devcontainer.
+func rpcOpen(params json.RawMessage) (any, error) {
+	var p rpcWorktreeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dir, err := rpcWorktreeDir(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".devcontainer", "devcontainer.json")); err != nil {
+		return rpcOpenResult{FolderURI: (&url.URL{Scheme: "file", Path: dir}).String()}, nil
+	}
+	uri, err := devcontainerFolderURI(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := getProxyPort(dir)
+	return rpcOpenResult{FolderURI: uri, ProxyPort: port}, nil
+}
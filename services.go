@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// composeProject returns the docker compose project of the worktree's
+// devcontainer, or an error for devcontainers that are not compose-based.
+func composeProject(dir string) (string, error) {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command("docker", "inspect", "--format", `{{index .Config.Labels "com.docker.compose.project"}}`, containerID).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect the devcontainer: %w", err)
+	}
+	project := strings.TrimSpace(string(out))
+	if project == "" {
+		return "", fmt.Errorf("the devcontainer of %s is a single container; --service needs a dockerComposeFile setup", filepath.Base(dir))
+	}
+	return project, nil
+}
+
+// composeServices returns the service names of the worktree's compose
+// project.
+func composeServices(project string) []string {
+	out, err := exec.Command("docker", "ps", "-a", "--filter", "label=com.docker.compose.project="+project,
+		"--format", `{{.Label "com.docker.compose.service"}}`).Output()
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var services []string
+	for _, s := range strings.Fields(string(out)) {
+		if !seen[s] {
+			seen[s] = true
+			services = append(services, s)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// serviceContainerID finds the container of a compose service next to the
+// worktree's devcontainer, e.g. its "db" or "worker".
+func serviceContainerID(dir, service string) (string, error) {
+	project, err := composeProject(dir)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command("docker", "ps", "-aq",
+		"--filter", "label=com.docker.compose.project="+project,
+		"--filter", "label=com.docker.compose.service="+service).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query docker: %w", err)
+	}
+	containerID := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	if containerID == "" {
+		return "", fmt.Errorf("no service %q in %s's compose project (services: %s)", service, filepath.Base(dir), strings.Join(composeServices(project), ", "))
+	}
+	return containerID, nil
+}
+
+// targetContainerID returns the container of the given compose service, or
+// the worktree's devcontainer when service is empty.
+func targetContainerID(dir, service string) (string, error) {
+	if service == "" {
+		return getContainerID(dir)
+	}
+	return serviceContainerID(dir, service)
+}
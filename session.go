@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workspaceFolderEnv carries the worktree's workspace folder in the
+// container into 'wt exec' sessions, next to worktreeNameEnv, so that wt
+// running in the container knows which worktree it is in.
+const workspaceFolderEnv = "WT_WORKSPACE_FOLDER"
+
+// execSessionEnv returns the env that 'wt exec' injects into a command in the
+// devcontainer of the worktree at dir.
+func execSessionEnv(dir string, dcConfig *devcontainerConfig) []string {
+	return []string{
+		worktreeNameEnv + "=" + filepath.Base(dir),
+		workspaceFolderEnv + "=" + dcConfig.remoteWorkspaceFolder(dir),
+		artifactsEnv + "=" + artifactsContainerPath,
+	}
+}
+
+// sessionWorktree returns the worktree directory name ("repo@name") and
+// workspace folder injected by 'wt exec', when the current directory is in
+// that folder. Inside a container, git may not resolve the main repository
+// (its common dir is a host path), so this env is the reliable source.
+func sessionWorktree() (base, folder string, ok bool) {
+	base, folder = os.Getenv(worktreeNameEnv), os.Getenv(workspaceFolderEnv)
+	if base == "" || folder == "" {
+		return "", "", false
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", "", false
+	}
+	rel, err := filepath.Rel(folder, cwd)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", false
+	}
+	return base, folder, true
+}
+
+// sessionWorktreeName returns the worktree name from a 'wt exec' session's
+// env; see sessionWorktree.
+func sessionWorktreeName() (string, bool, error) {
+	base, _, ok := sessionWorktree()
+	if !ok {
+		return "", false, nil
+	}
+	_, name, found := strings.Cut(base, worktreeDelimiter)
+	if !found || name == "" {
+		return "", true, fmt.Errorf("currently in the main worktree, not a named worktree")
+	}
+	return name, true, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	shareModeLink  = "link"
+	shareModeMount = "mount"
+)
+
+// sharedDir returns (and creates) the directory that holds the shared copy
+// of the worktree-relative path rel for every worktree of the repository.
+func sharedDir(rel string) (string, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(repoDir, "shared", filepath.FromSlash(rel))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create shared directory: %w", err)
+	}
+	return dir, nil
+}
+
+// linkSharedDirs replaces each share entry in link mode with a symlink to
+// its shared directory in the new worktree at dir. A path the checkout
+// already has is left alone. The links are added to the repository's
+// info/exclude, since ignore patterns such as "node_modules/" only match
+// directories.
+func linkSharedDirs(dir string, entries []ShareEntry) error {
+	var linked []string
+	for _, e := range entries {
+		if e.mode() != shareModeLink {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if _, err := os.Lstat(target); err == nil {
+			warnf(fmt.Sprintf("remove it from the branch or from share in %s", projectConfigFile), "%s already exists in %s; not sharing it", e.Path, filepath.Base(dir))
+			continue
+		}
+		shared, err := sharedDir(e.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(shared, target); err != nil {
+			return fmt.Errorf("failed to link %s: %w", e.Path, err)
+		}
+		linked = append(linked, e.Path)
+	}
+	if len(linked) == 0 {
+		return nil
+	}
+	return excludePaths(dir, linked)
+}
+
+// excludePaths adds the worktree-relative paths to the info/exclude file
+// shared by all worktrees of the repository at dir, unless already there.
+func excludePaths(dir string, paths []string) error {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return fmt.Errorf("failed to locate the git directory: %w", err)
+	}
+	exclude := filepath.Join(strings.TrimSpace(string(out)), "info", "exclude")
+	data, err := os.ReadFile(exclude)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	have := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		have[strings.TrimSpace(line)] = true
+	}
+	var add strings.Builder
+	for _, p := range paths {
+		pattern := "/" + strings.TrimPrefix(p, "/")
+		if !have[pattern] {
+			add.WriteString(pattern + "\n")
+		}
+	}
+	if add.Len() == 0 {
+		return nil
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(exclude), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(exclude, append(data, add.String()...), 0644)
+}
+
+// shareMountArgs returns the 'devcontainer up' arguments that bind-mount the
+// shared directory of each share entry in mount mode over its path in the
+// container's workspace folder.
+func shareMountArgs(dir string, entries []ShareEntry) ([]string, error) {
+	var args []string
+	var dcConfig *devcontainerConfig
+	for _, e := range entries {
+		if e.mode() != shareModeMount {
+			continue
+		}
+		if dcConfig == nil {
+			var err error
+			if dcConfig, err = readDevcontainerConfig(dir); err != nil {
+				return nil, err
+			}
+			if dcConfig == nil {
+				dcConfig = &devcontainerConfig{}
+			}
+		}
+		shared, err := sharedDir(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		target := dcConfig.remoteWorkspaceFolder(dir) + "/" + strings.TrimPrefix(e.Path, "/")
+		args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", shared, target))
+	}
+	return args, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// servicesNetwork is the docker network shared by the shared service
+// containers and the devcontainers that use them.
+const servicesNetwork = "wt-services"
+
+// sharedServiceLabel marks the containers of shared services.
+const sharedServiceLabel = "wt.service"
+
+var validSharedServiceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func sharedServiceContainerName(name string) string {
+	return "wt-svc-" + name
+}
+
+// address returns where devcontainers reach the service, or "" when it
+// declares no port.
+func (s SharedService) address() string {
+	if s.Port == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", sharedServiceContainerName(s.Name), s.Port)
+}
+
+// sharedServiceEnv returns the env assignments that tell code in a
+// devcontainer where the shared services are: WT_SERVICE_<NAME>
+// (host:port) for each service with a port.
+func sharedServiceEnv(services []SharedService) []string {
+	var env []string
+	for _, svc := range services {
+		if addr := svc.address(); addr != "" {
+			env = append(env, "WT_SERVICE_"+hostServiceEnvName(svc.Name)+"="+addr)
+		}
+	}
+	return env
+}
+
+// sharedServiceState returns the docker state and image of a shared
+// service's container, or "" if it does not exist.
+func sharedServiceState(name string) (state, image string) {
+	out, err := exec.Command("docker", "inspect", "--format", "{{.State.Status}} {{.Config.Image}}", sharedServiceContainerName(name)).Output()
+	if err != nil {
+		return "", ""
+	}
+	state, image, _ = strings.Cut(strings.TrimSpace(string(out)), " ")
+	return state, image
+}
+
+// ensureSharedServices starts the shared network and the declared services
+// if they are not running yet. Their data directories live in named
+// volumes, so they survive 'wt services down'.
+func ensureSharedServices(services []SharedService) error {
+	if exec.Command("docker", "network", "inspect", servicesNetwork).Run() != nil {
+		if out, err := exec.Command("docker", "network", "create", servicesNetwork).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create network %s: %s", servicesNetwork, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, svc := range services {
+		container := sharedServiceContainerName(svc.Name)
+		state, image := sharedServiceState(svc.Name)
+		if state != "" && image != svc.Image {
+			warnf(fmt.Sprintf("remove it with 'wt services down' to switch to %s", svc.Image),
+				"%s runs %s, not %s; another repository may declare a service of the same name", container, image, svc.Image)
+		}
+		var args []string
+		switch state {
+		case "running":
+			continue
+		case "":
+			args = []string{"run", "-d", "--restart", "unless-stopped", "--name", container,
+				"--network", servicesNetwork, "--label", sharedServiceLabel + "=" + svc.Name}
+			if offline {
+				args = append(args, "--pull=never")
+			}
+			for i, dir := range svc.Volumes {
+				args = append(args, "-v", fmt.Sprintf("%s-%d:%s", container, i, dir))
+			}
+			for _, e := range svc.Env {
+				args = append(args, "-e", e)
+			}
+			for _, p := range svc.Publish {
+				args = append(args, "-p", p)
+			}
+			args = append(append(args, svc.Image), svc.Command...)
+		default:
+			args = []string{"start", container}
+		}
+		fmt.Fprintf(os.Stderr, "Starting shared service %s (%s)\n", svc.Name, container)
+		if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start %s: %s", container, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// attachSharedServices connects the worktree's running devcontainer to the
+// shared services network.
+func attachSharedServices(dir string) error {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("docker", "network", "connect", servicesNetwork, containerID).CombinedOutput(); err != nil &&
+		!strings.Contains(string(out), "already exists") {
+		return fmt.Errorf("failed to connect to %s: %s", servicesNetwork, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runServicesDown stops and removes the containers of the declared shared
+// services; their volumes are kept.
+func runServicesDown(services []SharedService) error {
+	for _, svc := range services {
+		if state, _ := sharedServiceState(svc.Name); state == "" {
+			continue
+		}
+		container := sharedServiceContainerName(svc.Name)
+		if out, err := exec.Command("docker", "rm", "-f", container).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove %s: %s", container, strings.TrimSpace(string(out)))
+		}
+		fmt.Fprintf(os.Stderr, "Removed %s\n", container)
+	}
+	return nil
+}
+
+func runServicesStatus(services []SharedService) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tIMAGE\tSTATE\tADDRESS")
+	for _, svc := range services {
+		state, _ := sharedServiceState(svc.Name)
+		if state == "" {
+			state = "-"
+		}
+		addr := svc.address()
+		if addr == "" {
+			addr = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Name, svc.Image, state, addr)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// cdFileEnv names the environment variable the shell-init wrapper sets to a
+// temp file. 'wt cd' writes its target directory there so the wrapper can cd
+// the calling shell once wt (and any subshell it spawned) exits.
+const cdFileEnv = "WT_CD_FILE"
+
+const bashShellInit = `wt() {
+  local __wt_cd_file __wt_rc
+  __wt_cd_file="$(mktemp "${TMPDIR:-/tmp}/wt-cd.XXXXXX")" || return 1
+  WT_CD_FILE="$__wt_cd_file" command wt "$@"
+  __wt_rc=$?
+  if [ -s "$__wt_cd_file" ]; then
+    cd "$(cat "$__wt_cd_file")" || __wt_rc=$?
+  fi
+  rm -f "$__wt_cd_file"
+  return $__wt_rc
+}
+`
+
+const fishShellInit = `function wt
+    set -l __wt_cd_file (mktemp (set -q TMPDIR; and echo $TMPDIR; or echo /tmp)/wt-cd.XXXXXX); or return 1
+    WT_CD_FILE=$__wt_cd_file command wt $argv
+    set -l __wt_rc $status
+    if test -s $__wt_cd_file
+        cd (cat $__wt_cd_file); or set __wt_rc $status
+    end
+    rm -f $__wt_cd_file
+    return $__wt_rc
+end
+`
+
+// shellInitScript returns the wrapper function for the given shell.
+func shellInitScript(shell string) (string, error) {
+	switch shell {
+	case "bash", "zsh":
+		return bashShellInit, nil
+	case "fish":
+		return fishShellInit, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q; use bash, zsh, or fish", shell)
+	}
+}
+
+// recordCDTarget writes dir to the shell-init temp file, if the wrapper set
+// one, and reports whether it did. The variable is cleared so that shells
+// spawned by wt do not inherit the parent's file.
+func recordCDTarget(dir string) (bool, error) {
+	path := os.Getenv(cdFileEnv)
+	if path == "" {
+		return false, nil
+	}
+	os.Unsetenv(cdFileEnv)
+	if err := os.WriteFile(path, []byte(dir), 0600); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", cdFileEnv, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+// worktreeNameEnv carries the worktree's directory name ("repo@name") into
+// container sessions, for the prompt prefix and for wt running inside.
+const worktreeNameEnv = "WT_WORKTREE"
+
+// defaultShellScript opens bash in the container when available, else sh.
+const defaultShellScript = "command -v bash >/dev/null 2>&1 && exec bash || exec sh"
+
+// promptShellScript opens an interactive container shell whose prompt starts
+// with the worktree name and, after a failing command, its exit status. The
+// bash init file sources ~/.bashrc first and re-applies the prefix on every
+// prompt, so prompts that rebuild PS1 keep it.
+const promptShellScript = `f=$(mktemp 2>/dev/null || echo /tmp/wt-prompt-$$)
+cat > "$f" <<'WTEOF'
+[ -f ~/.bashrc ] && . ~/.bashrc
+__wt_prompt() {
+  PS1=${PS1#"$__wt_prefix"}
+  __wt_prefix="\[\e[1;36m\][⬢ $WT_WORKTREE]\[\e[0m\] "
+  [ "$__wt_status" != 0 ] && __wt_prefix="$__wt_prefix\[\e[1;31m\][$__wt_status]\[\e[0m\] "
+  PS1=$__wt_prefix$PS1
+}
+PROMPT_COMMAND="__wt_status=\$?${PROMPT_COMMAND:+;$PROMPT_COMMAND};__wt_prompt"
+WTEOF
+command -v bash >/dev/null 2>&1 && exec bash --rcfile "$f" -i
+PS1="[$WT_WORKTREE] \$ " exec sh -i`
+
+// interactiveShellArgv returns the command 'wt exec' runs in a container when
+// no command is given, with the worktree prompt prefix when prompt is set.
+func interactiveShellArgv(prompt bool) []string {
+	if prompt {
+		return []string{"/bin/sh", "-c", promptShellScript}
+	}
+	return []string{"/bin/sh", "-c", defaultShellScript}
+}
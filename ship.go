@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// shippedWtPath is where 'wt up' puts the wt binary in the devcontainer.
+const shippedWtPath = "/usr/local/bin/wt"
+
+// containerArches maps 'uname -m' output to Go architecture names.
+var containerArches = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"riscv64": "riscv64",
+}
+
+// shipBinary returns the wt binary to copy into a container of the Go
+// architecture arch: one configured in shipWt.binaries, else the running
+// binary when it is a linux one of the same architecture.
+func shipBinary(cfg ShipConfig, arch string) (string, error) {
+	if path := cfg.Binaries[arch]; path != "" {
+		if !filepath.IsAbs(path) {
+			mainRoot, err := getMainRepoRoot()
+			if err != nil {
+				return "", err
+			}
+			path = filepath.Join(mainRoot, path)
+		}
+		return path, nil
+	}
+	if runtime.GOOS != "linux" || runtime.GOARCH != arch {
+		return "", fmt.Errorf("this wt is a %s/%s binary and the container is linux/%s; set shipWt.binaries.%s in %s to a linux/%s build",
+			runtime.GOOS, runtime.GOARCH, arch, arch, projectConfigFile, arch)
+	}
+	return os.Executable()
+}
+
+// shipWt copies a wt binary matching the container's architecture into the
+// running devcontainer of the worktree at dir, so that scripts and agents in
+// it can call wt.
+func shipWt(dir string, cfg ShipConfig) error {
+	containerID, err := getContainerID(dir)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "exec", containerID, "uname", "-m").Output()
+	if err != nil {
+		return fmt.Errorf("failed to detect the container architecture: %w", err)
+	}
+	machine := strings.TrimSpace(string(out))
+	arch, ok := containerArches[machine]
+	if !ok {
+		return fmt.Errorf("unsupported container architecture %q", machine)
+	}
+	binary, err := shipBinary(cfg, arch)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("docker", "cp", "-L", binary, containerID+":"+shippedWtPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to copy wt into the container: %s", strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("docker", "exec", "-u", "0", containerID, "chmod", "755", shippedWtPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to make %s executable: %s", shippedWtPath, strings.TrimSpace(string(out)))
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Installed %s in the container as %s\n", binary, shippedWtPath)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sparseConfigKey holds the paths last passed to 'wt add --sparse', in the
+// main repository's local git config, so that later worktrees get the same
+// set.
+const sparseConfigKey = "wt.sparse"
+
+// rememberedSparsePaths returns the paths saved by an earlier 'wt add
+// --sparse'.
+func rememberedSparsePaths() []string {
+	out, err := exec.Command("git", "config", "--local", "--get-all", sparseConfigKey).Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+// rememberSparsePaths replaces the saved sparse paths with paths.
+func rememberSparsePaths(paths []string) error {
+	_ = exec.Command("git", "config", "--local", "--unset-all", sparseConfigKey).Run()
+	for _, p := range paths {
+		if out, err := exec.Command("git", "config", "--local", "--add", sparseConfigKey, p).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to save the sparse paths: %s", strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// sparsePaths returns the directories a new worktree checks out, or nil for
+// a full checkout: --sparse, else the remembered paths, else add.sparse.
+func (opts addOptions) sparsePaths(cfg AddConfig) []string {
+	switch {
+	case opts.noSparse:
+		return nil
+	case len(opts.sparse) > 0:
+		return opts.sparse
+	}
+	if paths := rememberedSparsePaths(); len(paths) > 0 {
+		return paths
+	}
+	return cfg.Sparse
+}
+
+// applySparseCheckout limits the worktree at dir, created with
+// --no-checkout, to the directories in paths (cone mode: their files plus
+// those at the top level) and checks it out.
+func applySparseCheckout(dir string, paths []string) error {
+	set := exec.Command("git", append([]string{"-C", dir, "sparse-checkout", "set", "--cone"}, paths...)...)
+	set.Stderr = os.Stderr
+	if err := set.Run(); err != nil {
+		return fmt.Errorf("git sparse-checkout set failed: %w", err)
+	}
+	checkout := exec.Command("git", "-C", dir, "checkout")
+	checkout.Stdout = os.Stderr
+	checkout.Stderr = os.Stderr
+	if err := checkout.Run(); err != nil {
+		return fmt.Errorf("git checkout failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// stackLink records that a worktree was started on top of another worktree.
+type stackLink struct {
+	Parent string `json:"parent"` // name of the parent worktree
+	Base   string `json:"base"`   // parent commit the worktree's own commits sit on
+}
+
+func stackFile(dir string) (string, error) {
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "stack.json"), nil
+}
+
+// loadStackLink returns the worktree's parent link, or nil if it is not
+// stacked.
+func loadStackLink(dir string) (*stackLink, error) {
+	path, err := stackFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var link stackLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &link, nil
+}
+
+func saveStackLink(dir string, link stackLink) error {
+	path, err := stackFile(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(link, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func revParse(dir, rev string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--verify", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s in %s", rev, filepath.Base(dir))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// stackParentForBase returns the sibling worktree whose checked-out branch is
+// base, so a worktree started from another worktree's branch is stacked on it.
+func stackParentForBase(mainRoot, base string) (siblingWorktree, bool) {
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return siblingWorktree{}, false
+	}
+	for _, wt := range worktrees {
+		if st := getWorktreeStatus(wt.path); st.branch != "" && st.branch == base {
+			return wt, true
+		}
+	}
+	return siblingWorktree{}, false
+}
+
+// stackNode is one layer of a stack of worktrees.
+type stackNode struct {
+	wt   siblingWorktree
+	link *stackLink
+}
+
+// loadStacks returns every worktree of the repository with its parent link,
+// keyed by name.
+func loadStacks(mainRoot string) (map[string]stackNode, error) {
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]stackNode{}
+	for _, wt := range worktrees {
+		link, err := loadStackLink(wt.path)
+		if err != nil {
+			return nil, err
+		}
+		nodes[wt.name] = stackNode{wt: wt, link: link}
+	}
+	return nodes, nil
+}
+
+// stackOrder returns the layers of the stack containing name, bottom first:
+// the root, then each worktree after its parent.
+func stackOrder(nodes map[string]stackNode, name string) ([]stackNode, error) {
+	root := name
+	seen := map[string]bool{}
+	for {
+		if seen[root] {
+			return nil, fmt.Errorf("worktree stack containing %s has a cycle", name)
+		}
+		seen[root] = true
+		node, ok := nodes[root]
+		if !ok || node.link == nil {
+			break
+		}
+		if _, ok := nodes[node.link.Parent]; !ok {
+			break
+		}
+		root = node.link.Parent
+	}
+	children := map[string][]string{}
+	for n, node := range nodes {
+		if node.link != nil {
+			children[node.link.Parent] = append(children[node.link.Parent], n)
+		}
+	}
+	var order []stackNode
+	var visit func(n string)
+	visit = func(n string) {
+		order = append(order, nodes[n])
+		kids := children[n]
+		sort.Strings(kids)
+		for _, kid := range kids {
+			visit(kid)
+		}
+	}
+	visit(root)
+	return order, nil
+}
+
+// runRestack rebases each layer of the stack containing the worktree at dir
+// onto its parent's current tip, bottom up. A layer that conflicts is left
+// mid-rebase for the user to resolve and the layers above it are skipped.
+func runRestack(dir string) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	name := parseWorktreeName(filepath.Base(dir), filepath.Base(mainRoot))
+	nodes, err := loadStacks(mainRoot)
+	if err != nil {
+		return err
+	}
+	if node, ok := nodes[name]; !ok || (node.link == nil && !hasStackChildren(nodes, name)) {
+		return fmt.Errorf("%s is not part of a stack; create one with 'wt add --stack-on <worktree> <name>'", filepath.Base(dir))
+	}
+	order, err := stackOrder(nodes, name)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tPARENT\tRESULT")
+	failed := map[string]bool{}
+	conflicts := 0
+	for _, node := range order {
+		if node.link == nil {
+			fmt.Fprintf(w, "%s\t-\tbase of the stack\n", node.wt.name)
+			continue
+		}
+		if failed[node.link.Parent] {
+			failed[node.wt.name] = true
+			fmt.Fprintf(w, "%s\t%s\tskipped: parent did not restack\n", node.wt.name, node.link.Parent)
+			continue
+		}
+		result, err := restackLayer(node, nodes[node.link.Parent])
+		if err != nil {
+			failed[node.wt.name] = true
+			conflicts++
+			result = err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", node.wt.name, node.link.Parent, result)
+	}
+	w.Flush()
+	if conflicts > 0 {
+		fmt.Fprintln(os.Stderr, "Fix the failed layers (for conflicts: resolve them, run 'git rebase --continue' in that worktree), then rerun 'wt restack'.")
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+func hasStackChildren(nodes map[string]stackNode, name string) bool {
+	for _, node := range nodes {
+		if node.link != nil && node.link.Parent == name {
+			return true
+		}
+	}
+	return false
+}
+
+// restackLayer rebases one worktree's own commits (base..HEAD) onto its
+// parent's tip and records the new base.
+func restackLayer(node, parent stackNode) (string, error) {
+	if parent.wt.path == "" {
+		return "", fmt.Errorf("parent worktree %s no longer exists", node.link.Parent)
+	}
+	tip, err := revParse(parent.wt.path, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	head, err := revParse(node.wt.path, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if exec.Command("git", "-C", node.wt.path, "merge-base", "--is-ancestor", tip, head).Run() == nil {
+		if node.link.Base != tip {
+			node.link.Base = tip
+			if err := saveStackLink(node.wt.path, *node.link); err != nil {
+				return "", err
+			}
+		}
+		return "up to date", nil
+	}
+	if getWorktreeStatus(node.wt.path).dirty {
+		return "", fmt.Errorf("not rebased: uncommitted changes")
+	}
+	count := "?"
+	if out, err := exec.Command("git", "-C", node.wt.path, "rev-list", "--count", node.link.Base+"..HEAD").Output(); err == nil {
+		count = strings.TrimSpace(string(out))
+	}
+	rebase := exec.Command("git", "-C", node.wt.path, "rebase", "--onto", tip, node.link.Base)
+	if out, err := rebase.CombinedOutput(); err != nil {
+		files, _ := exec.Command("git", "-C", node.wt.path, "diff", "--name-only", "--diff-filter=U").Output()
+		conflicted := strings.Fields(string(files))
+		if len(conflicted) == 0 {
+			return "", fmt.Errorf("rebase failed: %s", strings.TrimSpace(lastLine(string(out))))
+		}
+		return "", fmt.Errorf("CONFLICT in %s (rebase in progress in %s)", strings.Join(conflicted, ", "), node.wt.path)
+	}
+	node.link.Base = tip
+	if err := saveStackLink(node.wt.path, *node.link); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rebased %s commit(s) onto %.7s", count, tip), nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// runStackShow prints the stack containing the worktree at dir as a tree.
+func runStackShow(dir string) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	name := parseWorktreeName(filepath.Base(dir), filepath.Base(mainRoot))
+	nodes, err := loadStacks(mainRoot)
+	if err != nil {
+		return err
+	}
+	if node, ok := nodes[name]; !ok || (node.link == nil && !hasStackChildren(nodes, name)) {
+		fmt.Fprintf(os.Stderr, "%s is not part of a stack\n", filepath.Base(dir))
+		return nil
+	}
+	order, err := stackOrder(nodes, name)
+	if err != nil {
+		return err
+	}
+	depth := map[string]int{}
+	for _, node := range order {
+		d := 0
+		if node.link != nil {
+			d = depth[node.link.Parent] + 1
+		}
+		depth[node.wt.name] = d
+		marker := ""
+		if node.wt.name == name {
+			marker = "  *"
+		}
+		fmt.Printf("%s%s  %s%s\n", strings.Repeat("  ", d), node.wt.name, getWorktreeStatus(node.wt.path).ref(), marker)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stateHome returns the root directory for wt's machine-local state,
+// honoring $XDG_STATE_HOME.
+func stateHome() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "wt"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "wt"), nil
+}
+
+// repoStateDir returns the state directory shared by all worktrees of the
+// repository rooted at mainRoot. The directory is keyed by the repo basename
+// plus a short hash of its path so identically named repos do not collide.
+func repoStateDir(mainRoot string) (string, error) {
+	home, err := stateHome()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(mainRoot))
+	key := filepath.Base(mainRoot) + "-" + hex.EncodeToString(sum[:])[:8]
+	return filepath.Join(home, "repos", key), nil
+}
+
+// worktreeStateDir returns (and creates) the state directory for the worktree
+// at dir. Worktrees are keyed by their directory basename ("repo@name", or
+// "repo" for the main worktree).
+func worktreeStateDir(dir string) (string, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return "", err
+	}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return "", err
+	}
+	stateDir := filepath.Join(repoDir, "worktrees", filepath.Base(dir))
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return stateDir, nil
+}
+
+// worktreeSlot returns the small integer assigned to the worktree at dir. The
+// main worktree is always slot 0; named worktrees get $WT_SLOT or else the
+// lowest free slot on first use, and keep it for as long as their state
+// directory exists. Slots are assigned under a lock, so concurrent 'wt add's
+// get different ones, and a $WT_SLOT another worktree holds is an error.
+func worktreeSlot(dir string) (int, error) {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return 0, err
+	}
+	if filepath.Clean(dir) == mainRoot {
+		return 0, nil
+	}
+	stateDir, err := worktreeStateDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	slotFile := filepath.Join(stateDir, "slot")
+	if slot, ok := readSlot(slotFile); ok {
+		return slot, nil
+	}
+
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return 0, err
+	}
+	unlock, err := lockFile(filepath.Join(repoDir, "slots.lock"), "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock worktree slots: %w", err)
+	}
+	defer unlock()
+	// Another process may have assigned it while this one waited.
+	if slot, ok := readSlot(slotFile); ok {
+		return slot, nil
+	}
+	used := usedSlots(repoDir, mainRoot)
+	slot, pinned := pinnedSlot()
+	if pinned {
+		if owner, ok := used[slot]; ok {
+			return 0, fmt.Errorf("slot %d from $%s is already used by %s", slot, slotEnv, owner)
+		}
+	} else {
+		slot = 1
+		for used[slot] != "" {
+			slot++
+		}
+	}
+	if err := os.WriteFile(slotFile, []byte(strconv.Itoa(slot)+"\n"), 0644); err != nil {
+		return 0, fmt.Errorf("failed to record worktree slot: %w", err)
+	}
+	return slot, nil
+}
+
+// usedSlots returns the slots assigned in the repository at mainRoot, whose
+// state directory is repoDir, with the worktree holding each.
+func usedSlots(repoDir, mainRoot string) map[int]string {
+	used := map[int]string{0: filepath.Base(mainRoot)}
+	files, _ := filepath.Glob(filepath.Join(repoDir, "worktrees", "*", "slot"))
+	for _, f := range files {
+		if slot, ok := readSlot(f); ok {
+			used[slot] = filepath.Base(filepath.Dir(f))
+		}
+	}
+	return used
+}
+
+// checkPinnedSlot refuses early a $WT_SLOT that a worktree of the repository
+// at mainRoot already holds, before 'wt add' creates anything.
+func checkPinnedSlot(mainRoot string) error {
+	slot, ok := pinnedSlot()
+	if !ok {
+		return nil
+	}
+	repoDir, err := repoStateDir(mainRoot)
+	if err != nil {
+		return err
+	}
+	if owner, ok := usedSlots(repoDir, mainRoot)[slot]; ok {
+		return fmt.Errorf("slot %d from $%s is already used by %s", slot, slotEnv, owner)
+	}
+	return nil
+}
+
+// readSlot reads a slot file.
+func readSlot(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	slot, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return slot, err == nil
+}
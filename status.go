@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// worktreeStatus summarizes the git state of a worktree.
+type worktreeStatus struct {
+	branch     string    // current branch; empty when detached
+	head       string    // abbreviated HEAD commit
+	dirty      bool      // uncommitted changes or untracked files
+	lastCommit time.Time // committer date of HEAD
+}
+
+// getWorktreeStatus inspects the worktree at dir. Fields that cannot be
+// determined are left zero.
+func getWorktreeStatus(dir string) worktreeStatus {
+	var st worktreeStatus
+	if out, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%h %ct").Output(); err == nil {
+		fields := strings.Fields(string(out))
+		if len(fields) == 2 {
+			st.head = fields[0]
+			if secs, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				st.lastCommit = time.Unix(secs, 0)
+			}
+		}
+	}
+	if out, err := exec.Command("git", "-C", dir, "branch", "--show-current").Output(); err == nil {
+		st.branch = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output(); err == nil {
+		st.dirty = len(strings.TrimSpace(string(out))) > 0
+	}
+	return st
+}
+
+// ref returns the branch name, or the short HEAD commit when detached.
+func (st worktreeStatus) ref() string {
+	if st.branch != "" {
+		return st.branch
+	}
+	if st.head != "" {
+		return "(" + st.head + ")"
+	}
+	return "-"
+}
+
+// formatAge renders a duration since t in a compact human form like "3d".
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		return strconv.Itoa(int(d.Minutes())) + "m"
+	case d < 24*time.Hour:
+		return strconv.Itoa(int(d.Hours())) + "h"
+	case d < 30*24*time.Hour:
+		return strconv.Itoa(int(d.Hours()/24)) + "d"
+	case d < 365*24*time.Hour:
+		return strconv.Itoa(int(d.Hours()/24/30)) + "mo"
+	default:
+		return strconv.Itoa(int(d.Hours()/24/365)) + "y"
+	}
+}
+
+// formatSize renders a byte count with a binary unit, e.g. "1.4 GiB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
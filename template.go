@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// templateDir holds files seeded into every new worktree, e.g. local
+// override files, IDE settings, or empty scratch directories.
+const templateDir = ".wt/template"
+
+// findTemplateDir returns the template directory for the new worktree at
+// dir: add.template when set (relative to the main repository), otherwise
+// the .wt/template committed in the worktree itself, a local one in the main
+// repository, or else the extends source's. It returns "" when there is none.
+func findTemplateDir(dir string, cfg *Config) string {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		mainRoot = ""
+	}
+	var candidates []string
+	if cfg.Add.Template != "" {
+		path := cfg.Add.Template
+		if !filepath.IsAbs(path) && mainRoot != "" {
+			path = filepath.Join(mainRoot, path)
+		}
+		candidates = append(candidates, path)
+	} else {
+		candidates = append(candidates, filepath.Join(dir, templateDir))
+		if mainRoot != "" && mainRoot != dir {
+			candidates = append(candidates, filepath.Join(mainRoot, templateDir))
+		}
+		if cfg.extendsDir != "" {
+			candidates = append(candidates, filepath.Join(cfg.extendsDir, templateDir))
+		}
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+	}
+	if cfg.Add.Template != "" {
+		warnf(fmt.Sprintf("create it or fix add.template in %s", projectConfigFile), "template directory %s does not exist", cfg.Add.Template)
+	}
+	return ""
+}
+
+// seedFromTemplate copies the template directory into the new worktree at
+// dir, keeping file modes and creating empty directories. The __WT_*__
+// placeholders of copied env files are replaced in text files too. Files
+// the worktree already has are left alone. It returns the number of files
+// copied.
+func seedFromTemplate(dir string, cfg *Config) (int, error) {
+	src := findTemplateDir(dir, cfg)
+	if src == "" {
+		return 0, nil
+	}
+	vars, err := newEnvTemplateData(dir, cfg.Env)
+	if err != nil {
+		warnf("", "__WT_*__ placeholders in %s are left as is: %v", templateDir, err)
+	}
+	n := 0
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		dst := filepath.Join(dir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			if err := copyPreservingMode(path, dst); err != nil {
+				return fmt.Errorf("failed to copy template %s: %w", rel, err)
+			}
+			n++
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read template %s: %w", rel, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Binary files are copied as they are.
+		if vars != nil && !bytes.Contains(data, []byte{0}) {
+			data = []byte(vars.placeholders().Replace(string(data)))
+		}
+		if err := os.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to copy template %s: %w", rel, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
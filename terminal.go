@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+const defaultTerminalFormat = "{name}"
+
+// terminalIdentity labels the user's terminal while an interactive wt
+// session runs: the window/tab title (xterm OSC 2, saved and restored with
+// the title stack), and the iTerm2 badge plus a WezTerm "wt_worktree" user
+// variable.
+type terminalIdentity struct {
+	label string
+	title bool
+	badge bool
+}
+
+// newTerminalIdentity returns the identity for an interactive session in the
+// worktree at dir, or nil when terminal.title and terminal.badge are off or
+// stderr is not a terminal.
+func newTerminalIdentity(cfg TerminalConfig, dir string) *terminalIdentity {
+	if !cfg.Title && !cfg.Badge || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	format := cfg.Format
+	if format == "" {
+		format = defaultTerminalFormat
+	}
+	return &terminalIdentity{label: expandWorktreeFormat(format, dir), title: cfg.Title, badge: cfg.Badge}
+}
+
+// expandWorktreeFormat replaces the placeholders {name} and {repo} in format
+// for the worktree at dir. The main worktree's name is the repository's.
+func expandWorktreeFormat(format, dir string) string {
+	repo := filepath.Base(dir)
+	name := repo
+	if mainRoot, err := getMainRepoRoot(); err == nil {
+		repo = filepath.Base(mainRoot)
+		if n := parseWorktreeName(filepath.Base(dir), repo); n != "" {
+			name = n
+		}
+	}
+	return strings.NewReplacer("{name}", name, "{repo}", repo).Replace(format)
+}
+
+func (t *terminalIdentity) set() {
+	if t.title {
+		fmt.Fprintf(os.Stderr, "\x1b[22;0t\x1b]2;%s\x07", t.label)
+	}
+	if t.badge {
+		encoded := base64.StdEncoding.EncodeToString([]byte(t.label))
+		fmt.Fprintf(os.Stderr, "\x1b]1337;SetBadgeFormat=%s\x07\x1b]1337;SetUserVar=wt_worktree=%s\x07", encoded, encoded)
+	}
+}
+
+func (t *terminalIdentity) clear() {
+	if t.title {
+		fmt.Fprint(os.Stderr, "\x1b[23;0t")
+	}
+	if t.badge {
+		fmt.Fprint(os.Stderr, "\x1b]1337;SetBadgeFormat=\x07\x1b]1337;SetUserVar=wt_worktree=\x07")
+	}
+}
+
+// runTerminalSession runs the interactive argv as a child with the terminal
+// labeled, and clears the label once it exits. wt stays alive for that, so
+// it swallows the interrupts meant for the child.
+func runTerminalSession(t *terminalIdentity, argv []string) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+		}
+	}()
+	t.set()
+	defer t.clear()
+	child := exec.Command(argv[0], argv[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	return childExitError(child.Run())
+}
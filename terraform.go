@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+const defaultTerraformWorkspace = "{repo}-{name}"
+
+// terraformEnv returns the env assignments that bind 'wt exec' sessions in
+// the worktree at dir to a Terraform workspace (and backend state key) of
+// their own. The main worktree keeps Terraform's defaults.
+func terraformEnv(dir string, c TerraformConfig) []string {
+	if !c.Enabled {
+		return nil
+	}
+	if mainRoot, err := getMainRepoRoot(); err != nil || filepath.Clean(dir) == mainRoot {
+		return nil
+	}
+	workspace := c.Workspace
+	if workspace == "" {
+		workspace = defaultTerraformWorkspace
+	}
+	env := []string{"TF_WORKSPACE=" + expandWorktreeFormat(workspace, dir)}
+	if c.BackendKey != "" {
+		env = append(env, "TF_CLI_ARGS_init=-backend-config=key="+expandWorktreeFormat(c.BackendKey, dir))
+	}
+	return env
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// timeoutExitCode is the exit status wt returns when a command is killed for
+// exceeding --timeout, matching timeout(1).
+const timeoutExitCode = 124
+
+// timeoutGrace is how long a timed-out command gets to exit after SIGTERM
+// before it is killed.
+const timeoutGrace = 5 * time.Second
+
+const defaultRetryDelay = 5 * time.Second
+
+// retryOnTail is how much of an attempt's latest output --retry-on is
+// matched against.
+const retryOnTail = 1 << 20
+
+// runPolicy bounds how long a container-backed command may run and how often
+// it is retried after failing or timing out.
+type runPolicy struct {
+	timeout    time.Duration  // per attempt; 0 means no limit
+	retries    int            // extra attempts after the first
+	retryDelay time.Duration  // pause between attempts
+	retryOn    *regexp.Regexp // retry only when the failed attempt's output matches; nil retries any failure
+}
+
+// active reports whether the command must run as a supervised child rather
+// than replacing wt.
+func (p runPolicy) active() bool {
+	return p.timeout > 0 || p.retries > 0
+}
+
+// policy converts the config section, which validate has checked, into a
+// runPolicy.
+func (c RunPolicyConfig) policy() runPolicy {
+	p := runPolicy{timeout: c.Timeout, retries: c.Retries, retryDelay: c.RetryDelay}
+	if p.retryDelay <= 0 {
+		p.retryDelay = defaultRetryDelay
+	}
+	if c.RetryOn != "" {
+		p.retryOn = regexp.MustCompile(c.RetryOn)
+	}
+	return p
+}
+
+// addRunPolicyFlags registers --timeout, --retries, and --retry-on on cmd.
+func addRunPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("timeout", 0, "kill the command if an attempt runs longer than this (exit status 124)")
+	cmd.Flags().Int("retries", 0, "retry a failed or timed-out command this many times")
+	cmd.Flags().String("retry-on", "", "retry only when the failed attempt's output matches this regexp")
+}
+
+// runPolicyFromFlags returns the policy from cfg, overridden by any
+// --timeout, --retries, or --retry-on given on cmd.
+func runPolicyFromFlags(cmd *cobra.Command, cfg RunPolicyConfig) (runPolicy, error) {
+	p := cfg.policy()
+	if cmd.Flags().Changed("timeout") {
+		p.timeout, _ = cmd.Flags().GetDuration("timeout")
+	}
+	if cmd.Flags().Changed("retries") {
+		p.retries, _ = cmd.Flags().GetInt("retries")
+	}
+	if cmd.Flags().Changed("retry-on") {
+		pattern, _ := cmd.Flags().GetString("retry-on")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return p, fmt.Errorf("invalid --retry-on: %w", err)
+		}
+		if p.retries == 0 {
+			return p, fmt.Errorf("--retry-on needs --retries")
+		}
+		p.retryOn = re
+	}
+	return p, nil
+}
+
+// errTimedOut marks an attempt that was killed for exceeding its timeout.
+var errTimedOut = errors.New("timed out")
+
+// runWithPolicy runs argv with the standard streams (stdout and stderr
+// replaced by the given writers when non-nil), retrying on failure as p
+// allows. A final timeout is reported as exit status 124; other failures
+// carry the child's exit status.
+func runWithPolicy(argv []string, p runPolicy, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	return runAttempts(argv, p, func(int) (io.Writer, io.Writer, func(error)) {
+		return stdout, stderr, nil
+	})
+}
+
+// attemptOutput returns where attempt (counting from 0) of a command writes
+// its stdout and stderr, and an optional func called with its result.
+type attemptOutput func(attempt int) (stdout, stderr io.Writer, done func(error))
+
+// runAttempts is runWithPolicy with the output of each attempt sent where
+// output says.
+func runAttempts(argv []string, p runPolicy, output attemptOutput) error {
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(os.Stderr, "Retrying %s in %s (attempt %d of %d)...\n", argv[0], p.retryDelay, attempt+1, p.retries+1)
+			time.Sleep(p.retryDelay)
+		}
+		stdout, stderr, done := output(attempt)
+		var tail *tailBuffer
+		if p.retryOn != nil {
+			tail = &tailBuffer{max: retryOnTail}
+			stdout, stderr = io.MultiWriter(stdout, tail), io.MultiWriter(stderr, tail)
+		}
+		err = runAttempt(argv, p.timeout, stdout, stderr)
+		if done != nil {
+			done(err)
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errTimedOut) {
+			warnf("", "%s timed out after %s", strings.Join(argv, " "), p.timeout)
+		}
+		if tail != nil && attempt < p.retries && !p.retryOn.Match(tail.bytes()) {
+			fmt.Fprintf(os.Stderr, "Not retrying: the output does not match --retry-on %q\n", p.retryOn)
+			break
+		}
+	}
+	if errors.Is(err, errTimedOut) {
+		return &exitCodeError{code: timeoutExitCode}
+	}
+	return childExitError(err)
+}
+
+// attemptExitCode returns the exit status an attempt's error stands for: 0
+// for success, 124 for a timeout, and -1 when the command did not run.
+func attemptExitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errTimedOut):
+		return timeoutExitCode
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it. stdout and stderr
+// write to it concurrently.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return bytes.Clone(t.buf)
+}
+
+// runAttempt runs argv once. When stdin is not a terminal the child gets its
+// own process group so a timeout can kill everything it started; an
+// interactive child stays in wt's group so it keeps the terminal, and only
+// the child itself is signaled.
+func runAttempt(argv []string, timeout time.Duration, stdout, stderr io.Writer) error {
+	child := exec.Command(argv[0], argv[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = stdout
+	child.Stderr = stderr
+	ownGroup := !term.IsTerminal(int(os.Stdin.Fd()))
+	if ownGroup {
+		child.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", argv[0], err)
+	}
+	if timeout <= 0 {
+		return child.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- child.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+	}
+
+	signal := func(sig syscall.Signal) {
+		if ownGroup {
+			_ = syscall.Kill(-child.Process.Pid, sig)
+		} else {
+			_ = child.Process.Signal(sig)
+		}
+	}
+	signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(timeoutGrace):
+		signal(syscall.SIGKILL)
+		<-done
+	}
+	return errTimedOut
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// warning is a problem that did not stop a command, with what to do about it.
+type warning struct {
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+var (
+	// deferWarnings collects warnings for a summary at the end of the
+	// command instead of printing them as they happen; 'wt add' and 'wt rm'
+	// set it.
+	deferWarnings bool
+	warnings      []warning
+)
+
+// warnf reports a warning with an optional remediation hint.
+func warnf(hint, format string, args ...any) {
+	w := warning{Message: fmt.Sprintf(format, args...), Hint: hint}
+	if deferWarnings {
+		warnings = append(warnings, w)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", w.Message)
+	if w.Hint != "" {
+		fmt.Fprintf(os.Stderr, "  %s\n", w.Hint)
+	}
+}
+
+// printWarningSummary lists the collected warnings on stderr, so partial
+// failures don't scroll past among the output of git and other tools, and
+// forgets them.
+func printWarningSummary() {
+	if len(warnings) == 0 {
+		return
+	}
+	defer func() { warnings = nil }()
+	noun := "warnings"
+	if len(warnings) == 1 {
+		noun = "warning"
+	}
+	fmt.Fprintf(os.Stderr, "\n%d %s:\n", len(warnings), noun)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "  - %s\n", w.Message)
+		if w.Hint != "" {
+			fmt.Fprintf(os.Stderr, "    hint: %s\n", w.Hint)
+		}
+	}
+}
+
+// printJSONResult writes result, with the collected warnings added under
+// "warnings", as one JSON object on stdout for --json.
+func printJSONResult(result map[string]any) error {
+	if warnings == nil {
+		warnings = []warning{}
+	}
+	result["warnings"] = warnings
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(result)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// worktreeLocation describes the worktree a path was resolved to.
+type worktreeLocation struct {
+	repo     string // basename of the main repository
+	name     string // worktree name; empty for the main worktree
+	root     string // worktree root directory on the host
+	mainRoot string // main repository root on the host
+}
+
+func runWhich(cmd *cobra.Command, args []string) error {
+	path := "."
+	if len(args) == 1 {
+		path = args[0]
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	loc, err := locateWorktree(abs)
+	if err != nil {
+		return err
+	}
+	if nameOnly, _ := cmd.Flags().GetBool("name"); nameOnly {
+		if loc.name == "" {
+			return fmt.Errorf("%s is in the main worktree of %s", path, loc.repo)
+		}
+		fmt.Println(loc.name)
+		return nil
+	}
+	name := loc.name
+	if name == "" {
+		name = "(main)"
+	}
+	fmt.Printf("repo:     %s\n", loc.repo)
+	fmt.Printf("worktree: %s\n", name)
+	fmt.Printf("root:     %s\n", loc.root)
+	return nil
+}
+
+// locateWorktree resolves an absolute path to its worktree, asking git when
+// the path exists and falling back to matching repo@name directory
+// components against registered repositories otherwise.
+func locateWorktree(abs string) (*worktreeLocation, error) {
+	dir := abs
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				dir = filepath.Dir(dir)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			dir = ""
+			break
+		}
+		dir = parent
+	}
+	if dir != "" {
+		out, err := exec.Command("git", "-C", dir, "rev-parse", "--path-format=absolute", "--show-toplevel", "--git-common-dir").Output()
+		if err == nil {
+			lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+			if len(lines) == 2 {
+				root := lines[0]
+				mainRoot := filepath.Dir(filepath.Clean(lines[1]))
+				repo := filepath.Base(mainRoot)
+				loc := &worktreeLocation{repo: repo, root: root, mainRoot: mainRoot}
+				if root != mainRoot {
+					loc.name = parseWorktreeName(filepath.Base(root), repo)
+					if loc.name == "" {
+						loc.name = filepath.Base(root)
+					}
+				}
+				return loc, nil
+			}
+		}
+	}
+
+	roots, _ := loadRegistry()
+	parts := strings.Split(filepath.ToSlash(abs), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		for _, mainRoot := range roots {
+			repo := filepath.Base(mainRoot)
+			if parts[i] == repo {
+				return &worktreeLocation{repo: repo, root: mainRoot, mainRoot: mainRoot}, nil
+			}
+			if name := parseWorktreeName(parts[i], repo); name != "" {
+				root := filepath.Join(filepath.Dir(mainRoot), parts[i])
+				return &worktreeLocation{repo: repo, name: name, root: root, mainRoot: mainRoot}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not inside a known worktree", abs)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// listBranches returns local branches followed by remote-tracking branches,
+// most recently committed first.
+func listBranches() ([]string, error) {
+	out, err := exec.Command("git", "for-each-ref", "--sort=-committerdate",
+		"--format=%(refname:short)", "refs/heads", "refs/remotes").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref failed: %w", err)
+	}
+	var branches []string
+	for _, b := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		// Skip symbolic refs such as origin/HEAD.
+		if b != "" && !strings.HasSuffix(b, "/HEAD") && b != "origin" {
+			branches = append(branches, b)
+		}
+	}
+	return branches, nil
+}
+
+// checkedOutBranches maps each local branch checked out in a worktree to
+// that worktree's directory name.
+func checkedOutBranches() map[string]string {
+	branches := map[string]string{}
+	out, err := exec.Command("git", "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return branches
+	}
+	var dir string
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, ok := strings.CutPrefix(line, "worktree "); ok {
+			dir = filepath.Base(path)
+		} else if ref, ok := strings.CutPrefix(line, "branch refs/heads/"); ok {
+			branches[ref] = dir
+		}
+	}
+	return branches
+}
+
+// worktreeNameForBranch derives a worktree name from a branch name.
+func worktreeNameForBranch(branch string) string {
+	return strings.ReplaceAll(branch, "/", "-")
+}
+
+// runAddWizard interactively collects the options for 'wt add -i'. It starts
+// with a branch picker: a local branch is checked out, a remote one is
+// checked out tracking it, and the worktree is named after it. Picking HEAD,
+// or a branch another worktree has checked out, starts a new worktree there
+// instead.
+func runAddWizard(cmd *cobra.Command, args []string) error {
+	opts := addOptionsFromFlags(cmd)
+
+	branches, err := listBranches()
+	if err != nil {
+		return err
+	}
+	inUse := checkedOutBranches()
+	items := []string{"HEAD (start from the current commit)"}
+	for _, b := range branches {
+		if dir, ok := inUse[b]; ok {
+			b = fmt.Sprintf("%s (checked out in %s)", b, dir)
+		}
+		items = append(items, b)
+	}
+	picked, err := promptPick("Branch", items)
+	if err != nil {
+		return err
+	}
+	base, checkout := "HEAD", ""
+	if picked > 0 {
+		b := branches[picked-1]
+		if _, ok := inUse[b]; ok {
+			base = b
+		} else if remote, ok := strings.CutPrefix(b, "origin/"); ok && refExists("refs/remotes/"+b) {
+			checkout = remote
+		} else {
+			checkout = b
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	def := ""
+	if len(args) == 1 {
+		def = args[0]
+	} else if checkout != "" {
+		def = worktreeNameForBranch(checkout)
+		if suggestions := cfg.Naming.suggest(def); !cfg.Naming.matches(def) && len(suggestions) > 0 {
+			def = suggestions[0]
+		}
+	}
+	var name string
+	for {
+		if name, err = promptLine("Worktree name", def); err != nil {
+			return err
+		}
+		if err := checkNewWorktreeName(name, cfg.Naming); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if dir, err := resolveWorktreePath(name); err == nil {
+			if _, err := os.Stat(dir); err == nil {
+				fmt.Fprintf(os.Stderr, "'%s' already exists; choose another name\n", filepath.Base(dir))
+				continue
+			}
+		}
+		break
+	}
+
+	if checkout != "" {
+		opts.branch = checkout
+	} else {
+		opts.base = base
+		createBranch, err := promptYesNo("Create a branch for this worktree?", true)
+		if err != nil {
+			return err
+		}
+		if createBranch {
+			if opts.branch, err = promptLine("Branch name", name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if root, err := getCurrentWorktreeRoot(); err == nil {
+		if _, err := os.Stat(filepath.Join(root, ".devcontainer", "devcontainer.json")); err == nil && !opts.up {
+			if opts.up, err = promptYesNo("Start the devcontainer?", false); err != nil {
+				return err
+			}
+		}
+	}
+	if !opts.code {
+		if opts.code, err = promptYesNo("Open in VS Code?", false); err != nil {
+			return err
+		}
+	}
+
+	if err := addWorktree(name, opts); err != nil {
+		return err
+	}
+	return finishAdd(name, opts)
+}
+
+// runRemoveInteractive implements 'wt rm -i'. Any args are passed through to
+// 'git worktree remove' for every selected worktree; untracked files matching
+// keep (or .wt/keep) are backed up first.
+func runRemoveInteractive(gitArgs, keep []string) error {
+	mainRoot, err := getMainRepoRoot()
+	if err != nil {
+		return err
+	}
+	worktrees, err := siblingWorktrees(mainRoot)
+	if err != nil {
+		return err
+	}
+	if len(worktrees) == 0 {
+		fmt.Fprintln(os.Stderr, "No worktrees to remove")
+		return nil
+	}
+
+	width := 0
+	for _, wt := range worktrees {
+		width = max(width, len(wt.name))
+	}
+	items := make([]string, len(worktrees))
+	for i, wt := range worktrees {
+		st := getWorktreeStatus(wt.path)
+		state := "clean"
+		if st.dirty {
+			state = "dirty"
+		}
+		items[i] = fmt.Sprintf("%-*s  %-24s  %-5s  %s", width, wt.name, st.ref(), state, formatAge(st.lastCommit))
+	}
+	selected, err := promptMultiSelect("Worktrees to remove", items)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing selected")
+		return nil
+	}
+
+	var names []string
+	for _, i := range selected {
+		names = append(names, worktrees[i].name)
+	}
+	ok, err := promptYesNo(fmt.Sprintf("Remove %s?", strings.Join(names, ", ")), false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("aborted")
+	}
+
+	var failed []string
+	for _, name := range names {
+		if err := checkRemovePolicy(name, gitArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		if dir, err := resolveWorktreePath(name); err == nil {
+			// The selection was just confirmed; only back up kept files.
+			if err := reviewLeftovers(dir, keep, true); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", name, err)
+				failed = append(failed, name)
+				continue
+			}
+		}
+		if err := removeWorktree(name, gitArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Removed %s\n", name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// lruSuggestions is how many of the least recently used worktrees are
+// offered for removal when the repository is at quota.worktrees.
+const lruSuggestions = 5
+
+// usedWorktree is a sibling worktree with when it was last used.
+type usedWorktree struct {
+	siblingWorktree
+	status   worktreeStatus
+	lastUsed time.Time
+}
+
+// worktreeLastUsed estimates when the worktree at dir was last used: the
+// latest of its last commit, its git index (touched by checkouts, adds, and
+// status), and the worktree directory itself.
+func worktreeLastUsed(dir string, st worktreeStatus) time.Time {
+	last := st.lastCommit
+	paths := []string{dir}
+	if out, err := exec.Command("git", "-C", dir, "rev-parse", "--path-format=absolute", "--git-path", "index").Output(); err == nil {
+		paths = append(paths, strings.TrimSpace(string(out)))
+	}
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// leastRecentlyUsed returns the worktrees, least recently used first.
+func leastRecentlyUsed(worktrees []siblingWorktree) []usedWorktree {
+	used := make([]usedWorktree, len(worktrees))
+	for i, wt := range worktrees {
+		st := getWorktreeStatus(wt.path)
+		used[i] = usedWorktree{wt, st, worktreeLastUsed(wt.path, st)}
+	}
+	sort.SliceStable(used, func(i, j int) bool { return used[i].lastUsed.Before(used[j].lastUsed) })
+	return used
+}
+
+// describe returns a line for the worktree in a list of candidates.
+func (u usedWorktree) describe(width int) string {
+	state := "clean"
+	if u.status.dirty {
+		state = "dirty"
+	}
+	return fmt.Sprintf("%-*s  %-24s  %-5s  %s", width, u.name, u.status.ref(), state, formatAge(u.lastUsed))
+}
+
+// enforceWorktreeLimit runs before 'wt add' creates a worktree in the
+// repository at mainRoot. When that would exceed quota.worktrees, it lists
+// the least recently used worktrees and, on a terminal, offers to remove
+// them one at a time. Otherwise, or if the user declines, it warns or, with
+// quota.action block, refuses.
+func enforceWorktreeLimit(mainRoot string, cfg QuotaConfig) error {
+	if cfg.Worktrees <= 0 {
+		return nil
+	}
+	for {
+		worktrees, err := siblingWorktrees(mainRoot)
+		if err != nil || len(worktrees) < cfg.Worktrees {
+			return nil
+		}
+		candidates := leastRecentlyUsed(worktrees)
+		if len(candidates) > lruSuggestions {
+			candidates = candidates[:lruSuggestions]
+		}
+		width := 0
+		for _, c := range candidates {
+			width = max(width, len(c.name))
+		}
+		msg := fmt.Sprintf("%s has %d worktrees, and quota.worktrees allows %d", filepath.Base(mainRoot), len(worktrees), cfg.Worktrees)
+		if nonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+			var names []string
+			for _, c := range candidates {
+				names = append(names, fmt.Sprintf("%s (%s)", c.name, formatAge(c.lastUsed)))
+			}
+			hint := "remove one of the least recently used with 'wt rm <name>': " + strings.Join(names, ", ")
+			if cfg.Action == quotaActionBlock {
+				return fmt.Errorf("%s; %s", msg, hint)
+			}
+			warnf(hint, "%s", msg)
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "%s. Least recently used:\n", msg)
+		items := make([]string, 0, len(candidates)+1)
+		for _, c := range candidates {
+			items = append(items, c.describe(width))
+		}
+		keep := "Keep them all and continue"
+		if cfg.Action == quotaActionBlock {
+			keep = "Keep them all and cancel"
+		}
+		items = append(items, keep)
+		choice, err := promptChoice("Remove one to make room", items)
+		if err != nil {
+			return err
+		}
+		if choice == keep {
+			if cfg.Action == quotaActionBlock {
+				return fmt.Errorf("%s; remove a worktree first", msg)
+			}
+			return nil
+		}
+		var picked usedWorktree
+		for i, item := range items {
+			if item == choice {
+				picked = candidates[i]
+			}
+		}
+		if err := checkRemovePolicy(picked.name, nil); err != nil {
+			return err
+		}
+		if err := reviewLeftovers(picked.path, nil, false); err != nil {
+			return err
+		}
+		if err := removeWorktree(picked.name, nil); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", picked.name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Removed %s\n", picked.name)
+	}
+}